@@ -0,0 +1,59 @@
+package giteapages
+
+import (
+	"strconv"
+	"strings"
+)
+
+// acceptList holds the q-value of each media range or coding listed in an
+// Accept or Accept-Encoding header, keyed in lower case
+type acceptList map[string]float64
+
+// parseAccept parses an Accept or Accept-Encoding header. Entries without
+// a valid q parameter count as q=1, and an entry listed more than once
+// keeps its highest q-value.
+func parseAccept(header string) acceptList {
+	list := make(acceptList)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(param, "=")
+			if strings.EqualFold(strings.TrimSpace(key), "q") {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if prev, ok := list[name]; !ok || q > prev {
+			list[name] = q
+		}
+	}
+	return list
+}
+
+// q returns the highest q-value given to any of names, or -1 when none of
+// them is listed
+func (a acceptList) q(names ...string) float64 {
+	best := -1.0
+	for _, name := range names {
+		if q, ok := a[name]; ok {
+			best = max(best, q)
+		}
+	}
+	return best
+}
+
+// prefers reports whether the header ranks any of want above all of over,
+// ignoring want when it is refused with q=0
+func (a acceptList) prefers(want, over []string) bool {
+	q := a.q(want...)
+	return q > 0 && q > a.q(over...)
+}
+
+// htmlMediaTypes are the media types a rendered page is served as
+var htmlMediaTypes = []string{"text/html", "application/xhtml+xml"}
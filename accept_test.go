@@ -0,0 +1,31 @@
+package giteapages
+
+import "testing"
+
+func TestParseAccept(t *testing.T) {
+	accepted := parseAccept("Text/HTML;level=1;Q=0.5, application/json, gzip;q=bogus, text/html;q=0.2, , br;q=0")
+
+	tests := []struct {
+		names []string
+		want  float64
+	}{
+		{[]string{"text/html"}, 0.5}, // the higher of its two entries
+		{[]string{"application/json"}, 1},
+		{[]string{"gzip"}, 1}, // an invalid q counts as 1
+		{[]string{"br"}, 0},
+		{[]string{"text/plain"}, -1},
+		{[]string{"text/plain", "text/html"}, 0.5},
+	}
+	for _, tt := range tests {
+		if got := accepted.q(tt.names...); got != tt.want {
+			t.Errorf("q(%v) = %v, want %v", tt.names, got, tt.want)
+		}
+	}
+
+	if !accepted.prefers([]string{"application/json"}, htmlMediaTypes) {
+		t.Error("Expected JSON preferred over HTML")
+	}
+	if accepted.prefers([]string{"br"}, nil) {
+		t.Error("Expected a refused entry never to be preferred")
+	}
+}
@@ -0,0 +1,234 @@
+package giteapages
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// clfTimeFormat is the timestamp layout of the Common Log Format
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// defaultAccessLogRollSizeMB is the size at which an access log rolls
+// unless configured
+const defaultAccessLogRollSizeMB = 100
+
+// AccessLog writes an Apache-style access log line for every request the
+// handler sees, alongside the structured zap logs
+type AccessLog struct {
+	// File is the path of the log file
+	File string `json:"file"`
+
+	// Format is "common", "combined" (the default, which adds referer
+	// and User-Agent) or "vhost_combined" (which also leads with the
+	// requested host)
+	Format string `json:"format,omitempty"`
+
+	// RollSizeMB rolls the file over at this size, 100 MB by default;
+	// RollKeep and RollKeepDays bound how many rolled files are kept and
+	// for how long. RollDisabled leaves rotation to outside tools, which
+	// can ask for the file to be reopened through the admin API.
+	RollSizeMB   int  `json:"roll_size_mb,omitempty"`
+	RollKeep     int  `json:"roll_keep,omitempty"`
+	RollKeepDays int  `json:"roll_keep_days,omitempty"`
+	RollDisabled bool `json:"roll_disabled,omitempty"`
+
+	mu  sync.Mutex
+	out io.WriteCloser
+}
+
+// validate checks the format
+func (al *AccessLog) validate() error {
+	if al.File == "" {
+		return fmt.Errorf("access_log needs a file")
+	}
+	switch al.Format {
+	case "", "common", "combined", "vhost_combined":
+	default:
+		return fmt.Errorf("access_log format must be common, combined or vhost_combined, got %q", al.Format)
+	}
+	if al.RollSizeMB < 0 || al.RollKeep < 0 || al.RollKeepDays < 0 {
+		return fmt.Errorf("access_log roll settings must not be negative")
+	}
+	return nil
+}
+
+// open opens the log file for writing
+func (al *AccessLog) open() error {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	out, err := al.openWriter()
+	if err != nil {
+		return fmt.Errorf("failed to open access log: %v", err)
+	}
+	al.out = out
+	return nil
+}
+
+func (al *AccessLog) openWriter() (io.WriteCloser, error) {
+	if al.RollDisabled {
+		return os.OpenFile(al.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	}
+	size := al.RollSizeMB
+	if size == 0 {
+		size = defaultAccessLogRollSizeMB
+	}
+	return &lumberjack.Logger{
+		Filename:   al.File,
+		MaxSize:    size,
+		MaxBackups: al.RollKeep,
+		MaxAge:     al.RollKeepDays,
+	}, nil
+}
+
+// rotate rolls the log over, or with RollDisabled reopens it so a file
+// moved aside by an outside tool is recreated
+func (al *AccessLog) rotate() error {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if rolling, ok := al.out.(*lumberjack.Logger); ok {
+		return rolling.Rotate()
+	}
+	out, err := al.openWriter()
+	if err != nil {
+		return err
+	}
+	if al.out != nil {
+		al.out.Close()
+	}
+	al.out = out
+	return nil
+}
+
+// close closes the log file
+func (al *AccessLog) close() error {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if al.out == nil {
+		return nil
+	}
+	err := al.out.Close()
+	al.out = nil
+	return err
+}
+
+// log writes the line for r, answered with status and size bytes
+func (al *AccessLog) log(r *http.Request, status, size int, now time.Time) {
+	line := al.line(r, status, size, now)
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if al.out != nil {
+		io.WriteString(al.out, line)
+	}
+}
+
+// line formats one access log line, ending in a newline
+func (al *AccessLog) line(r *http.Request, status, size int, now time.Time) string {
+	client := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+	user := "-"
+	if name, _, ok := r.BasicAuth(); ok && name != "" {
+		user = clfEscape(name)
+	}
+	bytes := "-"
+	if size > 0 {
+		bytes = strconv.Itoa(size)
+	}
+
+	var b strings.Builder
+	if al.Format == "vhost_combined" {
+		b.WriteString(clfEscape(r.Host) + " ")
+	}
+	fmt.Fprintf(&b, "%s - %s [%s] \"%s %s %s\" %d %s",
+		clfField(client), user, now.Format(clfTimeFormat),
+		clfEscape(r.Method), clfEscape(r.URL.RequestURI()), clfEscape(r.Proto), status, bytes)
+	if al.Format != "common" {
+		fmt.Fprintf(&b, " \"%s\" \"%s\"", clfEscape(r.Referer()), clfEscape(r.UserAgent()))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// clfField returns s, or "-" when it is empty
+func clfField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return clfEscape(s)
+}
+
+// clfEscape escapes quotes, backslashes and control characters so a
+// value cannot break out of its field or forge a line
+func clfEscape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// logAccess wraps w so the response is written to the access log once
+// the request has been handled; the returned function does the writing
+func (gp *GitteaPages) logAccess(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func(error)) {
+	rec := caddyhttp.NewResponseRecorder(w, nil, nil)
+	return rec, func(err error) {
+		status := rec.Status()
+		if status == 0 {
+			// Nothing was written: Caddy answers a returned error itself
+			status = http.StatusOK
+			if err != nil {
+				status = http.StatusInternalServerError
+				var handlerErr caddyhttp.HandlerError
+				if errors.As(err, &handlerErr) && handlerErr.StatusCode != 0 {
+					status = handlerErr.StatusCode
+				}
+			}
+		}
+		gp.AccessLog.log(r, status, rec.Size(), time.Now())
+	}
+}
+
+// rotateAccessLogs rotates the access log of every running handler
+func rotateAccessLogs() error {
+	for _, gp := range runningHandlers() {
+		if gp.AccessLog == nil {
+			continue
+		}
+		if err := gp.AccessLog.rotate(); err != nil {
+			return fmt.Errorf("rotating %s: %v", gp.AccessLog.File, err)
+		}
+	}
+	return nil
+}
+
+func (AdminAPI) handleRotateAccessLog(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: fmt.Errorf("method not allowed")}
+	}
+	if err := rotateAccessLogs(); err != nil {
+		return caddy.APIError{HTTPStatus: http.StatusInternalServerError, Err: err}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
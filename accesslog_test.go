@@ -0,0 +1,177 @@
+package giteapages
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// combinedLine matches a Combined Log Format line
+var combinedLine = regexp.MustCompile(`^(\S+) - (\S+) \[(\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})\] "([^"]*)" (\d{3}) (\d+|-) "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)"$`)
+
+func readAccessLog(t *testing.T, file string) []string {
+	t.Helper()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed to read access log: %v", err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestServeHTTP_AccessLog(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+	})
+	file := filepath.Join(t.TempDir(), "access.log")
+	gp.AccessLog = &AccessLog{File: file, RollDisabled: true}
+	if err := gp.AccessLog.open(); err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	helper.CreateCacheEntry("user/site", "main", map[string]string{
+		"page.html": "<h1>Page</h1>",
+	})
+
+	w := helper.MakeHTTPRequest("GET", "/user/site/page.html?v=1", "pages.example.com", map[string]string{
+		"Referer":    "https://example.com/",
+		"User-Agent": `Mozilla/5.0 "quoted"`,
+	})
+	helper.AssertResponse(w, http.StatusOK, "Page")
+
+	lines := readAccessLog(t, file)
+	if len(lines) != 1 {
+		t.Fatalf("Expected one access log line, got %q", lines)
+	}
+	m := combinedLine.FindStringSubmatch(lines[0])
+	if m == nil {
+		t.Fatalf("Expected a Combined Log Format line, got %q", lines[0])
+	}
+	if m[1] != "192.0.2.1" || m[2] != "-" {
+		t.Errorf("Unexpected client or user in %q", lines[0])
+	}
+	if when, err := time.Parse(clfTimeFormat, m[3]); err != nil || time.Since(when) > time.Minute {
+		t.Errorf("Unexpected timestamp %q: %v", m[3], err)
+	}
+	if m[4] != "GET /user/site/page.html?v=1 HTTP/1.1" || m[5] != "200" || m[6] != "13" {
+		t.Errorf("Unexpected request, status or size in %q", lines[0])
+	}
+	if m[7] != "https://example.com/" || m[8] != `Mozilla/5.0 \"quoted\"` {
+		t.Errorf("Unexpected referer or User-Agent in %q", lines[0])
+	}
+
+	// Requests handed on to the next handler are logged with its answer
+	gp.AccessLog.Format = "common"
+	w = helper.MakeHTTPRequest("GET", "/user/site/missing.html", "", nil)
+	helper.AssertResponse(w, http.StatusNotFound, "")
+
+	lines = readAccessLog(t, file)
+	if len(lines) != 2 {
+		t.Fatalf("Expected two access log lines, got %q", lines)
+	}
+	if !strings.HasSuffix(lines[1], `"GET /user/site/missing.html HTTP/1.1" 404 26`) {
+		t.Errorf("Unexpected Common Log Format line %q", lines[1])
+	}
+}
+
+func TestAccessLog_Line(t *testing.T) {
+	now := time.Date(2024, time.March, 5, 14, 3, 9, 0, time.FixedZone("", -7*3600))
+	r := httptest.NewRequest("HEAD", "/docs/", nil)
+	r.Host = "docs.example.com"
+	r.SetBasicAuth("alice", "secret")
+	r.Header.Set("User-Agent", "curl/8.0\r\nforged")
+
+	tests := []struct {
+		format   string
+		status   int
+		size     int
+		expected string
+	}{
+		{"common", 200, 0, `192.0.2.1 - alice [05/Mar/2024:14:03:09 -0700] "HEAD /docs/ HTTP/1.1" 200 -`},
+		{"", 304, 0, `192.0.2.1 - alice [05/Mar/2024:14:03:09 -0700] "HEAD /docs/ HTTP/1.1" 304 - "" "curl/8.0\x0d\x0aforged"`},
+		{"vhost_combined", 200, 512, `docs.example.com 192.0.2.1 - alice [05/Mar/2024:14:03:09 -0700] "HEAD /docs/ HTTP/1.1" 200 512 "" "curl/8.0\x0d\x0aforged"`},
+	}
+	for _, tt := range tests {
+		al := &AccessLog{Format: tt.format}
+		if got := al.line(r, tt.status, tt.size, now); got != tt.expected+"\n" {
+			t.Errorf("format %q: expected\n%s\ngot\n%s", tt.format, tt.expected, got)
+		}
+	}
+}
+
+func TestAccessLog_RotateReopens(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+	})
+	dir := t.TempDir()
+	file := filepath.Join(dir, "access.log")
+	gp.AccessLog = &AccessLog{File: file, Format: "common", RollDisabled: true}
+	if err := gp.AccessLog.open(); err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	helper.CreateCacheEntry("user/site", "main", map[string]string{
+		"page.html": "<h1>Page</h1>",
+	})
+
+	helper.MakeHTTPRequest("GET", "/user/site/page.html", "", nil)
+
+	// An outside tool moves the log aside, then asks for it to be reopened
+	if err := os.Rename(file, file+".1"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/gitea_pages/access_log/rotate", nil)
+	if err := (AdminAPI{}).handleRotateAccessLog(w, req); err != nil {
+		t.Fatalf("rotate failed: %v", err)
+	}
+	helper.MakeHTTPRequest("GET", "/user/site/page.html?again", "", nil)
+
+	if old := readAccessLog(t, file+".1"); len(old) != 1 || !strings.Contains(old[0], "/user/site/page.html HTTP") {
+		t.Errorf("Expected the first request in the rotated file, got %q", old)
+	}
+	if current := readAccessLog(t, file); len(current) != 1 || !strings.Contains(current[0], "?again") {
+		t.Errorf("Expected the second request in the reopened file, got %q", current)
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_AccessLog(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		access_log /var/log/pages/access.log vhost_combined {
+			roll_size 10MB
+			roll_keep 5
+			roll_keep_for 720h
+		}
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	al := gp.AccessLog
+	if al == nil || al.File != "/var/log/pages/access.log" || al.Format != "vhost_combined" {
+		t.Fatalf("Unexpected access_log %+v", al)
+	}
+	if al.RollSizeMB != 10 || al.RollKeep != 5 || al.RollKeepDays != 30 {
+		t.Errorf("Unexpected roll settings %+v", al)
+	}
+	if err := gp.Validate(); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+
+	al.Format = "json"
+	if err := gp.Validate(); err == nil {
+		t.Error("Expected an unknown format to be rejected")
+	}
+}
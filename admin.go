@@ -0,0 +1,75 @@
+package giteapages
+
+import (
+	"sync"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func init() {
+	caddy.RegisterModule(AdminAPI{})
+}
+
+// adminHandlers are the provisioned handlers the admin API acts on
+var adminHandlers = struct {
+	sync.Mutex
+	handlers map[*GitteaPages]struct{}
+}{handlers: make(map[*GitteaPages]struct{})}
+
+// registerAdmin makes gp reachable from the admin API until
+// unregisterAdmin
+func (gp *GitteaPages) registerAdmin() {
+	adminHandlers.Lock()
+	defer adminHandlers.Unlock()
+	adminHandlers.handlers[gp] = struct{}{}
+}
+
+func (gp *GitteaPages) unregisterAdmin() {
+	adminHandlers.Lock()
+	defer adminHandlers.Unlock()
+	delete(adminHandlers.handlers, gp)
+}
+
+// runningHandlers returns the handlers registered with the admin API
+func runningHandlers() []*GitteaPages {
+	adminHandlers.Lock()
+	defer adminHandlers.Unlock()
+	handlers := make([]*GitteaPages, 0, len(adminHandlers.handlers))
+	for gp := range adminHandlers.handlers {
+		handlers = append(handlers, gp)
+	}
+	return handlers
+}
+
+// AdminAPI is the gitea_pages admin API module. Its endpoints act on every
+// running gitea_pages handler: commit pins, domain mappings and access log
+// rotation.
+type AdminAPI struct{}
+
+// CaddyModule returns the Caddy module information.
+func (AdminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.gitea_pages",
+		New: func() caddy.Module { return new(AdminAPI) },
+	}
+}
+
+// Routes returns the commit pins and domain mappings routes and the
+// access log rotation hook, which a POST to rolls every handler's access
+// log over
+func (a AdminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{
+			Pattern: "/gitea_pages/commit_pins",
+			Handler: caddy.AdminHandlerFunc(a.handleCommitPins),
+		},
+		{
+			Pattern: "/gitea_pages/domain_mappings",
+			Handler: caddy.AdminHandlerFunc(a.handleDomainMappings),
+		},
+		{
+			Pattern: "/gitea_pages/access_log/rotate",
+			Handler: caddy.AdminHandlerFunc(a.handleRotateAccessLog),
+		},
+	}
+}
@@ -0,0 +1,121 @@
+package giteapages
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// archiveFileName is the virtual file that, when AllowArchive is set,
+// downloads the directory it appears in as a zip
+const archiveFileName = "archive.zip"
+
+// serveArchive streams a zip of dir, which is relPath within the repository
+// checked out in entry. Files kept in memory are read from there. Denied
+// files, files verify_manifest refuses and files above cache_max_size are
+// left out: those are streamed from Gitea on each request and an archive
+// must not fetch them all at once. Nothing is written to the cache; the zip
+// is built straight into the response.
+func (gp *GitteaPages) serveArchive(w http.ResponseWriter, entry *cacheEntry, relPath, name string) error {
+	repoRoot := entry.path
+	dir := filepath.Join(repoRoot, relPath)
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return errFileNotFound
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, name))
+
+	zw := zip.NewWriter(w)
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		repoRel, err := filepath.Rel(repoRoot, path)
+		if err != nil {
+			return err
+		}
+		rel := filepath.ToSlash(repoRel)
+		if gp.isDenied(rel) || entry.rejected[rel] || entry.streamed[rel] {
+			return nil
+		}
+
+		entryName, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		return addZipFile(zw, path, filepath.ToSlash(entryName), entry.compressed, entry.memory[rel])
+	})
+	if err != nil {
+		// Headers are already sent, so the best we can do is log and
+		// leave the client with a truncated archive
+		gp.logger.Error("failed to build archive",
+			zap.String("dir", dir),
+			zap.Error(err))
+		return nil
+	}
+
+	return zw.Close()
+}
+
+// addZipFile copies the cached file at path into zw under name. For a file
+// kept in memory, path is its empty stand-in and data the content.
+func addZipFile(zw *zip.Writer, path, name string, compressed bool, data []byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var src io.Reader = f
+	if data != nil {
+		src = bytes.NewReader(data)
+	} else if compressed {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		src = gz
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+
+	dst, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// archiveName builds the download name for a repository subtree
+func archiveName(repo, relPath string) string {
+	if relPath == "" || relPath == "." {
+		return repo
+	}
+	return repo + "-" + strings.ReplaceAll(strings.Trim(relPath, "/"), "/", "-")
+}
@@ -0,0 +1,104 @@
+package giteapages
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestServeHTTP_ArchiveZip(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+	})
+	gp.AllowArchive = true
+	gp.DenyFiles = []string{"*.env", "docs/drafts/*"}
+	helper.CreateCacheEntry("user/site", "main", map[string]string{
+		"index.html":           "<h1>Home</h1>",
+		"secrets.env":          "TOKEN=hunter2",
+		"docs/guide.html":      "<h1>Guide</h1>",
+		"docs/img/logo.svg":    "<svg/>",
+		"docs/drafts/wip.html": "<h1>WIP</h1>",
+	})
+
+	tests := []struct {
+		name     string
+		path     string
+		expected []string
+	}{
+		{
+			name:     "whole repository",
+			path:     "/user/site/archive.zip",
+			expected: []string{"docs/guide.html", "docs/img/logo.svg", "index.html"},
+		},
+		{
+			name:     "subdirectory",
+			path:     "/user/site/docs/archive.zip",
+			expected: []string{"guide.html", "img/logo.svg"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := helper.MakeHTTPRequest("GET", tt.path, "", nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/zip" {
+				t.Errorf("Expected Content-Type 'application/zip', got '%s'", ct)
+			}
+
+			zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+			if err != nil {
+				t.Fatalf("Failed to read zip: %v", err)
+			}
+
+			var names []string
+			for _, f := range zr.File {
+				names = append(names, f.Name)
+			}
+			sort.Strings(names)
+			if strings.Join(names, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected entries %v, got %v", tt.expected, names)
+			}
+
+			for _, f := range zr.File {
+				if f.Name != "guide.html" && f.Name != "docs/guide.html" {
+					continue
+				}
+				rc, err := f.Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				content, _ := io.ReadAll(rc)
+				rc.Close()
+				if string(content) != "<h1>Guide</h1>" {
+					t.Errorf("Expected guide content, got '%s'", content)
+				}
+			}
+		})
+	}
+
+	t.Run("denied file not served directly", func(t *testing.T) {
+		w := helper.MakeHTTPRequest("GET", "/user/site/secrets.env", "", nil)
+		if w.Code == http.StatusOK || strings.Contains(w.Body.String(), "hunter2") {
+			t.Errorf("Expected denied file to be withheld, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		gp.AllowArchive = false
+		defer func() { gp.AllowArchive = true }()
+
+		w := helper.MakeHTTPRequest("GET", "/user/site/archive.zip", "", nil)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+		}
+	})
+}
@@ -0,0 +1,210 @@
+package giteapages
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// defaultSessionCookieName names the session cookie unless configured
+const defaultSessionCookieName = "gitea_pages_session"
+
+// defaultSessionTTL is how long a session cookie stays valid
+const defaultSessionTTL = 12 * time.Hour
+
+// minSessionSecret is the shortest accepted session cookie secret
+const minSessionSecret = 16
+
+// dummyBcryptHash is compared against for unknown users so that a login
+// takes as long whether or not the user exists
+var dummyBcryptHash = []byte("$2a$10$H8ZP6jEaySsyj7P7arVx4.Ryc/dT8dAZoBS7LcfceV75TasA/oFw6")
+
+// BasicAuth puts HTTP basic authentication in front of selected hosts
+type BasicAuth struct {
+	// Hosts lists host patterns, e.g. "*.internal.example.com", that
+	// require authentication; empty means every host
+	Hosts []string `json:"hosts,omitempty"`
+
+	// Users maps user names to bcrypt password hashes, as produced by
+	// caddy hash-password
+	Users map[string]string `json:"users,omitempty"`
+
+	// Realm is sent in the WWW-Authenticate challenge
+	Realm string `json:"realm,omitempty"`
+
+	// SessionCookie, when set, remembers a successful login in a signed
+	// cookie, which can be scoped to a parent domain so that sibling
+	// subdomains do not prompt again
+	SessionCookie *SessionCookie `json:"session_cookie,omitempty"`
+}
+
+// SessionCookie configures the signed cookie set after a login
+type SessionCookie struct {
+	// Name of the cookie
+	Name string `json:"name,omitempty"`
+
+	// Domain scopes the cookie, e.g. "example.com" to share it with
+	// every subdomain; empty keeps it to the host that set it
+	Domain string `json:"domain,omitempty"`
+
+	// Secret keys the cookie's HMAC signature
+	Secret string `json:"secret,omitempty"`
+
+	// TTL is how long a session lasts, 12 hours by default
+	TTL caddy.Duration `json:"ttl,omitempty"`
+}
+
+// validate checks the user hashes and the session cookie settings
+func (ba *BasicAuth) validate() error {
+	if len(ba.Users) == 0 {
+		return fmt.Errorf("basic_auth needs at least one user")
+	}
+	for user, hash := range ba.Users {
+		if user == "" || strings.Contains(user, ":") {
+			return fmt.Errorf("basic_auth user %q must be non-empty and contain no colon", user)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("basic_auth user %q: password must be a bcrypt hash: %v", user, err)
+		}
+	}
+	for _, pattern := range ba.Hosts {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("basic_auth host %q: %v", pattern, err)
+		}
+	}
+	if sc := ba.SessionCookie; sc != nil {
+		if len(sc.Secret) < minSessionSecret {
+			return fmt.Errorf("basic_auth session_cookie secret must be at least %d characters", minSessionSecret)
+		}
+		if sc.TTL < 0 {
+			return fmt.Errorf("basic_auth session_cookie ttl must not be negative")
+		}
+	}
+	return nil
+}
+
+// requireBasicAuth checks r against BasicAuth and answers with a 401
+// challenge when it fails, reporting whether it wrote a response. A
+// valid session cookie stands in for credentials; a successful login
+// without one sets one, marked Secure when the client's side of the
+// request is HTTPS.
+func (gp *GitteaPages) requireBasicAuth(w http.ResponseWriter, r *http.Request) bool {
+	ba := gp.BasicAuth
+	if ba == nil || len(ba.Hosts) > 0 && !hostMatches(ba.Hosts, r.Host) {
+		return false
+	}
+
+	sc := ba.SessionCookie
+	if sc != nil && ba.validSession(r) {
+		// Credentials sent alongside need no new cookie
+		return false
+	}
+
+	if user, pass, ok := r.BasicAuth(); ok && ba.checkPassword(user, pass) {
+		if sc != nil {
+			http.SetCookie(w, sc.issue(user, time.Now(), secureRequest(r)))
+		}
+		return false
+	}
+
+	realm := ba.Realm
+	if realm == "" {
+		realm = "restricted"
+	}
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", realm))
+	gp.serveError(w, r, http.StatusUnauthorized, "authentication required")
+	return true
+}
+
+// validSession reports whether r carries an unexpired session cookie of a
+// configured user
+func (ba *BasicAuth) validSession(r *http.Request) bool {
+	c, err := r.Cookie(ba.SessionCookie.cookieName())
+	if err != nil {
+		return false
+	}
+	user, ok := ba.SessionCookie.verify(c.Value, time.Now())
+	return ok && ba.Users[user] != ""
+}
+
+// checkPassword reports whether pass is user's password
+func (ba *BasicAuth) checkPassword(user, pass string) bool {
+	hash, ok := ba.Users[user]
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyBcryptHash, []byte(pass))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+}
+
+func (sc *SessionCookie) cookieName() string {
+	if sc.Name == "" {
+		return defaultSessionCookieName
+	}
+	return sc.Name
+}
+
+func (sc *SessionCookie) ttl() time.Duration {
+	if sc.TTL == 0 {
+		return defaultSessionTTL
+	}
+	return time.Duration(sc.TTL)
+}
+
+// issue returns a session cookie for user, valid for the TTL from now
+func (sc *SessionCookie) issue(user string, now time.Time, secure bool) *http.Cookie {
+	expires := now.Add(sc.ttl())
+	payload := user + "|" + strconv.FormatInt(expires.Unix(), 10)
+	return &http.Cookie{
+		Name:     sc.cookieName(),
+		Value:    base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + sc.sign(payload),
+		Path:     "/",
+		Domain:   sc.Domain,
+		Expires:  expires,
+		Secure:   secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// verify returns the user a cookie value was issued to, if its signature
+// holds and it has not expired
+func (sc *SessionCookie) verify(value string, now time.Time) (string, bool) {
+	encoded, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return "", false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false
+	}
+	payload := string(raw)
+	if !hmac.Equal([]byte(signature), []byte(sc.sign(payload))) {
+		return "", false
+	}
+	user, expiry, ok := strings.Cut(payload, "|")
+	if !ok {
+		return "", false
+	}
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || !now.Before(time.Unix(unix, 0)) {
+		return "", false
+	}
+	return user, true
+}
+
+// sign returns the HMAC-SHA256 of payload under the secret
+func (sc *SessionCookie) sign(payload string) string {
+	mac := hmac.New(sha256.New, []byte(sc.Secret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,224 @@
+package giteapages
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testSessionSecret = "0123456789abcdef0123456789abcdef"
+
+func testBasicAuth(t *testing.T) *BasicAuth {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword failed: %v", err)
+	}
+	return &BasicAuth{
+		Hosts: []string{"*.example.com"},
+		Users: map[string]string{"alice": string(hash)},
+		SessionCookie: &SessionCookie{
+			Domain: "example.com",
+			Secret: testSessionSecret,
+		},
+	}
+}
+
+func TestServeHTTP_BasicAuthSessionCookie(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"team/docs": {
+			Name:          "docs",
+			FullName:      "team/docs",
+			DefaultBranch: "main",
+			Files:         map[string]string{"page.html": "<h1>Docs</h1>"},
+		},
+		"team/wiki": {
+			Name:          "wiki",
+			FullName:      "team/wiki",
+			DefaultBranch: "main",
+			Files:         map[string]string{"page.html": "<h1>Wiki</h1>"},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+		DomainMappings: []DomainMapping{
+			{Domain: "docs.example.com", Owner: "team", Repository: "docs"},
+			{Domain: "wiki.example.com", Owner: "team", Repository: "wiki"},
+		},
+	})
+	gp.BasicAuth = testBasicAuth(t)
+
+	w := helper.MakeHTTPRequest("GET", "/page.html", "docs.example.com", nil)
+	if w.Code != http.StatusUnauthorized || !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Basic ") {
+		t.Fatalf("Expected a basic auth challenge, got %d %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.SetBasicAuth("alice", "hunter2")
+	w = helper.MakeHTTPRequest("GET", "/page.html", "docs.example.com",
+		map[string]string{"Authorization": req.Header.Get("Authorization")})
+	helper.AssertResponse(w, http.StatusOK, "Docs")
+
+	cookies := (&http.Response{Header: w.Header()}).Cookies()
+	if len(cookies) != 1 || cookies[0].Name != defaultSessionCookieName {
+		t.Fatalf("Expected a session cookie, got %v", cookies)
+	}
+	session := cookies[0]
+	if session.Domain != "example.com" || !session.HttpOnly {
+		t.Errorf("Expected an HttpOnly cookie for example.com, got %+v", session)
+	}
+
+	// The sibling subdomain accepts the cookie without a new challenge
+	w = helper.MakeHTTPRequest("GET", "/page.html", "wiki.example.com",
+		map[string]string{"Cookie": session.Name + "=" + session.Value})
+	helper.AssertResponse(w, http.StatusOK, "Wiki")
+
+	tampered := session.Value[:len(session.Value)-2] + "xx"
+	w = helper.MakeHTTPRequest("GET", "/page.html", "wiki.example.com",
+		map[string]string{"Cookie": session.Name + "=" + tampered})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a tampered cookie to be challenged, got %d", w.Code)
+	}
+
+	w = helper.MakeHTTPRequest("GET", "/page.html", "wiki.example.com",
+		map[string]string{"Authorization": "Basic YWxpY2U6d3Jvbmc="})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a wrong password to be challenged, got %d", w.Code)
+	}
+}
+
+func TestServeHTTP_BasicAuthSessionCookieBehindProxy(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"team/docs": {
+			Name:          "docs",
+			FullName:      "team/docs",
+			DefaultBranch: "main",
+			Files:         map[string]string{"page.html": "<h1>Docs</h1>"},
+		},
+	})
+	helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+		DomainMappings: []DomainMapping{
+			{Domain: "docs.example.com", Owner: "team", Repository: "docs"},
+		},
+	}).BasicAuth = testBasicAuth(t)
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.SetBasicAuth("alice", "hunter2")
+	login := map[string]string{
+		"Authorization":     req.Header.Get("Authorization"),
+		"X-Forwarded-Proto": "https",
+	}
+
+	// An untrusted client cannot claim HTTPS for a plain connection
+	w := helper.MakeHTTPRequest("GET", "/page.html", "docs.example.com", login)
+	cookies := (&http.Response{Header: w.Header()}).Cookies()
+	if len(cookies) != 1 || cookies[0].Secure {
+		t.Fatalf("Expected a cookie without Secure over plain HTTP, got %v", cookies)
+	}
+
+	// TLS terminated by a trusted proxy still gets a Secure cookie
+	helper.TrustProxies()
+	w = helper.MakeHTTPRequest("GET", "/page.html", "docs.example.com", login)
+	helper.AssertResponse(w, http.StatusOK, "Docs")
+	cookies = (&http.Response{Header: w.Header()}).Cookies()
+	if len(cookies) != 1 || !cookies[0].Secure {
+		t.Fatalf("Expected a Secure cookie behind a TLS proxy, got %v", cookies)
+	}
+
+	// Clients that keep sending credentials with a valid cookie are not
+	// handed a new cookie on every request
+	login["Cookie"] = cookies[0].Name + "=" + cookies[0].Value
+	w = helper.MakeHTTPRequest("GET", "/page.html", "docs.example.com", login)
+	helper.AssertResponse(w, http.StatusOK, "Docs")
+	if got := w.Header().Values("Set-Cookie"); len(got) != 0 {
+		t.Errorf("Expected no cookie re-issued, got %v", got)
+	}
+}
+
+func TestSessionCookie_Verify(t *testing.T) {
+	sc := &SessionCookie{Secret: testSessionSecret, TTL: 0}
+	now := time.Now()
+	cookie := sc.issue("alice", now, true)
+	if !cookie.Secure {
+		t.Error("Expected a Secure cookie over TLS")
+	}
+
+	if user, ok := sc.verify(cookie.Value, now.Add(time.Hour)); !ok || user != "alice" {
+		t.Errorf("Expected a valid session for alice, got %q %v", user, ok)
+	}
+	if _, ok := sc.verify(cookie.Value, now.Add(defaultSessionTTL+time.Second)); ok {
+		t.Error("Expected an expired session to be rejected")
+	}
+	other := &SessionCookie{Secret: strings.Repeat("x", 32)}
+	if _, ok := other.verify(cookie.Value, now); ok {
+		t.Error("Expected a cookie signed with another secret to be rejected")
+	}
+	for _, value := range []string{"", "garbage", "!!!.sig"} {
+		if _, ok := sc.verify(value, now); ok {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestBasicAuth_Validate(t *testing.T) {
+	valid := testBasicAuth(t)
+	if err := valid.validate(); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+
+	tests := map[string]func(ba *BasicAuth){
+		"no users":     func(ba *BasicAuth) { ba.Users = nil },
+		"plain text":   func(ba *BasicAuth) { ba.Users["alice"] = "hunter2" },
+		"short secret": func(ba *BasicAuth) { ba.SessionCookie.Secret = "short" },
+		"bad host":     func(ba *BasicAuth) { ba.Hosts = []string{"["} },
+	}
+	for name, mutate := range tests {
+		t.Run(name, func(t *testing.T) {
+			ba := testBasicAuth(t)
+			mutate(ba)
+			if err := ba.validate(); err == nil {
+				t.Error("Expected validation error")
+			}
+		})
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_BasicAuth(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		basic_auth *.example.com {
+			realm Docs
+			user alice $2a$10$pKLTzKJTtA3W8JEMdYkU6OLcD7//NTNV0Tv/YRjLV6F4gqiK/OPEy
+			session_cookie example.com {
+				secret 0123456789abcdef
+				ttl 1h
+			}
+		}
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	ba := gp.BasicAuth
+	if ba == nil || ba.Realm != "Docs" || len(ba.Hosts) != 1 || ba.Users["alice"] == "" {
+		t.Fatalf("Unexpected basic_auth %+v", ba)
+	}
+	if sc := ba.SessionCookie; sc == nil || sc.Domain != "example.com" || time.Duration(sc.TTL) != time.Hour {
+		t.Errorf("Unexpected session_cookie %+v", ba.SessionCookie)
+	}
+	if err := ba.validate(); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+}
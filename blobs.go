@@ -0,0 +1,178 @@
+package giteapages
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// treePageSize is how many entries are requested per page of a git tree
+const treePageSize = 1000
+
+// treeCache remembers the path to blob SHA mapping of repository trees
+// fetched for blob_path_length, keyed by "owner/repo@ref"
+type treeCache struct {
+	mu      sync.Mutex
+	entries map[string]treeCacheEntry
+}
+
+// treeCacheEntry is one resolved tree
+type treeCacheEntry struct {
+	blobs   map[string]string
+	fetched time.Time
+}
+
+// useBlobAPI reports whether relPath is long enough that it should be
+// fetched through the git blobs API rather than the raw file endpoint,
+// whose URL embeds the whole path
+func (gp *GitteaPages) useBlobAPI(relPath string) bool {
+	return gp.BlobPathLength > 0 && len(escapePath(relPath)) > gp.BlobPathLength
+}
+
+// serveBlob serves relPath at ref by its blob SHA, resolving the SHA from
+// the repository tree unless sha is already known
+func (gp *GitteaPages) serveBlob(w http.ResponseWriter, r *http.Request, owner, repo, ref, relPath, sha string, modTime time.Time) error {
+	if sha == "" {
+		blobs, err := gp.repoTree(r.Context(), owner, repo, ref)
+		if err != nil {
+			return err
+		}
+		var ok bool
+		if sha, ok = blobs[relPath]; !ok {
+			return errFileNotFound
+		}
+	}
+
+	data, err := gp.fetchBlob(r.Context(), owner, repo, sha)
+	if err != nil {
+		return err
+	}
+	serveMemoryFile(w, r, path.Base(relPath), modTime, data)
+	return nil
+}
+
+// repoTree returns the blob SHAs of every file at ref, keyed by path,
+// from the tree cache when it is younger than the repository's cache TTL
+func (gp *GitteaPages) repoTree(ctx context.Context, owner, repo, ref string) (map[string]string, error) {
+	repoKey := fmt.Sprintf("%s/%s", owner, repo)
+	key := repoKey + "@" + ref
+	ttl := gp.cacheTTLFor(repoKey)
+
+	gp.trees.mu.Lock()
+	cached, ok := gp.trees.entries[key]
+	gp.trees.mu.Unlock()
+	if ok && time.Since(cached.fetched) <= ttl {
+		return cached.blobs, nil
+	}
+
+	blobs, err := gp.fetchTree(ctx, owner, repo, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	gp.trees.mu.Lock()
+	defer gp.trees.mu.Unlock()
+	for k, e := range gp.trees.entries {
+		if time.Since(e.fetched) > ttl {
+			delete(gp.trees.entries, k)
+		}
+	}
+	gp.trees.entries[key] = treeCacheEntry{blobs: blobs, fetched: time.Now()}
+	return blobs, nil
+}
+
+// fetchTree lists ref's tree recursively through the Gitea git trees API,
+// following pages while Gitea reports the listing as truncated
+func (gp *GitteaPages) fetchTree(ctx context.Context, owner, repo, ref string) (map[string]string, error) {
+	client := &http.Client{Timeout: 30 * time.Second, Transport: gp.upstreamTransport()}
+	blobs := make(map[string]string)
+
+	for page := 1; ; page++ {
+		apiURL := fmt.Sprintf("%s/api/v1/repos/%s/%s/git/trees/%s?recursive=true&page=%d&per_page=%d",
+			strings.TrimRight(gp.GitteaURL, "/"), url.PathEscape(owner), url.PathEscape(repo),
+			url.PathEscape(ref), page, treePageSize)
+
+		req, err := gp.newUpstreamRequest(ctx, apiURL)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch tree: %w", err)
+		}
+
+		var tree struct {
+			Tree []struct {
+				Path string `json:"path"`
+				Type string `json:"type"`
+				SHA  string `json:"sha"`
+			} `json:"tree"`
+			Truncated bool `json:"truncated"`
+		}
+		switch resp.StatusCode {
+		case http.StatusOK:
+			err = gp.decodeJSON(resp, &tree)
+		case http.StatusTooManyRequests:
+			err = gp.rateLimited(resp)
+		default:
+			err = fmt.Errorf("failed to fetch tree: status %d", resp.StatusCode)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range tree.Tree {
+			if e.Type == "blob" {
+				blobs[e.Path] = e.SHA
+			}
+		}
+		if !tree.Truncated || len(tree.Tree) == 0 {
+			return blobs, nil
+		}
+	}
+}
+
+// fetchBlob downloads a blob's content by SHA through the Gitea git blobs
+// API
+func (gp *GitteaPages) fetchBlob(ctx context.Context, owner, repo, sha string) ([]byte, error) {
+	apiURL := fmt.Sprintf("%s/api/v1/repos/%s/%s/git/blobs/%s",
+		strings.TrimRight(gp.GitteaURL, "/"), url.PathEscape(owner), url.PathEscape(repo), url.PathEscape(sha))
+
+	req, err := gp.newUpstreamRequest(ctx, apiURL)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: 5 * time.Minute, Transport: gp.upstreamTransport()}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch blob: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusTooManyRequests:
+		return nil, gp.rateLimited(resp)
+	default:
+		return nil, fmt.Errorf("failed to fetch blob: status %d", resp.StatusCode)
+	}
+
+	var blob struct {
+		Content  string `json:"content"`
+		Encoding string `json:"encoding"`
+	}
+	if err := gp.decodeJSON(resp, &blob); err != nil {
+		return nil, fmt.Errorf("failed to decode blob: %w", err)
+	}
+	if blob.Encoding != "base64" {
+		return []byte(blob.Content), nil
+	}
+	return base64.StdEncoding.DecodeString(blob.Content)
+}
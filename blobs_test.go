@@ -0,0 +1,84 @@
+package giteapages
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_BlobPathLength(t *testing.T) {
+	deep := strings.Repeat("nested/", 40) + "large.bin"
+	large := strings.Repeat("L", 4096)
+
+	for _, etags := range []bool{false, true} {
+		name := map[bool]string{false: "tree lookup", true: "blob SHA from etags"}[etags]
+		t.Run(name, func(t *testing.T) {
+			helper := NewTestHelper(t)
+			defer helper.Cleanup()
+
+			helper.CreateMockGiteaServer(map[string]MockRepo{
+				"user/site": {
+					Name:          "site",
+					FullName:      "user/site",
+					DefaultBranch: "main",
+					Commit:        "3f9a1c2b7d8e4f6a0b1c2d3e4f5a6b7c8d9e0f1a",
+					Files: map[string]string{
+						"index.html": "<h1>Site</h1>",
+						deep:         large,
+					},
+				},
+			})
+			helper.LimitRawPathLength(200)
+
+			gp := &GitteaPages{
+				GitteaURL:    helper.server.URL,
+				CacheDir:     t.TempDir(),
+				CacheMaxSize: 1024,
+				ETags:        etags,
+			}
+			if err := gp.Provision(caddy.Context{}); err != nil {
+				t.Fatalf("Failed to provision: %v", err)
+			}
+			helper.gp = gp
+
+			// The raw file URL is too long for the upstream
+			w := helper.MakeHTTPRequest("GET", "/user/site/"+deep, "", nil)
+			if w.Code == http.StatusOK {
+				t.Fatal("Expected the raw file endpoint to be unreachable for the deep path")
+			}
+
+			gp.BlobPathLength = 100
+			w = helper.MakeHTTPRequest("GET", "/user/site/"+deep, "", nil)
+			if w.Code != http.StatusOK || w.Body.String() != large {
+				t.Fatalf("Expected 200 with the file content, got %d: %.40q", w.Code, w.Body.String())
+			}
+
+			w = helper.MakeHTTPRequest("GET", "/user/site/"+deep, "", map[string]string{"Range": "bytes=0-9"})
+			if w.Code != http.StatusPartialContent || w.Body.String() != large[:10] {
+				t.Errorf("Expected 206 with the first 10 bytes, got %d: %q", w.Code, w.Body.String())
+			}
+
+			if _, cached := gp.trees.entries["user/site@3f9a1c2b7d8e4f6a0b1c2d3e4f5a6b7c8d9e0f1a"]; cached == etags {
+				t.Errorf("Expected tree cached %v, got %v", !etags, cached)
+			}
+		})
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_BlobPathLength(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		blob_path_length 1024
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.BlobPathLength != 1024 {
+		t.Errorf("Expected blob_path_length 1024, got %d", gp.BlobPathLength)
+	}
+}
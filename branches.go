@@ -0,0 +1,247 @@
+package giteapages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// maxPagesBranches caps how many branches ?pages=true checks, as each
+// costs up to one contents API request per index file
+const maxPagesBranches = 50
+
+// pagesCheckWorkers is how many branches ?pages=true checks at once
+const pagesCheckWorkers = 4
+
+// errTooManyBranches is returned by listBranches when a repository has
+// more branches than ?pages=true checks
+var errTooManyBranches = fmt.Errorf("too many branches to filter by pages content (limit %d)", maxPagesBranches)
+
+// branchInfo is one branch in the branches endpoint response
+type branchInfo struct {
+	Name   string `json:"name"`
+	Commit string `json:"commit,omitempty"`
+}
+
+// branchList is the document served by the branches endpoint
+type branchList struct {
+	Owner      string       `json:"owner"`
+	Repository string       `json:"repository"`
+	Branches   []branchInfo `json:"branches"`
+}
+
+// branchCache keeps branch lists fetched from Gitea for BranchesTTL
+type branchCache struct {
+	mu    sync.Mutex
+	lists map[string]branchCacheEntry
+}
+
+// branchCacheEntry is a cached branch list and when it was fetched
+type branchCacheEntry struct {
+	fetched  time.Time
+	branches []branchInfo
+}
+
+// giteaBranch is the subset of Gitea's branch object we use
+type giteaBranch struct {
+	Name   string `json:"name"`
+	Commit struct {
+		ID string `json:"id"`
+	} `json:"commit"`
+}
+
+// serveBranches lists a repository's branches for preview UIs. Requests
+// take the form {branches_path}/{owner}/{repo}; adding ?pages=true keeps
+// only branches that contain one of the index files, for repositories
+// with at most maxPagesBranches branches.
+func (gp *GitteaPages) serveBranches(w http.ResponseWriter, r *http.Request) error {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, gp.BranchesPath), "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		http.Error(w, "expected /{owner}/{repo}", http.StatusBadRequest)
+		return nil
+	}
+	owner, repo := parts[0], parts[1]
+
+	pagesOnly := false
+	switch r.URL.Query().Get("pages") {
+	case "true", "1":
+		pagesOnly = true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	token, reachable := gp.requestToken(r, owner, repo)
+	if !reachable {
+		w.WriteHeader(http.StatusNotFound)
+		return json.NewEncoder(w).Encode(map[string]string{"error": errRepoNotFound.Error()})
+	}
+	branches, err := gp.listBranches(withUpstreamToken(r.Context(), token), owner, repo, pagesOnly)
+	if err != nil {
+		status := http.StatusBadGateway
+		switch {
+		case errors.Is(err, errRepoNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, errTooManyBranches):
+			status = http.StatusBadRequest
+		}
+		w.WriteHeader(status)
+		return json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+	}
+
+	return json.NewEncoder(w).Encode(branchList{
+		Owner:      owner,
+		Repository: repo,
+		Branches:   branches,
+	})
+}
+
+// listBranches returns the repository's branches, from the branch cache
+// when the list was fetched within BranchesTTL
+func (gp *GitteaPages) listBranches(ctx context.Context, owner, repo string, pagesOnly bool) ([]branchInfo, error) {
+	key := fmt.Sprintf("%s/%s:%t", owner, repo, pagesOnly)
+
+	gp.branches.mu.Lock()
+	cached, ok := gp.branches.lists[key]
+	gp.branches.mu.Unlock()
+	if ok && time.Since(cached.fetched) < time.Duration(gp.BranchesTTL) {
+		return cached.branches, nil
+	}
+
+	branches, err := gp.fetchBranches(ctx, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	if pagesOnly {
+		if branches, err = gp.pagesBranches(ctx, owner, repo, branches); err != nil {
+			return nil, err
+		}
+	}
+
+	gp.branches.mu.Lock()
+	gp.branches.lists[key] = branchCacheEntry{fetched: time.Now(), branches: branches}
+	gp.branches.mu.Unlock()
+
+	return branches, nil
+}
+
+// pagesBranches keeps the branches that contain one of the index files,
+// checking a few at a time
+func (gp *GitteaPages) pagesBranches(ctx context.Context, owner, repo string, branches []branchInfo) ([]branchInfo, error) {
+	if len(branches) > maxPagesBranches {
+		return nil, errTooManyBranches
+	}
+
+	hasPages := make([]bool, len(branches))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(pagesCheckWorkers)
+	for i, branch := range branches {
+		g.Go(func() error {
+			var err error
+			hasPages[i], err = gp.hasPagesContent(gctx, owner, repo, branch.Name)
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	filtered := branches[:0]
+	for i, branch := range branches {
+		if hasPages[i] {
+			filtered = append(filtered, branch)
+		}
+	}
+	return filtered, nil
+}
+
+// fetchBranches reads every page of the Gitea branches API
+func (gp *GitteaPages) fetchBranches(ctx context.Context, owner, repo string) ([]branchInfo, error) {
+	client := &http.Client{Timeout: 30 * time.Second, Transport: gp.upstreamTransport()}
+	branches := []branchInfo{}
+
+	pageSize := gp.apiPageSize()
+	base := fmt.Sprintf("%s/api/v1/repos/%s/%s/branches",
+		strings.TrimRight(gp.GitteaURL, "/"), url.PathEscape(owner), url.PathEscape(repo))
+	apiURL := fmt.Sprintf("%s?page=1&limit=%d", base, pageSize)
+
+	for page := 1; ; page++ {
+		req, err := gp.newUpstreamRequest(ctx, apiURL)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var batch []giteaBranch
+		switch resp.StatusCode {
+		case http.StatusOK:
+			err = gp.decodeJSON(resp, &batch)
+		case http.StatusNotFound:
+			err = errRepoNotFound
+		default:
+			err = fmt.Errorf("gitea API returned status %d", resp.StatusCode)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, b := range batch {
+			branches = append(branches, branchInfo{Name: b.Name, Commit: b.Commit.ID})
+		}
+		next, linked := nextPageURL(apiURL, resp.Header)
+		if linked && next == "" || !linked && len(batch) < pageSize {
+			return branches, nil
+		}
+		if next == "" {
+			next = fmt.Sprintf("%s?page=%d&limit=%d", base, page+1, pageSize)
+		}
+		apiURL = next
+	}
+}
+
+// hasPagesContent reports whether branch contains one of the index files
+// at its root, asking the Gitea contents API
+func (gp *GitteaPages) hasPagesContent(ctx context.Context, owner, repo, branch string) (bool, error) {
+	client := &http.Client{Timeout: 30 * time.Second, Transport: gp.upstreamTransport()}
+
+	for _, indexFile := range gp.IndexFiles {
+		if !gp.isServableIndex(indexFile) {
+			continue
+		}
+		apiURL := fmt.Sprintf("%s/api/v1/repos/%s/%s/contents/%s?ref=%s",
+			strings.TrimRight(gp.GitteaURL, "/"), url.PathEscape(owner), url.PathEscape(repo),
+			escapePath(indexFile), url.QueryEscape(branch))
+
+		req, err := gp.newUpstreamRequest(ctx, apiURL)
+		if err != nil {
+			return false, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return false, err
+		}
+		resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return true, nil
+		case http.StatusNotFound:
+			continue
+		default:
+			return false, fmt.Errorf("gitea API returned status %d", resp.StatusCode)
+		}
+	}
+	return false, nil
+}
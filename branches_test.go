@@ -0,0 +1,131 @@
+package giteapages
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestServeBranches(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	repos := GenerateTestRepos()
+	website := repos["user/website"]
+	website.Branches = map[string]map[string]string{
+		"dev":          {"index.html": "<h1>Dev</h1>"},
+		"feature-blog": {"index.htm": "<h1>Blog</h1>"},
+		"scripts":      {"build.sh": "#!/bin/sh"},
+	}
+	repos["user/website"] = website
+
+	helper.CreateMockGiteaServer(repos)
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.BranchesPath = "/_pages/branches"
+
+	tests := []struct {
+		name     string
+		path     string
+		expected []string
+	}{
+		{
+			name:     "all branches",
+			path:     "/_pages/branches/user/website",
+			expected: []string{"main", "dev", "feature-blog", "scripts"},
+		},
+		{
+			name:     "branches with pages content",
+			path:     "/_pages/branches/user/website?pages=true",
+			expected: []string{"main", "dev", "feature-blog"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := helper.MakeHTTPRequest("GET", tt.path, "", nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Expected Content-Type 'application/json', got '%s'", ct)
+			}
+
+			var list branchList
+			if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if list.Owner != "user" || list.Repository != "website" {
+				t.Errorf("Expected user/website, got %s/%s", list.Owner, list.Repository)
+			}
+
+			var names []string
+			for _, b := range list.Branches {
+				names = append(names, b.Name)
+			}
+			if len(names) != len(tt.expected) {
+				t.Fatalf("Expected branches %v, got %v", tt.expected, names)
+			}
+			for i := range names {
+				if names[i] != tt.expected[i] {
+					t.Errorf("Expected branches %v, got %v", tt.expected, names)
+					break
+				}
+			}
+			if list.Branches[0].Commit != website.Commit {
+				t.Errorf("Expected main commit '%s', got '%s'", website.Commit, list.Branches[0].Commit)
+			}
+		})
+	}
+
+	// A repeat request is answered from the branch cache
+	before := helper.BranchCalls()
+	helper.MakeHTTPRequest("GET", "/_pages/branches/user/website", "", nil)
+	if after := helper.BranchCalls(); after != before {
+		t.Errorf("Expected cached branch list, got %d extra Gitea calls", after-before)
+	}
+
+	w := helper.MakeHTTPRequest("GET", "/_pages/branches/nobody/missing", "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown repository, got %d", w.Code)
+	}
+
+	w = helper.MakeHTTPRequest("GET", "/_pages/branches/user", "", nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for malformed path, got %d", w.Code)
+	}
+}
+
+func TestServeBranches_PagesLimit(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	repos := GenerateTestRepos()
+	website := repos["user/website"]
+	website.Branches = make(map[string]map[string]string)
+	for i := 0; i < maxPagesBranches; i++ {
+		website.Branches[fmt.Sprintf("feature-%02d", i)] = map[string]string{"index.html": "<h1>Preview</h1>"}
+	}
+	repos["user/website"] = website
+
+	helper.CreateMockGiteaServer(repos)
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.BranchesPath = "/_pages/branches"
+
+	// The default branch makes one more than are checked for pages
+	w := helper.MakeHTTPRequest("GET", "/_pages/branches/user/website?pages=true", "", nil)
+	helper.AssertResponse(w, http.StatusBadRequest, "too many branches")
+	api, _ := helper.UpstreamCalls()
+	if api != helper.BranchCalls() {
+		t.Errorf("Expected no contents API calls, got %d", api-helper.BranchCalls())
+	}
+
+	w = helper.MakeHTTPRequest("GET", "/_pages/branches/user/website", "", nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected the unfiltered list to be served, got %d", w.Code)
+	}
+}
@@ -0,0 +1,85 @@
+package giteapages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// deletedBranch reports whether err, the failure serving branch, comes
+// from a branch Gitea no longer has in a repository that still exists,
+// e.g. the preview of a merged and deleted feature branch. The branches
+// API is asked, so an archive missing for another reason does not count.
+// The repository's information is returned for the fallback's use.
+func (gp *GitteaPages) deletedBranch(ctx context.Context, owner, repo, branch string, err error) (*GitteaRepo, bool) {
+	if !errors.Is(err, errArchiveNotFound) || gp.pinnedCommit(owner, repo) != "" {
+		return nil, false
+	}
+
+	info, infoErr := gp.getRepoInfo(ctx, owner, repo)
+	if infoErr != nil {
+		return nil, false
+	}
+	branches, listErr := gp.listBranches(ctx, owner, repo, false)
+	if listErr != nil {
+		gp.logger.Debug("failed to list branches for deleted branch",
+			zap.String("repo", owner+"/"+repo),
+			zap.Error(listErr))
+		return nil, false
+	}
+	for _, b := range branches {
+		if b.Name == branch {
+			return nil, false
+		}
+	}
+	return info, true
+}
+
+// serveDeletedBranch applies the policies for a request to a deleted
+// branch: BranchFallback serves the repository's default branch instead,
+// and MissingBranch otherwise answers with its own response. err is
+// returned unchanged when the branch is not deleted or neither applies.
+func (gp *GitteaPages) serveDeletedBranch(w http.ResponseWriter, r *http.Request, owner, repo, filePath, branch string, err error) error {
+	info, deleted := gp.deletedBranch(r.Context(), owner, repo, branch, err)
+	if !deleted {
+		return err
+	}
+
+	if gp.BranchFallback {
+		fallback := info.DefaultBranch
+		if fallback == "" {
+			fallback = gp.DefaultBranch
+		}
+		if fallback != branch {
+			return gp.serveBranchFallback(w, r, owner, repo, filePath, branch, fallback)
+		}
+	}
+	if gp.MissingBranch != nil {
+		gp.serveMissingBranch(w, owner, repo, branch)
+		return nil
+	}
+	return err
+}
+
+// serveBranchFallback serves a request for branch, which Gitea no longer
+// has, from fallback, the repository's default branch. The response
+// carries the missing branch in X-Pages-Branch-Fallback.
+func (gp *GitteaPages) serveBranchFallback(w http.ResponseWriter, r *http.Request, owner, repo, filePath, branch, fallback string) error {
+	gp.logger.Info("branch not found, serving default branch",
+		zap.String("repo", owner+"/"+repo),
+		zap.String("branch", branch),
+		zap.String("fallback", fallback))
+
+	w.Header().Set("X-Pages-Branch-Fallback", branch)
+	r = withRoute(r, Route{
+		Owner:    owner,
+		Repo:     repo,
+		Branch:   fallback,
+		Path:     filePath,
+		CacheHit: !gp.shouldUpdateCache(fmt.Sprintf("%s/%s", owner, repo), fallback),
+	})
+	return gp.serveFile(w, r, owner, repo, filePath, fallback)
+}
@@ -0,0 +1,75 @@
+package giteapages
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_BranchFallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		fallback bool
+		path     string
+		status   int
+		body     string
+		header   string
+		listed   []branchInfo // branch list cached in place of the API's
+	}{
+		{"deleted branch falls back", true, "/user/site/@feature-gone/about.html", http.StatusOK, "Main About", "feature-gone", nil},
+		{"deleted branch without fallback", false, "/user/site/@feature-gone/about.html", http.StatusNotFound, "Not handled by gitea-pages", "", nil},
+		{"existing branch is served", true, "/user/site/@preview/about.html", http.StatusOK, "Preview About", "", nil},
+		// The branches API lists it, so the 404 is not a deletion
+		{"existing branch missing its archive", true, "/user/site/@broken/about.html", http.StatusNotFound, "Not handled by gitea-pages", "",
+			[]branchInfo{{Name: "main"}, {Name: "broken"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helper := NewTestHelper(t)
+			defer helper.Cleanup()
+
+			helper.CreateMockGiteaServer(map[string]MockRepo{
+				"user/site": {
+					Name:          "site",
+					FullName:      "user/site",
+					DefaultBranch: "main",
+					Files:         map[string]string{"about.html": "<h1>Main About</h1>"},
+					Branches: map[string]map[string]string{
+						"preview": {"about.html": "<h1>Preview About</h1>"},
+					},
+					StrictBranches: true,
+				},
+			})
+			gp := helper.SetupGiteaPages(GitteaPagesConfig{
+				GitteaURL: helper.server.URL,
+			})
+			gp.BranchFallback = tt.fallback
+			if tt.listed != nil {
+				gp.branches.lists["user/site:false"] = branchCacheEntry{fetched: time.Now(), branches: tt.listed}
+			}
+
+			w := helper.MakeHTTPRequest("GET", tt.path, "", nil)
+			helper.AssertResponse(w, tt.status, tt.body)
+			if got := w.Header().Get("X-Pages-Branch-Fallback"); got != tt.header {
+				t.Errorf("Expected X-Pages-Branch-Fallback %q, got %q", tt.header, got)
+			}
+		})
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_BranchFallback(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		branch_fallback
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if !gp.BranchFallback {
+		t.Error("Expected branch_fallback to be enabled")
+	}
+}
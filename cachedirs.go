@@ -0,0 +1,70 @@
+package giteapages
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+// createdDirs remembers the cache subdirectories this instance has
+// created, such as the per-owner directories every download of an
+// owner's repositories extracts into. Concurrent downloads then create
+// each one once instead of racing through stat, mkdir and chmod, and
+// none of them writes into a directory whose CacheDirMode is not yet set.
+type createdDirs struct {
+	mu     sync.Mutex
+	dirs   map[string]*createdDir
+	create func(dir string) error
+}
+
+// createdDir serializes the creation of one directory
+type createdDir struct {
+	mu   sync.Mutex
+	done bool
+}
+
+func newCreatedDirs(create func(dir string) error) *createdDirs {
+	return &createdDirs{dirs: make(map[string]*createdDir), create: create}
+}
+
+// ensure creates dir unless an earlier call succeeded. Callers for the
+// same directory wait for the one creating it; a failure is not
+// remembered, so the next caller tries again.
+func (c *createdDirs) ensure(dir string) error {
+	c.mu.Lock()
+	d, ok := c.dirs[dir]
+	if !ok {
+		d = &createdDir{}
+		c.dirs[dir] = d
+	}
+	c.mu.Unlock()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.done {
+		return nil
+	}
+	if err := c.create(dir); err != nil {
+		return err
+	}
+	d.done = true
+	return nil
+}
+
+// ensureCacheDir creates dir, a cache subdirectory shared by several
+// entries, once per instance with makeCacheDir's permissions
+func (gp *GitteaPages) ensureCacheDir(dir string) error {
+	if gp.dirs == nil {
+		return gp.makeCacheDir(dir, 0755)
+	}
+	return gp.dirs.ensure(dir)
+}
+
+// makeEntryDir creates an entry's extraction directory. Its parent is
+// shared with the owner's other entries; should it have been removed
+// behind the cache's back, makeCacheDir recreates it.
+func (gp *GitteaPages) makeEntryDir(dir string) error {
+	if err := gp.ensureCacheDir(filepath.Dir(dir)); err != nil {
+		return err
+	}
+	return gp.makeCacheDir(dir, 0755)
+}
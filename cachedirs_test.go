@@ -0,0 +1,142 @@
+package giteapages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestCreatedDirs_Ensure(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "owner")
+	var calls atomic.Int32
+	dirs := newCreatedDirs(func(dir string) error {
+		calls.Add(1)
+		return os.Mkdir(dir, 0755)
+	})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- dirs.ensure(dir)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("ensure failed: %v", err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Expected the directory to be created once, got %d", n)
+	}
+}
+
+func TestCreatedDirs_RetriesFailure(t *testing.T) {
+	fail := errors.New("disk full")
+	calls := 0
+	dirs := newCreatedDirs(func(string) error {
+		calls++
+		if calls == 1 {
+			return fail
+		}
+		return nil
+	})
+
+	if err := dirs.ensure("/cache/owner"); !errors.Is(err, fail) {
+		t.Fatalf("Expected the first failure, got %v", err)
+	}
+	if err := dirs.ensure("/cache/owner"); err != nil {
+		t.Fatalf("Expected the retry to succeed, got %v", err)
+	}
+	if err := dirs.ensure("/cache/owner"); err != nil || calls != 2 {
+		t.Errorf("Expected no further attempts, got %d calls, %v", calls, err)
+	}
+}
+
+func TestConcurrentDownloadsShareOwnerDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("POSIX permissions are not supported on Windows")
+	}
+
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	const count = 20
+	repos := make(map[string]MockRepo)
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("site%d", i)
+		repos["team/"+name] = MockRepo{
+			Name:          name,
+			FullName:      "team/" + name,
+			DefaultBranch: "main",
+			Files: map[string]string{
+				"index.html": "<h1>" + name + "</h1>",
+			},
+		}
+	}
+	helper.CreateMockGiteaServer(repos)
+	gp := &GitteaPages{
+		GitteaURL:    helper.server.URL,
+		CacheDir:     filepath.Join(t.TempDir(), "cache"),
+		CacheDirMode: "0750",
+	}
+	if err := gp.Provision(caddy.Context{}); err != nil {
+		t.Fatalf("Failed to provision: %v", err)
+	}
+	helper.gp = gp
+
+	ownerDir := filepath.Dir(gp.cache.entryPath("team", "site0", "main"))
+	var created atomic.Int32
+	gp.dirs = newCreatedDirs(func(dir string) error {
+		if dir == ownerDir {
+			created.Add(1)
+		}
+		return gp.makeCacheDir(dir, 0755)
+	})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, count)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- gp.updateRepoCache(context.Background(), "team", fmt.Sprintf("site%d", i), "main")
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Download failed: %v", err)
+		}
+	}
+	if n := created.Load(); n != 1 {
+		t.Errorf("Expected the owner directory to be created once, got %d", n)
+	}
+
+	info, err := os.Stat(ownerDir)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", ownerDir, err)
+	}
+	if got := info.Mode().Perm(); got != 0750 {
+		t.Errorf("Expected owner directory mode 750, got %o", got)
+	}
+
+	for i := 0; i < count; i++ {
+		w := helper.MakeHTTPRequest("GET", fmt.Sprintf("/team/site%d/", i), "", nil)
+		helper.AssertResponse(w, http.StatusOK, fmt.Sprintf("<h1>site%d</h1>", i))
+	}
+}
@@ -0,0 +1,73 @@
+package giteapages
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// canonicalTarget returns the host r should be redirected to under
+// CanonicalHost, or "" when the request is already canonical or the
+// canonical host is not known to be able to serve HTTPS
+func (gp *GitteaPages) canonicalTarget(r *http.Request) string {
+	if gp.CanonicalHost == "" {
+		return ""
+	}
+
+	host, port := r.Host, ""
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host, port = h, p
+	}
+	if net.ParseIP(host) != nil || !strings.Contains(host, ".") {
+		return ""
+	}
+
+	var target string
+	switch gp.CanonicalHost {
+	case "www":
+		if strings.HasPrefix(host, "www.") {
+			return ""
+		}
+		target = "www." + host
+	case "apex":
+		if !strings.HasPrefix(host, "www.") {
+			return ""
+		}
+		target = strings.TrimPrefix(host, "www.")
+	default:
+		return ""
+	}
+
+	// Redirecting to a host without a certificate would leave visitors
+	// stuck on a TLS error, so it must be allowed explicitly or covered by
+	// a certificate Caddy manages
+	if !hostListed(gp.CanonicalHostAllow, target) &&
+		(gp.certificateFunc == nil || !gp.certificateFunc(target)) {
+		return ""
+	}
+
+	if port != "" {
+		target = net.JoinHostPort(target, port)
+	}
+	return target
+}
+
+// redirectToCanonical sends a permanent redirect to the same URL on host,
+// keeping the scheme the client used
+func redirectToCanonical(w http.ResponseWriter, r *http.Request, host string) {
+	target := url.URL{Scheme: requestScheme(r), Host: host, Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: r.URL.RawQuery}
+	http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+}
+
+// requestScheme returns the scheme the client used for r, trusting
+// X-Forwarded-Proto only from a trusted proxy
+func requestScheme(r *http.Request) string {
+	if proto := forwardedProto(r); proto != "" {
+		return strings.ToLower(proto)
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,89 @@
+package giteapages
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_CanonicalHost(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+		DomainMappings: []DomainMapping{
+			{Domain: "allowed.com", Owner: "user", Repository: "site"},
+			{Domain: "managed.com", Owner: "user", Repository: "site"},
+			{Domain: "bare.com", Owner: "user", Repository: "site"},
+		},
+	})
+	helper.CreateCacheEntry("user/site", "main", map[string]string{
+		"page.html": "<h1>Site</h1>",
+	})
+	gp.StripHostPrefix = "www."
+	gp.CanonicalHostAllow = []string{"www.allowed.com", "allowed.com"}
+	gp.certificateFunc = func(host string) bool {
+		return host == "www.managed.com"
+	}
+
+	tests := []struct {
+		name             string
+		mode             string
+		host             string
+		headers          map[string]string
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{"allowed target", "www", "allowed.com", nil, http.StatusMovedPermanently, "http://www.allowed.com/page.html?x=1"},
+		{"certificate managed by Caddy", "www", "managed.com", nil, http.StatusMovedPermanently, "http://www.managed.com/page.html?x=1"},
+		{"no certificate, served in place", "www", "bare.com", nil, http.StatusOK, ""},
+		{"already canonical", "www", "www.allowed.com", nil, http.StatusOK, ""},
+		{"port and forwarded scheme kept", "www", "allowed.com:8443", map[string]string{"X-Forwarded-Proto": "https"},
+			http.StatusMovedPermanently, "https://www.allowed.com:8443/page.html?x=1"},
+		{"apex", "apex", "www.allowed.com", nil, http.StatusMovedPermanently, "http://allowed.com/page.html?x=1"},
+		{"apex without certificate", "apex", "www.bare.com", nil, http.StatusOK, ""},
+		{"apex already canonical", "apex", "allowed.com", nil, http.StatusOK, ""},
+	}
+
+	helper.TrustProxies()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gp.CanonicalHost = tt.mode
+			w := helper.MakeHTTPRequest("GET", "/page.html?x=1", tt.host, tt.headers)
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if loc := w.Header().Get("Location"); loc != tt.expectedLocation {
+				t.Errorf("Expected Location '%s', got '%s'", tt.expectedLocation, loc)
+			}
+		})
+	}
+
+	// A forwarded scheme from a client that is not a trusted proxy is ignored
+	helper.trustProxies = false
+	gp.CanonicalHost = "www"
+	w := helper.MakeHTTPRequest("GET", "/page.html?x=1", "allowed.com", map[string]string{"X-Forwarded-Proto": "https"})
+	if loc := w.Header().Get("Location"); loc != "http://www.allowed.com/page.html?x=1" {
+		t.Errorf("Expected the untrusted scheme ignored, got Location '%s'", loc)
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_CanonicalHost(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		canonical_host www www.example.com www.example.org
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.CanonicalHost != "www" {
+		t.Errorf("Expected canonical_host 'www', got '%s'", gp.CanonicalHost)
+	}
+	if len(gp.CanonicalHostAllow) != 2 || gp.CanonicalHostAllow[1] != "www.example.org" {
+		t.Errorf("Expected two allowed hosts, got %v", gp.CanonicalHostAllow)
+	}
+}
@@ -0,0 +1,122 @@
+package giteapages
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// commitSHAPattern matches abbreviated and full SHA-1 or SHA-256 commits
+var commitSHAPattern = regexp.MustCompile(`^[0-9a-f]{7,64}$`)
+
+// commitPins holds the commit each pinned "owner/repo" is served from.
+// It starts from the CommitPins option and changes at runtime through
+// the admin API.
+type commitPins struct {
+	mu   sync.RWMutex
+	shas map[string]string
+}
+
+// normalizeCommitPin validates an "owner/repo" and commit pair, returning
+// the commit lower-cased
+func normalizeCommitPin(repoKey, sha string) (string, error) {
+	if owner, repo, ok := strings.Cut(repoKey, "/"); !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+		return "", fmt.Errorf("commit pin %q: expected owner/repo", repoKey)
+	}
+	sha = strings.ToLower(sha)
+	if !commitSHAPattern.MatchString(sha) {
+		return "", fmt.Errorf("commit pin %q: %q is not a commit SHA", repoKey, sha)
+	}
+	return sha, nil
+}
+
+// pinnedCommit returns the commit owner/repo is pinned to, or ""
+func (gp *GitteaPages) pinnedCommit(owner, repo string) string {
+	if gp.commitPins == nil {
+		return ""
+	}
+	gp.commitPins.mu.RLock()
+	defer gp.commitPins.mu.RUnlock()
+	return gp.commitPins.shas[owner+"/"+repo]
+}
+
+// isPinnedCommitKey reports whether cacheKey is the entry of a pinned
+// commit, which cannot change and so never needs refreshing
+func (gp *GitteaPages) isPinnedCommitKey(cacheKey string) bool {
+	repoKey, ref, ok := strings.Cut(cacheKey, ":")
+	if !ok {
+		return false
+	}
+	owner, repo, _ := strings.Cut(repoKey, "/")
+	sha := gp.pinnedCommit(owner, repo)
+	return sha != "" && sha == ref
+}
+
+// setCommitPin pins repoKey to sha, or unpins it when sha is empty
+func (gp *GitteaPages) setCommitPin(repoKey, sha string) {
+	gp.commitPins.mu.Lock()
+	defer gp.commitPins.mu.Unlock()
+	if sha == "" {
+		delete(gp.commitPins.shas, repoKey)
+		return
+	}
+	gp.commitPins.shas[repoKey] = sha
+}
+
+// commitPinRequest is the body of POST and DELETE requests
+type commitPinRequest struct {
+	Repo   string `json:"repo"`
+	Commit string `json:"commit,omitempty"`
+}
+
+// handleCommitPins serves the /gitea_pages/commit_pins admin API endpoint.
+// GET lists the pins of every running gitea_pages handler; POST with
+// {"repo": "owner/repo", "commit": "<sha>"} pins a repository in all of
+// them, and DELETE with {"repo": "owner/repo"} unpins it. Changes apply
+// immediately and last until the next config load.
+func (AdminAPI) handleCommitPins(w http.ResponseWriter, r *http.Request) error {
+	handlers := runningHandlers()
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodDelete:
+		var req commitPinRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("decoding request: %v", err)}
+		}
+		sha := ""
+		if r.Method == http.MethodPost {
+			var err error
+			if sha, err = normalizeCommitPin(req.Repo, req.Commit); err != nil {
+				return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: err}
+			}
+		} else if _, _, ok := strings.Cut(req.Repo, "/"); !ok {
+			return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("commit pin %q: expected owner/repo", req.Repo)}
+		}
+		if len(handlers) == 0 {
+			return caddy.APIError{HTTPStatus: http.StatusNotFound, Err: fmt.Errorf("no gitea_pages handlers are running")}
+		}
+		for _, gp := range handlers {
+			gp.setCommitPin(req.Repo, sha)
+		}
+	default:
+		return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: fmt.Errorf("method not allowed")}
+	}
+
+	// Respond with the pins now in effect
+	pins := make(map[string]string)
+	for _, gp := range handlers {
+		gp.commitPins.mu.RLock()
+		for repoKey, sha := range gp.commitPins.shas {
+			pins[repoKey] = sha
+		}
+		gp.commitPins.mu.RUnlock()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(pins)
+}
@@ -0,0 +1,119 @@
+package giteapages
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_CommitPins(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Files:         map[string]string{"page.html": "<h1>Main</h1>"},
+			Branches: map[string]map[string]string{
+				"aaaaaaa1": {"page.html": "<h1>Release A</h1>"},
+				"bbbbbbb2": {"page.html": "<h1>Release B</h1>"},
+				"develop":  {"page.html": "<h1>Develop</h1>"},
+			},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.setCommitPin("user/site", "aaaaaaa1")
+
+	w := helper.MakeHTTPRequest("GET", "/user/site/page.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "Release A")
+
+	// The pin overrides a branch named in the path too
+	w = helper.MakeHTTPRequest("GET", "/user/site/@develop/page.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "Release A")
+
+	if !gp.isPinned("user/site:aaaaaaa1") {
+		t.Error("Expected the pinned commit's cache entry to be pinned")
+	}
+	gp.cache.mu.RLock()
+	_, cached := gp.cache.repos["user/site:aaaaaaa1"]
+	gp.cache.mu.RUnlock()
+	if !cached {
+		t.Error("Expected the cache key to carry the pinned commit")
+	}
+
+	// Roll forward at runtime through the admin API
+	admin := AdminAPI{}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/gitea_pages/commit_pins",
+		strings.NewReader(`{"repo": "user/site", "commit": "BBBBBBB2"}`))
+	if err := admin.handleCommitPins(rec, req); err != nil {
+		t.Fatalf("POST commit pin failed: %v", err)
+	}
+	if !strings.Contains(rec.Body.String(), `"user/site":"bbbbbbb2"`) {
+		t.Errorf("Expected the new pin in the response, got %s", rec.Body.String())
+	}
+	w = helper.MakeHTTPRequest("GET", "/user/site/page.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "Release B")
+
+	// Unpinning returns to branch resolution
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest("DELETE", "/gitea_pages/commit_pins", strings.NewReader(`{"repo": "user/site"}`))
+	if err := admin.handleCommitPins(rec, req); err != nil {
+		t.Fatalf("DELETE commit pin failed: %v", err)
+	}
+	w = helper.MakeHTTPRequest("GET", "/user/site/page.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "Main")
+}
+
+func TestAdminAPI_Errors(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+	helper.SetupGiteaPages(GitteaPagesConfig{GitteaURL: "https://git.example.com"})
+
+	tests := []struct {
+		method string
+		body   string
+		status int
+	}{
+		{"POST", `{"repo": "user/site", "commit": "main"}`, http.StatusBadRequest},
+		{"POST", `{"repo": "site", "commit": "aaaaaaa1"}`, http.StatusBadRequest},
+		{"POST", `not json`, http.StatusBadRequest},
+		{"PUT", `{}`, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/gitea_pages/commit_pins", strings.NewReader(tt.body))
+		err := AdminAPI{}.handleCommitPins(httptest.NewRecorder(), req)
+		apiErr, ok := err.(caddy.APIError)
+		if !ok || apiErr.HTTPStatus != tt.status {
+			t.Errorf("%s %s: expected status %d, got %v", tt.method, tt.body, tt.status, err)
+		}
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_PinCommit(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		pin_commit user/site 0123abcd
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.CommitPins["user/site"] != "0123abcd" {
+		t.Errorf("Expected commit pin, got %v", gp.CommitPins)
+	}
+
+	gp.CommitPins["user/other"] = "not-a-sha"
+	if err := gp.Validate(); err == nil || !strings.Contains(err.Error(), "not a commit SHA") {
+		t.Errorf("Expected invalid commit pin error, got %v", err)
+	}
+}
@@ -0,0 +1,278 @@
+package giteapages
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sniffLen is how much of a file's head is inspected to decide whether it
+// is worth compressing, matching what http.DetectContentType considers
+const sniffLen = 512
+
+// compressedMagic lists signatures of compressed formats
+// http.DetectContentType does not recognise
+var compressedMagic = [][]byte{
+	[]byte("\x28\xb5\x2f\xfd"),     // zstd
+	[]byte("\xfd7zXZ\x00"),         // xz
+	[]byte("BZh"),                  // bzip2
+	[]byte("7z\xbc\xaf\x27\x1c"),   // 7-Zip
+	[]byte("\x00\x00\x00\x18ftyp"), // MP4/AVIF/HEIC
+	[]byte("\x00\x00\x00\x1cftyp"), // MP4/AVIF/HEIC
+	[]byte("\x00\x00\x00\x20ftyp"), // MP4/AVIF/HEIC
+	[]byte("\x1f\x8b"),             // gzip
+}
+
+// incompressibleType reports whether a MIME type denotes data that is
+// already compressed, so gzipping it again wastes CPU for no gain
+func incompressibleType(ctype string) bool {
+	ctype, _, _ = strings.Cut(ctype, ";")
+	ctype = strings.ToLower(strings.TrimSpace(ctype))
+	switch ctype {
+	case "image/svg+xml", "image/bmp", "image/x-icon", "image/vnd.microsoft.icon":
+		return false
+	case "application/zip", "application/gzip", "application/x-gzip",
+		"application/x-rar-compressed", "application/vnd.rar",
+		"application/x-7z-compressed", "application/x-bzip2", "application/x-xz",
+		"application/zstd", "application/pdf", "application/epub+zip",
+		"font/woff", "font/woff2", "application/font-woff":
+		return true
+	}
+	return strings.HasPrefix(ctype, "image/") ||
+		strings.HasPrefix(ctype, "video/") ||
+		strings.HasPrefix(ctype, "audio/")
+}
+
+// compressible decides whether the file name, starting with head, is worth
+// gzip-compressing. NoCompressExtensions and CompressExtensions override
+// the decision; otherwise both the type implied by the extension and the
+// one sniffed from head must be compressible, so a PNG mislabelled as
+// text is still recognised.
+func (gp *GitteaPages) compressible(name string, head []byte) bool {
+	if extensionListed(gp.NoCompressExtensions, name) {
+		return false
+	}
+	if extensionListed(gp.CompressExtensions, name) {
+		return true
+	}
+	if incompressibleType(mime.TypeByExtension(filepath.Ext(name))) {
+		return false
+	}
+	if len(head) == 0 {
+		return true
+	}
+	if incompressibleType(http.DetectContentType(head)) {
+		return false
+	}
+	for _, magic := range compressedMagic {
+		if bytes.HasPrefix(head, magic) {
+			return false
+		}
+	}
+	return true
+}
+
+// extensionListed reports whether name's extension is in exts, which may
+// be given with or without the leading dot
+func extensionListed(exts []string, name string) bool {
+	ext := filepath.Ext(name)
+	if ext == "" {
+		return false
+	}
+	for _, e := range exts {
+		if !strings.HasPrefix(e, ".") {
+			e = "." + e
+		}
+		if strings.EqualFold(ext, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCachedFile copies src into dst, gzip-compressing it when CompressCache
+// is enabled. Files not worth compressing are still wrapped in gzip, using
+// stored blocks, so every file of a compressed entry has the same format.
+func (gp *GitteaPages) writeCachedFile(dst io.Writer, src io.Reader, name string) error {
+	if !gp.CompressCache {
+		_, err := io.Copy(dst, src)
+		return err
+	}
+
+	br := bufio.NewReaderSize(src, sniffLen)
+	head, _ := br.Peek(sniffLen)
+	level := gzip.DefaultCompression
+	if !gp.compressible(name, head) {
+		level = gzip.NoCompression
+	}
+
+	gz, err := gzip.NewWriterLevel(dst, level)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(gz, br); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// readCachedFile returns the contents of a cached file, decompressing
+// entries stored gzip-compressed
+func readCachedFile(path string, compressed bool) ([]byte, error) {
+	if !compressed {
+		return os.ReadFile(path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return io.ReadAll(gz)
+}
+
+// serveCompressedFile serves a gzip-compressed cache file. Clients that
+// accept gzip get the stored bytes as-is, unless the file is not worth
+// compressing; everyone else gets them decompressed.
+func (gp *GitteaPages) serveCompressedFile(w http.ResponseWriter, r *http.Request, fullPath string) error {
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+	ctype := w.Header().Get("Content-Type")
+	if ctype == "" {
+		ctype = mime.TypeByExtension(filepath.Ext(fullPath))
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	head := make([]byte, sniffLen)
+	n, _ := io.ReadFull(gz, head)
+	head = head[:n]
+
+	if acceptsGzip(r.Header.Get("Accept-Encoding")) && gp.compressible(fullPath, head) {
+		// http.ServeContent would sniff the compressed bytes, so detect the
+		// type from the decompressed head when the extension is unknown
+		if ctype == "" {
+			ctype = http.DetectContentType(head)
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", ctype)
+		w.Header().Set("Content-Encoding", "gzip")
+		if etag := gzipETag(w.Header()); etag != "" {
+			// The stored bytes are a different representation, so
+			// ranges over them need their own validator
+			w.Header().Set("ETag", etag)
+		}
+		http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+		return nil
+	}
+
+	if ctype != "" {
+		w.Header().Set("Content-Type", ctype)
+	}
+	body, err := newGunzipSeeker(f, info.Size())
+	if err != nil {
+		return err
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), body)
+	return nil
+}
+
+// gunzipSeeker is the decompressed content of a gzip file as an
+// io.ReadSeeker, so http.ServeContent can answer ranges without holding
+// the file in memory. A read after seeking forwards decompresses and
+// discards up to the position; after seeking backwards it starts over.
+type gunzipSeeker struct {
+	f    *os.File
+	gz   *gzip.Reader
+	size int64 // of the decompressed content
+	pos  int64 // where the next Read starts
+	at   int64 // where gz is
+}
+
+// newGunzipSeeker reads the gzip file f, of compressedSize bytes. The
+// decompressed size comes from the gzip trailer, which records it modulo
+// 4GiB; a cache entry holding such a file is not a pages site.
+func newGunzipSeeker(f *os.File, compressedSize int64) (*gunzipSeeker, error) {
+	var trailer [4]byte
+	if _, err := f.ReadAt(trailer[:], compressedSize-4); err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return &gunzipSeeker{f: f, gz: gz, size: int64(binary.LittleEndian.Uint32(trailer[:]))}, nil
+}
+
+func (s *gunzipSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += s.size
+	}
+	if offset < 0 {
+		return 0, errors.New("gunzipSeeker: negative position")
+	}
+	s.pos = offset
+	return offset, nil
+}
+
+func (s *gunzipSeeker) Read(p []byte) (int, error) {
+	if s.pos < s.at {
+		if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+		if err := s.gz.Reset(s.f); err != nil {
+			return 0, err
+		}
+		s.at = 0
+	}
+	if s.pos > s.at {
+		n, err := io.CopyN(io.Discard, s.gz, s.pos-s.at)
+		s.at += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := s.gz.Read(p)
+	s.at += int64(n)
+	s.pos = s.at
+	return n, err
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(acceptEncoding string) bool {
+	return acceptsEncoding(acceptEncoding, "gzip")
+}
@@ -0,0 +1,238 @@
+package giteapages
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServeHTTP_CompressCache(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	page := "<h1>Docs</h1>\n" + strings.Repeat("<p>Lorem ipsum dolor sit amet.</p>\n", 200)
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/docs": {
+			Name:          "docs",
+			FullName:      "user/docs",
+			DefaultBranch: "main",
+			Files: map[string]string{
+				"index.html": page,
+				"guide.html": page,
+				"notes":      "plain notes",
+			},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL:     helper.server.URL,
+		CompressCache: true,
+	})
+
+	w := helper.MakeHTTPRequest("GET", "/user/docs/guide.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "<h1>Docs</h1>")
+	if w.Body.String() != page {
+		t.Errorf("Expected decompressed body of %d bytes, got %d", len(page), w.Body.Len())
+	}
+	if enc := w.Header().Get("Content-Encoding"); enc != "" {
+		t.Errorf("Expected no Content-Encoding for non-gzip client, got '%s'", enc)
+	}
+
+	entry := gp.cache.repos["user/docs:main"]
+	if entry == nil || !entry.compressed {
+		t.Fatal("Expected compressed cache entry")
+	}
+	info, err := os.Stat(filepath.Join(entry.path, "guide.html"))
+	if err != nil {
+		t.Fatalf("Failed to stat cached file: %v", err)
+	}
+	if info.Size() >= int64(len(page)) {
+		t.Errorf("Expected stored file smaller than %d bytes, got %d", len(page), info.Size())
+	}
+
+	w = helper.MakeHTTPRequest("GET", "/user/docs/guide.html", "", map[string]string{
+		"Accept-Encoding": "br, gzip",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if enc := w.Header().Get("Content-Encoding"); enc != "gzip" {
+		t.Errorf("Expected Content-Encoding 'gzip', got '%s'", enc)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Expected text/html Content-Type, got '%s'", ct)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("Expected gzip body: %v", err)
+	}
+	body, _ := io.ReadAll(gz)
+	if string(body) != page {
+		t.Errorf("Expected gzip body to decompress to the page, got %d bytes", len(body))
+	}
+
+	// Directory indexes and extensionless files are served from the
+	// compressed copy too
+	w = helper.MakeHTTPRequest("GET", "/user/docs/", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "<h1>Docs</h1>")
+
+	w = helper.MakeHTTPRequest("GET", "/user/docs/notes", "", map[string]string{
+		"Accept-Encoding": "gzip",
+	})
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Expected sniffed text/plain Content-Type, got '%s'", ct)
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip;q=0.5", true},
+		{"GZIP", true},
+		{"gzip;q=0", false},
+		{"br", false},
+	}
+
+	for _, tt := range tests {
+		if got := acceptsGzip(tt.header); got != tt.expected {
+			t.Errorf("acceptsGzip(%q): expected %v, got %v", tt.header, tt.expected, got)
+		}
+	}
+}
+
+func TestServeHTTP_CompressCache_SkipsIncompressible(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	png := "\x89PNG\r\n\x1a\n" + strings.Repeat("\x00IDAT", 400)
+	text := strings.Repeat("All work and no play makes Jack a dull boy.\n", 100)
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Files: map[string]string{
+				"index.html": "<h1>Site</h1>",
+				"logo.txt":   png,
+				"readme.txt": text,
+				"forced.txt": png,
+				"plain.html": "<p>" + text + "</p>",
+			},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL:     helper.server.URL,
+		CompressCache: true,
+	})
+	gp.CompressExtensions = []string{"txt"}
+	gp.NoCompressExtensions = []string{".html"}
+
+	tests := []struct {
+		path    string
+		body    string
+		encoded bool
+	}{
+		// The extension override wins over the sniffed PNG signature
+		{"/user/site/forced.txt", png, true},
+		{"/user/site/plain.html", "<p>" + text + "</p>", false},
+	}
+
+	run := func(t *testing.T, path, expected string, encoded bool) {
+		t.Helper()
+		w := helper.MakeHTTPRequest("GET", path, "", map[string]string{"Accept-Encoding": "gzip"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+		body := w.Body.Bytes()
+		if enc := w.Header().Get("Content-Encoding"); encoded {
+			if enc != "gzip" {
+				t.Fatalf("Expected Content-Encoding 'gzip', got '%s'", enc)
+			}
+			gz, err := gzip.NewReader(bytes.NewReader(body))
+			if err != nil {
+				t.Fatalf("Expected gzip body: %v", err)
+			}
+			body, _ = io.ReadAll(gz)
+		} else if enc != "" {
+			t.Fatalf("Expected no Content-Encoding, got '%s'", enc)
+		}
+		if string(body) != expected {
+			t.Errorf("Expected body of %d bytes, got %d", len(expected), len(body))
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			run(t, tt.path, tt.body, tt.encoded)
+		})
+	}
+
+	// Without overrides a PNG labelled as text is sent as-is while genuine
+	// text is compressed
+	gp.CompressExtensions = nil
+	run(t, "/user/site/logo.txt", png, false)
+	run(t, "/user/site/readme.txt", text, true)
+
+	entry := gp.cache.repos["user/site:main"]
+	if entry == nil {
+		t.Fatal("Expected cache entry")
+	}
+	info, err := os.Stat(filepath.Join(entry.path, "readme.txt"))
+	if err != nil {
+		t.Fatalf("Failed to stat cached file: %v", err)
+	}
+	if info.Size() >= int64(len(text)) {
+		t.Errorf("Expected compressed text smaller than %d bytes, got %d", len(text), info.Size())
+	}
+
+	// Files sent decompressed still answer ranges, including ones that
+	// go back in the file
+	w := helper.MakeHTTPRequest("GET", "/user/site/logo.txt", "", map[string]string{"Range": "bytes=1000-1009"})
+	if w.Code != http.StatusPartialContent || w.Body.String() != png[1000:1010] {
+		t.Errorf("Expected bytes 1000-1009, got %d %q", w.Code, w.Body.String())
+	}
+	if cl := w.Header().Get("Content-Length"); cl != "10" {
+		t.Errorf("Expected Content-Length 10, got %s", cl)
+	}
+	w = helper.MakeHTTPRequest("GET", "/user/site/logo.txt", "", map[string]string{"Range": "bytes=1000-1004,0-3"})
+	if w.Code != http.StatusPartialContent || !strings.Contains(w.Body.String(), png[1000:1005]) || !strings.Contains(w.Body.String(), png[:4]) {
+		t.Errorf("Expected both ranges, got %d %q", w.Code, w.Body.String())
+	}
+	w = helper.MakeHTTPRequest("GET", "/user/site/logo.txt", "", nil)
+	if w.Body.String() != png {
+		t.Errorf("Expected the whole file, got %d bytes", w.Body.Len())
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	gp := &GitteaPages{}
+	tests := []struct {
+		name     string
+		head     string
+		expected bool
+	}{
+		{"page.html", "<!doctype html>", true},
+		{"app.wasm", "\x00asm\x01\x00\x00\x00", true},
+		{"icon.svg", "<svg xmlns=\"http://www.w3.org/2000/svg\"/>", true},
+		{"photo.jpg", "", false},
+		{"font.woff2", "wOF2", false},
+		{"mislabeled.txt", "\x89PNG\r\n\x1a\n", false},
+		{"archive.dat", "PK\x03\x04", false},
+		{"data.bin", "\x28\xb5\x2f\xfd", false},
+		{"notes", "plain notes", true},
+	}
+
+	for _, tt := range tests {
+		if got := gp.compressible(tt.name, []byte(tt.head)); got != tt.expected {
+			t.Errorf("compressible(%q): expected %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package giteapages
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// BranchCookie lets visitors pin a preview branch with a cookie. Visiting
+// any page with ?{name}=branch sets the cookie (an empty value clears it),
+// and later requests for repositories in scope are served from that
+// branch as long as it matches one of the Allow patterns.
+type BranchCookie struct {
+	// Name of the cookie and of the query parameter that sets it
+	Name string `json:"name,omitempty"`
+
+	// Allow lists glob patterns of branches the cookie may select
+	Allow []string `json:"allow,omitempty"`
+
+	// Repos limits the cookie to these "owner/repo" entries; empty means
+	// every repository
+	Repos []string `json:"repos,omitempty"`
+}
+
+// inScope reports whether the cookie applies to owner/repo
+func (bc *BranchCookie) inScope(owner, repo string) bool {
+	if len(bc.Repos) == 0 {
+		return true
+	}
+	for _, r := range bc.Repos {
+		if r == owner+"/"+repo {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed reports whether branch may be selected by the cookie
+func (bc *BranchCookie) allowed(branch string) bool {
+	if branch == "" || strings.Contains(branch, "..") {
+		return false
+	}
+	for _, pattern := range bc.Allow {
+		if ok, _ := path.Match(pattern, branch); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// cookieBranch returns the branch selected by the branch cookie for
+// owner/repo, or "" when there is none. A selection made through the
+// query parameter is stored in the cookie for later requests.
+func (gp *GitteaPages) cookieBranch(w http.ResponseWriter, r *http.Request, owner, repo string) string {
+	bc := gp.BranchCookie
+	if bc == nil || bc.Name == "" || !bc.inScope(owner, repo) {
+		return ""
+	}
+	w.Header().Add("Vary", "Cookie")
+
+	if values, ok := r.URL.Query()[bc.Name]; ok {
+		selected := values[0]
+		if selected == "" {
+			http.SetCookie(w, &http.Cookie{Name: bc.Name, Path: "/", MaxAge: -1})
+			return ""
+		}
+		if bc.allowed(selected) {
+			http.SetCookie(w, &http.Cookie{
+				Name:     bc.Name,
+				Value:    selected,
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+			return selected
+		}
+		return ""
+	}
+
+	cookie, err := r.Cookie(bc.Name)
+	if err != nil || !bc.allowed(cookie.Value) {
+		return ""
+	}
+	return cookie.Value
+}
@@ -0,0 +1,101 @@
+package giteapages
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_BranchCookie(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	repos := GenerateTestRepos()
+	website := repos["user/website"]
+	website.Branches = map[string]map[string]string{
+		"preview-1": {"about.html": "<h1>Preview About</h1>"},
+		"secret":    {"about.html": "<h1>Secret About</h1>"},
+	}
+	repos["user/website"] = website
+
+	helper.CreateMockGiteaServer(repos)
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.BranchCookie = &BranchCookie{
+		Name:  "pages_branch",
+		Allow: []string{"preview-*"},
+		Repos: []string{"user/website"},
+	}
+
+	tests := []struct {
+		name             string
+		path             string
+		cookie           string
+		expectedContains string
+	}{
+		{"no cookie uses default branch", "/user/website/about.html", "", "About Us"},
+		{"cookie selects branch", "/user/website/about.html", "preview-1", "Preview About"},
+		{"disallowed branch is ignored", "/user/website/about.html", "secret", "About Us"},
+		{"path branch wins over cookie", "/user/website/@main/about.html", "preview-1", "About Us"},
+		{"out of scope repository", "/org/blog/feed.xml", "preview-1", "<rss>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if tt.cookie != "" {
+				headers["Cookie"] = "pages_branch=" + tt.cookie
+			}
+			w := helper.MakeHTTPRequest("GET", tt.path, "", headers)
+			helper.AssertResponse(w, http.StatusOK, tt.expectedContains)
+		})
+	}
+
+	t.Run("query parameter sets cookie", func(t *testing.T) {
+		w := helper.MakeHTTPRequest("GET", "/user/website/about.html?pages_branch=preview-1", "", nil)
+		helper.AssertResponse(w, http.StatusOK, "Preview About")
+		if sc := w.Header().Get("Set-Cookie"); !strings.HasPrefix(sc, "pages_branch=preview-1") {
+			t.Errorf("Expected branch cookie to be set, got '%s'", sc)
+		}
+	})
+
+	t.Run("invalid query value sets nothing", func(t *testing.T) {
+		w := helper.MakeHTTPRequest("GET", "/user/website/about.html?pages_branch=secret", "", nil)
+		helper.AssertResponse(w, http.StatusOK, "About Us")
+		if sc := w.Header().Get("Set-Cookie"); sc != "" {
+			t.Errorf("Expected no cookie for disallowed branch, got '%s'", sc)
+		}
+	})
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_BranchCookie(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		branch_cookie pages_branch {
+			allow preview-* dev
+			repos user/website
+		}
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.BranchCookie == nil || gp.BranchCookie.Name != "pages_branch" {
+		t.Fatalf("Expected branch cookie 'pages_branch', got %+v", gp.BranchCookie)
+	}
+	if len(gp.BranchCookie.Allow) != 2 || len(gp.BranchCookie.Repos) != 1 {
+		t.Errorf("Unexpected branch cookie config %+v", gp.BranchCookie)
+	}
+	if err := gp.Validate(); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+
+	gp.BranchCookie.Allow = nil
+	if err := gp.Validate(); err == nil {
+		t.Error("Expected validation error without allow patterns")
+	}
+}
@@ -0,0 +1,121 @@
+package giteapages
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// defaultCORSMethods are allowed in preflight responses when a policy
+// lists none
+var defaultCORSMethods = []string{"GET", "HEAD", "OPTIONS"}
+
+// CORSPolicy controls the cross-origin headers sent with responses
+type CORSPolicy struct {
+	// AllowOrigins lists origins, e.g. "https://app.example.com", allowed
+	// to read responses; "*" allows any. Empty allows none, which lets a
+	// domain mapping opt out of the global policy.
+	AllowOrigins []string `json:"allow_origins,omitempty"`
+
+	// AllowMethods and AllowHeaders are answered to preflight requests
+	AllowMethods []string `json:"allow_methods,omitempty"`
+	AllowHeaders []string `json:"allow_headers,omitempty"`
+
+	// MaxAge lets browsers cache a preflight response
+	MaxAge caddy.Duration `json:"max_age,omitempty"`
+}
+
+// allowedOrigin returns the Access-Control-Allow-Origin value for origin,
+// or "" when the policy does not allow it
+func (p *CORSPolicy) allowedOrigin(origin string) string {
+	for _, allowed := range p.AllowOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// corsPolicy returns the policy for r's host: its domain mapping's when
+// the mapping sets one, else the global CORS policy
+func (gp *GitteaPages) corsPolicy(r *http.Request) *CORSPolicy {
+	if mapping := gp.findDomainMapping(r.Host); mapping != nil && mapping.CORS != nil {
+		return mapping.CORS
+	}
+	return gp.CORS
+}
+
+// applyCORS adds CORS headers for r and answers preflight requests,
+// reporting whether the response has been written
+func (gp *GitteaPages) applyCORS(w http.ResponseWriter, r *http.Request) bool {
+	policy := gp.corsPolicy(r)
+	if policy == nil {
+		return false
+	}
+
+	w.Header().Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	allowed := ""
+	if origin != "" {
+		allowed = policy.allowedOrigin(origin)
+	}
+	if allowed != "" {
+		w.Header().Set("Access-Control-Allow-Origin", allowed)
+	}
+
+	if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+		return false
+	}
+
+	// A disallowed preflight is still answered, just without the headers
+	// that would let the browser proceed
+	if allowed != "" {
+		methods := policy.AllowMethods
+		if len(methods) == 0 {
+			methods = defaultCORSMethods
+		}
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if len(policy.AllowHeaders) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(policy.AllowHeaders, ", "))
+		}
+		if policy.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(time.Duration(policy.MaxAge).Seconds())))
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// parseCORS parses a cors directive: allowed origins as arguments and an
+// optional block of methods, headers and max_age
+func parseCORS(d *caddyfile.Dispenser) (*CORSPolicy, error) {
+	policy := &CORSPolicy{AllowOrigins: d.RemainingArgs()}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "methods":
+			policy.AllowMethods = append(policy.AllowMethods, d.RemainingArgs()...)
+		case "headers":
+			policy.AllowHeaders = append(policy.AllowHeaders, d.RemainingArgs()...)
+		case "max_age":
+			var age string
+			if !d.Args(&age) {
+				return nil, d.ArgErr()
+			}
+			duration, err := time.ParseDuration(age)
+			if err != nil {
+				return nil, d.Errf("invalid cors max_age: %v", err)
+			}
+			policy.MaxAge = caddy.Duration(duration)
+		default:
+			return nil, d.Errf("unknown cors subdirective: %s", d.Val())
+		}
+	}
+	return policy, nil
+}
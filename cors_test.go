@@ -0,0 +1,118 @@
+package giteapages
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_CORSPerDomain(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+		DomainMappings: []DomainMapping{
+			{Domain: "api.example.com", Owner: "user", Repository: "site",
+				CORS: &CORSPolicy{AllowOrigins: []string{"*"}, AllowHeaders: []string{"X-Token"}, MaxAge: caddy.Duration(time.Hour)}},
+			{Domain: "www.example.com", Owner: "user", Repository: "site", CORS: &CORSPolicy{}},
+			{Domain: "docs.example.com", Owner: "user", Repository: "site"},
+		},
+	})
+	gp.CORS = &CORSPolicy{AllowOrigins: []string{"https://app.example.com"}, AllowMethods: []string{"GET"}}
+	helper.CreateCacheEntry("user/site", "main", map[string]string{
+		"data.json": `{"ok":true}`,
+	})
+
+	tests := []struct {
+		name   string
+		host   string
+		origin string
+		allow  string
+	}{
+		{"mapping allows any origin", "api.example.com", "https://evil.example.net", "*"},
+		{"mapping allows none", "www.example.com", "https://app.example.com", ""},
+		{"global policy, listed origin", "docs.example.com", "https://app.example.com", "https://app.example.com"},
+		{"global policy, other origin", "docs.example.com", "https://evil.example.net", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := helper.MakeHTTPRequest("GET", "/data.json", tt.host, map[string]string{"Origin": tt.origin})
+			helper.AssertResponse(w, http.StatusOK, `{"ok":true}`)
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allow {
+				t.Errorf("Expected Access-Control-Allow-Origin '%s', got '%s'", tt.allow, got)
+			}
+		})
+	}
+
+	t.Run("preflight", func(t *testing.T) {
+		headers := map[string]string{
+			"Origin":                        "https://app.example.com",
+			"Access-Control-Request-Method": "GET",
+		}
+
+		w := helper.MakeHTTPRequest("OPTIONS", "/data.json", "api.example.com", headers)
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d", w.Code)
+		}
+		expected := map[string]string{
+			"Access-Control-Allow-Origin":  "*",
+			"Access-Control-Allow-Methods": "GET, HEAD, OPTIONS",
+			"Access-Control-Allow-Headers": "X-Token",
+			"Access-Control-Max-Age":       "3600",
+		}
+		for h, want := range expected {
+			if got := w.Header().Get(h); got != want {
+				t.Errorf("Expected %s '%s', got '%s'", h, want, got)
+			}
+		}
+
+		w = helper.MakeHTTPRequest("OPTIONS", "/data.json", "www.example.com", headers)
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d", w.Code)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Methods"); got != "" {
+			t.Errorf("Expected no Access-Control-Allow-Methods for a disallowed origin, got '%s'", got)
+		}
+	})
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_CORS(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		cors https://app.example.com {
+			methods GET POST
+			max_age 10m
+		}
+		domain_mapping api.example.com user api {
+			cors * {
+				headers Authorization
+			}
+		}
+		domain_mapping www.example.com user site {
+			cors
+		}
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.CORS == nil || len(gp.CORS.AllowOrigins) != 1 || len(gp.CORS.AllowMethods) != 2 || gp.CORS.MaxAge != caddy.Duration(10*time.Minute) {
+		t.Errorf("Unexpected global cors %+v", gp.CORS)
+	}
+	if len(gp.DomainMappings) != 2 {
+		t.Fatalf("Expected two domain mappings, got %d", len(gp.DomainMappings))
+	}
+	api := gp.DomainMappings[0].CORS
+	if api == nil || api.AllowOrigins[0] != "*" || len(api.AllowHeaders) != 1 {
+		t.Errorf("Unexpected api cors %+v", api)
+	}
+	if www := gp.DomainMappings[1].CORS; www == nil || len(www.AllowOrigins) != 0 {
+		t.Errorf("Expected www to allow no origins, got %+v", www)
+	}
+}
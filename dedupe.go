@@ -0,0 +1,126 @@
+package giteapages
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// fetchFlights tracks the cache downloads under way so concurrent
+// requests for one entry share a download. Unlike a singleflight.Group,
+// it also lets a request wait for a download without ever starting one.
+type fetchFlights struct {
+	mu    sync.Mutex
+	calls map[string]*fetchFlight
+}
+
+// fetchFlight is one download in progress; err is set before done closes.
+// cacheKey is the entry the download stores.
+type fetchFlight struct {
+	done     chan struct{}
+	err      error
+	cacheKey string
+}
+
+// flightKey is the key concurrent downloads collapse on: the repository
+// and ref a request resolved to, whatever host and path it arrived on,
+// normalized the way Gitea reads them. Owner and repository names are
+// case-insensitive and a branch may be named as refs/heads/<branch>, so
+// "/User/Site/@refs/heads/main/" and a domain mapped to user/site share
+// one download.
+func flightKey(owner, repo, branch string) string {
+	return strings.ToLower(owner) + "/" + strings.ToLower(repo) + ":" + strings.TrimPrefix(branch, "refs/heads/")
+}
+
+// do runs fn, which stores cacheKey, for key unless a call for key is
+// already running, in which case it waits for that one. It returns the
+// cache key the call that ran stored and its error.
+func (f *fetchFlights) do(key, cacheKey string, fn func() error) (string, error) {
+	f.mu.Lock()
+	if call, ok := f.calls[key]; ok {
+		f.mu.Unlock()
+		<-call.done
+		return call.cacheKey, call.err
+	}
+	call := &fetchFlight{done: make(chan struct{}), cacheKey: cacheKey}
+	f.calls[key] = call
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		delete(f.calls, key)
+		f.mu.Unlock()
+		close(call.done)
+	}()
+	call.err = fn()
+	return call.cacheKey, call.err
+}
+
+// join waits for a download of key already under way, returning the
+// cache key it stored and whether there was one and it succeeded. It gives
+// up when ctx is done.
+func (f *fetchFlights) join(ctx context.Context, key string) (string, bool) {
+	f.mu.Lock()
+	call, ok := f.calls[key]
+	f.mu.Unlock()
+	if !ok {
+		return "", false
+	}
+	select {
+	case <-call.done:
+		return call.cacheKey, call.err == nil
+	case <-ctx.Done():
+		return "", false
+	}
+}
+
+// refreshEntry downloads a branch into the cache for a request waiting on
+// it. With DedupeRequests, concurrent requests for one entry share the
+// download, even when they spell the repository differently.
+func (gp *GitteaPages) refreshEntry(ctx context.Context, owner, repo, branch, cacheKey string) error {
+	if !gp.DedupeRequests {
+		return gp.updateRepoCache(ctx, owner, repo, branch)
+	}
+	stored, err := gp.fetches.do(flightKey(owner, repo, branch), cacheKey, func() error {
+		return gp.updateRepoCache(ctx, owner, repo, branch)
+	})
+	if err == nil {
+		gp.shareEntry(stored, cacheKey)
+	}
+	return err
+}
+
+// joinRefresh waits for a download of the entry under way, for a HEAD
+// request that must not start one, reporting whether it stored the entry
+func (gp *GitteaPages) joinRefresh(ctx context.Context, owner, repo, branch, cacheKey string) bool {
+	stored, ok := gp.fetches.join(ctx, flightKey(owner, repo, branch))
+	if ok {
+		gp.shareEntry(stored, cacheKey)
+	}
+	return ok
+}
+
+// shareEntry makes the entry a download stored under from also the entry
+// of cacheKey, which names the same content differently. Both keys then
+// serve the one copy on disk until either is refreshed.
+func (gp *GitteaPages) shareEntry(from, cacheKey string) {
+	if from == cacheKey {
+		return
+	}
+	gp.cache.mu.Lock()
+	defer gp.cache.mu.Unlock()
+	if entry, ok := gp.cache.repos[from]; ok {
+		gp.cache.repos[cacheKey] = entry
+	}
+}
+
+// entryShared reports whether a cache entry other than cacheKey serves
+// from path. The caller holds gp.cache.mu.
+func (gp *GitteaPages) entryShared(cacheKey, path string) bool {
+	for key, entry := range gp.cache.repos {
+		if key != cacheKey && entry.path == path {
+			return true
+		}
+	}
+	return false
+}
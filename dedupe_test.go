@@ -0,0 +1,218 @@
+package giteapages
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_DedupeHeadAndGet(t *testing.T) {
+	tests := []struct {
+		name   string
+		first  string
+		second string
+	}{
+		{"GET then HEAD", "GET", "HEAD"},
+		{"HEAD then GET", "HEAD", "GET"},
+		{"GET then GET", "GET", "GET"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helper := NewTestHelper(t)
+			defer helper.Cleanup()
+
+			helper.CreateMockGiteaServer(GenerateTestRepos())
+			gp := helper.SetupGiteaPages(GitteaPagesConfig{
+				GitteaURL: helper.server.URL,
+			})
+			gp.DedupeRequests = true
+			helper.SetUpstreamDelay(100 * time.Millisecond)
+
+			responses := make([]*httptest.ResponseRecorder, 2)
+			var wg sync.WaitGroup
+			for i, method := range []string{tt.first, tt.second} {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					responses[i] = helper.MakeHTTPRequest(method, "/user/website/about.html", "", nil)
+				}()
+				// Let the first request reach Gitea before the second arrives
+				time.Sleep(20 * time.Millisecond)
+			}
+			wg.Wait()
+
+			for i, w := range responses {
+				if w.Code != http.StatusOK {
+					t.Errorf("Expected status 200 for request %d, got %d", i+1, w.Code)
+				}
+				if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+					t.Errorf("Expected an HTML Content-Type for request %d, got %q", i+1, ct)
+				}
+			}
+			if api, archive := helper.UpstreamCalls(); api != 1 || archive != 1 {
+				t.Errorf("Expected one metadata call and one download, got %d and %d", api, archive)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_DedupeOff(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	helper.SetUpstreamDelay(100 * time.Millisecond)
+
+	var wg sync.WaitGroup
+	for _, method := range []string{"GET", "HEAD"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			helper.MakeHTTPRequest(method, "/user/website/about.html", "", nil)
+		}()
+		time.Sleep(20 * time.Millisecond)
+	}
+	wg.Wait()
+
+	if api, _ := helper.UpstreamCalls(); api != 2 {
+		t.Errorf("Expected separate metadata calls without dedupe_requests, got %d", api)
+	}
+}
+
+func TestFetchFlights(t *testing.T) {
+	flights := &fetchFlights{calls: make(map[string]*fetchFlight)}
+
+	// Nothing under way: join returns at once without running anything
+	if _, ok := flights.join(context.Background(), "user/site:main"); ok {
+		t.Error("Expected join without a download to report false")
+	}
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	failed := errors.New("download failed")
+	go flights.do("user/site:main", "user/site:main", func() error {
+		close(started)
+		<-release
+		return failed
+	})
+	<-started
+
+	joined := make(chan bool)
+	go func() {
+		_, ok := flights.join(context.Background(), "user/site:main")
+		joined <- ok
+	}()
+	shared := make(chan error)
+	go func() {
+		stored, err := flights.do("user/site:main", "User/Site:main", func() error {
+			t.Error("Expected the second call to share the first")
+			return nil
+		})
+		if stored != "user/site:main" {
+			t.Errorf("Expected the first call's cache key, got %s", stored)
+		}
+		shared <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	if <-joined {
+		t.Error("Expected join of a failed download to report false")
+	}
+	if err := <-shared; !errors.Is(err, failed) {
+		t.Errorf("Expected the shared error, got %v", err)
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_DedupeRequests(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		dedupe_requests
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if !gp.DedupeRequests {
+		t.Error("Expected dedupe_requests to be enabled")
+	}
+}
+
+func TestServeHTTP_DedupeNormalizedRoutes(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+		DomainMappings: []DomainMapping{
+			{Domain: "site.example.com", Owner: "User", Repository: "Website"},
+			{Domain: "www.example.com", Owner: "user", Repository: "website", Branch: "refs/heads/main"},
+		},
+	})
+	gp.DedupeRequests = true
+	helper.SetUpstreamDelay(100 * time.Millisecond)
+
+	// Three URLs, on different hosts and routes, for the same file
+	requests := []struct{ path, host string }{
+		{"/user/website/about.html", ""},
+		{"/about.html", "site.example.com"},
+		{"/about.html", "www.example.com"},
+	}
+	responses := make([]*httptest.ResponseRecorder, len(requests))
+	var wg sync.WaitGroup
+	for i, req := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i] = helper.MakeHTTPRequest("GET", req.path, req.host, nil)
+		}()
+		// Let the first request start the download
+		time.Sleep(20 * time.Millisecond)
+	}
+	wg.Wait()
+
+	for i, w := range responses {
+		helper.AssertResponse(w, http.StatusOK, "About Us")
+		if t.Failed() {
+			t.Fatalf("request %d (%s%s) failed", i+1, requests[i].host, requests[i].path)
+		}
+	}
+	if _, archive := helper.UpstreamCalls(); archive != 1 {
+		t.Errorf("Expected one download for every route, got %d", archive)
+	}
+
+	// Evicting one name keeps the files the others still serve
+	gp.evictCacheEntry("User/Website:main")
+	w := helper.MakeHTTPRequest("GET", "/user/website/about.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "About Us")
+}
+
+func TestFlightKey(t *testing.T) {
+	tests := []struct {
+		owner, repo, branch string
+	}{
+		{"user", "site", "main"},
+		{"User", "Site", "main"},
+		{"USER", "site", "refs/heads/main"},
+	}
+	for _, tt := range tests {
+		if got := flightKey(tt.owner, tt.repo, tt.branch); got != "user/site:main" {
+			t.Errorf("flightKey(%s, %s, %s) = %s, want user/site:main", tt.owner, tt.repo, tt.branch, got)
+		}
+	}
+	if flightKey("user", "site", "Main") == flightKey("user", "site", "main") {
+		t.Error("Expected branch names to stay case-sensitive")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package giteapages
+
+import "errors"
+
+// diskFreeSpace is not implemented on this platform
+func diskFreeSpace(path string) (uint64, error) {
+	return 0, errors.New("free space reporting not supported on this platform")
+}
@@ -0,0 +1,71 @@
+package giteapages
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// defaultDownloadQuery is the query parameter of download_query unless
+// another name is configured
+const defaultDownloadQuery = "download"
+
+// wantsAttachment reports whether relPath is served as a download: the
+// DownloadQuery parameter decides when present, "?download=1" forcing a
+// download and "?download=0" inline display, and AttachmentFiles
+// otherwise
+func (gp *GitteaPages) wantsAttachment(r *http.Request, relPath string) bool {
+	if gp.DownloadQuery != "" {
+		if values, ok := r.URL.Query()[gp.DownloadQuery]; ok && len(values) > 0 {
+			return truthy(values[0])
+		}
+	}
+	return gp.isAttachment(relPath)
+}
+
+// isAttachment reports whether a repository-relative path matches
+// AttachmentFiles. Patterns containing a slash match the whole path;
+// others match any single path segment, so "downloads" covers everything
+// below a downloads directory and "*.zip" every zip file.
+func (gp *GitteaPages) isAttachment(relPath string) bool {
+	relPath = strings.Trim(relPath, "/")
+	segments := strings.Split(relPath, "/")
+	for _, pattern := range gp.AttachmentFiles {
+		if strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(strings.Trim(pattern, "/"), relPath); ok {
+				return true
+			}
+			continue
+		}
+		for _, segment := range segments {
+			if ok, _ := path.Match(pattern, segment); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// attachmentDisposition returns a Content-Disposition value telling
+// browsers to download the file as name. Characters that could break out
+// of the header or name a path are replaced, and non-ASCII names are
+// encoded as RFC 2231 extended parameters.
+func attachmentDisposition(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20 || r == 0x7f, r == '"', r == '\\', r == '/':
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimLeft(name, ".")
+	if name == "" {
+		name = "download"
+	}
+
+	if disposition := mime.FormatMediaType("attachment", map[string]string{"filename": name}); disposition != "" {
+		return disposition
+	}
+	return "attachment"
+}
@@ -0,0 +1,142 @@
+package giteapages
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_AttachmentFiles(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+	})
+	gp.AttachmentFiles = []string{"downloads/*.zip", "*.pdf"}
+	helper.CreateCacheEntry("user/site", "main", map[string]string{
+		"downloads/release.zip": "PK",
+		"downloads/notes.html":  "<h1>Notes</h1>",
+		"manual.pdf":            "%PDF",
+		"other/release.zip":     "PK",
+	})
+
+	tests := []struct {
+		path        string
+		disposition string
+	}{
+		{"/user/site/downloads/release.zip", `attachment; filename=release.zip`},
+		{"/user/site/manual.pdf", `attachment; filename=manual.pdf`},
+		{"/user/site/downloads/notes.html", ""},
+		{"/user/site/other/release.zip", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := helper.MakeHTTPRequest("GET", tt.path, "", nil)
+			helper.AssertResponse(w, http.StatusOK, "")
+			if got := w.Header().Get("Content-Disposition"); got != tt.disposition {
+				t.Errorf("Expected Content-Disposition '%s', got '%s'", tt.disposition, got)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_DownloadQuery(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+	})
+	gp.AttachmentFiles = []string{"*.pdf"}
+	gp.DownloadQuery = defaultDownloadQuery
+	helper.CreateCacheEntry("user/site", "main", map[string]string{
+		"page.html":    "<h1>Page</h1>",
+		"my page.html": "<h1>Spaced</h1>",
+		"manual.pdf":   "%PDF",
+	})
+
+	tests := []struct {
+		path        string
+		disposition string
+	}{
+		{"/user/site/page.html?download=1", `attachment; filename=page.html`},
+		{"/user/site/page.html?download", `attachment; filename=page.html`},
+		{"/user/site/page.html", ""},
+		{"/user/site/page.html?download=0", ""},
+		{"/user/site/my%20page.html?download=1", `attachment; filename="my page.html"`},
+		{"/user/site/manual.pdf", `attachment; filename=manual.pdf`},
+		{"/user/site/manual.pdf?download=0", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := helper.MakeHTTPRequest("GET", tt.path, "", nil)
+			helper.AssertResponse(w, http.StatusOK, "")
+			if got := w.Header().Get("Content-Disposition"); got != tt.disposition {
+				t.Errorf("Expected Content-Disposition '%s', got '%s'", tt.disposition, got)
+			}
+		})
+	}
+
+	// Without download_query the parameter is an ordinary query string
+	gp.DownloadQuery = ""
+	w := helper.MakeHTTPRequest("GET", "/user/site/page.html?download=1", "", nil)
+	if got := w.Header().Get("Content-Disposition"); got != "" {
+		t.Errorf("Expected inline display without download_query, got '%s'", got)
+	}
+}
+
+func TestAttachmentDisposition(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"report.pdf", `attachment; filename=report.pdf`},
+		{"my report.pdf", `attachment; filename="my report.pdf"`},
+		{"a\"b\r\nSet-Cookie: x.zip", `attachment; filename="a_b__Set-Cookie: x.zip"`},
+		{"..\\secret", `attachment; filename=_secret`},
+		{"résumé.pdf", `attachment; filename*=utf-8''r%C3%A9sum%C3%A9.pdf`},
+		{"...", `attachment; filename=download`},
+	}
+
+	for _, tt := range tests {
+		if got := attachmentDisposition(tt.name); got != tt.expected {
+			t.Errorf("attachmentDisposition(%q): expected %s, got %s", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_AttachmentFiles(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		attachment_files downloads/*.zip *.pdf
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if len(gp.AttachmentFiles) != 2 || gp.AttachmentFiles[0] != "downloads/*.zip" {
+		t.Errorf("Expected two attachment patterns, got %v", gp.AttachmentFiles)
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_DownloadQuery(t *testing.T) {
+	tests := map[string]string{
+		"download_query":    defaultDownloadQuery,
+		"download_query dl": "dl",
+	}
+	for directive, expected := range tests {
+		d := caddyfile.NewTestDispenser("gitea_pages {\n" + directive + "\n}")
+		var gp GitteaPages
+		if err := gp.UnmarshalCaddyfile(d); err != nil {
+			t.Fatalf("UnmarshalCaddyfile(%q) failed: %v", directive, err)
+		}
+		if gp.DownloadQuery != expected {
+			t.Errorf("%q: expected download query %q, got %q", directive, expected, gp.DownloadQuery)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package giteapages
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// domainMappings returns the domain mappings in effect. The admin API
+// replaces the slice rather than changing it, so callers may keep
+// pointers into it.
+func (gp *GitteaPages) domainMappings() []DomainMapping {
+	if gp.mappingsMu == nil {
+		// Not provisioned, so not reachable from the admin API
+		return gp.DomainMappings
+	}
+	gp.mappingsMu.RLock()
+	defer gp.mappingsMu.RUnlock()
+	return gp.DomainMappings
+}
+
+// putDomainMapping adds mapping, replacing any mapping of the same domain
+func (gp *GitteaPages) putDomainMapping(mapping DomainMapping) {
+	gp.mappingsMu.Lock()
+	defer gp.mappingsMu.Unlock()
+
+	mappings := make([]DomainMapping, 0, len(gp.DomainMappings)+1)
+	replaced := false
+	for _, m := range gp.DomainMappings {
+		if m.Domain == mapping.Domain {
+			m, replaced = mapping, true
+		}
+		mappings = append(mappings, m)
+	}
+	if !replaced {
+		mappings = append(mappings, mapping)
+	}
+	gp.DomainMappings = mappings
+}
+
+// removeDomainMapping drops the mapping of domain, reporting whether
+// there was one
+func (gp *GitteaPages) removeDomainMapping(domain string) bool {
+	gp.mappingsMu.Lock()
+	defer gp.mappingsMu.Unlock()
+
+	mappings := make([]DomainMapping, 0, len(gp.DomainMappings))
+	for _, m := range gp.DomainMappings {
+		if m.Domain != domain {
+			mappings = append(mappings, m)
+		}
+	}
+	removed := len(mappings) != len(gp.DomainMappings)
+	gp.DomainMappings = mappings
+	return removed
+}
+
+// validate checks a mapping's env prefixes
+func (m *DomainMapping) validate() error {
+	for prefix, branch := range m.EnvPrefixes {
+		if prefix == "" || strings.Contains(prefix, "/") || branch == "" {
+			return fmt.Errorf("domain_mapping %s: env_prefix %q must be a single path segment mapped to a branch", m.Domain, prefix)
+		}
+	}
+	return nil
+}
+
+// handleDomainMappings serves the /gitea_pages/domain_mappings admin API
+// endpoint. GET lists the mappings of every running gitea_pages handler;
+// POST with a mapping object, as in the domain_mappings config, adds it
+// to all of them or replaces the mapping of the same domain, and DELETE
+// with {"domain": "..."} removes it. Changes route requests immediately
+// but are not persisted: they last until the next config load, which
+// should carry any mapping meant to stay.
+func (AdminAPI) handleDomainMappings(w http.ResponseWriter, r *http.Request) error {
+	handlers := runningHandlers()
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodDelete:
+		var mapping DomainMapping
+		if err := json.NewDecoder(r.Body).Decode(&mapping); err != nil {
+			return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("decoding request: %v", err)}
+		}
+		mapping.Domain = strings.ToLower(mapping.Domain)
+		if mapping.Domain == "" || strings.ContainsAny(mapping.Domain, "/: ") {
+			return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("domain mapping: invalid domain %q", mapping.Domain)}
+		}
+		if r.Method == http.MethodPost {
+			if mapping.Owner == "" || mapping.Repository == "" {
+				return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: fmt.Errorf("domain_mapping %s: owner and repository are required", mapping.Domain)}
+			}
+			if err := mapping.validate(); err != nil {
+				return caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: err}
+			}
+		}
+		if len(handlers) == 0 {
+			return caddy.APIError{HTTPStatus: http.StatusNotFound, Err: fmt.Errorf("no gitea_pages handlers are running")}
+		}
+		removed := false
+		for _, gp := range handlers {
+			if r.Method == http.MethodPost {
+				gp.putDomainMapping(mapping)
+			} else if gp.removeDomainMapping(mapping.Domain) {
+				removed = true
+			}
+		}
+		if r.Method == http.MethodDelete && !removed {
+			return caddy.APIError{HTTPStatus: http.StatusNotFound, Err: fmt.Errorf("domain mapping %q not found", mapping.Domain)}
+		}
+	default:
+		return caddy.APIError{HTTPStatus: http.StatusMethodNotAllowed, Err: fmt.Errorf("method not allowed")}
+	}
+
+	// Respond with the mappings now in effect
+	mappings := make(map[string]DomainMapping)
+	for _, gp := range handlers {
+		for _, m := range gp.domainMappings() {
+			if m.Token != "" {
+				m.Token = "xxxxx"
+			}
+			mappings[m.Domain] = m
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(mappings)
+}
@@ -0,0 +1,156 @@
+package giteapages
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestAdminAPI_DomainMappings(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+
+	// Unmapped, the host falls through to the next handler
+	w := helper.MakeHTTPRequest("GET", "/about.html", "new.example.com", nil)
+	helper.AssertResponse(w, http.StatusNotFound, "Not handled by gitea-pages")
+
+	admin := AdminAPI{}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/gitea_pages/domain_mappings",
+		strings.NewReader(`{"domain": "New.Example.com", "owner": "user", "repository": "website"}`))
+	if err := admin.handleDomainMappings(rec, req); err != nil {
+		t.Fatalf("POST domain mapping failed: %v", err)
+	}
+	if !strings.Contains(rec.Body.String(), `"new.example.com":{"domain":"new.example.com","owner":"user","repository":"website"`) {
+		t.Errorf("Expected the new mapping in the response, got %s", rec.Body.String())
+	}
+	w = helper.MakeHTTPRequest("GET", "/about.html", "new.example.com", nil)
+	helper.AssertResponse(w, http.StatusOK, "About Us")
+
+	// Posting the domain again replaces its mapping
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/gitea_pages/domain_mappings",
+		strings.NewReader(`{"domain": "new.example.com", "owner": "org", "repository": "blog"}`))
+	if err := admin.handleDomainMappings(rec, req); err != nil {
+		t.Fatalf("POST domain mapping failed: %v", err)
+	}
+	if n := len(gp.domainMappings()); n != 1 {
+		t.Errorf("Expected the mapping replaced, got %d mappings", n)
+	}
+	if m := gp.findDomainMapping("new.example.com"); m == nil || m.Owner != "org" {
+		t.Errorf("Expected new.example.com mapped to org/blog, got %+v", m)
+	}
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest("DELETE", "/gitea_pages/domain_mappings", strings.NewReader(`{"domain": "new.example.com"}`))
+	if err := admin.handleDomainMappings(rec, req); err != nil {
+		t.Fatalf("DELETE domain mapping failed: %v", err)
+	}
+	w = helper.MakeHTTPRequest("GET", "/about.html", "new.example.com", nil)
+	helper.AssertResponse(w, http.StatusNotFound, "Not handled by gitea-pages")
+}
+
+func TestAdminAPI_DomainMappingsCase(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+		DomainMappings: []DomainMapping{
+			{Domain: "Docs.Example.com", Owner: "user", Repository: "website"},
+		},
+	})
+
+	// Neither the configured domain's case nor the request host's matters
+	w := helper.MakeHTTPRequest("GET", "/about.html", "DOCS.example.COM", nil)
+	helper.AssertResponse(w, http.StatusOK, "About Us")
+
+	// The admin API replaces and removes the configured mapping
+	admin := AdminAPI{}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/gitea_pages/domain_mappings",
+		strings.NewReader(`{"domain": "docs.example.com", "owner": "org", "repository": "blog"}`))
+	if err := admin.handleDomainMappings(rec, req); err != nil {
+		t.Fatalf("POST domain mapping failed: %v", err)
+	}
+	if n := len(gp.domainMappings()); n != 1 {
+		t.Errorf("Expected the configured mapping replaced, got %d mappings", n)
+	}
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest("DELETE", "/gitea_pages/domain_mappings", strings.NewReader(`{"domain": "DOCS.example.com"}`))
+	if err := admin.handleDomainMappings(rec, req); err != nil {
+		t.Fatalf("DELETE domain mapping failed: %v", err)
+	}
+	w = helper.MakeHTTPRequest("GET", "/about.html", "docs.example.com", nil)
+	helper.AssertResponse(w, http.StatusNotFound, "Not handled by gitea-pages")
+}
+
+func TestAdminAPI_DomainMappingsConcurrent(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+
+	// Run with -race: lookups must not see a mapping list being changed
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				gp.putDomainMapping(DomainMapping{Domain: "a.example.com", Owner: "user", Repository: "website"})
+				gp.removeDomainMapping("a.example.com")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if m := gp.findDomainMapping("a.example.com"); m != nil && m.Owner != "user" {
+					t.Errorf("Unexpected mapping %+v", m)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestAdminAPI_DomainMappingsErrors(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+	helper.SetupGiteaPages(GitteaPagesConfig{GitteaURL: "https://git.example.com"})
+
+	tests := []struct {
+		method string
+		body   string
+		status int
+	}{
+		{"POST", `{"domain": "a.example.com", "owner": "user"}`, http.StatusBadRequest},
+		{"POST", `{"domain": "a.example.com/x", "owner": "user", "repository": "site"}`, http.StatusBadRequest},
+		{"POST", `{"domain": "a.example.com", "owner": "user", "repository": "site", "env_prefix": {"a/b": "dev"}}`, http.StatusBadRequest},
+		{"POST", `not json`, http.StatusBadRequest},
+		{"DELETE", `{"domain": "missing.example.com"}`, http.StatusNotFound},
+		{"PUT", `{}`, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/gitea_pages/domain_mappings", strings.NewReader(tt.body))
+		err := AdminAPI{}.handleDomainMappings(httptest.NewRecorder(), req)
+		apiErr, ok := err.(caddy.APIError)
+		if !ok || apiErr.HTTPStatus != tt.status {
+			t.Errorf("%s %s: expected status %d, got %v", tt.method, tt.body, tt.status, err)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package giteapages
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// encodeFunc compresses a response body for one Content-Encoding
+type encodeFunc func(src []byte) ([]byte, error)
+
+// builtinEncoders maps the content codings dynamic_compression offers to
+// their encoder. It is never written; each handler picks its encoders
+// from it when provisioned.
+var builtinEncoders = map[string]encodeFunc{
+	"br":   encodeBrotli,
+	"gzip": encodeGzip,
+	"zstd": encodeZstd,
+}
+
+func encodeBrotli(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	// The best quality is too slow to run on the request path for files
+	// up to max_size
+	br := brotli.NewWriterLevel(&buf, brotli.DefaultCompression)
+	if _, err := br.Write(src); err != nil {
+		return nil, err
+	}
+	if err := br.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeGzip(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := gz.Write(src); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// zstdEncoder is shared by all requests; EncodeAll is safe for concurrent
+// use
+var zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+
+func encodeZstd(src []byte) ([]byte, error) {
+	return zstdEncoder.EncodeAll(src, nil), nil
+}
+
+// DynamicCompression compresses responses for the encodings clients
+// accept, keeping each compressed variant with the cache entry so a file
+// is compressed once per encoding until its branch is refreshed
+type DynamicCompression struct {
+	// Encodings are offered in order of preference; "gzip" when empty
+	Encodings []string `json:"encodings,omitempty"`
+
+	// Files smaller than MinSize or larger than MaxSize bytes are sent
+	// as they are
+	MinSize int64 `json:"min_size,omitempty"`
+	MaxSize int64 `json:"max_size,omitempty"`
+
+	// CacheSize bounds the bytes of compressed variants kept for each
+	// cached branch; the least recently served are dropped beyond it.
+	// Defaults to 16MB.
+	CacheSize int64 `json:"cache_size,omitempty"`
+
+	// encoders holds the encoder of each of Encodings
+	encoders map[string]encodeFunc
+}
+
+func (dc *DynamicCompression) provision() {
+	if len(dc.Encodings) == 0 {
+		dc.Encodings = []string{"gzip"}
+	}
+	dc.encoders = make(map[string]encodeFunc, len(dc.Encodings))
+	for _, coding := range dc.Encodings {
+		if encode, ok := builtinEncoders[strings.ToLower(coding)]; ok {
+			dc.encoders[coding] = encode
+		}
+	}
+	if dc.MinSize == 0 {
+		dc.MinSize = sniffLen
+	}
+	if dc.MaxSize == 0 {
+		dc.MaxSize = 8 << 20
+	}
+	if dc.CacheSize == 0 {
+		dc.CacheSize = 16 << 20
+	}
+}
+
+func (dc *DynamicCompression) validate() error {
+	for _, coding := range dc.Encodings {
+		if builtinEncoders[strings.ToLower(coding)] == nil {
+			return fmt.Errorf("dynamic_compression: no encoder for %q", coding)
+		}
+	}
+	if dc.MinSize < 0 || dc.MaxSize < 0 || dc.CacheSize < 0 {
+		return fmt.Errorf("dynamic_compression: sizes must not be negative")
+	}
+	return nil
+}
+
+// negotiate returns the first of Encodings that acceptEncoding allows,
+// or "" when it allows none
+func (dc *DynamicCompression) negotiate(acceptEncoding string) string {
+	for _, coding := range dc.Encodings {
+		if acceptsEncoding(acceptEncoding, coding) {
+			return coding
+		}
+	}
+	return ""
+}
+
+// encodingCache holds the compressed variants of a cache entry's files,
+// up to DynamicCompression.CacheSize bytes. A refresh replaces the entry
+// and with it this cache.
+type encodingCache struct {
+	variants *lruCache[variantKey, encodedVariant]
+}
+
+// variantKey names one compressed variant: the file, which of its
+// representations (as extracted, minified, assembled) and the encoding
+type variantKey struct {
+	rel, repr, coding string
+}
+
+// encodedVariant is a compressed body; data is nil when compressing did
+// not make the file smaller
+type encodedVariant struct {
+	data  []byte
+	ctype string
+}
+
+func newEncodingCache() *encodingCache {
+	return &encodingCache{variants: newLRUCache[variantKey, encodedVariant]()}
+}
+
+// serveEncoded serves the repr representation of rel, of size bytes,
+// compressed for the client when DynamicCompression applies, reading it
+// with load only when no variant is cached yet. It reports false, having
+// sent nothing, when the body should go out as it is.
+func (gp *GitteaPages) serveEncoded(w http.ResponseWriter, r *http.Request, entry *cacheEntry, rel, repr string, size int64, load func() ([]byte, error)) (bool, error) {
+	dc := gp.DynamicCompression
+	if dc == nil || size < dc.MinSize || size > dc.MaxSize || !gp.compressible(rel, nil) {
+		return false, nil
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	coding := dc.negotiate(r.Header.Get("Accept-Encoding"))
+	if coding == "" {
+		return false, nil
+	}
+
+	key := variantKey{rel: rel, repr: repr, coding: coding}
+	variant, ok := entry.encoded.get(key)
+	if !ok {
+		data, err := load()
+		if err != nil {
+			return false, err
+		}
+		if !gp.compressible(rel, data[:min(len(data), sniffLen)]) {
+			entry.encoded.put(key, encodedVariant{}, dc.CacheSize)
+			return false, nil
+		}
+		variant.ctype = mime.TypeByExtension(path.Ext(rel))
+		if variant.ctype == "" {
+			variant.ctype = http.DetectContentType(data)
+		}
+		if out, err := dc.encoders[coding](data); err == nil && len(out) < len(data) {
+			variant.data = out
+		}
+		entry.encoded.put(key, variant, dc.CacheSize)
+	}
+	if variant.data == nil {
+		return false, nil
+	}
+
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", variant.ctype)
+	}
+	w.Header().Set("Content-Encoding", coding)
+	if etag := w.Header().Get("ETag"); etag != "" {
+		// Ranges over the compressed bytes need their own validator
+		w.Header().Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+coding+`"`)
+	}
+	http.ServeContent(w, r, path.Base(rel), entry.lastUpdate, bytes.NewReader(variant.data))
+	return true, nil
+}
+
+// serveData serves data, the repr representation of rel held in memory,
+// compressed when DynamicCompression applies
+func (gp *GitteaPages) serveData(w http.ResponseWriter, r *http.Request, entry *cacheEntry, rel, repr string, data []byte) error {
+	served, err := gp.serveEncoded(w, r, entry, rel, repr, int64(len(data)), func() ([]byte, error) {
+		return data, nil
+	})
+	if err != nil || served {
+		return err
+	}
+	serveMemoryFile(w, r, path.Base(rel), entry.lastUpdate, data)
+	return nil
+}
+
+// get returns a cached variant; a nil cache, as for entries built by hand,
+// caches nothing
+func (c *encodingCache) get(key variantKey) (encodedVariant, bool) {
+	if c == nil {
+		return encodedVariant{}, false
+	}
+	return c.variants.get(key)
+}
+
+// put caches a variant, dropping the least recently served ones to keep
+// the cache within budget bytes
+func (c *encodingCache) put(key variantKey, v encodedVariant, budget int64) {
+	if c == nil {
+		return
+	}
+	c.variants.put(key, v, int64(len(key.rel)+len(v.data)), budget)
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows coding,
+// explicitly or through "*"
+func acceptsEncoding(acceptEncoding, coding string) bool {
+	accepted := parseAccept(acceptEncoding)
+	if q := accepted.q(strings.ToLower(coding)); q >= 0 {
+		return q > 0
+	}
+	return accepted.q("*") > 0
+}
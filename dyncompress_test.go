@@ -0,0 +1,226 @@
+package giteapages
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/klauspost/compress/zstd"
+)
+
+// countingEncoder wraps an encoder, counting the bodies it compresses
+func countingEncoder(calls *atomic.Int32, encode encodeFunc) encodeFunc {
+	return func(src []byte) ([]byte, error) {
+		calls.Add(1)
+		return encode(src)
+	}
+}
+
+func TestServeHTTP_DynamicCompression(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	script := strings.Repeat("console.log('compress me');\n", 100)
+	files := map[string]string{
+		"app.js":    script,
+		"small.txt": "tiny",
+	}
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {Name: "site", FullName: "user/site", DefaultBranch: "main", Files: files},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.ETags = true
+	gp.DynamicCompression = &DynamicCompression{Encodings: []string{"br", "gzip"}}
+	gp.DynamicCompression.provision()
+	var gzipCalls, brCalls atomic.Int32
+	encoders := gp.DynamicCompression.encoders
+	encoders["gzip"] = countingEncoder(&gzipCalls, encoders["gzip"])
+	encoders["br"] = countingEncoder(&brCalls, encoders["br"])
+
+	gunzip := func(data []byte) string {
+		t.Helper()
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Expected a gzip body: %v", err)
+		}
+		out, _ := io.ReadAll(gz)
+		return string(out)
+	}
+
+	// The first gzip request compresses, the second is served the variant
+	for i := 0; i < 2; i++ {
+		w := helper.MakeHTTPRequest("GET", "/user/site/app.js", "", map[string]string{"Accept-Encoding": "gzip"})
+		if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("Expected a gzip response, got %d %q", w.Code, w.Header().Get("Content-Encoding"))
+		}
+		if got := gunzip(w.Body.Bytes()); got != script {
+			t.Errorf("Expected the script decompressed, got %d bytes", len(got))
+		}
+		if etag := w.Header().Get("ETag"); !strings.HasSuffix(etag, `-gzip"`) {
+			t.Errorf("Expected a gzip variant ETag, got %s", etag)
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.Contains(ct, "javascript") {
+			t.Errorf("Expected a JavaScript Content-Type, got %q", ct)
+		}
+	}
+	if n := gzipCalls.Load(); n != 1 {
+		t.Errorf("Expected one gzip compression, got %d", n)
+	}
+
+	// br is preferred and kept as a variant of its own
+	w := helper.MakeHTTPRequest("GET", "/user/site/app.js", "", map[string]string{"Accept-Encoding": "gzip, br"})
+	if w.Header().Get("Content-Encoding") != "br" {
+		t.Errorf("Expected a br response, got %q", w.Header().Get("Content-Encoding"))
+	}
+	if got, err := io.ReadAll(brotli.NewReader(w.Body)); err != nil || string(got) != script {
+		t.Errorf("Expected the script decompressed, got %d bytes, %v", len(got), err)
+	}
+	if brCalls.Load() != 1 || gzipCalls.Load() != 1 {
+		t.Errorf("Expected one br and one gzip compression, got %d and %d", brCalls.Load(), gzipCalls.Load())
+	}
+
+	// Without a common encoding, and below min_size, bodies go out as-is
+	w = helper.MakeHTTPRequest("GET", "/user/site/app.js", "", map[string]string{"Accept-Encoding": "gzip;q=0, identity"})
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != script {
+		t.Errorf("Expected the script uncompressed, got %q", w.Header().Get("Content-Encoding"))
+	}
+	if vary := w.Header().Values("Vary"); !strings.Contains(strings.Join(vary, ","), "Accept-Encoding") {
+		t.Errorf("Expected Vary: Accept-Encoding, got %v", vary)
+	}
+	w = helper.MakeHTTPRequest("GET", "/user/site/small.txt", "", map[string]string{"Accept-Encoding": "gzip"})
+	helper.AssertResponse(w, http.StatusOK, "tiny")
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Error("Expected a file below min_size uncompressed")
+	}
+
+	// A refresh with new content drops the variants
+	files["app.js"] = strings.Repeat("console.log('changed');\n", 100)
+	if err := gp.updateRepoCache(context.Background(), "user", "site", "main"); err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+	w = helper.MakeHTTPRequest("GET", "/user/site/app.js", "", map[string]string{"Accept-Encoding": "gzip"})
+	if got := gunzip(w.Body.Bytes()); got != files["app.js"] {
+		t.Errorf("Expected the refreshed script, got %q...", got[:min(len(got), 30)])
+	}
+	if n := gzipCalls.Load(); n != 2 {
+		t.Errorf("Expected the refreshed file compressed again, got %d compressions", n)
+	}
+}
+
+func TestDynamicCompression_CacheSize(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	files := map[string]string{
+		"a.js": strings.Repeat("console.log('a');\n", 200),
+		"b.js": strings.Repeat("console.log('b');\n", 200),
+	}
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {Name: "site", FullName: "user/site", DefaultBranch: "main", Files: files},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	// Room for one compressed script only
+	gp.DynamicCompression = &DynamicCompression{CacheSize: 100}
+	gp.DynamicCompression.provision()
+	var calls atomic.Int32
+	gp.DynamicCompression.encoders["gzip"] = countingEncoder(&calls, encodeGzip)
+
+	accept := map[string]string{"Accept-Encoding": "gzip"}
+	for _, file := range []string{"a.js", "b.js", "a.js"} {
+		w := helper.MakeHTTPRequest("GET", "/user/site/"+file, "", accept)
+		if w.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("%s: expected a gzip response, got %q", file, w.Header().Get("Content-Encoding"))
+		}
+	}
+	// b.js pushed a.js out, so it was compressed again
+	if n := calls.Load(); n != 3 {
+		t.Errorf("Expected 3 compressions, got %d", n)
+	}
+	helper.MakeHTTPRequest("GET", "/user/site/a.js", "", accept)
+	if n := calls.Load(); n != 3 {
+		t.Errorf("Expected the last variant kept, got %d compressions", n)
+	}
+}
+
+func TestEncodeZstd(t *testing.T) {
+	src := []byte(strings.Repeat("zstd round trip ", 64))
+	out, err := encodeZstd(src)
+	if err != nil || len(out) >= len(src) {
+		t.Fatalf("Expected zstd to shrink the input, got %d bytes, %v", len(out), err)
+	}
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	if got, err := dec.DecodeAll(out, nil); err != nil || !bytes.Equal(got, src) {
+		t.Errorf("Expected the input back, got %v", err)
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header, coding string
+		want           bool
+	}{
+		{"gzip, br", "br", true},
+		{"gzip;q=0", "gzip", false},
+		{"*", "zstd", true},
+		{"*, gzip;q=0", "gzip", false},
+		{"*;q=0", "br", false},
+		{"deflate", "gzip", false},
+		{"", "gzip", false},
+	}
+	for _, tt := range tests {
+		if got := acceptsEncoding(tt.header, tt.coding); got != tt.want {
+			t.Errorf("acceptsEncoding(%q, %q) = %v, want %v", tt.header, tt.coding, got, tt.want)
+		}
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_DynamicCompression(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		dynamic_compression zstd gzip {
+			min_size 1KB
+			max_size 4MB
+		}
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	dc := gp.DynamicCompression
+	if dc == nil || strings.Join(dc.Encodings, " ") != "zstd gzip" || dc.MinSize != 1000 || dc.MaxSize != 4000000 {
+		t.Fatalf("Unexpected dynamic_compression %+v", dc)
+	}
+
+	d = caddyfile.NewTestDispenser(`gitea_pages {
+		dynamic_compression br {
+			cache_size 64MB
+		}
+	}`)
+	gp = GitteaPages{}
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if err := gp.DynamicCompression.validate(); err != nil || gp.DynamicCompression.CacheSize != 64000000 {
+		t.Errorf("Unexpected dynamic_compression %+v: %v", gp.DynamicCompression, err)
+	}
+
+	dc = &DynamicCompression{Encodings: []string{"deflate"}}
+	if err := dc.validate(); err == nil {
+		t.Error("Expected an encoding without an encoder to be rejected")
+	}
+}
@@ -0,0 +1,85 @@
+package giteapages
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+// errorPage is the value ErrorTemplate is executed with
+type errorPage struct {
+	Status     int
+	StatusText string
+	Message    string
+	Path       string
+	Host       string
+}
+
+// parseErrorTemplate reads and parses the ErrorTemplate file
+func parseErrorTemplate(name string) (*template.Template, error) {
+	src, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read error_template: %v", err)
+	}
+	tmpl, err := template.New("error").Parse(string(src))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse error_template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// serveError answers r with status and msg, rendered into ErrorTemplate
+// when one is configured and as plain text otherwise. A template that
+// fails to execute is logged and the plain text served instead.
+func (gp *GitteaPages) serveError(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	if gp.errorTmpl == nil {
+		http.Error(w, msg, status)
+		return
+	}
+
+	var page bytes.Buffer
+	err := gp.errorTmpl.Execute(&page, errorPage{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Message:    msg,
+		Path:       r.URL.Path,
+		Host:       r.Host,
+	})
+	if err != nil {
+		gp.logger.Warn("failed to render error template",
+			zap.Int("status", status),
+			zap.Error(err))
+		http.Error(w, msg, status)
+		return
+	}
+
+	// Headers meant for the file that could not be served do not apply
+	w.Header().Del("ETag")
+	w.Header().Del("Last-Modified")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(page.Len()))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(page.Bytes())
+	}
+}
+
+// templatedErrorStatus returns the status and message ErrorTemplate
+// answers err with when serving a file fails in a way that is otherwise
+// passed on to the next handler, or 0 when it is not one of them
+func templatedErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, errFileNotFound), errors.Is(err, errArchiveNotFound), errors.Is(err, errRepoNotFound):
+		return http.StatusNotFound, "page not found"
+	case errors.Is(err, errUpstreamBusy), errors.Is(err, errRepoRateLimited), errors.Is(err, errUpstreamRateLimited):
+		return http.StatusServiceUnavailable, "site temporarily unavailable, try again shortly"
+	}
+	return 0, ""
+}
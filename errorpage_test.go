@@ -0,0 +1,97 @@
+package giteapages
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+const testErrorTemplate = `<title>{{.Status}} {{.StatusText}}</title><p class="msg">{{.Message}}</p><p>{{.Host}}{{.Path}}</p>`
+
+func TestServeHTTP_ErrorTemplate(t *testing.T) {
+	tmplPath := filepath.Join(t.TempDir(), "error.html")
+	if err := os.WriteFile(tmplPath, []byte(testErrorTemplate), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	var err error
+	if gp.errorTmpl, err = parseErrorTemplate(tmplPath); err != nil {
+		t.Fatalf("parseErrorTemplate failed: %v", err)
+	}
+
+	// A missing page of a cached site
+	w := helper.MakeHTTPRequest("GET", "/user/website/missing.html", "", nil)
+	helper.AssertResponse(w, http.StatusNotFound, `<title>404 Not Found</title><p class="msg">page not found</p>`)
+	if !strings.Contains(w.Body.String(), "/user/website/missing.html") {
+		t.Errorf("Expected the request path in the page, got %s", w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Expected an HTML error page, got %q", ct)
+	}
+
+	// Gitea rate limiting a site that is not cached yet
+	helper.RateLimitNext(5, "60")
+	w = helper.MakeHTTPRequest("GET", "/org/blog/index.html", "", nil)
+	helper.AssertResponse(w, http.StatusServiceUnavailable,
+		`<title>503 Service Unavailable</title><p class="msg">site temporarily unavailable, try again shortly</p>`)
+	if cc := w.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("Expected Cache-Control no-store, got %q", cc)
+	}
+
+	// Errors answered before, e.g. source maps, use the template too
+	w = helper.MakeHTTPRequest("GET", "/user/website/js/script.js.map", "", nil)
+	helper.AssertResponse(w, http.StatusNotFound, "<title>404 Not Found</title>")
+}
+
+func TestServeHTTP_ErrorTemplateUnset(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+
+	// Without a template misses are still passed on
+	w := helper.MakeHTTPRequest("GET", "/user/website/missing.html", "", nil)
+	helper.AssertResponse(w, http.StatusNotFound, "Not handled by gitea-pages")
+}
+
+func TestParseErrorTemplate(t *testing.T) {
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "bad.html")
+	if err := os.WriteFile(bad, []byte("{{.Status"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseErrorTemplate(bad); err == nil {
+		t.Error("Expected a malformed template to fail")
+	}
+	if _, err := parseErrorTemplate(filepath.Join(dir, "missing.html")); err == nil {
+		t.Error("Expected a missing template to fail")
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_ErrorTemplate(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		error_template /srv/error.html
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.ErrorTemplate != "/srv/error.html" {
+		t.Errorf("Unexpected error_template %q", gp.ErrorTemplate)
+	}
+}
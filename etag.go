@@ -0,0 +1,37 @@
+package giteapages
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+)
+
+// newBlobHash returns a hash that, once fed a file's content, yields the
+// git blob SHA of a file of the given size
+func newBlobHash(size int64) hash.Hash {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", size)
+	return h
+}
+
+// ifRangeMatches reports whether an If-Range header allows serving a
+// partial response for a representation with the given strong ETag. Dates
+// are never accepted, as only the ETag identifies the content.
+func ifRangeMatches(ifRange, etag string) bool {
+	if ifRange == "" {
+		return true
+	}
+	return etag != "" && !strings.HasPrefix(ifRange, "W/") && ifRange == etag
+}
+
+// gzipETag returns the ETag of the gzip-encoded representation of a file
+// whose identity representation has etag, or "" when it has none
+func gzipETag(header http.Header) string {
+	etag := header.Get("ETag")
+	if etag == "" {
+		return ""
+	}
+	return strings.TrimSuffix(etag, `"`) + `-gzip"`
+}
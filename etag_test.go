@@ -0,0 +1,137 @@
+package giteapages
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestETags_IfRange(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	large := "hello\n" + strings.Repeat("x", 2048)
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/downloads": {
+			Name:          "downloads",
+			FullName:      "user/downloads",
+			DefaultBranch: "main",
+			Files: map[string]string{
+				"hello.txt": "hello\n",
+				"large.bin": large,
+			},
+		},
+	})
+
+	// git hash-object of "hello\n"
+	const helloETag = `"ce013625030ba8dba906f756967f9e9ca394464a"`
+
+	tests := []struct {
+		name    string
+		config  GitteaPages
+		file    string
+		content string
+		etag    string
+	}{
+		{"disk", GitteaPages{}, "hello.txt", "hello\n", helloETag},
+		{"memory", GitteaPages{CacheMinSize: 1024}, "hello.txt", "hello\n", helloETag},
+		{"streamed", GitteaPages{CacheMaxSize: 1024}, "large.bin", large, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gp := tt.config
+			gp.GitteaURL = helper.server.URL
+			gp.CacheDir = t.TempDir()
+			gp.ETags = true
+			if err := gp.Provision(caddy.Context{}); err != nil {
+				t.Fatalf("Failed to provision: %v", err)
+			}
+			helper.gp = &gp
+
+			w := helper.MakeHTTPRequest("GET", "/user/downloads/"+tt.file, "", nil)
+			helper.AssertResponse(w, http.StatusOK, "")
+			etag := w.Header().Get("ETag")
+			if etag == "" || (tt.etag != "" && etag != tt.etag) {
+				t.Fatalf("Expected ETag %s, got %s", tt.etag, etag)
+			}
+
+			w = helper.MakeHTTPRequest("GET", "/user/downloads/"+tt.file, "", map[string]string{
+				"Range":    "bytes=0-4",
+				"If-Range": etag,
+			})
+			if w.Code != http.StatusPartialContent || w.Body.String() != "hello" {
+				t.Errorf("Matching If-Range: expected 206 'hello', got %d %.20q", w.Code, w.Body.String())
+			}
+
+			w = helper.MakeHTTPRequest("GET", "/user/downloads/"+tt.file, "", map[string]string{
+				"Range":    "bytes=0-4",
+				"If-Range": `"0000000000000000000000000000000000000000"`,
+			})
+			if w.Code != http.StatusOK || w.Body.String() != tt.content {
+				t.Errorf("Stale If-Range: expected 200 with the full file, got %d %.20q", w.Code, w.Body.String())
+			}
+
+			w = helper.MakeHTTPRequest("GET", "/user/downloads/"+tt.file, "", map[string]string{
+				"If-None-Match": etag,
+			})
+			helper.AssertResponse(w, http.StatusNotModified, "")
+		})
+	}
+}
+
+func TestETags_CompressedRepresentation(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/downloads": {
+			Name:          "downloads",
+			FullName:      "user/downloads",
+			DefaultBranch: "main",
+			Files:         map[string]string{"hello.txt": "hello\n"},
+		},
+	})
+	helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL:     helper.server.URL,
+		CompressCache: true,
+	})
+	helper.gp.ETags = true
+
+	w := helper.MakeHTTPRequest("GET", "/user/downloads/hello.txt", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "hello")
+	if etag := w.Header().Get("ETag"); etag != `"ce013625030ba8dba906f756967f9e9ca394464a"` {
+		t.Errorf("Expected the blob SHA as ETag, got %s", etag)
+	}
+
+	w = helper.MakeHTTPRequest("GET", "/user/downloads/hello.txt", "", map[string]string{
+		"Accept-Encoding": "gzip",
+	})
+	if etag := w.Header().Get("ETag"); etag != `"ce013625030ba8dba906f756967f9e9ca394464a-gzip"` {
+		t.Errorf("Expected a distinct ETag for the gzip representation, got %s", etag)
+	}
+}
+
+func TestIfRangeMatches(t *testing.T) {
+	tests := []struct {
+		ifRange  string
+		etag     string
+		expected bool
+	}{
+		{"", `"abc"`, true},
+		{"", "", true},
+		{`"abc"`, `"abc"`, true},
+		{`"abd"`, `"abc"`, false},
+		{`W/"abc"`, `"abc"`, false},
+		{"Wed, 21 Oct 2015 07:28:00 GMT", `"abc"`, false},
+		{`"abc"`, "", false},
+	}
+
+	for _, tt := range tests {
+		if got := ifRangeMatches(tt.ifRange, tt.etag); got != tt.expected {
+			t.Errorf("ifRangeMatches(%q, %q): expected %v, got %v", tt.ifRange, tt.etag, tt.expected, got)
+		}
+	}
+}
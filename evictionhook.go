@@ -0,0 +1,110 @@
+package giteapages
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// evictionWebhookAttempts is how many times an eviction notice is sent
+// before giving up
+const evictionWebhookAttempts = 3
+
+// defaultEvictionRetryDelay is the wait before the first retry of an
+// eviction notice; later retries double it
+const defaultEvictionRetryDelay = time.Second
+
+// evictionForwardedHeader marks eviction notices. A relay passing a notice
+// on to a peer as a push webhook keeps the header, and the peer then
+// refreshes without sending a notice of its own, so peers never notify
+// each other in a loop.
+const evictionForwardedHeader = "X-Pages-Eviction-Forwarded"
+
+// Reasons an eviction notice is sent for
+const (
+	evictionReasonEvicted = "evicted" // dropped to free space or idle
+	evictionReasonPushed  = "pushed"  // refreshed and purged after a push
+)
+
+// evictionNotice is the JSON body POSTed to EvictionWebhook
+type evictionNotice struct {
+	Key       string    `json:"key"`
+	Owner     string    `json:"owner"`
+	Repo      string    `json:"repo"`
+	Branch    string    `json:"branch"`
+	Reason    string    `json:"reason"`
+	EvictedAt time.Time `json:"evicted_at"`
+}
+
+// notifyEviction tells EvictionWebhook that cacheKey was evicted, or
+// replaced for reason, so peers can drop their copies too. The notice is
+// sent in the background and retried on failure; eviction never waits
+// for it.
+func (gp *GitteaPages) notifyEviction(cacheKey, reason string) {
+	if gp.EvictionWebhook == "" {
+		return
+	}
+
+	owner, repo, branch := gp.parsePin(cacheKey)
+	body, err := json.Marshal(evictionNotice{
+		Key:       cacheKey,
+		Owner:     owner,
+		Repo:      repo,
+		Branch:    branch,
+		Reason:    reason,
+		EvictedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return
+	}
+
+	go func() {
+		delay := gp.evictionRetryDelay
+		for attempt := 1; ; attempt++ {
+			err := gp.postEvictionNotice(body)
+			if err == nil {
+				return
+			}
+			if attempt == evictionWebhookAttempts {
+				gp.logger.Warn("failed to send eviction notice",
+					zap.String("cache_key", cacheKey),
+					zap.String("webhook", gp.EvictionWebhook),
+					zap.Int("attempts", attempt),
+					zap.Error(err))
+				return
+			}
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}()
+}
+
+// postEvictionNotice sends one eviction notice, treating any non-2xx
+// response as a failure
+func (gp *GitteaPages) postEvictionNotice(body []byte) error {
+	req, err := http.NewRequest("POST", gp.EvictionWebhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(evictionForwardedHeader, "1")
+	if gp.UserAgent != "" {
+		req.Header.Set("User-Agent", gp.UserAgent)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
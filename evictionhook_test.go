@@ -0,0 +1,152 @@
+package giteapages
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestEvictionWebhook(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	// The first attempt fails, so the notice must arrive on a retry
+	var attempts atomic.Int32
+	notices := make(chan evictionNotice, 1)
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Expected JSON POST, got %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var notice evictionNotice
+		if err := json.NewDecoder(r.Body).Decode(&notice); err != nil {
+			t.Errorf("Failed to decode notice: %v", err)
+		}
+		notices <- notice
+	}))
+	defer peer.Close()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+	})
+	gp.EvictionWebhook = peer.URL
+	gp.evictionRetryDelay = time.Millisecond
+	helper.CreateCacheEntry("user/site", "main", map[string]string{
+		"index.html": strings.Repeat("s", 500),
+	})
+
+	gp.MinFreeSpace = 1000
+	gp.freeSpaceFunc = func(dir string) (uint64, error) {
+		size, err := cacheDirSize(dir)
+		return uint64(1200 - size), err
+	}
+	gp.checkDiskUsage()
+
+	select {
+	case notice := <-notices:
+		if notice.Key != "user/site:main" || notice.Owner != "user" || notice.Repo != "site" || notice.Branch != "main" || notice.Reason != "evicted" {
+			t.Errorf("Unexpected notice %+v", notice)
+		}
+		if notice.EvictedAt.IsZero() {
+			t.Error("Expected eviction time in notice")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected eviction notice to be POSTed")
+	}
+	if n := attempts.Load(); n != 2 {
+		t.Errorf("Expected 2 attempts, got %d", n)
+	}
+}
+
+func TestEvictionWebhook_Push(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	notices := make(chan evictionNotice, 2)
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(evictionForwardedHeader) == "" {
+			t.Error("Expected the notice marked as forwarded")
+		}
+		var notice evictionNotice
+		if err := json.NewDecoder(r.Body).Decode(&notice); err != nil {
+			t.Errorf("Failed to decode notice: %v", err)
+		}
+		notices <- notice
+	}))
+	defer peer.Close()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Files:         map[string]string{"index.html": "<h1>Site</h1>"},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.WebhookPath = "/_hooks/gitea"
+	gp.WebhookSecret = testWebhookSecret
+	gp.EvictionWebhook = peer.URL
+
+	// A push relayed from a peer's notice refreshes without a notice
+	relayed := signedPush(t, "push", "refs/heads/main", testWebhookSecret)
+	relayed.Header.Set(evictionForwardedHeader, "1")
+	if err := gp.ServeHTTP(httptest.NewRecorder(), relayed, nil); err != nil {
+		t.Fatalf("ServeHTTP failed: %v", err)
+	}
+	gp.webhooks.Wait()
+
+	// A push from Gitea is announced
+	if err := gp.ServeHTTP(httptest.NewRecorder(), signedPush(t, "push", "refs/heads/main", testWebhookSecret), nil); err != nil {
+		t.Fatalf("ServeHTTP failed: %v", err)
+	}
+	gp.webhooks.Wait()
+
+	select {
+	case notice := <-notices:
+		if notice.Key != "user/site:main" || notice.Reason != "pushed" {
+			t.Errorf("Unexpected notice %+v", notice)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a notice for the push")
+	}
+	select {
+	case notice := <-notices:
+		t.Errorf("Expected one notice, also got %+v", notice)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, archive := helper.UpstreamCalls(); archive != 2 {
+		t.Errorf("Expected both pushes to refresh, got %d downloads", archive)
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_EvictionWebhook(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		eviction_webhook https://peer.example.com/evict
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.EvictionWebhook != "https://peer.example.com/evict" {
+		t.Errorf("Expected eviction_webhook to be set, got '%s'", gp.EvictionWebhook)
+	}
+
+	gp.EvictionWebhook = "peer.example.com/evict"
+	if err := gp.Validate(); err == nil {
+		t.Error("Expected Validate to reject a webhook without scheme")
+	}
+}
@@ -0,0 +1,131 @@
+package giteapages
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// fileLists caches the file list of each cache entry until the entry is
+// refreshed
+type fileLists struct {
+	mu    sync.Mutex
+	lists map[string]fileList
+}
+
+// fileList is the newline-delimited list built for an entry updated at
+// lastUpdate
+type fileList struct {
+	lastUpdate time.Time
+	data       []byte
+}
+
+// serveFileList lists every file of a repository, one path per line, for
+// scripts that would otherwise parse autoindex pages. Requests take the
+// form {files_path}/{owner}/{repo}?branch=...; the branch is downloaded
+// first when it is not cached or has expired. Denied paths are left out.
+func (gp *GitteaPages) serveFileList(w http.ResponseWriter, r *http.Request) error {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, gp.FilesPath), "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		http.Error(w, "expected /{owner}/{repo}", http.StatusBadRequest)
+		return nil
+	}
+	owner, repo := parts[0], parts[1]
+
+	branch := r.URL.Query().Get("branch")
+	if branch == "" {
+		branch = gp.DefaultBranch
+	}
+	if sha := gp.pinnedCommit(owner, repo); sha != "" {
+		branch = sha
+	}
+
+	repoKey := fmt.Sprintf("%s/%s", owner, repo)
+	cacheKey := fmt.Sprintf("%s:%s", repoKey, branch)
+	if gp.shouldUpdateCache(repoKey, branch) {
+		if err := gp.refreshEntry(r.Context(), owner, repo, branch, cacheKey); err != nil && !gp.usableStaleCopy(repoKey, branch, cacheKey) {
+			if errors.Is(err, errRepoNotFound) {
+				http.Error(w, "repository not found", http.StatusNotFound)
+				return nil
+			}
+			gp.logger.Warn("failed to list repository files",
+				zap.String("repo", repoKey),
+				zap.String("branch", branch),
+				zap.Error(err))
+			http.Error(w, "failed to fetch repository", http.StatusBadGateway)
+			return nil
+		}
+	}
+
+	gp.cache.mu.RLock()
+	entry, exists := gp.cache.repos[cacheKey]
+	gp.cache.mu.RUnlock()
+	if !exists {
+		http.Error(w, "repository not cached", http.StatusNotFound)
+		return nil
+	}
+
+	data, err := gp.entryFileList(cacheKey, entry)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, "", entry.lastUpdate, bytes.NewReader(data))
+	return nil
+}
+
+// entryFileList returns the file list of entry, building it on first use
+func (gp *GitteaPages) entryFileList(cacheKey string, entry *cacheEntry) ([]byte, error) {
+	gp.fileLists.mu.Lock()
+	cached, ok := gp.fileLists.lists[cacheKey]
+	gp.fileLists.mu.Unlock()
+	if ok && cached.lastUpdate.Equal(entry.lastUpdate) {
+		return cached.data, nil
+	}
+
+	var paths []string
+	err := filepath.WalkDir(entry.path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(entry.path, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.Name() == indexDotfile || gp.isDenied(rel) || entry.rejected[rel] {
+			return nil
+		}
+		paths = append(paths, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", cacheKey, err)
+	}
+	sort.Strings(paths)
+
+	var buf bytes.Buffer
+	for _, p := range paths {
+		buf.WriteString(p)
+		buf.WriteByte('\n')
+	}
+
+	gp.fileLists.mu.Lock()
+	gp.fileLists.lists[cacheKey] = fileList{lastUpdate: entry.lastUpdate, data: buf.Bytes()}
+	gp.fileLists.mu.Unlock()
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,80 @@
+package giteapages
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeFileList(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Files: map[string]string{
+				"index.html":        "<h1>Home</h1>",
+				"docs/guide.html":   "<h1>Guide</h1>",
+				"docs/img/logo.png": "png",
+				".env":              "SECRET=1",
+				"private/notes.txt": "notes",
+			},
+			Branches: map[string]map[string]string{
+				"dev": {"index.html": "<h1>Dev</h1>", "new.html": "<h1>New</h1>"},
+			},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.FilesPath = "/_pages/files"
+	gp.DenyFiles = []string{".env", "private/*"}
+
+	w := helper.MakeHTTPRequest("GET", "/_pages/files/user/site", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "")
+	if got, want := w.Body.String(), "docs/guide.html\ndocs/img/logo.png\nindex.html\n"; got != want {
+		t.Errorf("Expected file list %q, got %q", want, got)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("Expected a plain text Content-Type, got %q", ct)
+	}
+
+	// The list is built once per cached copy
+	gp.cache.mu.RLock()
+	entry := gp.cache.repos["user/site:main"]
+	gp.cache.mu.RUnlock()
+	gp.fileLists.mu.Lock()
+	gp.fileLists.lists["user/site:main"] = fileList{lastUpdate: entry.lastUpdate, data: []byte("cached\n")}
+	gp.fileLists.mu.Unlock()
+	w = helper.MakeHTTPRequest("GET", "/_pages/files/user/site", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "cached\n")
+
+	w = helper.MakeHTTPRequest("GET", "/_pages/files/user/site?branch=dev", "", nil)
+	if got, want := w.Body.String(), "index.html\nnew.html\n"; got != want {
+		t.Errorf("Expected dev file list %q, got %q", want, got)
+	}
+
+	w = helper.MakeHTTPRequest("GET", "/_pages/files/user/missing", "", nil)
+	helper.AssertResponse(w, http.StatusNotFound, "repository not found")
+
+	w = helper.MakeHTTPRequest("GET", "/_pages/files/user", "", nil)
+	helper.AssertResponse(w, http.StatusBadRequest, "expected /{owner}/{repo}")
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_FilesPath(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		files_path /_pages/files
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.FilesPath != "/_pages/files" {
+		t.Errorf("Expected files_path /_pages/files, got %q", gp.FilesPath)
+	}
+}
@@ -2,13 +2,29 @@ package giteapages
 
 import (
 	"archive/tar"
+	"bufio"
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
+	"html/template"
 	"io"
+	"math"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,9 +33,15 @@ import (
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/caddyserver/caddy/v2/modules/caddytls"
+	"github.com/dustin/go-humanize"
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 )
 
+// moduleVersion is reported in the default upstream User-Agent
+const moduleVersion = "1.0.0"
+
 func init() {
 	caddy.RegisterModule(GitteaPages{})
 	httpcaddyfile.RegisterHandlerDirective("gitea_pages", parseCaddyfile)
@@ -32,21 +54,509 @@ type GitteaPages struct {
 	GitteaURL   string `json:"gitea_url,omitempty"`
 	GitteaToken string `json:"gitea_token,omitempty"`
 
+	// Upstream request identification
+	UserAgent       string `json:"user_agent,omitempty"`
+	RequestIDHeader string `json:"request_id_header,omitempty"`
+
+	// APIPageSize is the page size requested from Gitea list endpoints
+	// (default 50). Pages are followed through Gitea's Link headers, so
+	// listings stay complete when Gitea's MAX_RESPONSE_ITEMS is lower.
+	APIPageSize int `json:"api_page_size,omitempty"`
+
+	// UpstreamProxy routes Gitea requests through an http, https or socks5
+	// proxy. Hosts matching an UpstreamNoProxy entry (an exact host, or a
+	// domain suffix such as ".internal") are contacted directly. Without
+	// UpstreamProxy the standard proxy environment variables apply.
+	UpstreamProxy   string   `json:"upstream_proxy,omitempty"`
+	UpstreamNoProxy []string `json:"upstream_no_proxy,omitempty"`
+
+	// MaxConcurrentUpstream caps the requests in flight to Gitea. Once the
+	// cap is reached, further requests wait up to UpstreamQueueTimeout for
+	// a slot (failing at once when it is zero) and a stale cached copy is
+	// served if there is one.
+	MaxConcurrentUpstream int            `json:"max_concurrent_upstream,omitempty"`
+	UpstreamQueueTimeout  caddy.Duration `json:"upstream_queue_timeout,omitempty"`
+
+	// PerRepoRateLimit caps the upstream refreshes each owner/repo may
+	// make per PerRepoRateInterval (one minute by default). Over-quota
+	// requests are served from the stale cache when there is one.
+	PerRepoRateLimit    int            `json:"per_repo_rate_limit,omitempty"`
+	PerRepoRateInterval caddy.Duration `json:"per_repo_rate_interval,omitempty"`
+
+	// RevalidateCooldown serves expired cache entries stale while they
+	// refresh in the background, starting at most one refresh per entry
+	// within this window so bursts of stale hits coalesce into one
+	RevalidateCooldown caddy.Duration `json:"revalidate_cooldown,omitempty"`
+
+	// MaxStale bounds how long after expiring a cached copy may still be
+	// served stale, whether while revalidating or because Gitea is
+	// unavailable. Past it the request fails as if nothing were cached.
+	MaxStale caddy.Duration `json:"max_stale,omitempty"`
+
+	// DedupeRequests lets concurrent requests for one uncached branch
+	// share its repository metadata lookup and archive download; a HEAD
+	// arriving while a GET downloads the branch waits for that download
+	DedupeRequests bool `json:"dedupe_requests,omitempty"`
+
+	// MaxRepoSize refuses repositories whose size, as reported by Gitea,
+	// exceeds this many bytes; they get a 403 instead of being mirrored.
+	// Refusals are remembered for the repository's cache TTL.
+	MaxRepoSize int64 `json:"max_repo_size,omitempty"`
+
 	// Local cache configuration
 	CacheDir string        `json:"cache_dir,omitempty"`
 	CacheTTL caddy.Duration `json:"cache_ttl,omitempty"`
 
+	// CacheNamespace isolates this instance's entries inside CacheDir, so
+	// e.g. staging and production can share a cache volume
+	CacheNamespace string `json:"cache_namespace,omitempty"`
+
+	// RepoCacheTTL overrides CacheTTL for individual "owner/repo" entries,
+	// e.g. a short TTL for a news site and a long one for archived docs
+	RepoCacheTTL map[string]caddy.Duration `json:"repo_cache_ttl,omitempty"`
+
+	// CacheDirMode and CacheFileMode are octal permissions, e.g. "0700",
+	// applied exactly to cache directories and extracted files. Unset,
+	// directories get 0755 and files keep the archive's modes.
+	CacheDirMode  string `json:"cache_dir_mode,omitempty"`
+	CacheFileMode string `json:"cache_file_mode,omitempty"`
+
+	// Cache disk monitoring: when CacheMonitorInterval is set the cache size
+	// and free space are sampled periodically, and entries are evicted
+	// oldest-first while free space is below MinFreeSpace bytes
+	CacheMonitorInterval caddy.Duration `json:"cache_monitor_interval,omitempty"`
+	MinFreeSpace         int64          `json:"min_free_space,omitempty"`
+
+	// CacheMaxIdle removes entries not served for this long, files and all,
+	// whatever their TTL; pinned entries are kept. Idle entries are looked
+	// for every CacheSweepInterval.
+	CacheMaxIdle       caddy.Duration `json:"cache_max_idle,omitempty"`
+	CacheSweepInterval caddy.Duration `json:"cache_sweep_interval,omitempty"`
+
+	// SharedCache coordinates instances sharing CacheDir, e.g. over NFS:
+	// downloads of an entry are serialised by an advisory lock file, and
+	// each download is recorded in an index file other instances adopt
+	// instead of downloading again. Refreshes are always extracted aside
+	// and renamed into place, as with SnapshotByCommit, so readers on
+	// other nodes never see a half-extracted entry.
+	SharedCache bool `json:"shared_cache,omitempty"`
+
+	// PerOwnerCacheQuota bounds the bytes each owner's cache entries may
+	// take on disk; OwnerCacheQuotas overrides it for individual owners.
+	// An owner over quota loses its own least recently refreshed entries
+	// after each refresh, never another owner's.
+	PerOwnerCacheQuota int64            `json:"per_owner_cache_quota,omitempty"`
+	OwnerCacheQuotas   map[string]int64 `json:"owner_cache_quotas,omitempty"`
+
+	// EvictionWebhook is POSTed a JSON notice naming each evicted cache
+	// entry and each branch refreshed by a push webhook, so other nodes
+	// sharing the sites can drop their copies too. Notices are sent in the
+	// background and retried a few times, and carry an
+	// X-Pages-Eviction-Forwarded header; push webhooks carrying it are not
+	// announced again.
+	EvictionWebhook string `json:"eviction_webhook,omitempty"`
+
+	// Pins lists "owner/repo" or "owner/repo:branch" entries that, once
+	// cached, are never refreshed or evicted. Pinned copies left on disk by
+	// a previous run are served again without contacting Gitea.
+	Pins []string `json:"pins,omitempty"`
+
+	// CommitPins maps "owner/repo" to a commit SHA that repository is
+	// served from, whatever branch a request resolves to. Pins can be
+	// changed at runtime through the admin API at
+	// /gitea_pages/commit_pins.
+	CommitPins map[string]string `json:"commit_pins,omitempty"`
+
+	// CacheDownloadURL remembers the resolved archive URL and ETag of each
+	// cache entry so refreshes can skip the repository metadata call and
+	// revalidate the archive with a conditional request instead. Any answer
+	// but 304, and every tenth refresh, goes through the full lookup, so
+	// the repository checks still run before anything is downloaded.
+	CacheDownloadURL bool `json:"cache_download_url,omitempty"`
+
+	// CompressCache stores extracted files gzip-compressed on disk. Clients
+	// accepting gzip are sent the stored bytes directly; others get them
+	// decompressed on the fly.
+	CompressCache bool `json:"compress_cache,omitempty"`
+
+	// CompressExtensions and NoCompressExtensions list file extensions that
+	// compress_cache always or never compresses, overriding the decision
+	// made from the file's type and leading bytes
+	CompressExtensions   []string `json:"compress_extensions,omitempty"`
+	NoCompressExtensions []string `json:"no_compress_extensions,omitempty"`
+
+	// StaleOnAuthError keeps serving an expired cache entry when refreshing
+	// it fails with 401 or 403, e.g. after a botched token rotation
+	StaleOnAuthError bool `json:"stale_on_auth_error,omitempty"`
+
+	// WarmOnHead answers HEAD requests for uncached repositories without
+	// downloading the archive and refreshes the cache in the background,
+	// so the next GET is a cache hit. Unless ProbeContents confirms the
+	// path, such a HEAD is answered 404 until the cache is warm. Without
+	// either option HEAD downloads the archive like GET.
+	WarmOnHead bool `json:"warm_on_head,omitempty"`
+
+	// ProbeContents answers HEAD requests for uncached repositories
+	// without downloading the archive, asking the Gitea contents API
+	// whether the path is a file or a directory, so missing paths get a
+	// 404 and directories resolve their index document. Answers are
+	// cached per path for the cache TTL.
+	ProbeContents bool `json:"probe_contents,omitempty"`
+
+	// SelfHeal records what each archive extracted and downloads an entry
+	// again when a requested file has gone missing or changed size on disk
+	SelfHeal bool `json:"self_heal,omitempty"`
+
+	// ETags sends each file's git blob SHA as a strong ETag, so
+	// conditional and If-Range requests are validated against the file's
+	// content rather than its modification time
+	ETags bool `json:"etags,omitempty"`
+
+	// CacheMinSize and CacheMaxSize bound the size of files cached on disk.
+	// Smaller files are kept in memory and larger ones are streamed from
+	// Gitea on every request instead of being stored. Zero means no bound.
+	CacheMinSize int64 `json:"cache_min_size,omitempty"`
+	CacheMaxSize int64 `json:"cache_max_size,omitempty"`
+
+	// StreamFallback races the contents API against the raw endpoint
+	// for streamed files, serving whichever answers first
+	StreamFallback *StreamFallback `json:"stream_fallback,omitempty"`
+
+	// BlobPathLength makes streamed files whose escaped path is longer
+	// than this many bytes be fetched by blob SHA through the git trees
+	// and blobs API, since the raw file URL embeds the whole path and may
+	// exceed URL length limits. Zero always uses the raw file endpoint.
+	BlobPathLength int `json:"blob_path_length,omitempty"`
+
+	// ImmutableAssets sends content-hashed files such as app.3f9a1c2b.js
+	// with an immutable Cache-Control and serves them from the cache
+	// without revalidation until the entry is evicted
+	ImmutableAssets *ImmutableAssets `json:"immutable_assets,omitempty"`
+
+	// Prerender serves static snapshots to crawlers whose User-Agent
+	// matches, and the regular files to everyone else
+	Prerender *Prerender `json:"prerender,omitempty"`
+
+	// Minify serves HTML, CSS and JavaScript minified, keeping each
+	// minified copy with the cache entry
+	Minify *Minify `json:"minify,omitempty"`
+
+	// Integrity sends the SHA-384 Subresource Integrity hash of scripts
+	// and stylesheets in an X-Content-Integrity header, recorded with
+	// the cache entry on extraction
+	Integrity *Integrity `json:"integrity,omitempty"`
+
+	// Includes assembles HTML pages from partials referenced as
+	// {{include "header.html"}}, keeping each assembled page with the
+	// cache entry
+	Includes *Includes `json:"includes,omitempty"`
+
+	// DynamicCompression compresses responses for the encodings clients
+	// accept, keeping each compressed variant with the cache entry
+	DynamicCompression *DynamicCompression `json:"dynamic_compression,omitempty"`
+
+	// VerifyManifest checks every extracted file against the repository's
+	// SHA256SUMS file, when it has one, and refuses to serve files whose
+	// digest differs. ManifestUnlisted decides files the manifest does not
+	// list: "serve" (the default) or "refuse".
+	VerifyManifest   bool   `json:"verify_manifest,omitempty"`
+	ManifestUnlisted string `json:"manifest_unlisted,omitempty"`
+
+	// SnapshotByCommit extracts every refresh into its own directory keyed
+	// by commit and swaps it in once complete, so a page and its assets
+	// never come from different commits. The previous snapshot is kept
+	// for requests still reading from it.
+	SnapshotByCommit bool `json:"snapshot_by_commit,omitempty"`
+
+	// RetryStaleDownload re-reads repository metadata and retries once when
+	// an archive download returns 404
+	RetryStaleDownload bool `json:"retry_stale_download,omitempty"`
+
 	// Pages configuration
 	DefaultBranch string   `json:"default_branch,omitempty"`
 	IndexFiles    []string `json:"index_files,omitempty"`
 
+	// SkipIndexExtensions lists file extensions, e.g. ".php", that are never
+	// picked as a directory index; resolution moves on to the next
+	// candidate instead
+	SkipIndexExtensions []string `json:"skip_index_extensions,omitempty"`
+
+	// StrictContentType sends X-Content-Type-Options: nosniff and serves
+	// files whose extension has no known MIME type as an
+	// application/octet-stream download instead of letting browsers guess
+	StrictContentType bool `json:"strict_content_type,omitempty"`
+
+	// OverlayDir is a local directory of shared files, e.g. a CSS reset or
+	// a logo, served for every site when the repository has no file at
+	// the requested path. Repository files always take precedence.
+	OverlayDir string `json:"overlay_dir,omitempty"`
+
+	// ServiceWorkerAllowed is sent as the Service-Worker-Allowed header
+	// with service worker scripts, letting a worker under e.g. /js/ control
+	// a wider scope such as "/". ServiceWorkerFiles names the scripts and
+	// defaults to sw.js and service-worker.js.
+	ServiceWorkerAllowed string   `json:"service_worker_allowed,omitempty"`
+	ServiceWorkerFiles   []string `json:"service_worker_files,omitempty"`
+
+	// DenyFiles lists glob patterns of repository files that are never
+	// served. Patterns match the path within the repository or, for
+	// patterns without a slash, any single file or directory name.
+	DenyFiles []string `json:"deny_files,omitempty"`
+
+	// AttachmentFiles lists glob patterns, matched like DenyFiles, of
+	// files served with Content-Disposition: attachment so browsers
+	// download them instead of rendering them
+	AttachmentFiles []string `json:"attachment_files,omitempty"`
+
+	// DownloadQuery names a query parameter letting clients choose
+	// between download and inline display of any file, overriding
+	// AttachmentFiles: "?download=1" sends it as an attachment
+	DownloadQuery string `json:"download_query,omitempty"`
+
+	// NotFoundFallbacks maps file extensions such as ".json" to responses
+	// served when a file with that extension is missing, instead of
+	// handing the request on for an HTML 404
+	NotFoundFallbacks map[string]*NotFoundFallback `json:"not_found_fallbacks,omitempty"`
+
+	// DenyWellKnown lets DenyFiles patterns such as ".*" hide the
+	// .well-known directory, which is otherwise always served
+	DenyWellKnown bool `json:"deny_well_known,omitempty"`
+
+	// AllowArchive lets clients download any directory as a zip by
+	// requesting archive.zip inside it
+	AllowArchive bool `json:"allow_archive,omitempty"`
+
+	// GunzipFallback serves a missing file from its ".gz" sibling, e.g.
+	// data.json from data.json.gz, decompressed once into the cache entry
+	GunzipFallback bool `json:"gunzip_fallback,omitempty"`
+
+	// DirectorySlash decides what happens to a directory requested without
+	// a trailing slash: "serve" (the default) serves its index in place,
+	// "redirect" sends a 301 to the slash form so relative links resolve
+	DirectorySlash string `json:"directory_slash,omitempty"`
+
+	// Autoindex lists the contents of directories that have no index
+	// document. JSONIndex answers directory requests whose Accept header
+	// prefers application/json with a JSON array of name, type and size.
+	Autoindex bool `json:"autoindex,omitempty"`
+	JSONIndex bool `json:"json_index,omitempty"`
+
+	// AutoindexReadme renders a listed directory's README above its
+	// file list, like a repository view on a forge
+	AutoindexReadme bool `json:"autoindex_readme,omitempty"`
+
+	// ReadmeAsIndex serves a directory's README (rendered when markdown)
+	// when none of the index files exist
+	ReadmeAsIndex bool `json:"readme_as_index,omitempty"`
+
+	// RenderMarkdown serves .md files as HTML pages. Clients that prefer
+	// text/markdown or text/plain in their Accept header still get the
+	// raw source from the same URL.
+	RenderMarkdown bool `json:"render_markdown,omitempty"`
+
+	// NormalizePaths collapses duplicate slashes and resolves dot segments
+	// in request paths before routing, rejecting any that would escape the
+	// repository root
+	NormalizePaths bool `json:"normalize_paths,omitempty"`
+
+	// MaxPathDepth rejects requests whose path has more segments than this
+	// before any cache or Gitea lookup. Zero means no limit.
+	MaxPathDepth int `json:"max_path_depth,omitempty"`
+
+	// Rewrites are applied in order to the request path before routing;
+	// the first matching rule wins
+	Rewrites []RewriteRule `json:"rewrites,omitempty"`
+
+	// CatchAll serves every request no resolution strategy matches, and
+	// path-routed requests naming a repository that does not exist
+	CatchAll *CatchAll `json:"catch_all,omitempty"`
+
+	// ResolutionOrder lists the strategies tried to map a request to a
+	// repository: "domain_mapping", "auto_mapping" and "path". The first
+	// that matches wins; strategies left out are disabled. Unset, the
+	// order is domain_mapping, auto_mapping, path.
+	ResolutionOrder []string `json:"resolution_order,omitempty"`
+
+	// StatusPath, when set, exposes deployment build-info for cached
+	// repositories under this path prefix
+	StatusPath string `json:"status_path,omitempty"`
+
+	// FilesPath, when set, lists every file of a repository as plain text,
+	// one path per line, under this path prefix
+	FilesPath string `json:"files_path,omitempty"`
+
+	// WebhookPath, when set, receives Gitea push webhooks signed with
+	// WebhookSecret; a push refreshes the pushed branch's cache entry in
+	// the background
+	WebhookPath   string `json:"webhook_path,omitempty"`
+	WebhookSecret string `json:"webhook_secret,omitempty"`
+
+	// WebhookJSONErrors answers rejected and ignored webhook deliveries
+	// with a JSON body naming the reason, which Gitea's delivery log shows
+	WebhookJSONErrors bool `json:"webhook_json_errors,omitempty"`
+
+	// PrefetchSitemapOnPush serves every page listed in a pushed branch's
+	// sitemap.xml once the push has refreshed it, so the first visitors
+	// after a deploy find the per-page work already done
+	PrefetchSitemapOnPush bool `json:"prefetch_sitemap_on_push,omitempty"`
+
+	// SurrogateControl tags responses for a fronting CDN and, with a
+	// purge URL, purges a branch's pages there after a push
+	SurrogateControl *SurrogateControl `json:"surrogate_control,omitempty"`
+
+	// AccessLog writes Common or Combined Log Format lines for every
+	// request to a file, for tooling that expects Apache-style logs
+	AccessLog *AccessLog `json:"access_log,omitempty"`
+
+	// HealthPath, when set, serves a liveness probe at {path}/live and a
+	// readiness probe at {path}/ready; readiness answers 503 while Gitea
+	// is unreachable or the cache directory is not writable. The probes
+	// are answered on every host the handler serves, ahead of force_https,
+	// client certificates and basic_auth, so readiness only names failed
+	// checks and is rechecked at most every 5 seconds.
+	HealthPath string `json:"health_path,omitempty"`
+
+	// BranchFallback serves requests for a branch that no longer exists,
+	// as the branches API confirms, from the repository's default branch
+	// rather than answering 404. Such responses carry the missing branch
+	// in an X-Pages-Branch-Fallback header.
+	BranchFallback bool `json:"branch_fallback,omitempty"`
+
+	// MissingBranch answers requests for a deleted branch, e.g. one a
+	// domain mapping still names, with a response of its own rather than
+	// a file-not-found; BranchFallback, when it applies, takes precedence
+	MissingBranch *MissingBranch `json:"missing_branch,omitempty"`
+
+	// BranchesPath, when set, lists each repository's branches as JSON
+	// under this path prefix. Lists are cached for BranchesTTL.
+	BranchesPath string         `json:"branches_path,omitempty"`
+	BranchesTTL  caddy.Duration `json:"branches_ttl,omitempty"`
+
+	// MetadataPath, when set, serves each repository's description,
+	// topics, default branch and last update as JSON under this path
+	// prefix. Metadata is cached, and may be cached by clients, for
+	// MetadataTTL.
+	MetadataPath string         `json:"metadata_path,omitempty"`
+	MetadataTTL  caddy.Duration `json:"metadata_ttl,omitempty"`
+
+	// StripHostPrefix, e.g. "www.", is removed from the request host when
+	// no mapping matches it as-is, so one mapping serves both forms
+	StripHostPrefix string `json:"strip_host_prefix,omitempty"`
+
+	// CanonicalHost redirects every host to its "www" or "apex" form with a
+	// 301. A redirect only happens when the target is in
+	// CanonicalHostAllow ("*" allows any) or has a certificate managed by
+	// Caddy; otherwise the request is served in place.
+	CanonicalHost      string   `json:"canonical_host,omitempty"`
+	CanonicalHostAllow []string `json:"canonical_host_allow,omitempty"`
+
+	// ForceHTTPS lists hosts whose plain-HTTP requests are redirected to
+	// HTTPS with a 301; "*" covers every host
+	ForceHTTPS []string `json:"force_https,omitempty"`
+
+	// RequireClientCert lists hosts served only to clients that presented
+	// a verified TLS client certificate; others get a 403. Certificate
+	// verification itself is configured in Caddy's TLS connection policies.
+	RequireClientCert []string `json:"require_client_cert,omitempty"`
+
+	// BasicAuth asks for a user name and password on selected hosts,
+	// optionally remembering logins in a signed cookie shared across
+	// subdomains
+	BasicAuth *BasicAuth `json:"basic_auth,omitempty"`
+
+	// ServeSourceMaps lists hosts allowed to serve .map source maps; "*"
+	// allows every host. Elsewhere .map requests get a 404 without any
+	// cache or Gitea lookup.
+	ServeSourceMaps []string `json:"serve_source_maps,omitempty"`
+
+	// NoIndexHosts lists host patterns, e.g. "*.preview.example.com",
+	// that search engines must not index: they get a disallow-all
+	// robots.txt in place of the repository's and an X-Robots-Tag header
+	NoIndexHosts []string `json:"noindex_hosts,omitempty"`
+
+	// Variants are alternate renderings, e.g. AMP pages, served in place
+	// of a file when a request asks for them and the repository has them
+	Variants []Variant `json:"variants,omitempty"`
+
+	// RegionIndex serves per-region directory index documents, e.g.
+	// index.eu.html, chosen by a CDN's country header
+	RegionIndex *RegionIndex `json:"region_index,omitempty"`
+
+	// BranchCookie lets visitors pin an allowed preview branch
+	BranchCookie *BranchCookie `json:"branch_cookie,omitempty"`
+
+	// LandingPage is a file, and LandingHTML inline HTML, served for the
+	// bare root of a host that is not mapped to a repository, e.g. to
+	// present a directory of hosted sites
+	LandingPage string `json:"landing_page,omitempty"`
+	LandingHTML string `json:"landing_html,omitempty"`
+
+	// HubPage serves a generated directory of the mapped domains, and
+	// optionally of discovered repositories, at the bare root of an
+	// unmapped host
+	HubPage *HubPage `json:"hub_page,omitempty"`
+
+	// ErrorTemplate is an html/template file rendered for every error
+	// response of a page request, executed with the response's Status,
+	// StatusText and Message and the request's Path and Host. With it set,
+	// missing pages (404) and an unavailable Gitea (503) are answered
+	// rather than passed on to the next handler.
+	ErrorTemplate string `json:"error_template,omitempty"`
+
+	// CORS sends cross-origin headers and answers preflight requests;
+	// domain mappings may override it
+	CORS *CORSPolicy `json:"cors,omitempty"`
+
 	// Custom domain mapping
+	// DomainMappings can also be changed at runtime through the
+	// /gitea_pages/domain_mappings admin API endpoint
 	DomainMappings []DomainMapping `json:"domain_mappings,omitempty"`
 	AutoMapping    *AutoMapping    `json:"auto_mapping,omitempty"`
 
 	// Internal fields
-	logger *zap.Logger
-	cache  *repoCache
+	logger        *zap.Logger
+	cache         *repoCache
+	stopMonitor   chan struct{}
+	mappingsMu    *sync.RWMutex
+	stopSweep     chan struct{}
+	stopPrune     chan struct{}
+	freeSpaceFunc func(string) (uint64, error)
+
+	// certificateFunc reports whether Caddy has a certificate for a host
+	certificateFunc func(string) bool
+
+	// evictionRetryDelay is the wait before retrying an eviction notice
+	evictionRetryDelay time.Duration
+
+	// sharedRemoveDelay is how long evicted shared files outlive their index
+	sharedRemoveDelay time.Duration
+
+	trees        *treeCache
+	revalidating *revalidations
+	webhooks     *sync.WaitGroup
+	commitPins   *commitPins
+	probes       *pathProbeCache
+	hub          *hubState
+	errorTmpl    *template.Template
+	transport    *http.Transport
+	limiter      *limitedTransport
+	branches     *branchCache
+	metadata     *metadataCache
+	fileLists    *fileLists
+	quota        *repoQuota
+	backoff      *upstreamBackoff
+	tooLarge     *sizeRefusals
+	warming      *singleflight.Group
+	fetches      *fetchFlights
+	repoInfos    *singleflight.Group
+	accessed     *accessTimes
+	dirs         *createdDirs
+	readiness    *readinessState
+	metrics      *pagesMetrics
+	dirMode      os.FileMode
+	fileMode     os.FileMode
+	placeholder  []byte
+	landing      []byte
 }
 
 // DomainMapping represents a custom domain to repository mapping
@@ -55,6 +565,57 @@ type DomainMapping struct {
 	Owner      string `json:"owner"`
 	Repository string `json:"repository"`
 	Branch     string `json:"branch,omitempty"`
+
+	// CacheTTL, when set, overrides the global cache TTL for the mapped
+	// repository
+	CacheTTL caddy.Duration `json:"cache_ttl,omitempty"`
+
+	// CORS, when set, replaces the global CORS policy for this domain
+	CORS *CORSPolicy `json:"cors,omitempty"`
+
+	// EnvPrefixes maps a leading path segment to the branch it serves, e.g.
+	// "staging" to "develop", so /staging/index.html is develop's
+	// index.html. The segment is stripped from the file path.
+	EnvPrefixes map[string]string `json:"env_prefix,omitempty"`
+
+	// Token, when set, replaces GitteaToken for the Gitea requests made
+	// for this repository through this mapping's domain, including its
+	// env prefixes and the branches and metadata endpoints, and for
+	// background and webhook refreshes, so each site can use a credential
+	// scoped to its own organization. The repository is then not served
+	// through other hosts or path routes at all. The hub page lists
+	// owners, not repositories, so it keeps to GitteaToken.
+	Token string `json:"token,omitempty"`
+}
+
+// envBranch returns the branch selected by filePath's first segment and
+// the path below it, when that segment is one of the EnvPrefixes
+func (m *DomainMapping) envBranch(filePath string) (branch, rest string, ok bool) {
+	if m == nil {
+		return "", "", false
+	}
+	prefix, rest, _ := strings.Cut(filePath, "/")
+	branch, ok = m.EnvPrefixes[prefix]
+	return branch, rest, ok
+}
+
+// CatchAll is the repository serving requests nothing else resolves
+type CatchAll struct {
+	Owner      string `json:"owner"`
+	Repository string `json:"repository"`
+	Branch     string `json:"branch,omitempty"`
+}
+
+// RewriteRule rewrites request paths matching Pattern, a regular
+// expression, to Target, which may reference capture groups as $1 or
+// ${name}. With a Redirect status the client is redirected to the target
+// instead of it being served internally.
+type RewriteRule struct {
+	Pattern  string `json:"pattern"`
+	Target   string `json:"target"`
+	Redirect int    `json:"redirect,omitempty"`
+
+	re *regexp.Regexp
 }
 
 // AutoMapping defines automatic domain-to-repository mapping rules
@@ -64,6 +625,25 @@ type AutoMapping struct {
 	Owner      string `json:"owner,omitempty"`       // Default owner for auto-mapped repos
 	RepoFormat string `json:"repo_format,omitempty"` // e.g., "{domain}" or "{subdomain}"
 	Branch     string `json:"branch,omitempty"`      // Override default branch for auto-mapped repos
+
+	// DefaultRepo is served for the root of "{owner}.{domain}/{repo}"
+	// hosts when the path names no project, e.g. "{owner}.example.com"
+	DefaultRepo string `json:"default_repo,omitempty"`
+
+	// Repository is the single repository of the "{dir}.{domain}"
+	// pattern, which maps each subdomain to a directory of it, e.g.
+	// fr.example.com/about.html to fr/about.html. DirFormat builds the
+	// directory from "{subdomain}" (the default) and Dirs, when set,
+	// lists the subdomains that are mapped.
+	Repository string   `json:"repository,omitempty"`
+	DirFormat  string   `json:"dir_format,omitempty"`
+	Dirs       []string `json:"dirs,omitempty"`
+
+	// Placeholder is a local HTML file served, with PlaceholderStatus
+	// (404 by default), when a host auto-maps to a repository that does
+	// not exist in Gitea yet
+	Placeholder       string `json:"placeholder,omitempty"`
+	PlaceholderStatus int    `json:"placeholder_status,omitempty"`
 }
 
 // repoCache manages cached repository contents
@@ -74,16 +654,120 @@ type repoCache struct {
 }
 
 type cacheEntry struct {
-	lastUpdate time.Time
-	path       string
+	lastUpdate  time.Time
+	path        string
+	downloadURL string
+	reuses      int
+	etag        string
+	commit      string
+	compressed  bool
+	files       map[string]int64
+	memory      map[string][]byte
+	streamed    map[string]bool
+	blobs       map[string]string
+	integrity   map[string]string
+	rejected    map[string]bool
+
+	// indexes remembers the index document found for each directory, so
+	// repeat requests skip probing the candidates. A refresh replaces the
+	// entry and with it this cache.
+	indexes *indexCache
+
+	// minified holds minified copies of files served with Minify
+	minified *minifyCache
+
+	// included holds pages assembled from partials with Includes
+	included *includeCache
+
+	// encoded holds compressed variants served with DynamicCompression
+	encoded *encodingCache
+}
+
+// indexCache maps directories to their resolved index document, "" when
+// there is none
+type indexCache struct {
+	mu    sync.Mutex
+	names map[string]string
+}
+
+func newIndexCache() *indexCache {
+	return &indexCache{names: make(map[string]string)}
+}
+
+// archiveInfo describes an extracted repository archive
+type archiveInfo struct {
+	etag   string
+	commit string
+
+	// files maps each extracted file to its size on disk; it is only
+	// recorded when SelfHeal is on
+	files map[string]int64
+
+	// memory holds files below CacheMinSize and streamed marks files above
+	// CacheMaxSize, both keyed by path within the repository
+	memory   map[string][]byte
+	streamed map[string]bool
+
+	// blobs maps each file to its git blob SHA; it is only recorded when
+	// ETags is on
+	blobs map[string]string
+
+	// integrity maps files to their SRI hash; it is only recorded for
+	// the files Integrity covers
+	integrity map[string]string
+
+	// rejected holds files verify_manifest refuses to serve
+	rejected map[string]bool
+
+	// revalidateOnly makes downloadAndExtractRepo give up with
+	// errArchiveChanged on any answer but 304 instead of extracting it
+	revalidateOnly bool
 }
 
+// errNotModified is returned by downloadAndExtractRepo when the archive
+// has not changed since the ETag it was given.
+var errNotModified = errors.New("archive not modified")
+
+// errArchiveNotFound is returned by downloadAndExtractRepo when Gitea
+// answers the archive request with 404
+var errArchiveNotFound = errors.New("archive not found")
+
+// errArchiveChanged is returned by downloadAndExtractRepo when an archive
+// it was only asked to revalidate answers with anything but 304
+var errArchiveChanged = errors.New("archive changed")
+
+// downloadURLReuses is how many refreshes in a row may revalidate the
+// remembered archive URL of a CacheDownloadURL entry before the repository
+// is looked up again
+const downloadURLReuses = 10
+
+// errUpstreamAuth is returned when Gitea rejects the configured credentials
+var errUpstreamAuth = errors.New("gitea rejected credentials")
+
+// errRepoNotFound is returned by getRepoInfo when Gitea has no such
+// repository
+var errRepoNotFound = errors.New("repository not found")
+
+// errFileNotFound is returned by serveFile when the branch has no file
+// to serve for the request
+var errFileNotFound = errors.New("file not found")
+
+// errUnexpectedContent is returned when Gitea, or a proxy in front of it,
+// answers an API call with something other than JSON, e.g. a login page
+var errUnexpectedContent = errors.New("gitea returned unexpected content")
+
+// maxBodySnippet bounds how much of an unexpected upstream body is logged
+const maxBodySnippet = 200
+
 // GitteaRepo represents a repository from Gitea API
 type GitteaRepo struct {
 	Name          string `json:"name"`
 	FullName      string `json:"full_name"`
-	DefaultBranch string `json:"default_branch"`
-	UpdatedAt     string `json:"updated_at"`
+	DefaultBranch string   `json:"default_branch"`
+	UpdatedAt     string   `json:"updated_at"`
+	Size          int64    `json:"size"` // KiB
+	Description   string   `json:"description"`
+	Topics        []string `json:"topics"`
 }
 
 // CaddyModule returns the Caddy module information
@@ -111,9 +795,136 @@ func (gp *GitteaPages) Provision(ctx caddy.Context) error {
 	if len(gp.IndexFiles) == 0 {
 		gp.IndexFiles = []string{"index.html", "index.htm"}
 	}
+	if gp.UserAgent == "" {
+		gp.UserAgent = "caddy-gitea-pages/" + moduleVersion
+	}
+	if gp.RequestIDHeader == "" {
+		gp.RequestIDHeader = "X-Request-ID"
+	}
+	if gp.BranchesTTL == 0 {
+		gp.BranchesTTL = caddy.Duration(time.Minute)
+	}
+	if gp.MetadataTTL == 0 {
+		gp.MetadataTTL = caddy.Duration(5 * time.Minute)
+	}
+	if gp.DynamicCompression != nil {
+		gp.DynamicCompression.provision()
+	}
+	if gp.RegionIndex != nil {
+		gp.RegionIndex.provision()
+	}
+	if gp.MissingBranch != nil {
+		if err := gp.MissingBranch.provision(); err != nil {
+			return err
+		}
+	}
+
+	// Host names are case-insensitive, so mapped domains are kept in lower
+	// case, as the admin API stores them, and matched against the request
+	// host in lower case
+	for i := range gp.DomainMappings {
+		gp.DomainMappings[i].Domain = strings.ToLower(gp.DomainMappings[i].Domain)
+	}
+	gp.StripHostPrefix = strings.ToLower(gp.StripHostPrefix)
+
+	transport, err := gp.newTransport()
+	if err != nil {
+		return err
+	}
+	gp.transport = transport
+	if gp.MaxConcurrentUpstream > 0 {
+		gp.limiter = &limitedTransport{
+			base:    transport,
+			slots:   make(chan struct{}, gp.MaxConcurrentUpstream),
+			timeout: time.Duration(gp.UpstreamQueueTimeout),
+		}
+	}
+
+	if gp.PerRepoRateLimit > 0 {
+		if gp.PerRepoRateInterval == 0 {
+			gp.PerRepoRateInterval = caddy.Duration(time.Minute)
+		}
+		gp.quota = &repoQuota{
+			limit:    gp.PerRepoRateLimit,
+			interval: time.Duration(gp.PerRepoRateInterval),
+			windows:  make(map[string]*quotaWindow),
+		}
+	}
+
+	if gp.OverlayDir != "" {
+		info, err := os.Stat(gp.OverlayDir)
+		if err != nil {
+			return fmt.Errorf("failed to open overlay_dir: %v", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("overlay_dir %s is not a directory", gp.OverlayDir)
+		}
+	}
+
+	switch {
+	case gp.LandingPage != "":
+		page, err := os.ReadFile(gp.LandingPage)
+		if err != nil {
+			return fmt.Errorf("failed to read landing_page: %v", err)
+		}
+		gp.landing = page
+	case gp.LandingHTML != "":
+		gp.landing = []byte(gp.LandingHTML)
+	}
+
+	if gp.ImmutableAssets != nil {
+		if err := gp.ImmutableAssets.provision(); err != nil {
+			return err
+		}
+	}
+
+	if gp.Prerender != nil {
+		if err := gp.Prerender.provision(); err != nil {
+			return err
+		}
+	}
+
+	if gp.HubPage != nil {
+		hub, err := newHubState(gp.HubPage)
+		if err != nil {
+			return err
+		}
+		gp.hub = hub
+	}
+	if gp.ErrorTemplate != "" {
+		if gp.errorTmpl, err = parseErrorTemplate(gp.ErrorTemplate); err != nil {
+			return err
+		}
+	}
+
+	if gp.AutoMapping != nil && gp.AutoMapping.Placeholder != "" {
+		page, err := os.ReadFile(gp.AutoMapping.Placeholder)
+		if err != nil {
+			return fmt.Errorf("failed to read auto_mapping placeholder: %v", err)
+		}
+		gp.placeholder = page
+		if gp.AutoMapping.PlaceholderStatus == 0 {
+			gp.AutoMapping.PlaceholderStatus = http.StatusNotFound
+		}
+	}
+
+	for i := range gp.Rewrites {
+		re, err := regexp.Compile(gp.Rewrites[i].Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid rewrite pattern %q: %v", gp.Rewrites[i].Pattern, err)
+		}
+		gp.Rewrites[i].re = re
+	}
+
+	if gp.dirMode, err = parseFileMode(gp.CacheDirMode); err != nil {
+		return fmt.Errorf("invalid cache_dir_mode: %v", err)
+	}
+	if gp.fileMode, err = parseFileMode(gp.CacheFileMode); err != nil {
+		return fmt.Errorf("invalid cache_file_mode: %v", err)
+	}
 
 	// Create cache directory
-	if err := os.MkdirAll(gp.CacheDir, 0755); err != nil {
+	if err := gp.makeCacheDir(gp.CacheDir, 0755); err != nil {
 		return fmt.Errorf("failed to create cache directory: %v", err)
 	}
 
@@ -122,330 +933,1888 @@ func (gp *GitteaPages) Provision(ctx caddy.Context) error {
 		repos:    make(map[string]*cacheEntry),
 		cacheDir: gp.CacheDir,
 	}
+	if gp.CacheNamespace != "" {
+		// Gitea owner names cannot start with "@", so a namespace never
+		// collides with an owner directory of an instance without one
+		gp.cache.cacheDir = filepath.Join(gp.CacheDir, "@"+cacheSegment(gp.CacheNamespace))
+		if err := gp.makeCacheDir(gp.cache.cacheDir, 0755); err != nil {
+			return fmt.Errorf("failed to create cache namespace directory: %v", err)
+		}
+	}
+	gp.branches = &branchCache{lists: make(map[string]branchCacheEntry)}
+	gp.metadata = newMetadataCache()
+	gp.mappingsMu = &sync.RWMutex{}
+	gp.fileLists = &fileLists{lists: make(map[string]fileList)}
+	gp.warming = &singleflight.Group{}
+	gp.fetches = &fetchFlights{calls: make(map[string]*fetchFlight)}
+	gp.repoInfos = &singleflight.Group{}
+	gp.accessed = newAccessTimes()
+	gp.readiness = &readinessState{}
+	metrics, err := newPagesMetrics(metricsRegistry(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to register metrics: %v", err)
+	}
+	gp.metrics = metrics
+	gp.dirs = newCreatedDirs(func(dir string) error { return gp.makeCacheDir(dir, 0755) })
+	gp.backoff = &upstreamBackoff{}
+	gp.tooLarge = &sizeRefusals{until: make(map[string]time.Time)}
+	gp.evictionRetryDelay = defaultEvictionRetryDelay
+	gp.sharedRemoveDelay = defaultSharedRemoveDelay
+	gp.trees = &treeCache{entries: make(map[string]treeCacheEntry)}
+	gp.probes = newPathProbeCache()
+	gp.webhooks = &sync.WaitGroup{}
+	gp.revalidating = &revalidations{started: make(map[string]time.Time)}
+	gp.commitPins = &commitPins{shas: make(map[string]string, len(gp.CommitPins))}
+	for repoKey, sha := range gp.CommitPins {
+		gp.commitPins.shas[repoKey] = strings.ToLower(sha)
+	}
+
+	if gp.CanonicalHost != "" {
+		if app, err := ctx.AppIfConfigured("tls"); err == nil {
+			if tlsApp, ok := app.(*caddytls.TLS); ok {
+				gp.certificateFunc = tlsApp.HasCertificateForSubject
+			}
+		}
+	}
+
+	// Bring back pinned entries extracted by a previous run. Entries with a
+	// cache size band are skipped, as their disk copy is incomplete.
+	for _, pin := range gp.Pins {
+		if gp.CacheMinSize > 0 || gp.CacheMaxSize > 0 {
+			break
+		}
+		owner, repo, branch := gp.parsePin(pin)
+		entryPath := gp.cache.entryPath(owner, repo, branch)
+		if gp.snapshotEntries() {
+			entryPath = latestSnapshot(entryPath)
+		}
+		info, err := os.Stat(entryPath)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		entry := &cacheEntry{
+			lastUpdate: info.ModTime(),
+			path:       entryPath,
+			compressed: gp.CompressCache,
+			indexes:    newIndexCache(),
+			minified:   newMinifyCache(),
+			included:   newIncludeCache(),
+			encoded:    newEncodingCache(),
+		}
+		if gp.VerifyManifest {
+			// The checksums of a previous run are not kept, so hash the
+			// copy on disk again
+			sums, err := gp.diskChecksums(entryPath)
+			if err != nil {
+				continue
+			}
+			entry.rejected = gp.manifestRejections(entryPath, sums, nil, "")
+		}
+		gp.cache.repos[fmt.Sprintf("%s/%s:%s", owner, repo, branch)] = entry
+	}
+
+	if gp.CacheMonitorInterval > 0 {
+		gp.stopMonitor = make(chan struct{})
+		go gp.monitorCache(time.Duration(gp.CacheMonitorInterval), gp.stopMonitor)
+	}
+	if gp.CacheMaxIdle > 0 {
+		gp.stopSweep = make(chan struct{})
+		go gp.sweepIdleCache(gp.cacheSweepInterval(), gp.stopSweep)
+	}
+	if gp.ProbeContents || gp.MetadataPath != "" {
+		gp.stopPrune = make(chan struct{})
+		go gp.pruneLookups(lookupPruneInterval, gp.stopPrune)
+	}
 
 	gp.logger.Info("gitea_pages module provisioned",
 		zap.String("gitea_url", gp.GitteaURL),
 		zap.String("cache_dir", gp.CacheDir),
 		zap.Duration("cache_ttl", time.Duration(gp.CacheTTL)))
 
+	if gp.AccessLog != nil {
+		if err := gp.AccessLog.open(); err != nil {
+			return err
+		}
+	}
+
+	gp.registerAdmin()
+	return nil
+}
+
+// Cleanup stops background work started by Provision
+func (gp *GitteaPages) Cleanup() error {
+	if gp.stopMonitor != nil {
+		close(gp.stopMonitor)
+		gp.stopMonitor = nil
+	}
+	if gp.stopSweep != nil {
+		close(gp.stopSweep)
+		gp.stopSweep = nil
+	}
+	if gp.stopPrune != nil {
+		close(gp.stopPrune)
+		gp.stopPrune = nil
+	}
+	gp.unregisterAdmin()
+	if gp.AccessLog != nil {
+		return gp.AccessLog.close()
+	}
 	return nil
 }
 
-// ServeHTTP handles HTTP requests
+// ServeHTTP handles HTTP requests, recording them in the access log
 func (gp *GitteaPages) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
-	// Try to resolve the request using custom domain mapping
-	owner, repo, filePath, branch := gp.resolveDomainMapping(r)
+	if gp.AccessLog == nil {
+		return gp.serveRequest(w, r, next)
+	}
+	rec, logged := gp.logAccess(w, r)
+	err := gp.serveRequest(rec, r, next)
+	logged(err)
+	return err
+}
 
-	if owner == "" || repo == "" {
-		// Fallback to path-based routing if no domain mapping found
-		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
-		if len(parts) < 2 {
-			return next.ServeHTTP(w, r)
-		}
+// serveRequest handles HTTP requests
+func (gp *GitteaPages) serveRequest(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	// Carry the incoming request ID through to any upstream fetches
+	if id := r.Header.Get(gp.RequestIDHeader); id != "" {
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+	}
 
-		owner = parts[0]
-		repo = parts[1]
-		filePath = strings.Join(parts[2:], "/")
+	// Probes come from the orchestrator, not browsers, so they skip the
+	// redirects and access checks below
+	if gp.HealthPath != "" && strings.HasPrefix(r.URL.Path, strings.TrimRight(gp.HealthPath, "/")+"/") {
+		return gp.serveHealth(w, r)
 	}
 
-	// If no file path specified, look for index files
-	if filePath == "" {
-		filePath = gp.findIndexFile(owner, repo)
-		if filePath == "" {
-			return next.ServeHTTP(w, r)
+	// Webhooks are authenticated by their signature instead
+	if gp.WebhookPath != "" && r.URL.Path == gp.WebhookPath {
+		return gp.serveWebhook(w, r)
+	}
+
+	if gp.requiresHTTPS(r) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
 		}
+		target := url.URL{Scheme: "https", Host: host, Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+		return nil
 	}
 
-	// Use custom branch if specified, otherwise use default
-	if branch == "" {
-		branch = gp.DefaultBranch
+	if target := gp.canonicalTarget(r); target != "" {
+		redirectToCanonical(w, r, target)
+		return nil
 	}
 
-	// Serve the file from cache or fetch from Gitea
-	if err := gp.serveFile(w, r, owner, repo, filePath, branch); err != nil {
-		gp.logger.Error("failed to serve file",
-			zap.String("owner", owner),
-			zap.String("repo", repo),
-			zap.String("file", filePath),
-			zap.String("branch", branch),
-			zap.Error(err))
-		return next.ServeHTTP(w, r)
+	if hostListed(gp.RequireClientCert, r.Host) && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+		gp.serveError(w, r, http.StatusForbidden, "client certificate required")
+		return nil
 	}
 
-	return nil
-}
+	if gp.applyCORS(w, r) {
+		return nil
+	}
 
-// serveFile serves a file from the repository
-func (gp *GitteaPages) serveFile(w http.ResponseWriter, r *http.Request, owner, repo, filePath, branch string) error {
-	repoKey := fmt.Sprintf("%s/%s", owner, repo)
+	if gp.requireBasicAuth(w, r) {
+		return nil
+	}
+
+	if gp.serveNoIndex(w, r) {
+		return nil
+	}
 
-	// Check if we need to update the cache
-	if gp.shouldUpdateCache(repoKey, branch) {
-		if err := gp.updateRepoCache(owner, repo, branch); err != nil {
-			return fmt.Errorf("failed to update cache: %v", err)
+	if gp.NormalizePaths {
+		// Path-based routing roots each repository at /{owner}/{repo}, while
+		// mapped domains serve the repository from /
+		floor := 2
+		if owner, _, _, _ := gp.resolveDomainMapping(r); owner != "" {
+			floor = 0
+		}
+
+		cleaned, ok := normalizePath(r.URL.Path, floor)
+		if !ok {
+			gp.serveError(w, r, http.StatusBadRequest, "invalid path")
+			return nil
+		}
+		if cleaned != r.URL.Path {
+			r = r.Clone(r.Context())
+			r.URL.Path = cleaned
+			r.URL.RawPath = ""
 		}
 	}
 
-	// Get cached repo path
-	cacheKey := fmt.Sprintf("%s:%s", repoKey, branch)
-	gp.cache.mu.RLock()
-	entry, exists := gp.cache.repos[cacheKey]
-	gp.cache.mu.RUnlock()
-
-	if !exists {
-		return fmt.Errorf("repository not found in cache")
+	if gp.MaxPathDepth > 0 && pathDepth(r.URL.Path) > gp.MaxPathDepth {
+		gp.serveError(w, r, http.StatusNotFound, "404 page not found")
+		return nil
 	}
 
-	// Serve the file
-	fullPath := filepath.Join(entry.path, filePath)
+	for _, rule := range gp.Rewrites {
+		match := rule.re.FindStringSubmatchIndex(r.URL.Path)
+		if match == nil {
+			continue
+		}
+		target := string(rule.re.ExpandString(nil, rule.Target, r.URL.Path, match))
 
-	// Security check: ensure the file is within the repository directory
-	if !strings.HasPrefix(fullPath, entry.path) {
-		return fmt.Errorf("invalid file path")
+		if rule.Redirect != 0 {
+			http.Redirect(w, r, target, rule.Redirect)
+			return nil
+		}
+
+		// Internal rewrite: route on the new path while the client's URL
+		// stays as requested
+		r = r.Clone(r.Context())
+		r.URL.Path = target
+		r.URL.RawPath = ""
+		break
 	}
 
-	// Check if file exists
-	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
-		return fmt.Errorf("file not found")
+	if gp.StatusPath != "" && strings.HasPrefix(r.URL.Path, strings.TrimRight(gp.StatusPath, "/")+"/") {
+		return gp.serveStatus(w, r)
 	}
 
-	http.ServeFile(w, r, fullPath)
-	return nil
-}
+	if gp.FilesPath != "" && strings.HasPrefix(r.URL.Path, strings.TrimRight(gp.FilesPath, "/")+"/") {
+		return gp.serveFileList(w, r)
+	}
 
-// shouldUpdateCache checks if the cache needs updating
-func (gp *GitteaPages) shouldUpdateCache(repoKey, branch string) bool {
-	cacheKey := fmt.Sprintf("%s:%s", repoKey, branch)
-	gp.cache.mu.RLock()
-	entry, exists := gp.cache.repos[cacheKey]
-	gp.cache.mu.RUnlock()
+	if gp.BranchesPath != "" && strings.HasPrefix(r.URL.Path, strings.TrimRight(gp.BranchesPath, "/")+"/") {
+		return gp.serveBranches(w, r)
+	}
 
-	if !exists {
-		return true
+	if gp.MetadataPath != "" && strings.HasPrefix(r.URL.Path, strings.TrimRight(gp.MetadataPath, "/")+"/") {
+		return gp.serveMetadata(w, r)
+	}
+
+	// Resolve the request with the first strategy that matches: domain
+	// mapping, auto-mapping or path-based routing, in ResolutionOrder
+	match := gp.resolveRoute(r)
+	if match.strategy == "" {
+		if r.URL.Path == "/" && gp.hub != nil {
+			return gp.serveHub(w, r)
+		}
+		if r.URL.Path == "/" && gp.landing != nil {
+			gp.serveLanding(w, r)
+			return nil
+		}
+		if gp.CatchAll == nil {
+			return next.ServeHTTP(w, r)
+		}
+		match = gp.catchAllMatch(r)
 	}
 
-	return time.Since(entry.lastUpdate) > time.Duration(gp.CacheTTL)
+	return gp.serveMatch(w, r, next, match)
 }
 
-// updateRepoCache downloads and caches repository content
-func (gp *GitteaPages) updateRepoCache(owner, repo, branch string) error {
-	repoKey := fmt.Sprintf("%s/%s", owner, repo)
+// serveMatch serves a request resolved to a repository by match
+func (gp *GitteaPages) serveMatch(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler, match routeMatch) error {
+	owner, repo, filePath, branch := match.owner, match.repo, match.filePath, match.branch
+	autoMapped := match.strategy == strategyAutoMapping
+	explicitBranch := match.explicitBranch
+	token, reachable := gp.requestToken(r, owner, repo)
+	if !reachable {
+		return next.ServeHTTP(w, r)
+	}
+	r = r.WithContext(withUpstreamToken(r.Context(), token))
 
-	// Get repository info from Gitea API
-	repoInfo, err := gp.getRepoInfo(owner, repo)
-	if err != nil {
-		return fmt.Errorf("failed to get repo info: %v", err)
+	if strings.HasSuffix(strings.ToLower(filePath), ".map") && !hostListed(gp.ServeSourceMaps, r.Host) {
+		gp.serveError(w, r, http.StatusNotFound, "404 page not found")
+		return nil
 	}
 
-	// Use provided branch, fallback to repo default, then module default
+	// A branch picked with the cookie overrides the mapping's branch, but
+	// not one named in the path
+	if selected := gp.cookieBranch(w, r, owner, repo); selected != "" && !explicitBranch {
+		branch = selected
+	}
+
+	// Use custom branch if specified, otherwise use default
 	if branch == "" {
-		if repoInfo.DefaultBranch != "" {
-			branch = repoInfo.DefaultBranch
-		} else {
-			branch = gp.DefaultBranch
+		branch = gp.DefaultBranch
+	}
+
+	// A commit pin overrides every way of choosing a branch
+	if sha := gp.pinnedCommit(owner, repo); sha != "" {
+		branch = sha
+	}
+
+	r = withRoute(r, Route{
+		Owner:    owner,
+		Repo:     repo,
+		Branch:   branch,
+		Path:     filePath,
+		CacheHit: !gp.shouldUpdateCache(fmt.Sprintf("%s/%s", owner, repo), branch),
+	})
+
+	// Serve the file from cache or fetch from Gitea
+	err := gp.serveFile(w, r, owner, repo, filePath, branch)
+	if err != nil && (gp.BranchFallback || gp.MissingBranch != nil) {
+		err = gp.serveDeletedBranch(w, r, owner, repo, filePath, branch, err)
+	}
+	if err != nil {
+		// A path that only looks like /{owner}/{repo} belongs to the
+		// catch-all site
+		if match.strategy == strategyPath && gp.CatchAll != nil && errors.Is(err, errRepoNotFound) {
+			return gp.serveMatch(w, r, next, gp.catchAllMatch(r))
+		}
+		if autoMapped && gp.placeholder != nil && errors.Is(err, errRepoNotFound) {
+			gp.servePlaceholder(w)
+			return nil
+		}
+		if errors.Is(err, errRepoTooLarge) {
+			gp.serveError(w, r, http.StatusForbidden, "repository too large to serve")
+			return nil
+		}
+		if errors.Is(err, errInclude) {
+			gp.logger.Warn("failed to assemble page",
+				zap.String("repo", owner+"/"+repo),
+				zap.String("file", filePath),
+				zap.Error(err))
+			gp.serveError(w, r, http.StatusInternalServerError, "failed to assemble page")
+			return nil
+		}
+		if errors.Is(err, errChecksumMismatch) {
+			gp.serveError(w, r, http.StatusForbidden, "file failed checksum verification")
+			return nil
+		}
+		if errors.Is(err, errUnexpectedContent) {
+			gp.serveError(w, r, http.StatusBadGateway, "unexpected response from gitea")
+			return nil
+		}
+		gp.logger.Error("failed to serve file",
+			zap.String("owner", owner),
+			zap.String("repo", repo),
+			zap.String("file", filePath),
+			zap.String("branch", branch),
+			zap.Error(err))
+		if status, msg := templatedErrorStatus(err); gp.errorTmpl != nil && status != 0 {
+			gp.serveError(w, r, status, msg)
+			return nil
 		}
+		return next.ServeHTTP(w, r)
 	}
 
-	// Download repository archive
-	archiveURL := fmt.Sprintf("%s/api/v1/repos/%s/%s/archive/%s.tar.gz",
-		strings.TrimRight(gp.GitteaURL, "/"), owner, repo, branch)
+	return nil
+}
 
-	cacheKey := fmt.Sprintf("%s:%s", repoKey, branch)
-	if err := gp.downloadAndExtractRepo(archiveURL, cacheKey); err != nil {
-		return fmt.Errorf("failed to download repo: %v", err)
+// requiresHTTPS reports whether r arrived over plain HTTP for a host listed
+// in ForceHTTPS. X-Forwarded-Proto from a trusted proxy takes precedence
+// over the connection itself so TLS terminated in front of Caddy is
+// recognized.
+func (gp *GitteaPages) requiresHTTPS(r *http.Request) bool {
+	if len(gp.ForceHTTPS) == 0 {
+		return false
 	}
 
-	// Update cache entry
-	gp.cache.mu.Lock()
-	gp.cache.repos[cacheKey] = &cacheEntry{
-		lastUpdate: time.Now(),
-		path:       filepath.Join(gp.cache.cacheDir, cacheKey),
+	if secureRequest(r) {
+		return false
 	}
-	gp.cache.mu.Unlock()
 
-	gp.logger.Debug("updated repo cache",
-		zap.String("repo", repoKey),
-		zap.String("branch", branch))
+	return hostListed(gp.ForceHTTPS, r.Host)
+}
 
-	return nil
+// secureRequest reports whether r reached the client's side over HTTPS,
+// going by X-Forwarded-Proto when r came through a trusted proxy and by
+// the connection otherwise
+func secureRequest(r *http.Request) bool {
+	if proto := forwardedProto(r); proto != "" {
+		return !strings.EqualFold(proto, "http")
+	}
+	return r.TLS != nil
 }
 
-// getRepoInfo fetches repository information from Gitea API
-func (gp *GitteaPages) getRepoInfo(owner, repo string) (*GitteaRepo, error) {
-	url := fmt.Sprintf("%s/api/v1/repos/%s/%s",
-		strings.TrimRight(gp.GitteaURL, "/"), owner, repo)
+// forwardedProto returns the X-Forwarded-Proto header of r when it came
+// through one of the server's trusted_proxies, and "" otherwise, since any
+// client can send the header
+func forwardedProto(r *http.Request) string {
+	if trusted, _ := caddyhttp.GetVar(r.Context(), caddyhttp.TrustedProxyVarKey).(bool); !trusted {
+		return ""
+	}
+	return r.Header.Get("X-Forwarded-Proto")
+}
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
+// hostListed reports whether host, ignoring any port, appears in hosts or
+// hosts contains "*"
+func hostListed(hosts []string, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, domain := range hosts {
+		if domain == "*" || strings.EqualFold(domain, host) {
+			return true
+		}
 	}
+	return false
+}
+
+// serveFile serves a file from the repository
+func (gp *GitteaPages) serveFile(w http.ResponseWriter, r *http.Request, owner, repo, filePath, branch string) error {
+	repoKey := fmt.Sprintf("%s/%s", owner, repo)
 
-	if gp.GitteaToken != "" {
-		req.Header.Set("Authorization", "token "+gp.GitteaToken)
+	// Check if we need to update the cache. A content-hashed asset
+	// already on disk cannot have changed, so it never triggers a refresh.
+	cacheKey := fmt.Sprintf("%s:%s", repoKey, branch)
+	if gp.SharedCache {
+		gp.dropWithdrawnEntry(owner, repo, branch, cacheKey)
+	}
+	refresh := gp.shouldUpdateCache(repoKey, branch) && !gp.immutableCached(cacheKey, filePath)
+	if refresh && r.Method == http.MethodHead && gp.DedupeRequests && gp.joinRefresh(r.Context(), owner, repo, branch, cacheKey) {
+		// A GET downloaded the entry while this HEAD waited
+		refresh = false
 	}
+	if refresh && r.Method == http.MethodHead && (gp.WarmOnHead || gp.ProbeContents) {
+		// With either option HEAD never waits for an archive download: an
+		// expired copy is good enough, and without one the repository
+		// metadata answers
+		if gp.WarmOnHead {
+			gp.warmCache(r.Context(), owner, repo, branch)
+		}
+		if !gp.staleServable(repoKey, cacheKey) {
+			return gp.serveHeadFromMetadata(w, r, owner, repo, filePath, branch)
+		}
+	} else if refresh && gp.RevalidateCooldown > 0 && gp.staleServable(repoKey, cacheKey) {
+		gp.revalidateStale(r.Context(), owner, repo, branch, cacheKey)
+	} else if refresh {
+		if err := gp.refreshEntry(r.Context(), owner, repo, branch, cacheKey); err != nil {
+			stale := gp.usableStaleCopy(repoKey, branch, cacheKey)
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+			switch {
+			case stale && (errors.Is(err, errUpstreamBusy) || errors.Is(err, errRepoRateLimited) ||
+				errors.Is(err, errUpstreamRateLimited) || errors.Is(err, errUnexpectedContent)):
+				// Rather than queue behind a saturated Gitea, fall back
+				// to whatever copy is already on disk
+				gp.logger.Warn("upstream unavailable, serving stale cache",
+					zap.String("repo", repoKey),
+					zap.String("branch", branch),
+					zap.Error(err))
+			case stale && gp.StaleOnAuthError && errors.Is(err, errUpstreamAuth):
+				gp.logger.Error("gitea rejected credentials, serving stale cache; check gitea_token",
+					zap.String("repo", repoKey),
+					zap.String("branch", branch),
+					zap.Error(err))
+			default:
+				return fmt.Errorf("failed to update cache: %w", err)
+			}
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("gitea API returned status %d", resp.StatusCode)
+	// Get cached repo path
+	gp.cache.mu.RLock()
+	entry, exists := gp.cache.repos[cacheKey]
+	gp.cache.mu.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("repository not found in cache")
+	}
+	if gp.CacheMaxIdle > 0 {
+		gp.accessed.touch(cacheKey, time.Now())
 	}
 
-	var repoInfo GitteaRepo
-	if err := json.NewDecoder(resp.Body).Decode(&repoInfo); err != nil {
-		return nil, err
+	// A copy damaged on disk is refreshed as if it had expired
+	if gp.SelfHeal && gp.entryDamaged(entry, filePath) {
+		gp.logger.Warn("cache entry damaged on disk, downloading again",
+			zap.String("repo", repoKey),
+			zap.String("branch", branch),
+			zap.String("file", filePath))
+
+		// Forget the entry first so the download is unconditional rather
+		// than revalidated against the damaged copy's ETag
+		gp.cache.mu.Lock()
+		delete(gp.cache.repos, cacheKey)
+		gp.cache.mu.Unlock()
+		if err := gp.updateRepoCache(r.Context(), owner, repo, branch); err != nil {
+			return fmt.Errorf("failed to repair cache: %w", err)
+		}
+		gp.cache.mu.RLock()
+		entry = gp.cache.repos[cacheKey]
+		gp.cache.mu.RUnlock()
 	}
 
-	return &repoInfo, nil
-}
+	if gp.SnapshotByCommit && entry.commit != "" {
+		w.Header().Set("X-Pages-Commit", entry.commit)
+	}
+	gp.setSurrogateHeaders(w, owner, repo, branch)
 
-// downloadAndExtractRepo downloads and extracts repository archive
-func (gp *GitteaPages) downloadAndExtractRepo(archiveURL, cacheKey string) error {
-	// Create request
-	req, err := http.NewRequest("GET", archiveURL, nil)
-	if err != nil {
-		return err
+	// Serve the file
+	fullPath := filepath.Join(entry.path, filePath)
+
+	// Security check: ensure the file is within the repository directory
+	if !withinDir(entry.path, fullPath) {
+		return fmt.Errorf("invalid file path")
 	}
 
-	if gp.GitteaToken != "" {
-		req.Header.Set("Authorization", "token "+gp.GitteaToken)
+	if gp.isDenied(filePath) {
+		return errFileNotFound
 	}
 
-	// Download archive
-	client := &http.Client{Timeout: 5 * time.Minute}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+	if gp.Prerender != nil {
+		// Caches must keep snapshots and the app shell apart
+		w.Header().Add("Vary", "User-Agent")
+		if snapshot := gp.prerenderDirPath(r, entry, filePath); snapshot != "" {
+			fullPath = snapshot
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download archive: status %d", resp.StatusCode)
+	// Check if file exists
+	info, err := os.Stat(fullPath)
+	if os.IsNotExist(err) && gp.GunzipFallback && gp.gunzipSibling(entry, fullPath) {
+		info, err = os.Stat(fullPath)
+	}
+	if os.IsNotExist(err) {
+		if gp.AllowArchive && path.Base(filePath) == archiveFileName {
+			dir := path.Dir(filePath)
+			return gp.serveArchive(w, entry, dir, archiveName(repo, dir))
+		}
+		if overlayPath := gp.overlayFile(filePath); overlayPath != "" {
+			gp.setPWAHeaders(w, filePath)
+			if gp.StrictContentType {
+				setStrictContentType(w, overlayPath)
+			}
+			if gp.wantsAttachment(r, filePath) {
+				w.Header().Set("Content-Disposition", attachmentDisposition(path.Base(filePath)))
+			}
+			http.ServeFile(w, r, overlayPath)
+			return nil
+		}
+		if gp.serveNotFoundFallback(w, r, filePath) {
+			return nil
+		}
+		return errFileNotFound
 	}
 
-	// Extract archive to cache directory
-	extractPath := filepath.Join(gp.cache.cacheDir, cacheKey)
-	if err := os.RemoveAll(extractPath); err != nil {
-		return err
+	// Serve the directory's index document rather than a listing
+	servingReadme := false
+	if err == nil && info.IsDir() {
+		if gp.DirectorySlash == "redirect" && !strings.HasSuffix(r.URL.Path, "/") {
+			redirectToSlash(w, r)
+			return nil
+		}
+		if gp.JSONIndex {
+			w.Header().Add("Vary", "Accept")
+			if prefersJSON(r.Header.Get("Accept")) {
+				return gp.serveListing(w, fullPath, filePath, entry.compressed, true)
+			}
+		}
+		indexFile := gp.entryIndexFile(entry, fullPath)
+		if indexFile == "" {
+			if gp.Autoindex {
+				return gp.serveListing(w, fullPath, filePath, entry.compressed, false)
+			}
+			return errFileNotFound
+		}
+		servingReadme = isReadmeFile(indexFile) && !gp.isIndexFile(indexFile)
+		fullPath = filepath.Join(fullPath, indexFile)
+		if gp.RegionIndex != nil && !servingReadme {
+			fullPath = gp.selectRegionIndex(w, r, entry.path, fullPath)
+		}
 	}
-	if err := os.MkdirAll(extractPath, 0755); err != nil {
-		return err
+
+	if len(gp.Variants) > 0 {
+		fullPath = gp.selectVariant(w, r, entry.path, fullPath)
+	}
+	if gp.Prerender != nil {
+		fullPath = gp.prerenderSuffixPath(r, fullPath)
 	}
 
-	// Extract tar.gz archive
-	gzr, err := gzip.NewReader(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to create gzip reader: %v", err)
+	if len(entry.rejected) > 0 {
+		if rel, err := filepath.Rel(entry.path, fullPath); err == nil && entry.rejected[filepath.ToSlash(rel)] {
+			return errChecksumMismatch
+		}
 	}
-	defer gzr.Close()
 
-	tr := tar.NewReader(gzr)
-	for {
-		header, err := tr.Next()
-		if err == io.EOF {
-			break
+	// A README standing in for the index is a page, so render it even
+	// when markdown rendering is off for regular files
+	if (gp.RenderMarkdown || servingReadme) && isMarkdownFile(fullPath) {
+		return gp.serveMarkdown(w, r, fullPath, entry.compressed)
+	}
+
+	gp.setPWAHeaders(w, fullPath)
+	if gp.StrictContentType {
+		setStrictContentType(w, fullPath)
+	}
+
+	if rel, err := filepath.Rel(entry.path, fullPath); err == nil {
+		rel = filepath.ToSlash(rel)
+		if sha, ok := entry.blobs[rel]; ok {
+			w.Header().Set("ETag", `"`+sha+`"`)
 		}
-		if err != nil {
-			return fmt.Errorf("failed to read tar header: %v", err)
+		if sri, ok := entry.integrity[rel]; ok {
+			w.Header().Set(integrityHeader, sri)
+		}
+		if gp.wantsAttachment(r, rel) {
+			w.Header().Set("Content-Disposition", attachmentDisposition(path.Base(rel)))
+		}
+		if gp.isImmutable(rel) {
+			w.Header().Set("Cache-Control", immutableCacheControl)
 		}
 
-		// Skip the top-level directory from the archive
-		pathParts := strings.Split(header.Name, "/")
-		if len(pathParts) > 1 {
-			relativePath := strings.Join(pathParts[1:], "/")
-			targetPath := filepath.Join(extractPath, relativePath)
-
-			// Security check: ensure the file is within the extract directory
-			if !strings.HasPrefix(targetPath, extractPath) {
-				continue
+		if gp.shouldInclude(rel) && !entry.streamed[rel] {
+			page, err := gp.assembled(entry, rel)
+			if err != nil {
+				return err
 			}
-
-			switch header.Typeflag {
-			case tar.TypeDir:
-				if err := os.MkdirAll(targetPath, os.FileMode(header.Mode)); err != nil {
-					return fmt.Errorf("failed to create directory %s: %v", targetPath, err)
-				}
-			case tar.TypeReg:
-				// Create parent directories if they don't exist
-				if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
-					return fmt.Errorf("failed to create parent directory for %s: %v", targetPath, err)
+			data := page.data
+			if w.Header().Get("ETag") != "" {
+				// Partials change what is sent without changing the page
+				w.Header().Set("ETag", `"`+page.sha+`"`)
+			}
+			if gp.shouldMinify(rel) {
+				if etag := w.Header().Get("ETag"); etag != "" {
+					w.Header().Set("ETag", strings.TrimSuffix(etag, `"`)+`-min"`)
 				}
+				data = gp.minified(entry, rel, data)
+			}
+			if w.Header().Get(integrityHeader) != "" {
+				w.Header().Set(integrityHeader, integrityOf(data))
+			}
+			return gp.serveData(w, r, entry, rel, "included", data)
+		}
 
-				file, err := os.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY, os.FileMode(header.Mode))
-				if err != nil {
-					return fmt.Errorf("failed to create file %s: %v", targetPath, err)
+		if gp.shouldMinify(rel) && !entry.streamed[rel] {
+			data, ok := entry.memory[rel]
+			if !ok {
+				if data, err = readCachedFile(fullPath, entry.compressed); err != nil {
+					return err
 				}
+			}
+			if etag := w.Header().Get("ETag"); etag != "" {
+				// The minified bytes are a representation of their own
+				w.Header().Set("ETag", strings.TrimSuffix(etag, `"`)+`-min"`)
+			}
+			minified := gp.minified(entry, rel, data)
+			if w.Header().Get(integrityHeader) != "" {
+				w.Header().Set(integrityHeader, integrityOf(minified))
+			}
+			return gp.serveData(w, r, entry, rel, "minified", minified)
+		}
 
-				if _, err := io.Copy(file, tr); err != nil {
-					file.Close()
-					return fmt.Errorf("failed to extract file %s: %v", targetPath, err)
-				}
-				file.Close()
+		// Files outside the cache size band are not stored on disk
+		if data, ok := entry.memory[rel]; ok {
+			return gp.serveData(w, r, entry, rel, "", data)
+		}
+		if entry.streamed[rel] {
+			ref := entry.commit
+			if ref == "" {
+				ref = branch
+			}
+			if gp.useBlobAPI(rel) {
+				return gp.serveBlob(w, r, owner, repo, ref, rel, entry.blobs[rel], entry.lastUpdate)
 			}
+			return gp.serveStreamed(w, r, owner, repo, ref, rel)
 		}
 	}
 
-	gp.logger.Debug("extracted repository archive",
-		zap.String("cache_key", cacheKey),
-		zap.String("path", extractPath))
+	if entry.compressed {
+		return gp.serveCompressedFile(w, r, fullPath)
+	}
+
+	if gp.DynamicCompression != nil {
+		if info, err := os.Stat(fullPath); err == nil && info.Mode().IsRegular() {
+			rel := filepath.ToSlash(strings.TrimPrefix(fullPath, entry.path+string(filepath.Separator)))
+			served, err := gp.serveEncoded(w, r, entry, rel, "", info.Size(), func() ([]byte, error) {
+				return os.ReadFile(fullPath)
+			})
+			if err != nil || served {
+				return err
+			}
+		}
+	}
 
+	http.ServeFile(w, r, fullPath)
 	return nil
 }
 
-// findIndexFile looks for index files in the repository
-func (gp *GitteaPages) findIndexFile(owner, repo string) string {
-	// Try with default branch first
-	branch := gp.DefaultBranch
-	cacheKey := fmt.Sprintf("%s/%s:%s", owner, repo, branch)
+// setStrictContentType forbids MIME sniffing of the response and, when
+// name has no known type, sends it as a download rather than leaving the
+// type for the browser to guess
+func setStrictContentType(w http.ResponseWriter, name string) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if w.Header().Get("Content-Type") != "" || mime.TypeByExtension(filepath.Ext(name)) != "" {
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", attachmentDisposition(filepath.Base(name)))
+}
 
-	gp.cache.mu.RLock()
-	entry, exists := gp.cache.repos[cacheKey]
-	gp.cache.mu.RUnlock()
+// redirectToSlash sends a permanent redirect to the request path with a
+// trailing slash. The Location is relative to the last segment so it stays
+// correct when the path was rewritten internally.
+func redirectToSlash(w http.ResponseWriter, r *http.Request) {
+	target := (&url.URL{Path: "./" + path.Base(r.URL.Path) + "/"}).EscapedPath()
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	w.Header().Set("Location", target)
+	w.WriteHeader(http.StatusMovedPermanently)
+}
 
-	if !exists {
-		return ""
+// serveHeadFromMetadata answers a HEAD request for a repository that is
+// not cached yet, or only past MaxStale, checking that the repository
+// exists in Gitea and that the path does: through the contents API with
+// ProbeContents, else in the expired copy. A path neither confirms is
+// answered 404, as the GET could not promise more.
+func (gp *GitteaPages) serveHeadFromMetadata(w http.ResponseWriter, r *http.Request, owner, repo, filePath, branch string) error {
+	if gp.isDenied(filePath) {
+		return errFileNotFound
+	}
+	if gp.tooLarge.refused(owner+"/"+repo, time.Now()) {
+		return errRepoTooLarge
+	}
+	repoInfo, err := gp.getRepoInfo(r.Context(), owner, repo)
+	if err != nil {
+		return fmt.Errorf("failed to get repo info: %w", err)
+	}
+	if err := gp.checkRepoSize(owner+"/"+repo, repoInfo); err != nil {
+		return err
 	}
 
-	for _, indexFile := range gp.IndexFiles {
-		fullPath := filepath.Join(entry.path, indexFile)
-		if _, err := os.Stat(fullPath); err == nil {
-			return indexFile
+	if gp.ProbeContents {
+		probe, err := gp.probePath(r.Context(), owner, repo, branch, filePath)
+		if err != nil {
+			return err
+		}
+		switch probe.kind {
+		case pathMissing:
+			return errFileNotFound
+		case pathDir:
+			if gp.DirectorySlash == "redirect" && !strings.HasSuffix(r.URL.Path, "/") {
+				redirectToSlash(w, r)
+				return nil
+			}
+			if probe.index == "" {
+				if !gp.Autoindex {
+					return errFileNotFound
+				}
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.WriteHeader(http.StatusOK)
+				return nil
+			}
+			filePath = path.Join(filePath, probe.index)
+		}
+	} else {
+		cached, ok := gp.cachedPath(owner, repo, branch, filePath)
+		if !ok {
+			return errFileNotFound
 		}
+		filePath = cached
 	}
 
-	return ""
+	if ctype := mime.TypeByExtension(path.Ext(filePath)); ctype != "" {
+		w.Header().Set("Content-Type", ctype)
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
 }
 
-// resolveDomainMapping resolves a request to owner/repo based on domain mappings
-func (gp *GitteaPages) resolveDomainMapping(r *http.Request) (owner, repo, filePath, branch string) {
-	host := r.Host
+// cachedPath returns the file a request for filePath resolves to in the
+// branch's cached copy, fresh or not, reporting whether there is one
+func (gp *GitteaPages) cachedPath(owner, repo, branch, filePath string) (string, bool) {
+	gp.cache.mu.RLock()
+	entry, ok := gp.cache.repos[fmt.Sprintf("%s/%s:%s", owner, repo, branch)]
+	gp.cache.mu.RUnlock()
+	if !ok {
+		return "", false
+	}
 
-	// Remove port if present
-	if colonIndex := strings.Index(host, ":"); colonIndex != -1 {
-		host = host[:colonIndex]
+	fullPath := filepath.Join(entry.path, filepath.FromSlash(filePath))
+	if !withinDir(entry.path, fullPath) {
+		return "", false
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return "", false
+	}
+	if info.IsDir() {
+		index := gp.entryIndexFile(entry, fullPath)
+		if index == "" {
+			return "", false
+		}
+		return path.Join(filePath, index), true
 	}
+	return filePath, true
+}
 
-	filePath = strings.Trim(r.URL.Path, "/")
+// warmCache refreshes a repository branch in the background. Concurrent
+// warms of the same branch share one download.
+func (gp *GitteaPages) warmCache(ctx context.Context, owner, repo, branch string) {
+	ctx = context.WithoutCancel(ctx)
+	cacheKey := fmt.Sprintf("%s/%s:%s", owner, repo, branch)
 
-	// Check explicit domain mappings first
-	for _, mapping := range gp.DomainMappings {
-		if mapping.Domain == host {
-			return mapping.Owner, mapping.Repository, filePath, mapping.Branch
+	go gp.warming.Do(cacheKey, func() (interface{}, error) {
+		err := gp.updateRepoCache(ctx, owner, repo, branch)
+		if err != nil {
+			gp.logger.Warn("failed to warm cache",
+				zap.String("repo", owner+"/"+repo),
+				zap.String("branch", branch),
+				zap.Error(err))
+		}
+		return nil, err
+	})
+}
+
+// normalizePath collapses repeated slashes and resolves "." and ".."
+// segments. The first floor segments form the root that ".." may not climb
+// out of; ok is false when a path tries to. A trailing slash is preserved.
+func normalizePath(p string, floor int) (cleaned string, ok bool) {
+	var segments []string
+	for _, segment := range strings.Split(p, "/") {
+		switch segment {
+		case "", ".":
+			continue
+		case "..":
+			if len(segments) <= floor {
+				return "", false
+			}
+			segments = segments[:len(segments)-1]
+		default:
+			segments = append(segments, segment)
 		}
 	}
 
-	// Check auto-mapping if enabled
-	if gp.AutoMapping != nil && gp.AutoMapping.Enabled {
-		return gp.resolveAutoMapping(host, filePath)
+	cleaned = "/" + strings.Join(segments, "/")
+	if len(segments) > 0 && (strings.HasSuffix(p, "/") || strings.HasSuffix(p, "/.") || strings.HasSuffix(p, "/..")) {
+		cleaned += "/"
 	}
+	return cleaned, true
+}
 
+// isDenied reports whether a repository-relative path matches DenyFiles.
+// Patterns containing a slash match the whole path; others match any single
+// path segment, so ".*" hides dotfiles and dot-directories at every level.
+// The top-level .well-known directory (RFC 8615) is exempt from segment
+// matches unless DenyWellKnown is set; files inside it are still checked.
+func (gp *GitteaPages) isDenied(relPath string) bool {
+	relPath = strings.Trim(relPath, "/")
+	segments := strings.Split(relPath, "/")
+	for _, pattern := range gp.DenyFiles {
+		if strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(strings.Trim(pattern, "/"), relPath); ok {
+				return true
+			}
+			continue
+		}
+		for i, segment := range segments {
+			if i == 0 && segment == ".well-known" && len(segments) > 1 && !gp.DenyWellKnown {
+				continue
+			}
+			if ok, _ := path.Match(pattern, segment); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pathDepth counts the non-empty segments of a URL path
+func pathDepth(p string) int {
+	depth := 0
+	for _, segment := range strings.Split(p, "/") {
+		if segment != "" {
+			depth++
+		}
+	}
+	return depth
+}
+
+// withinDir reports whether target is dir itself or lies beneath it
+func withinDir(dir, target string) bool {
+	dir = filepath.Clean(dir)
+	target = filepath.Clean(target)
+	return target == dir || strings.HasPrefix(target, dir+string(filepath.Separator))
+}
+
+// entryDamaged reports whether the extracted copy behind entry is gone or
+// no longer matches what was extracted for relPath: the file itself, or
+// the index documents of a directory, are missing or changed size
+func (gp *GitteaPages) entryDamaged(entry *cacheEntry, relPath string) bool {
+	if info, err := os.Stat(entry.path); err != nil || !info.IsDir() {
+		return true
+	}
+	if entry.files == nil {
+		return false
+	}
+
+	candidates := []string{relPath}
+	for _, indexFile := range gp.IndexFiles {
+		candidates = append(candidates, path.Join(relPath, indexFile))
+	}
+	for _, candidate := range candidates {
+		size, ok := entry.files[strings.TrimPrefix(candidate, "/")]
+		if !ok {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(entry.path, filepath.FromSlash(candidate)))
+		if err != nil || info.Size() != size {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldUpdateCache checks if the cache needs updating
+func (gp *GitteaPages) shouldUpdateCache(repoKey, branch string) bool {
+	cacheKey := fmt.Sprintf("%s:%s", repoKey, branch)
+	gp.cache.mu.RLock()
+	entry, exists := gp.cache.repos[cacheKey]
+	gp.cache.mu.RUnlock()
+
+	if !exists {
+		return true
+	}
+
+	if gp.isPinned(cacheKey) {
+		return false
+	}
+
+	return time.Since(entry.lastUpdate) > gp.cacheTTLFor(repoKey)
+}
+
+// cacheTTLFor returns the cache TTL for repoKey: its RepoCacheTTL entry,
+// else the shortest override among domain mappings serving it, else the
+// global CacheTTL
+func (gp *GitteaPages) cacheTTLFor(repoKey string) time.Duration {
+	if ttl, ok := gp.RepoCacheTTL[repoKey]; ok && ttl > 0 {
+		return time.Duration(ttl)
+	}
+
+	var ttl time.Duration
+	for _, mapping := range gp.domainMappings() {
+		if mapping.CacheTTL <= 0 || mapping.Owner+"/"+mapping.Repository != repoKey {
+			continue
+		}
+		if ttl == 0 || time.Duration(mapping.CacheTTL) < ttl {
+			ttl = time.Duration(mapping.CacheTTL)
+		}
+	}
+	if ttl > 0 {
+		return ttl
+	}
+	return time.Duration(gp.CacheTTL)
+}
+
+// parsePin splits a pin into its owner, repository and branch, defaulting
+// the branch to DefaultBranch
+func (gp *GitteaPages) parsePin(pin string) (owner, repo, branch string) {
+	repoKey, branch, _ := strings.Cut(pin, ":")
+	owner, repo, _ = strings.Cut(repoKey, "/")
+	if branch == "" {
+		branch = gp.DefaultBranch
+	}
+	return owner, repo, branch
+}
+
+// isPinned reports whether the cache entry for cacheKey is pinned, or is
+// the copy of a pinned commit
+func (gp *GitteaPages) isPinned(cacheKey string) bool {
+	for _, pin := range gp.Pins {
+		owner, repo, branch := gp.parsePin(pin)
+		if fmt.Sprintf("%s/%s:%s", owner, repo, branch) == cacheKey {
+			return true
+		}
+	}
+	return gp.isPinnedCommitKey(cacheKey)
+}
+
+// updateRepoCache downloads and caches repository content
+func (gp *GitteaPages) updateRepoCache(ctx context.Context, owner, repo, branch string) error {
+	repoKey := fmt.Sprintf("%s/%s", owner, repo)
+	ctx = gp.withRefreshToken(ctx, owner, repo)
+
+	if gp.quota != nil && !gp.quota.allow(repoKey, time.Now()) {
+		return errRepoRateLimited
+	}
+	if gp.backoff.active(time.Now()) {
+		return errUpstreamRateLimited
+	}
+	if gp.tooLarge.refused(repoKey, time.Now()) {
+		return errRepoTooLarge
+	}
+
+	// Revalidate the previously resolved archive URL when allowed, which
+	// saves the metadata round-trip on every refresh of a hot repository
+	var previous *cacheEntry
+	if gp.CacheDownloadURL && branch != "" {
+		gp.cache.mu.RLock()
+		previous = gp.cache.repos[fmt.Sprintf("%s:%s", repoKey, branch)]
+		gp.cache.mu.RUnlock()
+		if previous != nil && previous.downloadURL == "" {
+			previous = nil
+		}
+	}
+
+	var archiveURL string
+	var current archiveInfo
+	if previous != nil {
+		current = archiveInfo{
+			etag:      previous.etag,
+			commit:    previous.commit,
+			files:     previous.files,
+			memory:    previous.memory,
+			streamed:  previous.streamed,
+			blobs:     previous.blobs,
+			integrity: previous.integrity,
+			rejected:  previous.rejected,
+		}
+	}
+	reused := previous != nil && previous.reuses < downloadURLReuses
+	if reused {
+		archiveURL = previous.downloadURL
+		current.revalidateOnly = true
+	} else {
+		var err error
+		archiveURL, branch, err = gp.resolveArchiveURL(ctx, owner, repo, branch)
+		if err != nil {
+			return err
+		}
+	}
+
+	cacheKey := fmt.Sprintf("%s:%s", repoKey, branch)
+	base := gp.cache.entryPath(owner, repo, branch)
+	extractPath := base
+	if gp.snapshotEntries() {
+		extractPath = stagingPath(base)
+	}
+
+	var sharedPrevious string
+	if gp.SharedCache {
+		var since time.Time
+		gp.cache.mu.RLock()
+		if existing := gp.cache.repos[cacheKey]; existing != nil {
+			since = existing.lastUpdate
+		}
+		gp.cache.mu.RUnlock()
+
+		unlock, err := gp.lockCacheEntry(ctx, base)
+		if err != nil {
+			return fmt.Errorf("failed to lock cache entry: %w", err)
+		}
+		defer unlock()
+
+		// Another node, or another request of this one, may have refreshed
+		// the entry while the lock was held
+		if entry := gp.adoptSharedEntry(repoKey, base, since); entry != nil {
+			gp.cache.mu.Lock()
+			gp.cache.repos[cacheKey] = entry
+			gp.cache.mu.Unlock()
+			gp.logger.Debug("adopted shared cache entry",
+				zap.String("repo", repoKey),
+				zap.String("branch", branch))
+			return nil
+		}
+		_, sharedPrevious, _ = gp.readSharedIndex(base)
+	}
+	info, err := gp.downloadAndExtractRepo(ctx, archiveURL, extractPath, current)
+
+	// Anything but a 304 on the remembered URL goes through the full
+	// lookup, which checks the repository again before downloading
+	if errors.Is(err, errArchiveChanged) {
+		reused = false
+		archiveURL, _, err = gp.resolveArchiveURL(ctx, owner, repo, branch)
+		if err != nil {
+			return err
+		}
+		current.revalidateOnly = false
+		info, err = gp.downloadAndExtractRepo(ctx, archiveURL, extractPath, current)
+	}
+
+	// The archive URL may have gone stale since the metadata was read, e.g.
+	// the repository was renamed or transferred, so look it up once more
+	if errors.Is(err, errArchiveNotFound) && gp.RetryStaleDownload {
+		gp.logger.Debug("archive not found, refreshing repository metadata",
+			zap.String("repo", repoKey),
+			zap.String("archive_url", archiveURL))
+
+		archiveURL, _, err = gp.resolveArchiveURL(ctx, owner, repo, branch)
+		if err != nil {
+			return err
+		}
+		info, err = gp.downloadAndExtractRepo(ctx, archiveURL, extractPath, archiveInfo{})
+	}
+
+	if err != nil && !errors.Is(err, errNotModified) {
+		if gp.snapshotEntries() {
+			os.RemoveAll(extractPath)
+		}
+		return fmt.Errorf("failed to download repo: %w", err)
+	}
+
+	entryPath := extractPath
+	if errors.Is(err, errNotModified) {
+		entryPath = previous.path
+	} else if gp.snapshotEntries() {
+		entryPath, err = publishSnapshot(base, extractPath, info.commit)
+		if err != nil {
+			os.RemoveAll(extractPath)
+			return fmt.Errorf("failed to publish snapshot: %w", err)
+		}
+	}
+
+	// Update cache entry
+	entry := &cacheEntry{
+		lastUpdate: time.Now(),
+		path:       entryPath,
+		etag:       info.etag,
+		commit:     info.commit,
+		compressed: gp.CompressCache,
+		files:      info.files,
+		memory:     info.memory,
+		streamed:   info.streamed,
+		blobs:      info.blobs,
+		integrity:  info.integrity,
+		rejected:   info.rejected,
+		indexes:    newIndexCache(),
+		minified:   newMinifyCache(),
+		included:   newIncludeCache(),
+		encoded:    newEncodingCache(),
+	}
+	if gp.CacheDownloadURL {
+		entry.downloadURL = archiveURL
+		if reused {
+			entry.reuses = previous.reuses + 1
+		}
+	}
+	gp.cache.mu.Lock()
+	replaced := gp.cache.repos[cacheKey]
+	gp.cache.repos[cacheKey] = entry
+	gp.cache.mu.Unlock()
+
+	if gp.SharedCache {
+		if err := gp.writeSharedIndex(base, entry); err != nil {
+			gp.logger.Warn("failed to write shared cache index",
+				zap.String("repo", repoKey),
+				zap.String("branch", branch),
+				zap.Error(err))
+		}
+	}
+
+	// Keep the snapshot just replaced so requests that started on it can
+	// finish from the same commit, and with SharedCache the one other
+	// nodes were told to serve
+	if gp.snapshotEntries() {
+		keep := []string{entryPath}
+		if replaced != nil {
+			keep = append(keep, replaced.path)
+		}
+		if sharedPrevious != "" {
+			keep = append(keep, sharedPrevious)
+		}
+		gp.pruneSnapshots(base, keep...)
+	}
+
+	gp.enforceOwnerQuota(owner, cacheKey)
+
+	gp.logger.Debug("updated repo cache",
+		zap.String("repo", repoKey),
+		zap.String("branch", branch),
+		zap.Bool("not_modified", errors.Is(err, errNotModified)))
+
+	return nil
+}
+
+// resolveArchiveURL looks up the repository in Gitea and returns the URL of
+// its branch archive. An empty branch resolves to the repository's default
+// branch, falling back to the module default; the branch used is returned.
+func (gp *GitteaPages) resolveArchiveURL(ctx context.Context, owner, repo, branch string) (string, string, error) {
+	// Get repository info from Gitea API
+	repoInfo, err := gp.getRepoInfo(ctx, owner, repo)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get repo info: %w", err)
+	}
+	if err := gp.checkRepoSize(owner+"/"+repo, repoInfo); err != nil {
+		return "", "", err
+	}
+
+	// Use provided branch, fallback to repo default, then module default
+	if branch == "" {
+		if repoInfo.DefaultBranch != "" {
+			branch = repoInfo.DefaultBranch
+		} else {
+			branch = gp.DefaultBranch
+		}
+	}
+
+	// Prefer the canonical name Gitea reports, which follows renames and
+	// transfers that the requested owner/repo may predate
+	fullName := repoInfo.FullName
+	if fullName == "" {
+		fullName = owner + "/" + repo
+	}
+
+	archiveURL := fmt.Sprintf("%s/api/v1/repos/%s/archive/%s.tar.gz",
+		strings.TrimRight(gp.GitteaURL, "/"), escapePath(fullName), escapePath(branch))
+	return archiveURL, branch, nil
+}
+
+// escapePath percent-encodes each segment of a slash-separated path so
+// names with spaces, '#', '?' or non-ASCII characters survive in URLs
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// entryPath returns the directory a repository branch is extracted to.
+// Every component is escaped into a single path element, so branch names
+// containing slashes cannot nest inside another branch's directory and no
+// component can climb out of the cache directory.
+func (c *repoCache) entryPath(owner, repo, branch string) string {
+	return filepath.Join(c.cacheDir, cacheSegment(owner), cacheSegment(repo)+":"+cacheSegment(branch))
+}
+
+// makeCacheDir creates dir and any missing parents. With CacheDirMode set
+// every directory created gets exactly that mode, regardless of umask;
+// otherwise fallback is used as given to os.MkdirAll.
+func (gp *GitteaPages) makeCacheDir(dir string, fallback os.FileMode) error {
+	if gp.dirMode == 0 {
+		return os.MkdirAll(dir, fallback)
+	}
+
+	var missing []string
+	for p := dir; ; p = filepath.Dir(p) {
+		if _, err := os.Stat(p); err == nil {
+			break
+		}
+		missing = append(missing, p)
+		if filepath.Dir(p) == p {
+			break
+		}
+	}
+
+	if err := os.MkdirAll(dir, gp.dirMode); err != nil {
+		return err
+	}
+	for _, p := range missing {
+		if err := os.Chmod(p, gp.dirMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseFileMode parses an octal permission string such as "0750". An
+// empty string yields zero, meaning the default applies.
+func parseFileMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an octal mode", s)
+	}
+	if mode == 0 || mode > 0777 {
+		return 0, fmt.Errorf("%q is outside 0001-0777", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// cacheSegment escapes s for use as a single file name
+func cacheSegment(s string) string {
+	if s == "." || s == ".." {
+		return strings.ReplaceAll(s, ".", "%2E")
+	}
+	return url.PathEscape(s)
+}
+
+// requestIDKey is the context key for the propagated request ID
+type requestIDKey struct{}
+
+// newUpstreamRequest builds a GET request to Gitea carrying the
+// credentials, User-Agent and request ID shared by all upstream calls.
+// Cancellation of ctx is deliberately not inherited, so a client going
+// away does not abort a cache refresh other requests may be waiting on.
+func (gp *GitteaPages) newUpstreamRequest(ctx context.Context, target string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(context.WithoutCancel(ctx), "GET", target, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if token := gp.upstreamToken(ctx); token != "" {
+		req.Header.Set("Authorization", "token "+token)
+	}
+	if gp.UserAgent != "" {
+		req.Header.Set("User-Agent", gp.UserAgent)
+	}
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok && gp.RequestIDHeader != "" {
+		req.Header.Set(gp.RequestIDHeader, id)
+	}
+
+	return req, nil
+}
+
+// newTransport builds the transport shared by all upstream calls, routing
+// them through UpstreamProxy when one is configured
+func (gp *GitteaPages) newTransport() (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if gp.UpstreamProxy == "" {
+		return transport, nil
+	}
+
+	proxyURL, err := parseProxyURL(gp.UpstreamProxy)
+	if err != nil {
+		return nil, err
+	}
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		if gp.bypassProxy(req.URL.Hostname()) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}
+	return transport, nil
+}
+
+// upstreamTransport returns the shared transport, concurrency-limited when
+// configured, falling back to the default one for handlers that were
+// never provisioned
+func (gp *GitteaPages) upstreamTransport() http.RoundTripper {
+	if gp.limiter != nil {
+		return gp.limiter
+	}
+	if gp.transport == nil {
+		return http.DefaultTransport
+	}
+	return gp.transport
+}
+
+// bypassProxy reports whether host is listed in UpstreamNoProxy. Entries
+// starting with a dot match any subdomain; others match the host itself
+// and its subdomains.
+func (gp *GitteaPages) bypassProxy(host string) bool {
+	host = strings.ToLower(host)
+	for _, entry := range gp.UpstreamNoProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			return true
+		case strings.HasPrefix(entry, "."):
+			if strings.HasSuffix(host, entry) {
+				return true
+			}
+		case host == entry || strings.HasSuffix(host, "."+entry):
+			return true
+		}
+	}
+	return false
+}
+
+// parseProxyURL parses an upstream_proxy value, accepting only schemes the
+// HTTP transport can dial through
+func parseProxyURL(raw string) (*url.URL, error) {
+	proxyURL, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream_proxy %q: %v", raw, err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid upstream_proxy %q: unsupported scheme %q", raw, proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid upstream_proxy %q: missing host", raw)
+	}
+	return proxyURL, nil
+}
+
+// getRepoInfo fetches repository information from Gitea API. With
+// DedupeRequests, concurrent lookups of one repository share a call,
+// whatever letter case they name it in.
+func (gp *GitteaPages) getRepoInfo(ctx context.Context, owner, repo string) (*GitteaRepo, error) {
+	if !gp.DedupeRequests {
+		return gp.fetchRepoInfo(ctx, owner, repo)
+	}
+	info, err, _ := gp.repoInfos.Do(strings.ToLower(owner+"/"+repo), func() (interface{}, error) {
+		return gp.fetchRepoInfo(ctx, owner, repo)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info.(*GitteaRepo), nil
+}
+
+// fetchRepoInfo requests repository information from Gitea API
+func (gp *GitteaPages) fetchRepoInfo(ctx context.Context, owner, repo string) (*GitteaRepo, error) {
+	apiURL := fmt.Sprintf("%s/api/v1/repos/%s/%s",
+		strings.TrimRight(gp.GitteaURL, "/"), url.PathEscape(owner), url.PathEscape(repo))
+
+	req, err := gp.newUpstreamRequest(ctx, apiURL)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second, Transport: gp.upstreamTransport()}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errRepoNotFound
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, fmt.Errorf("%w: status %d", errUpstreamAuth, resp.StatusCode)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, gp.rateLimited(resp)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("gitea API returned status %d", resp.StatusCode)
+	}
+
+	var repoInfo GitteaRepo
+	if err := gp.decodeJSON(resp, &repoInfo); err != nil {
+		return nil, err
+	}
+
+	return &repoInfo, nil
+}
+
+// decodeJSON decodes a Gitea API response into v. A response that is not
+// JSON, as when a proxy serves an HTML login page with 200, fails with
+// errUnexpectedContent and a snippet of the body is logged.
+func (gp *GitteaPages) decodeJSON(resp *http.Response, v interface{}) error {
+	body := bufio.NewReaderSize(resp.Body, 512)
+	head, _ := body.Peek(512)
+
+	ctype := resp.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(ctype); ctype != "" &&
+		(err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json"))) {
+		return gp.unexpectedContent(resp, head, fmt.Errorf("content type %q", ctype))
+	}
+	if err := json.NewDecoder(body).Decode(v); err != nil {
+		return gp.unexpectedContent(resp, head, err)
+	}
+	return nil
+}
+
+// unexpectedContent logs the start of an unusable upstream body and
+// returns errUnexpectedContent wrapping cause
+func (gp *GitteaPages) unexpectedContent(resp *http.Response, head []byte, cause error) error {
+	snippet := strings.ToValidUTF8(string(head), "")
+	if len(snippet) > maxBodySnippet {
+		snippet = strings.ToValidUTF8(snippet[:maxBodySnippet], "") + "..."
+	}
+	gp.logger.Warn("gitea returned unexpected content",
+		zap.String("url", resp.Request.URL.Redacted()),
+		zap.String("content_type", resp.Header.Get("Content-Type")),
+		zap.String("body", snippet),
+		zap.Error(cause))
+	return fmt.Errorf("%w: %v", errUnexpectedContent, cause)
+}
+
+// downloadAndExtractRepo downloads and extracts repository archive. When
+// current carries an ETag the download is conditional; an unchanged archive
+// leaves the extracted copy in place and reports errNotModified. The
+// returned info describes the archive now on disk.
+func (gp *GitteaPages) downloadAndExtractRepo(ctx context.Context, archiveURL, extractPath string, current archiveInfo) (archiveInfo, error) {
+	// Create request
+	req, err := gp.newUpstreamRequest(ctx, archiveURL)
+	if err != nil {
+		return archiveInfo{}, err
+	}
+
+	if current.etag != "" {
+		req.Header.Set("If-None-Match", current.etag)
+	}
+
+	// Download archive
+	client := &http.Client{Timeout: 5 * time.Minute, Transport: gp.upstreamTransport()}
+	resp, err := client.Do(req)
+	if err != nil {
+		return archiveInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && current.etag != "" {
+		return current, errNotModified
+	}
+	if current.revalidateOnly && resp.StatusCode != http.StatusTooManyRequests {
+		return archiveInfo{}, errArchiveChanged
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return archiveInfo{}, errArchiveNotFound
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return archiveInfo{}, fmt.Errorf("%w: status %d", errUpstreamAuth, resp.StatusCode)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return archiveInfo{}, gp.rateLimited(resp)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return archiveInfo{}, fmt.Errorf("failed to download archive: status %d", resp.StatusCode)
+	}
+
+	// Extract archive to cache directory
+	if err := os.RemoveAll(extractPath); err != nil {
+		return archiveInfo{}, err
+	}
+	if err := gp.makeEntryDir(extractPath); err != nil {
+		return archiveInfo{}, err
+	}
+
+	// Extract tar.gz archive
+	gzr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return archiveInfo{}, fmt.Errorf("failed to create gzip reader: %v", err)
+	}
+	defer gzr.Close()
+
+	info := archiveInfo{etag: resp.Header.Get("ETag")}
+	if gp.SelfHeal {
+		info.files = make(map[string]int64)
+	}
+	sums := make(map[string]string)
+	// Directories made so far, so a directory's files do not each stat
+	// their way up to it again
+	made := map[string]bool{extractPath: true}
+	tr := tar.NewReader(gzr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return archiveInfo{}, fmt.Errorf("failed to read tar header: %v", err)
+		}
+
+		// git archive records the commit ID in the pax global header
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			if commit, ok := header.PAXRecords["comment"]; ok {
+				info.commit = commit
+			}
+			continue
+		}
+
+		// Skip the top-level directory from the archive
+		pathParts := strings.Split(header.Name, "/")
+		if len(pathParts) > 1 {
+			relativePath := strings.Join(pathParts[1:], "/")
+			targetPath := filepath.Join(extractPath, relativePath)
+
+			// Security check: ensure the file is within the extract directory
+			if !withinDir(extractPath, targetPath) {
+				continue
+			}
+
+			switch header.Typeflag {
+			case tar.TypeDir:
+				if err := gp.makeCacheDir(targetPath, os.FileMode(header.Mode)); err != nil {
+					return archiveInfo{}, fmt.Errorf("failed to create directory %s: %v", targetPath, err)
+				}
+				made[targetPath] = true
+			case tar.TypeReg:
+				// Create parent directories if they don't exist
+				if parent := filepath.Dir(targetPath); !made[parent] {
+					if err := gp.makeCacheDir(parent, 0755); err != nil {
+						return archiveInfo{}, fmt.Errorf("failed to create parent directory for %s: %v", targetPath, err)
+					}
+					made[parent] = true
+				}
+
+				mode := os.FileMode(header.Mode)
+				if gp.fileMode != 0 {
+					mode = gp.fileMode
+				}
+				file, err := os.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY, mode)
+				if err != nil {
+					return archiveInfo{}, fmt.Errorf("failed to create file %s: %v", targetPath, err)
+				}
+				if gp.fileMode != 0 {
+					if err := file.Chmod(gp.fileMode); err != nil {
+						file.Close()
+						return archiveInfo{}, fmt.Errorf("failed to set mode of %s: %v", targetPath, err)
+					}
+				}
+
+				var content io.Reader = tr
+				var blob hash.Hash
+				if gp.ETags {
+					blob = newBlobHash(header.Size)
+					content = io.TeeReader(content, blob)
+				}
+				var sri hash.Hash
+				if gp.wantsIntegrity(relativePath) {
+					sri = newIntegrityHash()
+					content = io.TeeReader(content, sri)
+				}
+				var sum hash.Hash
+				if gp.VerifyManifest {
+					sum = sha256.New()
+					content = io.TeeReader(content, sum)
+				}
+
+				// Files outside the cache size band leave only an empty
+				// stand-in on disk so directory and index lookups still
+				// find them
+				src := content
+				tier := gp.cacheTier(header.Size)
+				if isMarkdownFile(relativePath) || path.Base(relativePath) == indexDotfile || relativePath == manifestFile {
+					// Files the module reads itself always stay on disk
+					tier = tierDisk
+				}
+				switch tier {
+				case tierMemory:
+					data, err := io.ReadAll(content)
+					if err != nil {
+						file.Close()
+						return archiveInfo{}, fmt.Errorf("failed to extract file %s: %v", targetPath, err)
+					}
+					if info.memory == nil {
+						info.memory = make(map[string][]byte)
+					}
+					info.memory[relativePath] = data
+					src = strings.NewReader("")
+				case tierStream:
+					if info.streamed == nil {
+						info.streamed = make(map[string]bool)
+					}
+					info.streamed[relativePath] = true
+					src = strings.NewReader("")
+					if blob != nil || sri != nil || sum != nil {
+						if _, err := io.Copy(io.Discard, content); err != nil {
+							file.Close()
+							return archiveInfo{}, fmt.Errorf("failed to extract file %s: %v", targetPath, err)
+						}
+					}
+				}
+
+				if err := gp.writeCachedFile(file, src, relativePath); err != nil {
+					file.Close()
+					return archiveInfo{}, fmt.Errorf("failed to extract file %s: %v", targetPath, err)
+				}
+				if info.files != nil {
+					if stat, err := file.Stat(); err == nil {
+						info.files[relativePath] = stat.Size()
+					}
+				}
+				if blob != nil {
+					if info.blobs == nil {
+						info.blobs = make(map[string]string)
+					}
+					info.blobs[relativePath] = hex.EncodeToString(blob.Sum(nil))
+				}
+				if sri != nil {
+					if info.integrity == nil {
+						info.integrity = make(map[string]string)
+					}
+					info.integrity[relativePath] = integrityValue(sri.Sum(nil))
+				}
+				if sum != nil {
+					sums[relativePath] = hex.EncodeToString(sum.Sum(nil))
+				}
+				file.Close()
+			}
+		}
+	}
+
+	if gp.VerifyManifest {
+		info.rejected = gp.manifestRejections(extractPath, sums, info.streamed, info.commit)
+	}
+
+	gp.logger.Debug("extracted repository archive",
+		zap.String("archive_url", archiveURL),
+		zap.String("path", extractPath))
+
+	return info, nil
+}
+
+// overlayFile returns the path of relPath within OverlayDir, or "" when
+// there is no overlay or it has no regular file there
+func (gp *GitteaPages) overlayFile(relPath string) string {
+	if gp.OverlayDir == "" {
+		return ""
+	}
+	p := filepath.Join(gp.OverlayDir, filepath.FromSlash(relPath))
+	if !withinDir(gp.OverlayDir, p) {
+		return ""
+	}
+	if info, err := os.Stat(p); err != nil || !info.Mode().IsRegular() {
+		return ""
+	}
+	return p
+}
+
+// indexDotfile names the per-directory file that overrides the index list
+const indexDotfile = ".gitea-pages-index"
+
+// findIndexFile returns the name of the document to serve for dir. A
+// .gitea-pages-index file in the directory takes precedence over the
+// configured index files; with ReadmeAsIndex a README is the last resort.
+func (gp *GitteaPages) findIndexFile(dir string, compressed bool) string {
+	if data, err := readCachedFile(filepath.Join(dir, indexDotfile), compressed); err == nil {
+		name := strings.TrimSpace(string(data))
+		// Only plain file names are honored so the dotfile cannot point
+		// outside its own directory
+		if name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`) && gp.isServableIndex(name) {
+			if info, err := os.Stat(filepath.Join(dir, name)); err == nil && !info.IsDir() {
+				return name
+			}
+		}
+	}
+
+	index := gp.pickIndexFile(dir, func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return err == nil
+	})
+	if index != "" {
+		return index
+	}
+
+	if gp.ReadmeAsIndex {
+		return findReadme(dir)
+	}
+
+	return ""
+}
+
+// entryIndexFile returns findIndexFile's answer for dir within entry,
+// remembering it for later requests to the same directory
+func (gp *GitteaPages) entryIndexFile(entry *cacheEntry, dir string) string {
+	if entry.indexes == nil {
+		return gp.findIndexFile(dir, entry.compressed)
+	}
+
+	entry.indexes.mu.Lock()
+	name, ok := entry.indexes.names[dir]
+	entry.indexes.mu.Unlock()
+	if ok {
+		return name
+	}
+
+	name = gp.findIndexFile(dir, entry.compressed)
+	entry.indexes.mu.Lock()
+	entry.indexes.names[dir] = name
+	entry.indexes.mu.Unlock()
+	return name
+}
+
+// pickIndexFile returns the first of the configured index files that present
+// reports for dir. When a directory holds several, IndexFiles order decides,
+// never the order of a directory listing, and the tie is logged so the
+// extra documents can be cleaned up.
+func (gp *GitteaPages) pickIndexFile(dir string, present func(name string) bool) string {
+	var found []string
+	for _, indexFile := range gp.IndexFiles {
+		if gp.isServableIndex(indexFile) && present(indexFile) {
+			found = append(found, indexFile)
+		}
+	}
+	if len(found) == 0 {
+		return ""
+	}
+	if len(found) > 1 {
+		gp.logger.Info("directory has several index files, serving the first configured",
+			zap.String("dir", dir),
+			zap.String("index", found[0]),
+			zap.Strings("ignored", found[1:]))
+	}
+	return found[0]
+}
+
+// isServableIndex reports whether name may be served as a directory index,
+// i.e. its extension is not listed in SkipIndexExtensions
+func (gp *GitteaPages) isServableIndex(name string) bool {
+	return !extensionListed(gp.SkipIndexExtensions, name)
+}
+
+// readmeExtensions lists README variants in order of preference
+var readmeExtensions = []string{".md", ".markdown", ".txt", ""}
+
+// findReadme returns the name of the README in dir, matched
+// case-insensitively, or "" when there is none
+func findReadme(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+
+	for _, ext := range readmeExtensions {
+		for _, e := range entries {
+			if !e.IsDir() && strings.EqualFold(e.Name(), "readme"+ext) {
+				return e.Name()
+			}
+		}
+	}
+	return ""
+}
+
+// isReadmeFile reports whether name is a README document
+func isReadmeFile(name string) bool {
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	return strings.EqualFold(base, "readme")
+}
+
+// isIndexFile reports whether name is one of the configured index files
+func (gp *GitteaPages) isIndexFile(name string) bool {
+	for _, indexFile := range gp.IndexFiles {
+		if indexFile == name {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveDomainMapping resolves a request to owner/repo based on the host,
+// trying explicit and automatic mappings in the resolution order
+func (gp *GitteaPages) resolveDomainMapping(r *http.Request) (owner, repo, filePath, branch string) {
+	for _, strategy := range gp.resolutionOrder() {
+		if strategy == strategyPath {
+			continue
+		}
+		if m := gp.resolveStrategy(r, strategy); m.owner != "" && m.repo != "" {
+			return m.owner, m.repo, m.filePath, m.branch
+		}
+	}
 	return "", "", "", ""
 }
 
+// findDomainMapping returns the explicit mapping for host, letting the
+// bare domain's mapping cover the StripHostPrefix form, e.g. www.
+func (gp *GitteaPages) findDomainMapping(host string) *DomainMapping {
+	if colonIndex := strings.Index(host, ":"); colonIndex != -1 {
+		host = host[:colonIndex]
+	}
+	host = strings.ToLower(host)
+
+	mappings := gp.domainMappings()
+	for i, mapping := range mappings {
+		if mapping.Domain == host {
+			return &mappings[i]
+		}
+	}
+
+	if gp.StripHostPrefix != "" && strings.HasPrefix(host, gp.StripHostPrefix) {
+		host = strings.TrimPrefix(host, gp.StripHostPrefix)
+		for i, mapping := range mappings {
+			if mapping.Domain == host {
+				return &mappings[i]
+			}
+		}
+	}
+	return nil
+}
+
+// serveLanding writes the landing page for the bare root of an unmapped
+// host
+func (gp *GitteaPages) serveLanding(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.Itoa(len(gp.landing)))
+		return
+	}
+	w.Write(gp.landing)
+}
+
+// servePlaceholder writes the auto-mapping "coming soon" page. It is not
+// cacheable so the real site shows up as soon as the repository exists.
+func (gp *GitteaPages) servePlaceholder(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(gp.AutoMapping.PlaceholderStatus)
+	w.Write(gp.placeholder)
+}
+
 // resolveAutoMapping handles automatic domain-to-repository mapping
 func (gp *GitteaPages) resolveAutoMapping(host, filePath string) (owner, repo, newFilePath, branch string) {
 	if gp.AutoMapping == nil {
@@ -470,6 +2839,43 @@ func (gp *GitteaPages) resolveAutoMapping(host, filePath string) (owner, repo, n
 			repo = gp.formatRepoName(subdomain, gp.AutoMapping.RepoFormat)
 		}
 
+	case "{owner}.{domain}/{repo}":
+		// Organization sites: org.example.com/blog/x -> org/blog repo, file x
+		parts := strings.Split(host, ".")
+		if len(parts) < 2 {
+			break
+		}
+		owner = parts[0]
+
+		segments := strings.SplitN(filePath, "/", 2)
+		if segments[0] != "" {
+			repo = gp.formatRepoName(segments[0], gp.AutoMapping.RepoFormat)
+			newFilePath = ""
+			if len(segments) > 1 {
+				newFilePath = segments[1]
+			}
+		} else if gp.AutoMapping.DefaultRepo != "" {
+			repo = strings.ReplaceAll(gp.AutoMapping.DefaultRepo, "{owner}", owner)
+		}
+
+	case "{dir}.{domain}":
+		// Language or section roots: fr.example.com/about.html -> fr/about.html
+		parts := strings.Split(host, ".")
+		if len(parts) < 2 || parts[0] == "" {
+			break
+		}
+		if len(gp.AutoMapping.Dirs) > 0 && !slices.Contains(gp.AutoMapping.Dirs, parts[0]) {
+			break
+		}
+		dirFormat := gp.AutoMapping.DirFormat
+		if dirFormat == "" {
+			dirFormat = "{subdomain}"
+		}
+		repo = gp.AutoMapping.Repository
+		// Cleaning as if rooted keeps ".." from climbing into a sibling
+		// directory
+		newFilePath = strings.TrimPrefix(path.Join(strings.ReplaceAll(dirFormat, "{subdomain}", parts[0]), path.Clean("/"+filePath)), "/")
+
 	case "{user}.pages.{domain}":
 		// User pages: john.pages.example.com -> john/john.pages.example.com repo
 		parts := strings.Split(host, ".")
@@ -522,6 +2928,187 @@ func (gp *GitteaPages) Validate() error {
 	if gp.GitteaURL == "" {
 		return fmt.Errorf("gitea_url is required")
 	}
+	if gp.UpstreamProxy != "" {
+		if _, err := parseProxyURL(gp.UpstreamProxy); err != nil {
+			return err
+		}
+	}
+	if err := validateResolutionOrder(gp.ResolutionOrder); err != nil {
+		return err
+	}
+	if gp.CatchAll != nil && (gp.CatchAll.Owner == "" || gp.CatchAll.Repository == "") {
+		return fmt.Errorf("catch_all requires an owner and a repository")
+	}
+	for _, mapping := range gp.DomainMappings {
+		if err := mapping.validate(); err != nil {
+			return err
+		}
+	}
+	if gp.EvictionWebhook != "" {
+		u, err := url.Parse(gp.EvictionWebhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("eviction_webhook must be an http or https URL, got %q", gp.EvictionWebhook)
+		}
+	}
+	if gp.SurrogateControl != nil {
+		if err := gp.SurrogateControl.validate(); err != nil {
+			return err
+		}
+	}
+	if gp.WebhookPath != "" && gp.WebhookSecret == "" {
+		return fmt.Errorf("webhook_path requires webhook_secret")
+	}
+	if gp.PrefetchSitemapOnPush && gp.WebhookPath == "" {
+		return fmt.Errorf("prefetch_sitemap_on_push requires webhook_path")
+	}
+	for repoKey, sha := range gp.CommitPins {
+		if _, err := normalizeCommitPin(repoKey, sha); err != nil {
+			return err
+		}
+	}
+	for _, pin := range gp.Pins {
+		if owner, repo, _ := gp.parsePin(pin); owner == "" || repo == "" {
+			return fmt.Errorf("pin %q: expected owner/repo[:branch]", pin)
+		}
+	}
+	if _, err := parseFileMode(gp.CacheDirMode); err != nil {
+		return fmt.Errorf("invalid cache_dir_mode: %v", err)
+	}
+	if _, err := parseFileMode(gp.CacheFileMode); err != nil {
+		return fmt.Errorf("invalid cache_file_mode: %v", err)
+	}
+	if gp.BranchCookie != nil {
+		if gp.BranchCookie.Name == "" {
+			return fmt.Errorf("branch_cookie requires a cookie name")
+		}
+		if len(gp.BranchCookie.Allow) == 0 {
+			return fmt.Errorf("branch_cookie requires at least one allow pattern")
+		}
+		for _, pattern := range gp.BranchCookie.Allow {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("branch_cookie allow %q: %v", pattern, err)
+			}
+		}
+	}
+	if gp.BasicAuth != nil {
+		if err := gp.BasicAuth.validate(); err != nil {
+			return err
+		}
+	}
+	if err := gp.validateNotFoundFallbacks(); err != nil {
+		return err
+	}
+	if gp.AccessLog != nil {
+		if err := gp.AccessLog.validate(); err != nil {
+			return err
+		}
+	}
+	for _, pattern := range gp.NoIndexHosts {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("noindex_hosts %q: %v", pattern, err)
+		}
+	}
+	for _, v := range gp.Variants {
+		if v.Name == "" || strings.ContainsAny(v.Name, "/.") {
+			return fmt.Errorf("variant name %q must be a non-empty name without dots or slashes", v.Name)
+		}
+	}
+	switch gp.ManifestUnlisted {
+	case "", "serve", "refuse":
+	default:
+		return fmt.Errorf("manifest_unlisted must be serve or refuse, got %q", gp.ManifestUnlisted)
+	}
+	switch gp.DirectorySlash {
+	case "", "serve", "redirect":
+	default:
+		return fmt.Errorf("directory_slash must be serve or redirect, got %q", gp.DirectorySlash)
+	}
+	if gp.SharedCache && !fileLockingSupported {
+		return fmt.Errorf("shared_cache is not supported on %s: it needs flock to lock cache entries", runtime.GOOS)
+	}
+	if gp.SharedCache && gp.CacheMinSize > 0 {
+		return fmt.Errorf("shared_cache cannot be combined with cache_min_size: in-memory files are not shared")
+	}
+	if gp.APIPageSize < 0 {
+		return fmt.Errorf("api_page_size must not be negative")
+	}
+	if gp.MaxConcurrentUpstream < 0 {
+		return fmt.Errorf("max_concurrent_upstream must not be negative")
+	}
+	if gp.PerRepoRateLimit < 0 {
+		return fmt.Errorf("per_repo_rate_limit must not be negative")
+	}
+	if gp.RevalidateCooldown < 0 {
+		return fmt.Errorf("revalidate_cooldown must not be negative")
+	}
+	if gp.MaxStale < 0 {
+		return fmt.Errorf("max_stale must not be negative")
+	}
+	if gp.DynamicCompression != nil {
+		if err := gp.DynamicCompression.validate(); err != nil {
+			return err
+		}
+	}
+	if gp.RegionIndex != nil {
+		if err := gp.RegionIndex.validate(); err != nil {
+			return err
+		}
+	}
+	if gp.MissingBranch != nil {
+		if err := gp.MissingBranch.validate(); err != nil {
+			return err
+		}
+	}
+	if gp.CacheMaxIdle < 0 || gp.CacheSweepInterval < 0 {
+		return fmt.Errorf("cache_max_idle must not be negative")
+	}
+	if gp.PerOwnerCacheQuota < 0 {
+		return fmt.Errorf("per_owner_cache_quota must not be negative")
+	}
+	for owner, quota := range gp.OwnerCacheQuotas {
+		if quota < 0 {
+			return fmt.Errorf("per_owner_cache_quota for %s must not be negative", owner)
+		}
+	}
+	switch gp.CanonicalHost {
+	case "", "www", "apex":
+	default:
+		return fmt.Errorf("canonical_host must be www or apex, got %q", gp.CanonicalHost)
+	}
+	if gp.ServiceWorkerAllowed != "" && !strings.HasPrefix(gp.ServiceWorkerAllowed, "/") {
+		return fmt.Errorf("service_worker_allowed must be an absolute path, got %q", gp.ServiceWorkerAllowed)
+	}
+	if gp.LandingPage != "" && gp.LandingHTML != "" {
+		return fmt.Errorf("landing_page and landing_html are mutually exclusive")
+	}
+	if gp.HubPage != nil && (gp.LandingPage != "" || gp.LandingHTML != "") {
+		return fmt.Errorf("hub_page cannot be combined with landing_page or landing_html")
+	}
+	if gp.CacheMinSize > 0 && gp.CacheMaxSize > 0 && gp.CacheMinSize > gp.CacheMaxSize {
+		return fmt.Errorf("cache_min_size must not exceed cache_max_size")
+	}
+	if gp.StreamFallback != nil && gp.StreamFallback.After < 0 {
+		return fmt.Errorf("stream_fallback delay must not be negative")
+	}
+	if gp.StreamFallback != nil && gp.StreamFallback.MaxSize < 0 {
+		return fmt.Errorf("stream_fallback max_size must not be negative")
+	}
+	if gp.AutoMapping != nil {
+		if status := gp.AutoMapping.PlaceholderStatus; status != 0 && (status < 400 || status > 599) {
+			return fmt.Errorf("auto_mapping placeholder status must be 4xx or 5xx, got %d", status)
+		}
+		if gp.AutoMapping.Pattern == "{dir}.{domain}" && (gp.AutoMapping.Owner == "" || gp.AutoMapping.Repository == "") {
+			return fmt.Errorf("auto_mapping pattern {dir}.{domain} requires owner and repository")
+		}
+		if strings.Contains(gp.AutoMapping.DirFormat, "..") {
+			return fmt.Errorf("auto_mapping dir_format must not contain '..', got %q", gp.AutoMapping.DirFormat)
+		}
+	}
+	for _, rule := range gp.Rewrites {
+		if rule.Redirect != 0 && (rule.Redirect < 300 || rule.Redirect > 399) {
+			return fmt.Errorf("rewrite %q: redirect status must be 3xx, got %d", rule.Pattern, rule.Redirect)
+		}
+	}
 	return nil
 }
 
@@ -534,12 +3121,493 @@ func (gp *GitteaPages) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 				if !d.Args(&gp.GitteaURL) {
 					return d.ArgErr()
 				}
-			case "gitea_token":
-				if !d.Args(&gp.GitteaToken) {
+			case "gitea_token":
+				if !d.Args(&gp.GitteaToken) {
+					return d.ArgErr()
+				}
+			case "cache_dir":
+				if !d.Args(&gp.CacheDir) {
+					return d.ArgErr()
+				}
+			case "service_worker_allowed":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				gp.ServiceWorkerAllowed = args[0]
+				gp.ServiceWorkerFiles = append(gp.ServiceWorkerFiles, args[1:]...)
+			case "landing_page":
+				if !d.Args(&gp.LandingPage) {
+					return d.ArgErr()
+				}
+			case "landing_html":
+				if !d.Args(&gp.LandingHTML) {
+					return d.ArgErr()
+				}
+			case "cache_namespace":
+				if !d.Args(&gp.CacheNamespace) {
+					return d.ArgErr()
+				}
+			case "overlay_dir":
+				if !d.Args(&gp.OverlayDir) {
+					return d.ArgErr()
+				}
+			case "user_agent":
+				if !d.Args(&gp.UserAgent) {
+					return d.ArgErr()
+				}
+			case "request_id_header":
+				if !d.Args(&gp.RequestIDHeader) {
+					return d.ArgErr()
+				}
+			case "normalize_paths":
+				gp.NormalizePaths = true
+			case "max_path_depth":
+				var depth string
+				if !d.Args(&depth) {
+					return d.ArgErr()
+				}
+				n, err := strconv.Atoi(depth)
+				if err != nil || n < 0 {
+					return d.Errf("invalid max_path_depth: %s", depth)
+				}
+				gp.MaxPathDepth = n
+			case "blob_path_length":
+				var length string
+				if !d.Args(&length) {
+					return d.ArgErr()
+				}
+				n, err := strconv.Atoi(length)
+				if err != nil || n < 0 {
+					return d.Errf("invalid blob_path_length: %s", length)
+				}
+				gp.BlobPathLength = n
+			case "rewrite":
+				args := d.RemainingArgs()
+				if len(args) < 2 || len(args) > 3 {
+					return d.ArgErr()
+				}
+				rule := RewriteRule{Pattern: args[0], Target: args[1]}
+				if len(args) == 3 {
+					status, err := strconv.Atoi(args[2])
+					if err != nil {
+						return d.Errf("invalid rewrite redirect status: %s", args[2])
+					}
+					rule.Redirect = status
+				}
+				gp.Rewrites = append(gp.Rewrites, rule)
+			case "upstream_proxy":
+				if !d.Args(&gp.UpstreamProxy) {
+					return d.ArgErr()
+				}
+			case "upstream_no_proxy":
+				hosts := d.RemainingArgs()
+				if len(hosts) == 0 {
+					return d.ArgErr()
+				}
+				gp.UpstreamNoProxy = append(gp.UpstreamNoProxy, hosts...)
+			case "api_page_size":
+				var size string
+				if !d.Args(&size) {
+					return d.ArgErr()
+				}
+				n, err := strconv.Atoi(size)
+				if err != nil {
+					return d.Errf("invalid api_page_size: %v", err)
+				}
+				gp.APIPageSize = n
+			case "max_concurrent_upstream":
+				args := d.RemainingArgs()
+				if len(args) < 1 || len(args) > 2 {
+					return d.ArgErr()
+				}
+				limit, err := strconv.Atoi(args[0])
+				if err != nil {
+					return d.Errf("invalid max_concurrent_upstream: %v", err)
+				}
+				gp.MaxConcurrentUpstream = limit
+				if len(args) == 2 {
+					timeout, err := time.ParseDuration(args[1])
+					if err != nil {
+						return d.Errf("invalid upstream queue timeout: %v", err)
+					}
+					gp.UpstreamQueueTimeout = caddy.Duration(timeout)
+				}
+			case "per_repo_rate_limit":
+				args := d.RemainingArgs()
+				if len(args) < 1 || len(args) > 2 {
+					return d.ArgErr()
+				}
+				limit, err := strconv.Atoi(args[0])
+				if err != nil {
+					return d.Errf("invalid per_repo_rate_limit: %v", err)
+				}
+				gp.PerRepoRateLimit = limit
+				if len(args) == 2 {
+					interval, err := time.ParseDuration(args[1])
+					if err != nil {
+						return d.Errf("invalid per_repo_rate_limit interval: %v", err)
+					}
+					gp.PerRepoRateInterval = caddy.Duration(interval)
+				}
+			case "health_path":
+				if !d.Args(&gp.HealthPath) {
+					return d.ArgErr()
+				}
+			case "catch_all":
+				args := d.RemainingArgs()
+				if len(args) < 2 || len(args) > 3 {
+					return d.ArgErr()
+				}
+				gp.CatchAll = &CatchAll{Owner: args[0], Repository: args[1]}
+				if len(args) == 3 {
+					gp.CatchAll.Branch = args[2]
+				}
+			case "resolution_order":
+				order := d.RemainingArgs()
+				if len(order) == 0 {
+					return d.ArgErr()
+				}
+				gp.ResolutionOrder = order
+			case "files_path":
+				if !d.Args(&gp.FilesPath) {
+					return d.ArgErr()
+				}
+			case "status_path":
+				if !d.Args(&gp.StatusPath) {
+					return d.ArgErr()
+				}
+			case "branch_fallback":
+				gp.BranchFallback = true
+			case "missing_branch":
+				gp.MissingBranch = &MissingBranch{}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				for d.NextBlock(1) {
+					switch d.Val() {
+					case "page":
+						if !d.Args(&gp.MissingBranch.Page) {
+							return d.ArgErr()
+						}
+					case "status":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						status, err := strconv.Atoi(d.Val())
+						if err != nil {
+							return d.Errf("invalid missing_branch status: %v", err)
+						}
+						gp.MissingBranch.Status = status
+					default:
+						return d.Errf("unknown missing_branch subdirective: %s", d.Val())
+					}
+				}
+			case "branches_path":
+				if !d.Args(&gp.BranchesPath) {
+					return d.ArgErr()
+				}
+			case "branches_ttl":
+				var ttl string
+				if !d.Args(&ttl) {
+					return d.ArgErr()
+				}
+				duration, err := time.ParseDuration(ttl)
+				if err != nil {
+					return d.Errf("invalid branches_ttl: %v", err)
+				}
+				gp.BranchesTTL = caddy.Duration(duration)
+			case "metadata_path":
+				if !d.Args(&gp.MetadataPath) {
+					return d.ArgErr()
+				}
+			case "metadata_ttl":
+				var ttl string
+				if !d.Args(&ttl) {
+					return d.ArgErr()
+				}
+				duration, err := time.ParseDuration(ttl)
+				if err != nil {
+					return d.Errf("invalid metadata_ttl: %v", err)
+				}
+				gp.MetadataTTL = caddy.Duration(duration)
+			case "cache_monitor_interval":
+				var interval string
+				if !d.Args(&interval) {
+					return d.ArgErr()
+				}
+				duration, err := time.ParseDuration(interval)
+				if err != nil {
+					return d.Errf("invalid cache_monitor_interval: %v", err)
+				}
+				gp.CacheMonitorInterval = caddy.Duration(duration)
+			case "cache_max_idle":
+				var idle string
+				if !d.Args(&idle) {
+					return d.ArgErr()
+				}
+				duration, err := time.ParseDuration(idle)
+				if err != nil {
+					return d.Errf("invalid cache_max_idle: %v", err)
+				}
+				gp.CacheMaxIdle = caddy.Duration(duration)
+				if d.NextArg() {
+					interval, err := time.ParseDuration(d.Val())
+					if err != nil {
+						return d.Errf("invalid cache_max_idle sweep interval: %v", err)
+					}
+					gp.CacheSweepInterval = caddy.Duration(interval)
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+			case "max_repo_size":
+				var size string
+				if !d.Args(&size) {
+					return d.ArgErr()
+				}
+				bytes, err := humanize.ParseBytes(size)
+				if err != nil {
+					return d.Errf("invalid max_repo_size: %v", err)
+				}
+				gp.MaxRepoSize = int64(bytes)
+			case "stream_fallback":
+				gp.StreamFallback = &StreamFallback{}
+				if d.NextArg() {
+					after, err := time.ParseDuration(d.Val())
+					if err != nil {
+						return d.Errf("invalid stream_fallback delay: %v", err)
+					}
+					gp.StreamFallback.After = caddy.Duration(after)
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				for d.NextBlock(1) {
+					switch d.Val() {
+					case "max_size":
+						var size string
+						if !d.Args(&size) {
+							return d.ArgErr()
+						}
+						bytes, err := humanize.ParseBytes(size)
+						if err != nil {
+							return d.Errf("invalid stream_fallback max_size: %v", err)
+						}
+						gp.StreamFallback.MaxSize = int64(bytes)
+					default:
+						return d.Errf("unknown stream_fallback subdirective: %s", d.Val())
+					}
+				}
+			case "cache_min_size", "cache_max_size":
+				option := d.Val()
+				var size string
+				if !d.Args(&size) {
+					return d.ArgErr()
+				}
+				bytes, err := humanize.ParseBytes(size)
+				if err != nil {
+					return d.Errf("invalid %s: %v", option, err)
+				}
+				if option == "cache_min_size" {
+					gp.CacheMinSize = int64(bytes)
+				} else {
+					gp.CacheMaxSize = int64(bytes)
+				}
+			case "min_free_space":
+				var size string
+				if !d.Args(&size) {
 					return d.ArgErr()
 				}
-			case "cache_dir":
-				if !d.Args(&gp.CacheDir) {
+				bytes, err := humanize.ParseBytes(size)
+				if err != nil {
+					return d.Errf("invalid min_free_space: %v", err)
+				}
+				gp.MinFreeSpace = int64(bytes)
+			case "per_owner_cache_quota":
+				args := d.RemainingArgs()
+				if len(args) < 1 || len(args) > 2 {
+					return d.ArgErr()
+				}
+				bytes, err := humanize.ParseBytes(args[len(args)-1])
+				if err != nil {
+					return d.Errf("invalid per_owner_cache_quota: %v", err)
+				}
+				if len(args) == 1 {
+					gp.PerOwnerCacheQuota = int64(bytes)
+					break
+				}
+				if gp.OwnerCacheQuotas == nil {
+					gp.OwnerCacheQuotas = make(map[string]int64)
+				}
+				gp.OwnerCacheQuotas[args[0]] = int64(bytes)
+			case "access_log":
+				gp.AccessLog = &AccessLog{}
+				if !d.Args(&gp.AccessLog.File) {
+					return d.ArgErr()
+				}
+				if d.NextArg() {
+					gp.AccessLog.Format = d.Val()
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				for d.NextBlock(1) {
+					switch d.Val() {
+					case "roll_size":
+						var size string
+						if !d.Args(&size) {
+							return d.ArgErr()
+						}
+						bytes, err := humanize.ParseBytes(size)
+						if err != nil {
+							return d.Errf("invalid roll_size: %v", err)
+						}
+						gp.AccessLog.RollSizeMB = int(math.Ceil(float64(bytes) / humanize.MiByte))
+					case "roll_keep":
+						var keep string
+						if !d.Args(&keep) {
+							return d.ArgErr()
+						}
+						n, err := strconv.Atoi(keep)
+						if err != nil {
+							return d.Errf("invalid roll_keep: %v", err)
+						}
+						gp.AccessLog.RollKeep = n
+					case "roll_keep_for":
+						var keepFor string
+						if !d.Args(&keepFor) {
+							return d.ArgErr()
+						}
+						dur, err := caddy.ParseDuration(keepFor)
+						if err != nil {
+							return d.Errf("invalid roll_keep_for: %v", err)
+						}
+						gp.AccessLog.RollKeepDays = int(math.Ceil(dur.Hours() / 24))
+					case "roll_disabled":
+						gp.AccessLog.RollDisabled = true
+					default:
+						return d.Errf("unknown access_log subdirective: %s", d.Val())
+					}
+				}
+			case "surrogate_control":
+				gp.SurrogateControl = &SurrogateControl{}
+				if d.NextArg() {
+					gp.SurrogateControl.Value = d.Val()
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				for d.NextBlock(1) {
+					switch d.Val() {
+					case "purge_url":
+						if !d.Args(&gp.SurrogateControl.PurgeURL) {
+							return d.ArgErr()
+						}
+					case "purge_header":
+						var name, value string
+						if !d.Args(&name, &value) {
+							return d.ArgErr()
+						}
+						if gp.SurrogateControl.PurgeHeaders == nil {
+							gp.SurrogateControl.PurgeHeaders = make(map[string]string)
+						}
+						gp.SurrogateControl.PurgeHeaders[name] = value
+					default:
+						return d.Errf("unknown surrogate_control subdirective: %s", d.Val())
+					}
+				}
+			case "eviction_webhook":
+				if !d.Args(&gp.EvictionWebhook) {
+					return d.ArgErr()
+				}
+			case "pin_commit":
+				var repoKey, sha string
+				if !d.Args(&repoKey, &sha) {
+					return d.ArgErr()
+				}
+				if gp.CommitPins == nil {
+					gp.CommitPins = make(map[string]string)
+				}
+				gp.CommitPins[repoKey] = sha
+			case "pin":
+				args := d.RemainingArgs()
+				if len(args) < 1 || len(args) > 2 {
+					return d.ArgErr()
+				}
+				pin := args[0]
+				if len(args) == 2 {
+					pin += ":" + args[1]
+				}
+				gp.Pins = append(gp.Pins, pin)
+			case "cache_download_url":
+				gp.CacheDownloadURL = true
+			case "compress_cache":
+				gp.CompressCache = true
+			case "stale_on_auth_error":
+				gp.StaleOnAuthError = true
+			case "dedupe_requests":
+				gp.DedupeRequests = true
+			case "revalidate_cooldown":
+				var cooldown string
+				if !d.Args(&cooldown) {
+					return d.ArgErr()
+				}
+				duration, err := time.ParseDuration(cooldown)
+				if err != nil {
+					return d.Errf("invalid revalidate_cooldown: %v", err)
+				}
+				gp.RevalidateCooldown = caddy.Duration(duration)
+			case "max_stale":
+				var maxStale string
+				if !d.Args(&maxStale) {
+					return d.ArgErr()
+				}
+				duration, err := time.ParseDuration(maxStale)
+				if err != nil {
+					return d.Errf("invalid max_stale: %v", err)
+				}
+				gp.MaxStale = caddy.Duration(duration)
+			case "warm_on_head":
+				gp.WarmOnHead = true
+			case "probe_contents":
+				gp.ProbeContents = true
+			case "webhook_path":
+				if !d.Args(&gp.WebhookPath) {
+					return d.ArgErr()
+				}
+			case "webhook_secret":
+				if !d.Args(&gp.WebhookSecret) {
+					return d.ArgErr()
+				}
+			case "error_template":
+				if !d.Args(&gp.ErrorTemplate) {
+					return d.ArgErr()
+				}
+			case "webhook_json_errors":
+				gp.WebhookJSONErrors = true
+			case "prefetch_sitemap_on_push":
+				gp.PrefetchSitemapOnPush = true
+			case "etags":
+				gp.ETags = true
+			case "strict_content_type":
+				gp.StrictContentType = true
+			case "self_heal":
+				gp.SelfHeal = true
+			case "snapshot_by_commit":
+				gp.SnapshotByCommit = true
+			case "shared_cache":
+				gp.SharedCache = true
+			case "gunzip_fallback":
+				gp.GunzipFallback = true
+			case "retry_stale_download":
+				gp.RetryStaleDownload = true
+			case "cache_dir_mode":
+				if !d.Args(&gp.CacheDirMode) {
+					return d.ArgErr()
+				}
+			case "cache_file_mode":
+				if !d.Args(&gp.CacheFileMode) {
 					return d.ArgErr()
 				}
 			case "cache_ttl":
@@ -552,6 +3620,19 @@ func (gp *GitteaPages) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 					return d.Errf("invalid cache_ttl: %v", err)
 				}
 				gp.CacheTTL = caddy.Duration(duration)
+			case "repo_cache_ttl":
+				var repoKey, ttl string
+				if !d.Args(&repoKey, &ttl) {
+					return d.ArgErr()
+				}
+				duration, err := time.ParseDuration(ttl)
+				if err != nil {
+					return d.Errf("invalid repo_cache_ttl: %v", err)
+				}
+				if gp.RepoCacheTTL == nil {
+					gp.RepoCacheTTL = make(map[string]caddy.Duration)
+				}
+				gp.RepoCacheTTL[repoKey] = caddy.Duration(duration)
 			case "default_branch":
 				if !d.Args(&gp.DefaultBranch) {
 					return d.ArgErr()
@@ -561,6 +3642,182 @@ func (gp *GitteaPages) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 				if len(gp.IndexFiles) == 0 {
 					return d.ArgErr()
 				}
+			case "skip_index_extensions":
+				exts := d.RemainingArgs()
+				if len(exts) == 0 {
+					return d.ArgErr()
+				}
+				gp.SkipIndexExtensions = append(gp.SkipIndexExtensions, exts...)
+			case "deny_files":
+				patterns := d.RemainingArgs()
+				if len(patterns) == 0 {
+					return d.ArgErr()
+				}
+				gp.DenyFiles = append(gp.DenyFiles, patterns...)
+			case "compress_extensions":
+				exts := d.RemainingArgs()
+				if len(exts) == 0 {
+					return d.ArgErr()
+				}
+				gp.CompressExtensions = append(gp.CompressExtensions, exts...)
+			case "no_compress_extensions":
+				exts := d.RemainingArgs()
+				if len(exts) == 0 {
+					return d.ArgErr()
+				}
+				gp.NoCompressExtensions = append(gp.NoCompressExtensions, exts...)
+			case "attachment_files":
+				patterns := d.RemainingArgs()
+				if len(patterns) == 0 {
+					return d.ArgErr()
+				}
+				gp.AttachmentFiles = append(gp.AttachmentFiles, patterns...)
+			case "not_found_fallback":
+				var ext string
+				if !d.Args(&ext) {
+					return d.ArgErr()
+				}
+				fallback := &NotFoundFallback{}
+				if d.NextArg() {
+					status, err := strconv.Atoi(d.Val())
+					if err != nil {
+						return d.Errf("invalid not_found_fallback status: %v", err)
+					}
+					fallback.Status = status
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				for d.NextBlock(1) {
+					switch d.Val() {
+					case "body":
+						if !d.Args(&fallback.Body) {
+							return d.ArgErr()
+						}
+					case "content_type":
+						if !d.Args(&fallback.ContentType) {
+							return d.ArgErr()
+						}
+					default:
+						return d.Errf("unknown not_found_fallback subdirective: %s", d.Val())
+					}
+				}
+				if gp.NotFoundFallbacks == nil {
+					gp.NotFoundFallbacks = make(map[string]*NotFoundFallback)
+				}
+				gp.NotFoundFallbacks[strings.ToLower(ext)] = fallback
+			case "download_query":
+				gp.DownloadQuery = defaultDownloadQuery
+				if d.NextArg() {
+					gp.DownloadQuery = d.Val()
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+			case "deny_well_known":
+				gp.DenyWellKnown = true
+			case "allow_archive":
+				gp.AllowArchive = true
+			case "directory_slash":
+				if !d.Args(&gp.DirectorySlash) {
+					return d.ArgErr()
+				}
+			case "autoindex":
+				gp.Autoindex = true
+			case "json_index":
+				gp.JSONIndex = true
+			case "autoindex_readme":
+				gp.AutoindexReadme = true
+			case "readme_as_index":
+				gp.ReadmeAsIndex = true
+			case "render_markdown":
+				gp.RenderMarkdown = true
+			case "strip_host_prefix":
+				gp.StripHostPrefix = "www."
+				d.Args(&gp.StripHostPrefix)
+			case "canonical_host":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				gp.CanonicalHost = args[0]
+				gp.CanonicalHostAllow = append(gp.CanonicalHostAllow, args[1:]...)
+			case "force_https":
+				domains := d.RemainingArgs()
+				if len(domains) == 0 {
+					domains = []string{"*"}
+				}
+				gp.ForceHTTPS = append(gp.ForceHTTPS, domains...)
+			case "basic_auth":
+				gp.BasicAuth = &BasicAuth{Hosts: d.RemainingArgs(), Users: make(map[string]string)}
+				for d.NextBlock(1) {
+					switch d.Val() {
+					case "realm":
+						if !d.Args(&gp.BasicAuth.Realm) {
+							return d.ArgErr()
+						}
+					case "user":
+						var user, hash string
+						if !d.Args(&user, &hash) {
+							return d.ArgErr()
+						}
+						gp.BasicAuth.Users[user] = hash
+					case "session_cookie":
+						sc := &SessionCookie{}
+						if d.NextArg() {
+							sc.Domain = d.Val()
+						}
+						for d.NextBlock(2) {
+							switch d.Val() {
+							case "name":
+								if !d.Args(&sc.Name) {
+									return d.ArgErr()
+								}
+							case "domain":
+								if !d.Args(&sc.Domain) {
+									return d.ArgErr()
+								}
+							case "secret":
+								if !d.Args(&sc.Secret) {
+									return d.ArgErr()
+								}
+							case "ttl":
+								var ttl string
+								if !d.Args(&ttl) {
+									return d.ArgErr()
+								}
+								duration, err := time.ParseDuration(ttl)
+								if err != nil {
+									return d.Errf("invalid session_cookie ttl: %v", err)
+								}
+								sc.TTL = caddy.Duration(duration)
+							default:
+								return d.Errf("unknown session_cookie subdirective: %s", d.Val())
+							}
+						}
+						gp.BasicAuth.SessionCookie = sc
+					default:
+						return d.Errf("unknown basic_auth subdirective: %s", d.Val())
+					}
+				}
+			case "require_client_cert":
+				domains := d.RemainingArgs()
+				if len(domains) == 0 {
+					domains = []string{"*"}
+				}
+				gp.RequireClientCert = append(gp.RequireClientCert, domains...)
+			case "serve_source_maps":
+				domains := d.RemainingArgs()
+				if len(domains) == 0 {
+					domains = []string{"*"}
+				}
+				gp.ServeSourceMaps = append(gp.ServeSourceMaps, domains...)
+			case "noindex_hosts":
+				patterns := d.RemainingArgs()
+				if len(patterns) == 0 {
+					return d.ArgErr()
+				}
+				gp.NoIndexHosts = append(gp.NoIndexHosts, patterns...)
 			case "domain_mapping":
 				args := d.RemainingArgs()
 				if len(args) < 3 {
@@ -574,7 +3831,251 @@ func (gp *GitteaPages) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 				if len(args) > 3 {
 					mapping.Branch = args[3]
 				}
+				for d.NextBlock(1) {
+					switch d.Val() {
+					case "cache_ttl":
+						var ttl string
+						if !d.Args(&ttl) {
+							return d.ArgErr()
+						}
+						duration, err := time.ParseDuration(ttl)
+						if err != nil {
+							return d.Errf("invalid cache_ttl: %v", err)
+						}
+						mapping.CacheTTL = caddy.Duration(duration)
+					case "cors":
+						policy, err := parseCORS(d)
+						if err != nil {
+							return err
+						}
+						mapping.CORS = policy
+					case "token":
+						if !d.Args(&mapping.Token) {
+							return d.ArgErr()
+						}
+					case "env_prefix":
+						var prefix, branch string
+						if !d.Args(&prefix, &branch) {
+							return d.ArgErr()
+						}
+						if mapping.EnvPrefixes == nil {
+							mapping.EnvPrefixes = make(map[string]string)
+						}
+						mapping.EnvPrefixes[strings.Trim(prefix, "/")] = branch
+					default:
+						return d.Errf("unknown domain_mapping subdirective: %s", d.Val())
+					}
+				}
 				gp.DomainMappings = append(gp.DomainMappings, mapping)
+			case "cors":
+				policy, err := parseCORS(d)
+				if err != nil {
+					return err
+				}
+				gp.CORS = policy
+			case "verify_manifest":
+				gp.VerifyManifest = true
+				if d.NextArg() {
+					gp.ManifestUnlisted = d.Val()
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+			case "prerender":
+				gp.Prerender = &Prerender{}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				for d.NextBlock(1) {
+					switch d.Val() {
+					case "user_agents":
+						gp.Prerender.UserAgents = d.RemainingArgs()
+						if len(gp.Prerender.UserAgents) == 0 {
+							return d.ArgErr()
+						}
+					case "suffix":
+						if !d.Args(&gp.Prerender.Suffix) {
+							return d.ArgErr()
+						}
+					case "dir":
+						if !d.Args(&gp.Prerender.Dir) {
+							return d.ArgErr()
+						}
+					default:
+						return d.Errf("unknown prerender subdirective: %s", d.Val())
+					}
+				}
+			case "minify":
+				gp.Minify = &Minify{Extensions: d.RemainingArgs()}
+			case "includes":
+				gp.Includes = &Includes{Extensions: d.RemainingArgs()}
+				for d.NextBlock(1) {
+					switch d.Val() {
+					case "max_depth":
+						var depth string
+						if !d.Args(&depth) {
+							return d.ArgErr()
+						}
+						n, err := strconv.Atoi(depth)
+						if err != nil || n < 1 {
+							return d.Errf("invalid includes max_depth: %s", depth)
+						}
+						gp.Includes.MaxDepth = n
+					case "max_size":
+						var size string
+						if !d.Args(&size) {
+							return d.ArgErr()
+						}
+						bytes, err := humanize.ParseBytes(size)
+						if err != nil || bytes == 0 {
+							return d.Errf("invalid includes max_size: %s", size)
+						}
+						gp.Includes.MaxSize = int64(bytes)
+					default:
+						return d.Errf("unknown includes subdirective: %s", d.Val())
+					}
+				}
+			case "integrity":
+				gp.Integrity = &Integrity{Extensions: d.RemainingArgs()}
+			case "dynamic_compression":
+				gp.DynamicCompression = &DynamicCompression{Encodings: d.RemainingArgs()}
+				for d.NextBlock(1) {
+					switch d.Val() {
+					case "min_size", "max_size", "cache_size":
+						option := d.Val()
+						var size string
+						if !d.Args(&size) {
+							return d.ArgErr()
+						}
+						bytes, err := humanize.ParseBytes(size)
+						if err != nil {
+							return d.Errf("invalid dynamic_compression %s: %v", option, err)
+						}
+						switch option {
+						case "min_size":
+							gp.DynamicCompression.MinSize = int64(bytes)
+						case "max_size":
+							gp.DynamicCompression.MaxSize = int64(bytes)
+						default:
+							gp.DynamicCompression.CacheSize = int64(bytes)
+						}
+					default:
+						return d.Errf("unknown dynamic_compression subdirective: %s", d.Val())
+					}
+				}
+			case "region_index":
+				gp.RegionIndex = &RegionIndex{}
+				if d.NextArg() {
+					gp.RegionIndex.Header = d.Val()
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				for d.NextBlock(1) {
+					switch d.Val() {
+					case "region":
+						var region Region
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						region.Name = d.Val()
+						region.Countries = d.RemainingArgs()
+						if len(region.Countries) == 0 {
+							return d.ArgErr()
+						}
+						gp.RegionIndex.Regions = append(gp.RegionIndex.Regions, region)
+					case "default":
+						if !d.Args(&gp.RegionIndex.Default) {
+							return d.ArgErr()
+						}
+					default:
+						return d.Errf("unknown region_index subdirective: %s", d.Val())
+					}
+				}
+			case "variant":
+				var v Variant
+				if !d.Args(&v.Name) {
+					return d.ArgErr()
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				for d.NextBlock(1) {
+					switch d.Val() {
+					case "query":
+						if !d.Args(&v.Query) {
+							return d.ArgErr()
+						}
+					case "header":
+						if !d.Args(&v.Header) {
+							return d.ArgErr()
+						}
+					default:
+						return d.Errf("unknown variant subdirective: %s", d.Val())
+					}
+				}
+				gp.Variants = append(gp.Variants, v)
+			case "immutable_assets":
+				gp.ImmutableAssets = &ImmutableAssets{}
+				if d.NextArg() {
+					gp.ImmutableAssets.Pattern = d.Val()
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				for d.NextBlock(1) {
+					switch d.Val() {
+					case "extensions":
+						exts := d.RemainingArgs()
+						if len(exts) == 0 {
+							return d.ArgErr()
+						}
+						gp.ImmutableAssets.Extensions = append(gp.ImmutableAssets.Extensions, exts...)
+					default:
+						return d.Errf("unknown immutable_assets subdirective: %s", d.Val())
+					}
+				}
+			case "hub_page":
+				gp.HubPage = &HubPage{}
+				for d.NextBlock(1) {
+					switch d.Val() {
+					case "owners":
+						owners := d.RemainingArgs()
+						if len(owners) == 0 {
+							return d.ArgErr()
+						}
+						gp.HubPage.Owners = append(gp.HubPage.Owners, owners...)
+					case "template":
+						if !d.Args(&gp.HubPage.Template) {
+							return d.ArgErr()
+						}
+					default:
+						return d.Errf("unknown hub_page subdirective: %s", d.Val())
+					}
+				}
+			case "branch_cookie":
+				gp.BranchCookie = &BranchCookie{}
+				if !d.Args(&gp.BranchCookie.Name) {
+					return d.ArgErr()
+				}
+				for d.NextBlock(1) {
+					switch d.Val() {
+					case "allow":
+						patterns := d.RemainingArgs()
+						if len(patterns) == 0 {
+							return d.ArgErr()
+						}
+						gp.BranchCookie.Allow = append(gp.BranchCookie.Allow, patterns...)
+					case "repos":
+						repos := d.RemainingArgs()
+						if len(repos) == 0 {
+							return d.ArgErr()
+						}
+						gp.BranchCookie.Repos = append(gp.BranchCookie.Repos, repos...)
+					default:
+						return d.Errf("unknown branch_cookie subdirective: %s", d.Val())
+					}
+				}
 			case "auto_mapping":
 				if gp.AutoMapping == nil {
 					gp.AutoMapping = &AutoMapping{}
@@ -603,6 +4104,37 @@ func (gp *GitteaPages) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 						if !d.Args(&gp.AutoMapping.Branch) {
 							return d.ArgErr()
 						}
+					case "default_repo":
+						if !d.Args(&gp.AutoMapping.DefaultRepo) {
+							return d.ArgErr()
+						}
+					case "repository":
+						if !d.Args(&gp.AutoMapping.Repository) {
+							return d.ArgErr()
+						}
+					case "dir_format":
+						if !d.Args(&gp.AutoMapping.DirFormat) {
+							return d.ArgErr()
+						}
+					case "dirs":
+						dirs := d.RemainingArgs()
+						if len(dirs) == 0 {
+							return d.ArgErr()
+						}
+						gp.AutoMapping.Dirs = append(gp.AutoMapping.Dirs, dirs...)
+					case "placeholder":
+						args := d.RemainingArgs()
+						if len(args) < 1 || len(args) > 2 {
+							return d.ArgErr()
+						}
+						gp.AutoMapping.Placeholder = args[0]
+						if len(args) == 2 {
+							status, err := strconv.Atoi(args[1])
+							if err != nil {
+								return d.Errf("invalid placeholder status: %v", err)
+							}
+							gp.AutoMapping.PlaceholderStatus = status
+						}
 					default:
 						return d.Errf("unknown auto_mapping subdirective: %s", d.Val())
 					}
@@ -628,8 +4160,9 @@ func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error)
 
 // Interface guards
 var (
-	_ caddy.Provisioner              = (*GitteaPages)(nil)
-	_ caddy.Validator                = (*GitteaPages)(nil)
-	_ caddyhttp.MiddlewareHandler    = (*GitteaPages)(nil)
-	_ caddyfile.Unmarshaler          = (*GitteaPages)(nil)
+	_ caddy.Provisioner           = (*GitteaPages)(nil)
+	_ caddy.Validator             = (*GitteaPages)(nil)
+	_ caddy.CleanerUpper          = (*GitteaPages)(nil)
+	_ caddyhttp.MiddlewareHandler = (*GitteaPages)(nil)
+	_ caddyfile.Unmarshaler       = (*GitteaPages)(nil)
 )
@@ -1,11 +1,24 @@
 package giteapages
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 )
 
 func TestGiteaPages_CaddyModule(t *testing.T) {
@@ -277,3 +290,2177 @@ func TestShouldUpdateCache(t *testing.T) {
 		t.Error("Expected shouldUpdateCache to return true for old entry")
 	}
 }
+
+func TestShouldUpdateCache_TTLOverrides(t *testing.T) {
+	gp := &GitteaPages{
+		CacheTTL: caddy.Duration(time.Hour),
+		DomainMappings: []DomainMapping{
+			{Domain: "news.example.com", Owner: "org", Repository: "news", CacheTTL: caddy.Duration(time.Minute)},
+			{Domain: "docs.example.com", Owner: "org", Repository: "docs"},
+		},
+		RepoCacheTTL: map[string]caddy.Duration{
+			"org/archive": caddy.Duration(24 * time.Hour),
+		},
+		cache: &repoCache{
+			repos: make(map[string]*cacheEntry),
+		},
+	}
+
+	// Every entry was refreshed five minutes ago
+	for _, repoKey := range []string{"org/news", "org/docs", "org/archive"} {
+		gp.cache.repos[repoKey+":main"] = &cacheEntry{lastUpdate: time.Now().Add(-5 * time.Minute)}
+	}
+
+	if !gp.shouldUpdateCache("org/news", "main") {
+		t.Error("Expected the domain with a one-minute TTL to refresh")
+	}
+	if gp.shouldUpdateCache("org/docs", "main") {
+		t.Error("Expected the domain on the global TTL to stay cached")
+	}
+	if gp.shouldUpdateCache("org/archive", "main") {
+		t.Error("Expected the repository TTL override to keep the entry cached")
+	}
+
+	gp.cache.repos["org/archive:main"].lastUpdate = time.Now().Add(-2 * time.Hour)
+	if gp.shouldUpdateCache("org/archive", "main") {
+		t.Error("Expected a 24h repository TTL to outlast the global TTL")
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_CacheTTLOverrides(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		domain_mapping news.example.com org news {
+			cache_ttl 1m
+		}
+		domain_mapping docs.example.com org docs
+		repo_cache_ttl org/archive 24h
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if len(gp.DomainMappings) != 2 {
+		t.Fatalf("Expected 2 domain mappings, got %d", len(gp.DomainMappings))
+	}
+	if ttl := time.Duration(gp.DomainMappings[0].CacheTTL); ttl != time.Minute {
+		t.Errorf("Expected news TTL 1m, got %v", ttl)
+	}
+	if ttl := time.Duration(gp.DomainMappings[1].CacheTTL); ttl != 0 {
+		t.Errorf("Expected no docs TTL override, got %v", ttl)
+	}
+	if ttl := time.Duration(gp.RepoCacheTTL["org/archive"]); ttl != 24*time.Hour {
+		t.Errorf("Expected archive TTL 24h, got %v", ttl)
+	}
+}
+
+func TestUpdateRepoCache_CacheDownloadURL(t *testing.T) {
+	tests := []struct {
+		name             string
+		cacheDownloadURL bool
+		expectedAPI      int64
+		expectedArchive  int64
+	}{
+		{
+			name:             "metadata fetched on every refresh",
+			cacheDownloadURL: false,
+			expectedAPI:      2,
+			expectedArchive:  2,
+		},
+		{
+			name:             "metadata skipped once download URL is cached",
+			cacheDownloadURL: true,
+			expectedAPI:      1,
+			expectedArchive:  2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helper := NewTestHelper(t)
+			defer helper.Cleanup()
+
+			helper.CreateMockGiteaServer(GenerateTestRepos())
+			gp := helper.SetupGiteaPages(GitteaPagesConfig{
+				GitteaURL:        helper.server.URL,
+				CacheDownloadURL: tt.cacheDownloadURL,
+			})
+
+			for i := 0; i < 2; i++ {
+				if err := gp.updateRepoCache(context.Background(), "user", "website", "main"); err != nil {
+					t.Fatalf("updateRepoCache failed on refresh %d: %v", i+1, err)
+				}
+			}
+
+			api, archive := helper.UpstreamCalls()
+			if api != tt.expectedAPI {
+				t.Errorf("Expected %d API calls, got %d", tt.expectedAPI, api)
+			}
+			if archive != tt.expectedArchive {
+				t.Errorf("Expected %d archive calls, got %d", tt.expectedArchive, archive)
+			}
+
+			w := helper.MakeHTTPRequest("GET", "/user/website/about.html", "", nil)
+			helper.AssertResponse(w, http.StatusOK, "About Us")
+		})
+	}
+}
+
+func TestUpdateRepoCache_NotModifiedKeepsContent(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL:        helper.server.URL,
+		CacheDownloadURL: true,
+	})
+
+	if err := gp.updateRepoCache(context.Background(), "user", "website", "main"); err != nil {
+		t.Fatalf("initial updateRepoCache failed: %v", err)
+	}
+
+	gp.cache.mu.RLock()
+	entry := gp.cache.repos["user/website:main"]
+	gp.cache.mu.RUnlock()
+	if entry == nil || entry.etag == "" || entry.downloadURL == "" {
+		t.Fatalf("Expected cache entry with download URL and ETag, got %+v", entry)
+	}
+
+	if err := gp.updateRepoCache(context.Background(), "user", "website", "main"); err != nil {
+		t.Fatalf("revalidating updateRepoCache failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(entry.path, "index.html")); err != nil {
+		t.Errorf("Expected cached content to survive a not-modified refresh: %v", err)
+	}
+}
+
+func TestServeHTTP_DirectoryIndexDotfile(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+	})
+	helper.CreateCacheEntry("user/site", "main", map[string]string{
+		"index.html":                "<h1>Root</h1>",
+		"docs/.gitea-pages-index":   "landing.html\n",
+		"docs/landing.html":         "<h1>Docs Landing</h1>",
+		"docs/index.html":           "<h1>Docs Index</h1>",
+		"blog/index.html":           "<h1>Blog Index</h1>",
+		"escape/.gitea-pages-index": "../index.html",
+		"escape/index.html":         "<h1>Escape Index</h1>",
+	})
+
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{"dotfile overrides index list", "/user/site/docs/", "Docs Landing"},
+		{"sibling uses default index", "/user/site/blog/", "Blog Index"},
+		{"dotfile cannot leave directory", "/user/site/escape/", "Escape Index"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := helper.MakeHTTPRequest("GET", tt.path, "", nil)
+			helper.AssertResponse(w, http.StatusOK, tt.expected)
+		})
+	}
+}
+
+func TestUpstreamRequestIdentification(t *testing.T) {
+	tests := []struct {
+		name              string
+		userAgent         string
+		expectedUserAgent string
+	}{
+		{
+			name:              "default user agent",
+			expectedUserAgent: "caddy-gitea-pages/" + moduleVersion,
+		},
+		{
+			name:              "configured user agent",
+			userAgent:         "pages-edge/2.0",
+			expectedUserAgent: "pages-edge/2.0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helper := NewTestHelper(t)
+			defer helper.Cleanup()
+
+			helper.CreateMockGiteaServer(GenerateTestRepos())
+			helper.SetupGiteaPages(GitteaPagesConfig{
+				GitteaURL: helper.server.URL,
+				UserAgent: tt.userAgent,
+			})
+
+			w := helper.MakeHTTPRequest("GET", "/user/website/about.html", "", map[string]string{
+				"X-Request-ID": "req-1234",
+			})
+			helper.AssertResponse(w, http.StatusOK, "About Us")
+
+			headers := helper.UpstreamHeaders()
+			if len(headers) == 0 {
+				t.Fatal("Expected upstream requests to be made")
+			}
+			for i, h := range headers {
+				if ua := h.Get("User-Agent"); ua != tt.expectedUserAgent {
+					t.Errorf("Upstream request %d: expected User-Agent '%s', got '%s'", i, tt.expectedUserAgent, ua)
+				}
+				if id := h.Get("X-Request-ID"); id != "req-1234" {
+					t.Errorf("Upstream request %d: expected X-Request-ID 'req-1234', got '%s'", i, id)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		floor    int
+		expected string
+		ok       bool
+	}{
+		{"duplicate slashes", "/owner//repo///index.html", 2, "/owner/repo/index.html", true},
+		{"dot segments", "/owner/repo/./docs/./index.html", 2, "/owner/repo/docs/index.html", true},
+		{"dotdot within bounds", "/owner/repo/a/../b.html", 2, "/owner/repo/b.html", true},
+		{"trailing slash kept", "/owner/repo/docs//", 2, "/owner/repo/docs/", true},
+		{"dotdot escaping repo", "/owner/repo/../other/index.html", 2, "", false},
+		{"dotdot escaping root", "/../etc/passwd", 0, "", false},
+		{"dotdot within mapped domain", "/a/../b.html", 0, "/b.html", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cleaned, ok := normalizePath(tt.path, tt.floor)
+			if ok != tt.ok {
+				t.Fatalf("Expected ok=%v, got %v", tt.ok, ok)
+			}
+			if cleaned != tt.expected {
+				t.Errorf("Expected '%s', got '%s'", tt.expected, cleaned)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_NormalizePaths(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+		DomainMappings: []DomainMapping{
+			{Domain: "site.example.com", Owner: "user", Repository: "site"},
+		},
+	})
+	gp.NormalizePaths = true
+	helper.CreateCacheEntry("user/site", "main", map[string]string{
+		"index.html": "<h1>Root</h1>",
+		"b.html":     "<h1>Page B</h1>",
+	})
+
+	tests := []struct {
+		name           string
+		path           string
+		host           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"duplicate slashes", "/user//site/./b.html", "", http.StatusOK, "Page B"},
+		{"legitimate dotdot", "/user/site/a/../b.html", "", http.StatusOK, "Page B"},
+		{"escaping dotdot", "/user/site/../../etc/passwd", "", http.StatusBadRequest, ""},
+		{"mapped domain dotdot", "/a/../b.html", "site.example.com", http.StatusOK, "Page B"},
+		{"mapped domain escape", "/../b.html", "site.example.com", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := helper.MakeHTTPRequest("GET", tt.path, tt.host, nil)
+			helper.AssertResponse(w, tt.expectedStatus, tt.expectedBody)
+		})
+	}
+}
+
+func TestResolveAutoMapping_OrgProjectPaths(t *testing.T) {
+	gp := &GitteaPages{
+		AutoMapping: &AutoMapping{
+			Enabled:     true,
+			Pattern:     "{owner}.{domain}/{repo}",
+			DefaultRepo: "{owner}.example.com",
+		},
+	}
+
+	tests := []struct {
+		name         string
+		host         string
+		filePath     string
+		expectedRepo string
+		expectedFile string
+	}{
+		{"project file", "org.example.com", "blog/index.html", "blog", "index.html"},
+		{"project root", "org.example.com", "blog", "blog", ""},
+		{"nested file", "org.example.com", "docs/guide/intro.html", "docs", "guide/intro.html"},
+		{"root uses default repo", "org.example.com", "", "org.example.com", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owner, repo, filePath, _ := gp.resolveAutoMapping(tt.host, tt.filePath)
+			if owner != "org" {
+				t.Errorf("Expected owner 'org', got '%s'", owner)
+			}
+			if repo != tt.expectedRepo {
+				t.Errorf("Expected repo '%s', got '%s'", tt.expectedRepo, repo)
+			}
+			if filePath != tt.expectedFile {
+				t.Errorf("Expected file path '%s', got '%s'", tt.expectedFile, filePath)
+			}
+		})
+	}
+
+	gp.AutoMapping.DefaultRepo = ""
+	if owner, repo, _, _ := gp.resolveAutoMapping("org.example.com", ""); owner != "" || repo != "" {
+		t.Errorf("Expected no mapping for root without default repo, got %s/%s", owner, repo)
+	}
+}
+
+func TestServeHTTP_OrgProjectPaths(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+		AutoMapping: &AutoMapping{
+			Enabled: true,
+			Pattern: "{owner}.{domain}/{repo}",
+		},
+	})
+	helper.CreateCacheEntry("org/blog", "main", map[string]string{
+		"index.html": "<h1>Org Blog</h1>",
+		"post.html":  "<h1>Org Post</h1>",
+	})
+
+	w := helper.MakeHTTPRequest("GET", "/blog/", "org.example.com", nil)
+	helper.AssertResponse(w, http.StatusOK, "Org Blog")
+
+	w = helper.MakeHTTPRequest("GET", "/blog/post.html", "org.example.com", nil)
+	helper.AssertResponse(w, http.StatusOK, "Org Post")
+}
+
+func TestResolveAutoMapping_DirPattern(t *testing.T) {
+	gp := &GitteaPages{
+		AutoMapping: &AutoMapping{
+			Enabled:    true,
+			Pattern:    "{dir}.{domain}",
+			Owner:      "acme",
+			Repository: "site",
+			Dirs:       []string{"en", "fr"},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		host         string
+		filePath     string
+		expectedRepo string
+		expectedFile string
+	}{
+		{"file", "fr.example.com", "about.html", "site", "fr/about.html"},
+		{"root", "en.example.com", "", "site", "en"},
+		{"nested", "en.example.com", "guide/intro.html", "site", "en/guide/intro.html"},
+		{"dot segments stay inside", "fr.example.com", "../en/secret.html", "site", "fr/en/secret.html"},
+		{"unlisted subdomain", "de.example.com", "about.html", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, repo, filePath, _ := gp.resolveAutoMapping(tt.host, tt.filePath)
+			if repo != tt.expectedRepo {
+				t.Errorf("Expected repo '%s', got '%s'", tt.expectedRepo, repo)
+			}
+			if filePath != tt.expectedFile {
+				t.Errorf("Expected file path '%s', got '%s'", tt.expectedFile, filePath)
+			}
+		})
+	}
+
+	gp.AutoMapping.Dirs = nil
+	gp.AutoMapping.DirFormat = "locales/{subdomain}"
+	if _, _, filePath, _ := gp.resolveAutoMapping("de.example.com", "about.html"); filePath != "locales/de/about.html" {
+		t.Errorf("Expected dir_format to apply, got '%s'", filePath)
+	}
+}
+
+func TestServeHTTP_LanguageSubdomains(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+		AutoMapping: &AutoMapping{
+			Enabled:    true,
+			Pattern:    "{dir}.{domain}",
+			Owner:      "acme",
+			Repository: "site",
+		},
+	})
+	helper.CreateCacheEntry("acme/site", "main", map[string]string{
+		"en/about.html": "<h1>About us</h1>",
+		"en/index.html": "<h1>Welcome</h1>",
+		"fr/about.html": "<h1>À propos</h1>",
+	})
+
+	w := helper.MakeHTTPRequest("GET", "/about.html", "fr.example.com", nil)
+	helper.AssertResponse(w, http.StatusOK, "À propos")
+
+	w = helper.MakeHTTPRequest("GET", "/about.html", "en.example.com", nil)
+	helper.AssertResponse(w, http.StatusOK, "About us")
+
+	w = helper.MakeHTTPRequest("GET", "/", "en.example.com", nil)
+	helper.AssertResponse(w, http.StatusOK, "Welcome")
+}
+
+func TestServeHTTP_MaxPathDepth(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.MaxPathDepth = 4
+
+	w := helper.MakeHTTPRequest("GET", "/user/website/a/b/c/d.html", "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d past the limit, got %d", http.StatusNotFound, w.Code)
+	}
+	if api, archive := helper.UpstreamCalls(); api != 0 || archive != 0 {
+		t.Errorf("Expected no upstream requests for a rejected path, got %d API and %d archive", api, archive)
+	}
+
+	w = helper.MakeHTTPRequest("GET", "/user/website/css/style.css", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "font-family")
+}
+
+func TestUpdateRepoCache_RetryStaleDownload(t *testing.T) {
+	tests := []struct {
+		name               string
+		retryStaleDownload bool
+		expectErr          bool
+		expectedAPI        int64
+		expectedArchive    int64
+	}{
+		{
+			name:            "gives up without retry",
+			expectErr:       true,
+			expectedAPI:     1,
+			expectedArchive: 1,
+		},
+		{
+			name:               "refreshes metadata and retries once",
+			retryStaleDownload: true,
+			expectedAPI:        2,
+			expectedArchive:    2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helper := NewTestHelper(t)
+			defer helper.Cleanup()
+
+			helper.CreateMockGiteaServer(GenerateTestRepos())
+			gp := helper.SetupGiteaPages(GitteaPagesConfig{
+				GitteaURL: helper.server.URL,
+			})
+			gp.RetryStaleDownload = tt.retryStaleDownload
+			helper.FailNextArchives(1)
+
+			err := gp.updateRepoCache(context.Background(), "user", "website", "main")
+			if tt.expectErr && err == nil {
+				t.Error("Expected an error but got none")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("Expected no error but got: %v", err)
+			}
+
+			api, archive := helper.UpstreamCalls()
+			if api != tt.expectedAPI {
+				t.Errorf("Expected %d API calls, got %d", tt.expectedAPI, api)
+			}
+			if archive != tt.expectedArchive {
+				t.Errorf("Expected %d archive calls, got %d", tt.expectedArchive, archive)
+			}
+		})
+	}
+}
+
+func TestUpdateRepoCache_RetryStaleCachedDownloadURL(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL:        helper.server.URL,
+		CacheDownloadURL: true,
+	})
+	gp.RetryStaleDownload = true
+
+	if err := gp.updateRepoCache(context.Background(), "user", "website", "main"); err != nil {
+		t.Fatalf("initial updateRepoCache failed: %v", err)
+	}
+
+	// The cached download URL now 404s; the refresh must recover by
+	// re-reading metadata instead of failing
+	helper.FailNextArchives(1)
+	if err := gp.updateRepoCache(context.Background(), "user", "website", "main"); err != nil {
+		t.Fatalf("refresh with stale download URL failed: %v", err)
+	}
+
+	api, archive := helper.UpstreamCalls()
+	if api != 2 || archive != 3 {
+		t.Errorf("Expected 2 API and 3 archive calls, got %d and %d", api, archive)
+	}
+
+	w := helper.MakeHTTPRequest("GET", "/user/website/about.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "About Us")
+}
+
+func TestServeHTTP_ReadmeAsIndex(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+	})
+	gp.ReadmeAsIndex = true
+	helper.CreateCacheEntry("user/docs", "main", map[string]string{
+		"README.md":           "# Project Docs\n",
+		"sub/Readme.MARKDOWN": "# Sub Section\n",
+		"notes/readme.txt":    "plain notes",
+	})
+	helper.CreateCacheEntry("user/site", "main", map[string]string{
+		"index.html": "<h1>Explicit Index</h1>",
+		"README.md":  "# Site Readme\n",
+	})
+
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{"readme rendered at root", "/user/docs/", "<h1>Project Docs</h1>"},
+		{"case-insensitive readme", "/user/docs/sub/", "<h1>Sub Section</h1>"},
+		{"text readme served as-is", "/user/docs/notes/", "plain notes"},
+		{"index preferred over readme", "/user/site/", "Explicit Index"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := helper.MakeHTTPRequest("GET", tt.path, "", nil)
+			helper.AssertResponse(w, http.StatusOK, tt.expected)
+		})
+	}
+
+	gp.ReadmeAsIndex = false
+	w := helper.MakeHTTPRequest("GET", "/user/docs/", "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d without readme_as_index, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestServeHTTP_RootIndexOnColdCache(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+
+	w := helper.MakeHTTPRequest("GET", "/user/website/", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "Welcome to My Website")
+}
+
+func TestServeHTTP_IndexFileCached(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Files: map[string]string{
+				"docs/index.htm": "<h1>Docs</h1>",
+			},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+
+	w := helper.MakeHTTPRequest("GET", "/user/site/docs/", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "<h1>Docs</h1>")
+
+	// A higher-priority candidate appearing on disk is not picked up, as
+	// the second request does not probe the candidates again
+	entry := gp.cache.repos["user/site:main"]
+	dir := filepath.Join(entry.path, "docs")
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("<h1>Probed</h1>"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	w = helper.MakeHTTPRequest("GET", "/user/site/docs/", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "<h1>Docs</h1>")
+	if name := entry.indexes.names[dir]; name != "index.htm" {
+		t.Errorf("Expected cached index 'index.htm', got '%s'", name)
+	}
+
+	// A refresh starts over with an empty index cache
+	entry.lastUpdate = time.Now().Add(-time.Hour)
+	helper.MakeHTTPRequest("GET", "/user/site/docs/", "", nil)
+	refreshed := gp.cache.repos["user/site:main"]
+	if refreshed == entry || refreshed.indexes == entry.indexes {
+		t.Fatal("Expected the refresh to replace the entry and its index cache")
+	}
+	if len(refreshed.indexes.names) != 1 {
+		t.Errorf("Expected one probed directory after refresh, got %v", refreshed.indexes.names)
+	}
+}
+
+func TestServeHTTP_Rewrites(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+		Rewrites: []RewriteRule{
+			{Pattern: `^/legacy/(?P<page>.+)$`, Target: "/org/newsite/${page}"},
+			{Pattern: `^/moved/(.*)$`, Target: "/org/newsite/$1", Redirect: http.StatusMovedPermanently},
+		},
+	})
+	helper.CreateCacheEntry("org/newsite", "main", map[string]string{
+		"about.html": "<h1>New About</h1>",
+	})
+
+	t.Run("internal rewrite", func(t *testing.T) {
+		w := helper.MakeHTTPRequest("GET", "/legacy/about.html", "", nil)
+		helper.AssertResponse(w, http.StatusOK, "New About")
+		if loc := w.Header().Get("Location"); loc != "" {
+			t.Errorf("Expected no redirect for internal rewrite, got Location '%s'", loc)
+		}
+	})
+
+	t.Run("external redirect", func(t *testing.T) {
+		w := helper.MakeHTTPRequest("GET", "/moved/about.html", "", nil)
+		if w.Code != http.StatusMovedPermanently {
+			t.Errorf("Expected status %d, got %d", http.StatusMovedPermanently, w.Code)
+		}
+		if loc := w.Header().Get("Location"); loc != "/org/newsite/about.html" {
+			t.Errorf("Expected Location '/org/newsite/about.html', got '%s'", loc)
+		}
+	})
+
+	t.Run("unmatched path untouched", func(t *testing.T) {
+		w := helper.MakeHTTPRequest("GET", "/org/newsite/about.html", "", nil)
+		helper.AssertResponse(w, http.StatusOK, "New About")
+	})
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_Rewrite(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		rewrite ^/legacy/(.*)$ /org/site/$1
+		rewrite ^/old/(.*)$ /new/$1 308
+	}`)
+	gp := new(GitteaPages)
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+
+	if len(gp.Rewrites) != 2 {
+		t.Fatalf("Expected 2 rewrites, got %d", len(gp.Rewrites))
+	}
+	if gp.Rewrites[0].Target != "/org/site/$1" || gp.Rewrites[0].Redirect != 0 {
+		t.Errorf("Unexpected first rewrite: %+v", gp.Rewrites[0])
+	}
+	if gp.Rewrites[1].Redirect != 308 {
+		t.Errorf("Expected redirect status 308, got %d", gp.Rewrites[1].Redirect)
+	}
+}
+
+func TestEscapePath(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"user/website", "user/website"},
+		{"feature/new nav", "feature/new%20nav"},
+		{"release#1?", "release%231%3F"},
+		{"docs/café", "docs/caf%C3%A9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := escapePath(tt.input); got != tt.expected {
+				t.Errorf("Expected '%s', got '%s'", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRepoCache_EntryPath(t *testing.T) {
+	c := &repoCache{cacheDir: "/var/cache/pages"}
+
+	tests := []struct {
+		name     string
+		owner    string
+		repo     string
+		branch   string
+		expected string
+	}{
+		{"plain names", "user", "site", "main", "/var/cache/pages/user/site:main"},
+		{"branch with slash", "user", "site", "feature/x", "/var/cache/pages/user/site:feature%2Fx"},
+		{"dot segments", "..", "..", "main", "/var/cache/pages/%2E%2E/%2E%2E:main"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := c.entryPath(tt.owner, tt.repo, tt.branch)
+			if got != tt.expected {
+				t.Errorf("Expected '%s', got '%s'", tt.expected, got)
+			}
+			if !withinDir(c.cacheDir, got) {
+				t.Errorf("Expected '%s' to stay within the cache directory", got)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_SpecialCharacterFileNames(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Files: map[string]string{
+				"my file #1.html": "<h1>Special File</h1>",
+				"café/menu?.html": "<h1>Menu</h1>",
+			},
+		},
+	})
+	helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+
+	w := helper.MakeHTTPRequest("GET", "/user/site/my%20file%20%231.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "Special File")
+
+	w = helper.MakeHTTPRequest("GET", "/user/site/caf%C3%A9/menu%3F.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "Menu")
+}
+
+func TestResolveDomainMapping_StripHostPrefix(t *testing.T) {
+	gp := &GitteaPages{
+		DomainMappings: []DomainMapping{
+			{Domain: "example.com", Owner: "user", Repository: "site"},
+			{Domain: "www.other.com", Owner: "user", Repository: "other-www"},
+			{Domain: "other.com", Owner: "user", Repository: "other"},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		prefix       string
+		host         string
+		expectedRepo string
+	}{
+		{"bare host", "www.", "example.com", "site"},
+		{"www host collapses", "www.", "www.example.com", "site"},
+		{"www host with port", "www.", "www.example.com:8443", "site"},
+		{"explicit www mapping wins", "www.", "www.other.com", "other-www"},
+		{"off keeps www distinct", "", "www.example.com", ""},
+		{"off keeps bare host", "", "example.com", "site"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gp.StripHostPrefix = tt.prefix
+			req := httptest.NewRequest("GET", "/index.html", nil)
+			req.Host = tt.host
+
+			_, repo, _, _ := gp.resolveDomainMapping(req)
+			if repo != tt.expectedRepo {
+				t.Errorf("Expected repo '%s', got '%s'", tt.expectedRepo, repo)
+			}
+		})
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_StripHostPrefix(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"gitea_pages {\n\tstrip_host_prefix\n}", "www."},
+		{"gitea_pages {\n\tstrip_host_prefix w3.\n}", "w3."},
+	}
+
+	for _, tt := range tests {
+		gp := new(GitteaPages)
+		if err := gp.UnmarshalCaddyfile(caddyfile.NewTestDispenser(tt.input)); err != nil {
+			t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+		}
+		if gp.StripHostPrefix != tt.expected {
+			t.Errorf("Expected prefix '%s', got '%s'", tt.expected, gp.StripHostPrefix)
+		}
+	}
+}
+
+func TestPinnedCacheEntries(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+		CacheTTL:  time.Minute,
+	})
+	gp.Pins = []string{"user/archived-docs:v1", "user/legacy"}
+
+	helper.CreateCacheEntry("user/archived-docs", "v1", map[string]string{
+		"index.html": "<h1>v1 Docs</h1>",
+	})
+	helper.CreateCacheEntry("user/legacy", "main", map[string]string{
+		"index.html": "<h1>Legacy</h1>",
+	})
+	helper.CreateCacheEntry("user/scratch", "main", map[string]string{
+		"index.html": "<h1>Scratch</h1>",
+	})
+	for _, entry := range gp.cache.repos {
+		entry.lastUpdate = time.Now().Add(-24 * time.Hour)
+	}
+
+	t.Run("never refreshed", func(t *testing.T) {
+		if gp.shouldUpdateCache("user/archived-docs", "v1") {
+			t.Error("Expected pinned entry with explicit branch not to need an update")
+		}
+		if gp.shouldUpdateCache("user/legacy", "main") {
+			t.Error("Expected pinned entry on the default branch not to need an update")
+		}
+		if !gp.shouldUpdateCache("user/scratch", "main") {
+			t.Error("Expected unpinned expired entry to need an update")
+		}
+
+		w := helper.MakeHTTPRequest("GET", "/user/legacy/", "", nil)
+		helper.AssertResponse(w, http.StatusOK, "Legacy")
+		if api, archive := helper.UpstreamCalls(); api != 0 || archive != 0 {
+			t.Errorf("Expected no upstream requests for pinned entry, got %d API and %d archive", api, archive)
+		}
+	})
+
+	t.Run("survives eviction", func(t *testing.T) {
+		gp.MinFreeSpace = 1 << 40
+		gp.freeSpaceFunc = func(string) (uint64, error) { return 0, nil }
+		gp.checkDiskUsage()
+
+		if _, exists := gp.cache.repos["user/scratch:main"]; exists {
+			t.Error("Expected unpinned entry to be evicted")
+		}
+		for _, key := range []string{"user/archived-docs:v1", "user/legacy:main"} {
+			if _, exists := gp.cache.repos[key]; !exists {
+				t.Errorf("Expected pinned entry %s to survive eviction", key)
+			}
+		}
+	})
+
+	t.Run("restored after restart", func(t *testing.T) {
+		if err := os.MkdirAll(gp.cache.entryPath("user", "frozen", "main"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		restarted := &GitteaPages{
+			GitteaURL: helper.server.URL,
+			CacheDir:  gp.CacheDir,
+			Pins:      []string{"user/frozen"},
+		}
+		if err := restarted.Provision(caddy.Context{}); err != nil {
+			t.Fatalf("Provision failed: %v", err)
+		}
+		if restarted.shouldUpdateCache("user/frozen", "main") {
+			t.Error("Expected pinned entry on disk to be restored on provision")
+		}
+	})
+}
+
+func TestIsDenied_WellKnownCarveOut(t *testing.T) {
+	gp := &GitteaPages{DenyFiles: []string{".*", "*.key"}}
+
+	tests := []struct {
+		name          string
+		path          string
+		denyWellKnown bool
+		expected      bool
+	}{
+		{"dotfile blocked", ".env", false, true},
+		{"nested dotfile blocked", "config/.secrets", false, true},
+		{"dot directory blocked", ".git/config", false, true},
+		{"well-known served", ".well-known/security.txt", false, false},
+		{"well-known acme served", ".well-known/acme-challenge/token", false, false},
+		{"files in well-known still checked", ".well-known/private.key", false, true},
+		{"nested well-known not exempt", "docs/.well-known/security.txt", false, true},
+		{"carve-out disabled", ".well-known/security.txt", true, true},
+		{"regular file served", "index.html", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gp.DenyWellKnown = tt.denyWellKnown
+			if got := gp.isDenied(tt.path); got != tt.expected {
+				t.Errorf("Expected isDenied(%s) = %v, got %v", tt.path, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_WellKnownWithDotfileDeny(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+	})
+	gp.DenyFiles = []string{".*"}
+	helper.CreateCacheEntry("user/site", "main", map[string]string{
+		".well-known/security.txt": "Contact: mailto:security@example.com",
+		".env":                     "SECRET=hunter2",
+	})
+
+	w := helper.MakeHTTPRequest("GET", "/user/site/.well-known/security.txt", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "Contact:")
+
+	w = helper.MakeHTTPRequest("GET", "/user/site/.env", "", nil)
+	if w.Code == http.StatusOK || strings.Contains(w.Body.String(), "hunter2") {
+		t.Errorf("Expected .env to stay blocked, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestUpstreamProxy(t *testing.T) {
+	tests := []struct {
+		name          string
+		noProxy       []string
+		expectProxied bool
+	}{
+		{
+			name:          "requests go through proxy",
+			expectProxied: true,
+		},
+		{
+			name:          "no-proxy host bypasses proxy",
+			noProxy:       []string{"127.0.0.1"},
+			expectProxied: false,
+		},
+		{
+			name:          "unrelated no-proxy entry",
+			noProxy:       []string{".internal"},
+			expectProxied: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helper := NewTestHelper(t)
+			defer helper.Cleanup()
+
+			helper.CreateMockGiteaServer(GenerateTestRepos())
+
+			var proxied atomic.Int64
+			proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				proxied.Add(1)
+				if !r.URL.IsAbs() {
+					http.Error(w, "expected absolute request URI", http.StatusBadRequest)
+					return
+				}
+				r.RequestURI = ""
+				resp, err := http.DefaultTransport.RoundTrip(r)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadGateway)
+					return
+				}
+				defer resp.Body.Close()
+				for key, values := range resp.Header {
+					w.Header()[key] = values
+				}
+				w.WriteHeader(resp.StatusCode)
+				io.Copy(w, resp.Body)
+			}))
+			defer proxy.Close()
+
+			helper.SetupGiteaPages(GitteaPagesConfig{
+				GitteaURL:       helper.server.URL,
+				UpstreamProxy:   proxy.URL,
+				UpstreamNoProxy: tt.noProxy,
+			})
+
+			w := helper.MakeHTTPRequest("GET", "/user/website/about.html", "", nil)
+			helper.AssertResponse(w, http.StatusOK, "About Us")
+
+			if got := proxied.Load() > 0; got != tt.expectProxied {
+				t.Errorf("Expected proxied=%v, got %d proxied requests", tt.expectProxied, proxied.Load())
+			}
+		})
+	}
+}
+
+func TestGiteaPages_Validate_UpstreamProxy(t *testing.T) {
+	tests := []struct {
+		proxy   string
+		wantErr bool
+	}{
+		{"http://proxy.example.com:3128", false},
+		{"https://proxy.example.com", false},
+		{"socks5://127.0.0.1:1080", false},
+		{"socks5h://proxy.example.com:1080", false},
+		{"ftp://proxy.example.com", true},
+		{"proxy.example.com:3128", true},
+	}
+
+	for _, tt := range tests {
+		gp := &GitteaPages{GitteaURL: "https://git.example.com", UpstreamProxy: tt.proxy}
+		if err := gp.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%q): expected error %v, got %v", tt.proxy, tt.wantErr, err)
+		}
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_UpstreamProxy(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		upstream_proxy socks5://127.0.0.1:1080
+		upstream_no_proxy git.internal .corp.example.com
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.UpstreamProxy != "socks5://127.0.0.1:1080" {
+		t.Errorf("Expected upstream proxy 'socks5://127.0.0.1:1080', got '%s'", gp.UpstreamProxy)
+	}
+	if len(gp.UpstreamNoProxy) != 2 || gp.UpstreamNoProxy[1] != ".corp.example.com" {
+		t.Errorf("Expected two no-proxy entries, got %v", gp.UpstreamNoProxy)
+	}
+	if !gp.bypassProxy("api.corp.example.com") || !gp.bypassProxy("git.internal") || gp.bypassProxy("git.example.com") {
+		t.Errorf("Unexpected no-proxy matching for %v", gp.UpstreamNoProxy)
+	}
+}
+
+func TestServeHTTP_ForceHTTPS(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+		DomainMappings: []DomainMapping{
+			{Domain: "secure.example.com", Owner: "user", Repository: "website"},
+			{Domain: "plain.example.com", Owner: "user", Repository: "website"},
+		},
+	})
+	gp.ForceHTTPS = []string{"secure.example.com"}
+
+	tests := []struct {
+		name             string
+		url              string
+		forwardedProto   string
+		untrusted        bool // the request did not come through a trusted proxy
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{
+			name:             "plain HTTP is redirected",
+			url:              "http://secure.example.com:8080/about.html?ref=nav",
+			expectedStatus:   http.StatusMovedPermanently,
+			expectedLocation: "https://secure.example.com/about.html?ref=nav",
+		},
+		{
+			name:             "forwarded plain HTTP is redirected",
+			url:              "https://secure.example.com/about.html",
+			forwardedProto:   "http",
+			expectedStatus:   http.StatusMovedPermanently,
+			expectedLocation: "https://secure.example.com/about.html",
+		},
+		{
+			name:           "HTTPS is served",
+			url:            "https://secure.example.com/about.html",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "TLS terminated upstream is served",
+			url:            "http://secure.example.com/about.html",
+			forwardedProto: "https",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:             "forwarded HTTPS from an untrusted client is redirected",
+			url:              "http://secure.example.com/about.html",
+			forwardedProto:   "https",
+			untrusted:        true,
+			expectedStatus:   http.StatusMovedPermanently,
+			expectedLocation: "https://secure.example.com/about.html",
+		},
+		{
+			name:           "other domains are untouched",
+			url:            "http://plain.example.com/about.html",
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			if tt.forwardedProto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.forwardedProto)
+			}
+			if !tt.untrusted {
+				req = trustedProxyRequest(req)
+			}
+			w := httptest.NewRecorder()
+
+			if err := gp.ServeHTTP(w, req, next); err != nil {
+				t.Fatalf("ServeHTTP returned error: %v", err)
+			}
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if loc := w.Header().Get("Location"); loc != tt.expectedLocation {
+				t.Errorf("Expected Location '%s', got '%s'", tt.expectedLocation, loc)
+			}
+		})
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_ForceHTTPS(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"force_https", []string{"*"}},
+		{"force_https a.example.com b.example.com", []string{"a.example.com", "b.example.com"}},
+	}
+
+	for _, tt := range tests {
+		d := caddyfile.NewTestDispenser("gitea_pages {\n" + tt.input + "\n}")
+		var gp GitteaPages
+		if err := gp.UnmarshalCaddyfile(d); err != nil {
+			t.Fatalf("UnmarshalCaddyfile(%q) failed: %v", tt.input, err)
+		}
+		if strings.Join(gp.ForceHTTPS, ",") != strings.Join(tt.expected, ",") {
+			t.Errorf("%q: expected %v, got %v", tt.input, tt.expected, gp.ForceHTTPS)
+		}
+	}
+}
+
+func TestServeHTTP_BranchPrefixedPaths(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	repos := GenerateTestRepos()
+	website := repos["user/website"]
+	website.Branches = map[string]map[string]string{
+		"dev": {
+			"index.html": "<h1>Preview Build</h1>",
+			"about.html": "<h1>Preview About</h1>",
+		},
+	}
+	repos["user/website"] = website
+
+	helper.CreateMockGiteaServer(repos)
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+
+	tests := []struct {
+		path             string
+		expectedContains string
+	}{
+		{"/user/website/@dev/about.html", "Preview About"},
+		{"/user/website/@dev/", "Preview Build"},
+		{"/user/website/about.html", "About Us"},
+		{"/user/website/", "Welcome to My Website"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := helper.MakeHTTPRequest("GET", tt.path, "", nil)
+			helper.AssertResponse(w, http.StatusOK, tt.expectedContains)
+		})
+	}
+
+	for _, key := range []string{"user/website:dev", "user/website:main"} {
+		if _, ok := gp.cache.repos[key]; !ok {
+			t.Errorf("Expected cache entry %s", key)
+		}
+	}
+}
+
+func TestServeHTTP_AutoMappingPlaceholder(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	placeholder := filepath.Join(t.TempDir(), "coming-soon.html")
+	if err := os.WriteFile(placeholder, []byte("<h1>Coming Soon</h1>"), 0644); err != nil {
+		t.Fatalf("Failed to write placeholder: %v", err)
+	}
+
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+		DomainMappings: []DomainMapping{
+			{Domain: "missing.example.com", Owner: "nobody", Repository: "missing"},
+		},
+		AutoMapping: &AutoMapping{
+			Enabled:           true,
+			Pattern:           "{subdomain}.{domain}",
+			Owner:             "user",
+			RepoFormat:        "{subdomain}",
+			Placeholder:       placeholder,
+			PlaceholderStatus: http.StatusServiceUnavailable,
+		},
+	})
+
+	w := helper.MakeHTTPRequest("GET", "/about.html", "website.example.com", nil)
+	helper.AssertResponse(w, http.StatusOK, "About Us")
+
+	w = helper.MakeHTTPRequest("GET", "/", "upcoming.example.com", nil)
+	helper.AssertResponse(w, http.StatusServiceUnavailable, "Coming Soon")
+	if cc := w.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("Expected Cache-Control 'no-store', got '%s'", cc)
+	}
+
+	// Explicitly mapped hosts keep falling through
+	w = helper.MakeHTTPRequest("GET", "/", "missing.example.com", nil)
+	helper.AssertResponse(w, http.StatusNotFound, "Not handled by gitea-pages")
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_Placeholder(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		auto_mapping {
+			enabled true
+			placeholder /srv/coming-soon.html 503
+		}
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.AutoMapping.Placeholder != "/srv/coming-soon.html" {
+		t.Errorf("Expected placeholder '/srv/coming-soon.html', got '%s'", gp.AutoMapping.Placeholder)
+	}
+	if gp.AutoMapping.PlaceholderStatus != 503 {
+		t.Errorf("Expected placeholder status 503, got %d", gp.AutoMapping.PlaceholderStatus)
+	}
+
+	gp.AutoMapping.PlaceholderStatus = 200
+	if err := gp.Validate(); err == nil {
+		t.Error("Expected validation error for placeholder status 200")
+	}
+}
+
+func TestFindIndexFile_SkipIndexExtensions(t *testing.T) {
+	gp := &GitteaPages{
+		IndexFiles:          []string{"index.php", "index.html"},
+		SkipIndexExtensions: []string{".php", "ASP"},
+	}
+
+	tests := []struct {
+		name     string
+		files    []string
+		expected string
+	}{
+		{"php skipped for html", []string{"index.php", "index.html"}, "index.html"},
+		{"only php present", []string{"index.php"}, ""},
+		{"dotfile naming skipped extension", []string{"index.asp", "index.html", indexDotfile + "=index.asp"}, "index.html"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, f := range tt.files {
+				name, content, _ := strings.Cut(f, "=")
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := gp.findIndexFile(dir, false); got != tt.expected {
+				t.Errorf("Expected index '%s', got '%s'", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_StaleOnAuthError(t *testing.T) {
+	tests := []struct {
+		name             string
+		staleOnAuthError bool
+		cached           bool
+		expectedStatus   int
+		expectedContains string
+	}{
+		{
+			name:             "stale copy served on 401",
+			staleOnAuthError: true,
+			cached:           true,
+			expectedStatus:   http.StatusOK,
+			expectedContains: "Cached Secret",
+		},
+		{
+			name:             "policy off surfaces the failure",
+			cached:           true,
+			expectedStatus:   http.StatusNotFound,
+			expectedContains: "Not handled by gitea-pages",
+		},
+		{
+			name:             "nothing cached surfaces the failure",
+			staleOnAuthError: true,
+			expectedStatus:   http.StatusNotFound,
+			expectedContains: "Not handled by gitea-pages",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helper := NewTestHelper(t)
+			defer helper.Cleanup()
+
+			helper.CreateMockGiteaServer(GenerateTestRepos())
+			gp := helper.SetupGiteaPages(GitteaPagesConfig{
+				GitteaURL: helper.server.URL,
+			})
+			gp.StaleOnAuthError = tt.staleOnAuthError
+
+			if tt.cached {
+				helper.CreateCacheEntry("company/private", "main", map[string]string{
+					"secret.html": "<h1>Cached Secret</h1>",
+				})
+				gp.cache.repos["company/private:main"].lastUpdate = time.Now().Add(-time.Hour)
+			}
+
+			// No token is configured, so the private repository answers 401
+			w := helper.MakeHTTPRequest("GET", "/company/private/secret.html", "", nil)
+			helper.AssertResponse(w, tt.expectedStatus, tt.expectedContains)
+		})
+	}
+}
+
+func TestServeHTTP_UnexpectedUpstreamContent(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+
+	t.Run("typed error instead of not found", func(t *testing.T) {
+		helper.ServeLoginPageNext(1)
+		_, err := gp.getRepoInfo(context.Background(), "user", "website")
+		if !errors.Is(err, errUnexpectedContent) {
+			t.Fatalf("Expected errUnexpectedContent, got %v", err)
+		}
+		if errors.Is(err, errRepoNotFound) {
+			t.Error("Expected unexpected content not to be reported as not found")
+		}
+	})
+
+	t.Run("bad gateway on cold cache", func(t *testing.T) {
+		helper.ServeLoginPageNext(1)
+		w := helper.MakeHTTPRequest("GET", "/user/website/index.html", "", nil)
+		helper.AssertResponse(w, http.StatusBadGateway, "unexpected response from gitea")
+	})
+
+	t.Run("stale copy served", func(t *testing.T) {
+		helper.CreateCacheEntry("user/blog", "main", map[string]string{
+			"post.html": "<h1>Cached Post</h1>",
+		})
+		gp.cache.repos["user/blog:main"].lastUpdate = time.Now().Add(-time.Hour)
+
+		helper.ServeLoginPageNext(1)
+		w := helper.MakeHTTPRequest("GET", "/user/blog/post.html", "", nil)
+		helper.AssertResponse(w, http.StatusOK, "Cached Post")
+	})
+
+	t.Run("malformed JSON", func(t *testing.T) {
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"name": "website", `))
+		}))
+		defer upstream.Close()
+
+		gp.GitteaURL = upstream.URL
+		defer func() { gp.GitteaURL = helper.server.URL }()
+		if _, err := gp.getRepoInfo(context.Background(), "user", "website"); !errors.Is(err, errUnexpectedContent) {
+			t.Errorf("Expected errUnexpectedContent, got %v", err)
+		}
+	})
+}
+
+func TestCacheModes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("POSIX permissions are not supported on Windows")
+	}
+
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	gp := &GitteaPages{
+		GitteaURL:     helper.server.URL,
+		CacheDir:      filepath.Join(t.TempDir(), "nested", "cache"),
+		CacheDirMode:  "0700",
+		CacheFileMode: "0600",
+	}
+	if err := gp.Provision(caddy.Context{}); err != nil {
+		t.Fatalf("Failed to provision: %v", err)
+	}
+	helper.gp = gp
+
+	w := helper.MakeHTTPRequest("GET", "/user/website/css/style.css", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "font-family")
+
+	entryPath := gp.cache.entryPath("user", "website", "main")
+	checks := []struct {
+		path string
+		mode os.FileMode
+	}{
+		{filepath.Dir(gp.CacheDir), 0700},
+		{gp.CacheDir, 0700},
+		{entryPath, 0700},
+		{filepath.Join(entryPath, "css"), 0700},
+		{filepath.Join(entryPath, "index.html"), 0600},
+		{filepath.Join(entryPath, "css", "style.css"), 0600},
+	}
+	for _, c := range checks {
+		info, err := os.Stat(c.path)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", c.path, err)
+		}
+		if got := info.Mode().Perm(); got != c.mode {
+			t.Errorf("%s: expected mode %o, got %o", c.path, c.mode, got)
+		}
+	}
+}
+
+func TestGiteaPages_Validate_CacheModes(t *testing.T) {
+	tests := []struct {
+		mode    string
+		wantErr bool
+	}{
+		{"0750", false},
+		{"640", false},
+		{"0", true},
+		{"0999", true},
+		{"01777", true},
+		{"rwx", true},
+	}
+
+	for _, tt := range tests {
+		gp := &GitteaPages{GitteaURL: "https://git.example.com", CacheFileMode: tt.mode}
+		if err := gp.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(cache_file_mode %q): expected error %v, got %v", tt.mode, tt.wantErr, err)
+		}
+	}
+}
+
+func TestServeHTTP_WarmOnHead(t *testing.T) {
+	tests := []struct {
+		name       string
+		warmOnHead bool
+	}{
+		{"warming on", true},
+		{"warming off", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helper := NewTestHelper(t)
+			defer helper.Cleanup()
+
+			helper.CreateMockGiteaServer(GenerateTestRepos())
+			gp := helper.SetupGiteaPages(GitteaPagesConfig{
+				GitteaURL: helper.server.URL,
+			})
+			gp.WarmOnHead = tt.warmOnHead
+
+			// Warming answers at once, but without a probe it cannot
+			// confirm the path before the cache is warm; otherwise HEAD
+			// downloads the archive like GET
+			w := helper.MakeHTTPRequest("HEAD", "/user/website/css/style.css", "", nil)
+			want := http.StatusOK
+			if tt.warmOnHead {
+				want = http.StatusNotFound
+			}
+			if w.Code != want {
+				t.Fatalf("Expected status %d, got %d", want, w.Code)
+			}
+
+			cached := func() bool {
+				gp.cache.mu.RLock()
+				defer gp.cache.mu.RUnlock()
+				_, ok := gp.cache.repos["user/website:main"]
+				return ok
+			}
+
+			deadline := time.Now().Add(2 * time.Second)
+			for !cached() && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if !cached() {
+				t.Fatal("Expected the branch cached after HEAD")
+			}
+
+			w = helper.MakeHTTPRequest("HEAD", "/user/website/css/style.css", "", nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200 once cached, got %d", w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/css") {
+				t.Errorf("Expected text/css Content-Type, got '%s'", ct)
+			}
+		})
+	}
+
+	// A HEAD for a repository Gitea does not know still falls through
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	helper.SetupGiteaPages(GitteaPagesConfig{GitteaURL: helper.server.URL})
+
+	w := helper.MakeHTTPRequest("HEAD", "/nobody/missing/index.html", "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown repository, got %d", w.Code)
+	}
+
+	// HEAD agrees with GET about a missing file of a cold repository
+	w = helper.MakeHTTPRequest("HEAD", "/org/blog/does-not-exist", "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a missing file, got %d", w.Code)
+	}
+}
+
+func TestServeHeadFromMetadata_ExpiredCopy(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{GitteaURL: helper.server.URL})
+	gp.WarmOnHead = true
+	gp.MaxStale = caddy.Duration(time.Minute)
+	helper.CreateCacheEntry("user/website", "main", map[string]string{
+		"index.html":  "<h1>Old</h1>",
+		"css/app.css": "body {}",
+	})
+	gp.cache.repos["user/website:main"].lastUpdate = time.Now().Add(-24 * time.Hour)
+	// Warms fail, so the HEADs are answered from the expired copy
+	helper.FailNextArchives(10)
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/user/website/css/app.css", http.StatusOK},
+		{"/user/website/", http.StatusOK},
+		{"/user/website/gone.html", http.StatusNotFound},
+		{"/user/website/../../etc/passwd", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := helper.MakeHTTPRequest("HEAD", tt.path, "", nil)
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.status, w.Code)
+		}
+	}
+}
+
+func TestServeHTTP_DirectorySlash(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+		DomainMappings: []DomainMapping{
+			{Domain: "docs.example.com", Owner: "user", Repository: "docs"},
+		},
+	})
+	helper.CreateCacheEntry("user/docs", "main", map[string]string{
+		"index.html":       "<h1>Home</h1>",
+		"guide/index.html": `<h1>Guide</h1><img src="diagram.png">`,
+	})
+
+	tests := []struct {
+		name             string
+		policy           string
+		path             string
+		host             string
+		expectedStatus   int
+		expectedLocation string
+		expectedContains string
+	}{
+		{
+			name:             "default serves index in place",
+			path:             "/user/docs/guide",
+			expectedStatus:   http.StatusOK,
+			expectedContains: "Guide",
+		},
+		{
+			name:             "redirect policy adds slash",
+			policy:           "redirect",
+			path:             "/user/docs/guide?tab=2",
+			expectedStatus:   http.StatusMovedPermanently,
+			expectedLocation: "./guide/?tab=2",
+		},
+		{
+			name:             "redirect policy on mapped domain",
+			policy:           "redirect",
+			path:             "/guide",
+			host:             "docs.example.com",
+			expectedStatus:   http.StatusMovedPermanently,
+			expectedLocation: "./guide/",
+		},
+		{
+			name:             "redirect policy on repository root",
+			policy:           "redirect",
+			path:             "/user/docs",
+			expectedStatus:   http.StatusMovedPermanently,
+			expectedLocation: "./docs/",
+		},
+		{
+			name:             "slash form is served",
+			policy:           "redirect",
+			path:             "/user/docs/guide/",
+			expectedStatus:   http.StatusOK,
+			expectedContains: "Guide",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gp.DirectorySlash = tt.policy
+			w := helper.MakeHTTPRequest("GET", tt.path, tt.host, nil)
+			helper.AssertResponse(w, tt.expectedStatus, tt.expectedContains)
+			if loc := w.Header().Get("Location"); loc != tt.expectedLocation {
+				t.Errorf("Expected Location '%s', got '%s'", tt.expectedLocation, loc)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_ServeSourceMaps(t *testing.T) {
+	tests := []struct {
+		name             string
+		serveSourceMaps  []string
+		host             string
+		expectedStatus   int
+		expectedContains string
+		expectUpstream   bool
+	}{
+		{
+			name:           "off by default",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:             "on for every host",
+			serveSourceMaps:  []string{"*"},
+			expectedStatus:   http.StatusOK,
+			expectedContains: `"mappings"`,
+			expectUpstream:   true,
+		},
+		{
+			name:             "on for the staging domain",
+			serveSourceMaps:  []string{"staging.example.com"},
+			host:             "staging.example.com",
+			expectedStatus:   http.StatusOK,
+			expectedContains: `"mappings"`,
+			expectUpstream:   true,
+		},
+		{
+			name:            "off for other domains",
+			serveSourceMaps: []string{"staging.example.com"},
+			host:            "www.example.com",
+			expectedStatus:  http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helper := NewTestHelper(t)
+			defer helper.Cleanup()
+
+			repos := GenerateTestRepos()
+			repos["user/website"].Files["js/script.js.map"] = `{"version":3,"mappings":""}`
+			helper.CreateMockGiteaServer(repos)
+
+			mappings := []DomainMapping{}
+			if tt.host != "" {
+				mappings = append(mappings, DomainMapping{Domain: tt.host, Owner: "user", Repository: "website"})
+			}
+			gp := helper.SetupGiteaPages(GitteaPagesConfig{
+				GitteaURL:      helper.server.URL,
+				DomainMappings: mappings,
+			})
+			gp.ServeSourceMaps = tt.serveSourceMaps
+
+			path := "/user/website/js/script.js.map"
+			if tt.host != "" {
+				path = "/js/script.js.map"
+			}
+			w := helper.MakeHTTPRequest("GET", path, tt.host, nil)
+			helper.AssertResponse(w, tt.expectedStatus, tt.expectedContains)
+
+			api, archive := helper.UpstreamCalls()
+			if got := api+archive > 0; got != tt.expectUpstream {
+				t.Errorf("Expected upstream calls=%v, got %d", tt.expectUpstream, api+archive)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_SelfHeal(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(t *testing.T, entryPath string)
+		path   string
+		expect string
+	}{
+		{
+			name: "deleted file",
+			damage: func(t *testing.T, entryPath string) {
+				if err := os.Remove(filepath.Join(entryPath, "about.html")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			path:   "/user/website/about.html",
+			expect: "About Us",
+		},
+		{
+			name: "truncated file",
+			damage: func(t *testing.T, entryPath string) {
+				if err := os.Truncate(filepath.Join(entryPath, "about.html"), 3); err != nil {
+					t.Fatal(err)
+				}
+			},
+			path:   "/user/website/about.html",
+			expect: "About Us",
+		},
+		{
+			name: "deleted index",
+			damage: func(t *testing.T, entryPath string) {
+				if err := os.Remove(filepath.Join(entryPath, "index.html")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			path:   "/user/website/",
+			expect: "Welcome to My Website",
+		},
+		{
+			name: "deleted entry directory",
+			damage: func(t *testing.T, entryPath string) {
+				if err := os.RemoveAll(entryPath); err != nil {
+					t.Fatal(err)
+				}
+			},
+			path:   "/user/website/contact.html",
+			expect: "Contact",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helper := NewTestHelper(t)
+			defer helper.Cleanup()
+
+			helper.CreateMockGiteaServer(GenerateTestRepos())
+			gp := helper.SetupGiteaPages(GitteaPagesConfig{
+				GitteaURL:        helper.server.URL,
+				CacheDownloadURL: true,
+			})
+			gp.SelfHeal = true
+
+			w := helper.MakeHTTPRequest("GET", "/user/website/css/style.css", "", nil)
+			helper.AssertResponse(w, http.StatusOK, "font-family")
+			_, archives := helper.UpstreamCalls()
+
+			tt.damage(t, gp.cache.entryPath("user", "website", "main"))
+
+			w = helper.MakeHTTPRequest("GET", tt.path, "", nil)
+			helper.AssertResponse(w, http.StatusOK, tt.expect)
+			if _, after := helper.UpstreamCalls(); after != archives+1 {
+				t.Errorf("Expected one repair download, got %d", after-archives)
+			}
+		})
+	}
+
+	// Files that were never in the repository are not treated as damage
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{GitteaURL: helper.server.URL})
+	gp.SelfHeal = true
+
+	helper.MakeHTTPRequest("GET", "/user/website/about.html", "", nil)
+	_, archives := helper.UpstreamCalls()
+	w := helper.MakeHTTPRequest("GET", "/user/website/missing.html", "", nil)
+	helper.AssertResponse(w, http.StatusNotFound, "")
+	if _, after := helper.UpstreamCalls(); after != archives {
+		t.Errorf("Expected no download for a file absent upstream, got %d", after-archives)
+	}
+}
+
+func TestServeHTTP_RequireClientCert(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+		DomainMappings: []DomainMapping{
+			{Domain: "internal.example.com", Owner: "corp", Repository: "wiki"},
+			{Domain: "public.example.com", Owner: "corp", Repository: "wiki"},
+		},
+	})
+	gp.RequireClientCert = []string{"internal.example.com"}
+	helper.CreateCacheEntry("corp/wiki", "main", map[string]string{
+		"page.html": "<h1>Wiki</h1>",
+	})
+
+	verified := &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{&x509.Certificate{}}},
+	}
+
+	tests := []struct {
+		name           string
+		host           string
+		tls            *tls.ConnectionState
+		expectedStatus int
+	}{
+		{"no TLS", "internal.example.com", nil, http.StatusForbidden},
+		{"TLS without client cert", "internal.example.com", &tls.ConnectionState{}, http.StatusForbidden},
+		{"verified client cert", "internal.example.com", verified, http.StatusOK},
+		{"public site without cert", "public.example.com", &tls.ConnectionState{}, http.StatusOK},
+	}
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/page.html", nil)
+			req.Host = tt.host
+			req.TLS = tt.tls
+			w := httptest.NewRecorder()
+
+			if err := gp.ServeHTTP(w, req, next); err != nil {
+				t.Fatalf("ServeHTTP returned error: %v", err)
+			}
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_OverlayDir(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+	})
+	gp.DenyFiles = []string{"*.secret"}
+	helper.CreateCacheEntry("user/site", "main", map[string]string{
+		"index.html":       "<h1>Site</h1>",
+		"assets/logo.svg":  "<svg>repo logo</svg>",
+		"assets/style.css": "body { color: red }",
+	})
+
+	overlay := t.TempDir()
+	for name, content := range map[string]string{
+		"assets/logo.svg":   "<svg>shared logo</svg>",
+		"assets/reset.css":  "* { margin: 0 }",
+		"assets/key.secret": "hunter2",
+	} {
+		p := filepath.Join(overlay, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gp.OverlayDir = overlay
+
+	tests := []struct {
+		path           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"/user/site/assets/reset.css", http.StatusOK, "margin: 0"},
+		{"/user/site/assets/logo.svg", http.StatusOK, "repo logo"},
+		{"/user/site/assets/style.css", http.StatusOK, "color: red"},
+		{"/user/site/assets/missing.css", http.StatusNotFound, ""},
+		{"/user/site/assets/key.secret", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := helper.MakeHTTPRequest("GET", tt.path, "", nil)
+			helper.AssertResponse(w, tt.expectedStatus, tt.expectedBody)
+		})
+	}
+}
+
+func TestServeHTTP_StrictContentType(t *testing.T) {
+	for name, compressed := range map[string]bool{"plain": false, "compressed": true} {
+		t.Run(name, func(t *testing.T) {
+			helper := NewTestHelper(t)
+			defer helper.Cleanup()
+
+			gp := helper.SetupGiteaPages(GitteaPagesConfig{
+				GitteaURL:     "https://git.example.com",
+				CompressCache: compressed,
+			})
+			helper.CreateCacheEntry("user/site", "main", map[string]string{
+				"payload.unknownext": "<html><script>alert(1)</script></html>",
+				"style.css":          "body { margin: 0 }",
+			})
+
+			w := helper.MakeHTTPRequest("GET", "/user/site/payload.unknownext", "", nil)
+			if w.Header().Get("X-Content-Type-Options") != "" {
+				t.Error("Expected no nosniff header without strict_content_type")
+			}
+
+			gp.StrictContentType = true
+
+			w = helper.MakeHTTPRequest("GET", "/user/site/payload.unknownext", "", nil)
+			helper.AssertResponse(w, http.StatusOK, "alert(1)")
+			if got := w.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+				t.Errorf("Expected nosniff, got '%s'", got)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/octet-stream" {
+				t.Errorf("Expected Content-Type 'application/octet-stream', got '%s'", ct)
+			}
+			if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename=payload.unknownext` {
+				t.Errorf("Expected attachment disposition, got '%s'", cd)
+			}
+
+			w = helper.MakeHTTPRequest("GET", "/user/site/style.css", "", nil)
+			helper.AssertResponse(w, http.StatusOK, "margin: 0")
+			if got := w.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+				t.Errorf("Expected nosniff, got '%s'", got)
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/css") {
+				t.Errorf("Expected Content-Type 'text/css', got '%s'", ct)
+			}
+			if cd := w.Header().Get("Content-Disposition"); cd != "" {
+				t.Errorf("Expected no disposition for a known type, got '%s'", cd)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_LandingPage(t *testing.T) {
+	landingFile := filepath.Join(t.TempDir(), "landing.html")
+	if err := os.WriteFile(landingFile, []byte("<h1>Hosted sites</h1>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		landingPage    string
+		landingHTML    string
+		host           string
+		path           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"file on bare root", landingFile, "", "pages.example.com", "/", http.StatusOK, "Hosted sites"},
+		{"inline html on bare root", "", "<p>Welcome</p>", "pages.example.com", "/", http.StatusOK, "<p>Welcome</p>"},
+		{"not configured", "", "", "pages.example.com", "/", http.StatusNotFound, "Not handled by gitea-pages"},
+		{"other paths fall through", landingFile, "", "pages.example.com", "/about", http.StatusNotFound, "Not handled by gitea-pages"},
+		{"mapped host serves its site", landingFile, "", "blog.example.com", "/", http.StatusOK, "<h1>Blog</h1>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helper := NewTestHelper(t)
+			defer helper.Cleanup()
+
+			gp := &GitteaPages{
+				GitteaURL:   "https://git.example.com",
+				CacheDir:    t.TempDir(),
+				LandingPage: tt.landingPage,
+				LandingHTML: tt.landingHTML,
+				DomainMappings: []DomainMapping{
+					{Domain: "blog.example.com", Owner: "user", Repository: "blog"},
+				},
+			}
+			if err := gp.Provision(caddy.Context{}); err != nil {
+				t.Fatalf("Failed to provision: %v", err)
+			}
+			helper.gp = gp
+			helper.CreateCacheEntry("user/blog", "main", map[string]string{
+				"index.html": "<h1>Blog</h1>",
+			})
+
+			w := helper.MakeHTTPRequest("GET", tt.path, tt.host, nil)
+			helper.AssertResponse(w, tt.expectedStatus, tt.expectedBody)
+		})
+	}
+}
+
+func TestCacheNamespace_Isolation(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	repos := map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Files:         map[string]string{"page.html": "<h1>Release 1</h1>"},
+		},
+	}
+	helper.CreateMockGiteaServer(repos)
+	sharedDir := t.TempDir()
+
+	newInstance := func(namespace string, pins ...string) *GitteaPages {
+		gp := &GitteaPages{
+			GitteaURL:      helper.server.URL,
+			CacheDir:       sharedDir,
+			CacheNamespace: namespace,
+			Pins:           pins,
+		}
+		if err := gp.Provision(caddy.Context{}); err != nil {
+			t.Fatalf("Failed to provision %s: %v", namespace, err)
+		}
+		return gp
+	}
+
+	production := newInstance("production")
+	helper.gp = production
+	w := helper.MakeHTTPRequest("GET", "/user/site/page.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "Release 1")
+
+	// Staging picks up a newer release of the same owner/repo/branch
+	repos["user/site"] = MockRepo{
+		Name:          "site",
+		FullName:      "user/site",
+		DefaultBranch: "main",
+		Files:         map[string]string{"page.html": "<h1>Release 2</h1>"},
+	}
+	staging := newInstance("staging")
+	helper.gp = staging
+	w = helper.MakeHTTPRequest("GET", "/user/site/page.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "Release 2")
+
+	helper.gp = production
+	w = helper.MakeHTTPRequest("GET", "/user/site/page.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "Release 1")
+
+	if production.cache.entryPath("user", "site", "main") == staging.cache.entryPath("user", "site", "main") {
+		t.Error("Expected namespaces to use separate directories")
+	}
+
+	// A restarted instance only brings back entries of its own namespace
+	restarted := newInstance("production", "user/site")
+	entry, ok := restarted.cache.repos["user/site:main"]
+	if !ok {
+		t.Fatal("Expected the pinned production entry to be restored")
+	}
+	data, err := os.ReadFile(filepath.Join(entry.path, "page.html"))
+	if err != nil || !strings.Contains(string(data), "Release 1") {
+		t.Errorf("Expected production's copy, got %q, %v", data, err)
+	}
+	if other := newInstance("preview", "user/site"); len(other.cache.repos) != 0 {
+		t.Error("Expected no entries restored into an unused namespace")
+	}
+}
+
+func TestServeHTTP_EnvPrefix(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Files:         map[string]string{"index.html": "<h1>Production</h1>", "app.js": "prod()"},
+			Branches: map[string]map[string]string{
+				"develop": {"index.html": "<h1>Staging</h1>", "app.js": "staging()"},
+			},
+		},
+	})
+	helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+		DomainMappings: []DomainMapping{{
+			Domain:      "site.example.com",
+			Owner:       "user",
+			Repository:  "site",
+			EnvPrefixes: map[string]string{"prod": "main", "staging": "develop"},
+		}},
+	})
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/staging/", "Staging"},
+		{"/staging/app.js", "staging()"},
+		{"/prod/", "Production"},
+		{"/prod/app.js", "prod()"},
+		{"/app.js", "prod()"},
+	}
+
+	for _, tt := range tests {
+		w := helper.MakeHTTPRequest("GET", tt.path, "site.example.com", nil)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), tt.expected) {
+			t.Errorf("%s: expected 200 containing %q, got %d: %s", tt.path, tt.expected, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_EnvPrefix(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		domain_mapping site.example.com user site {
+			env_prefix /prod main
+			env_prefix staging develop
+		}
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	prefixes := gp.DomainMappings[0].EnvPrefixes
+	if len(prefixes) != 2 || prefixes["prod"] != "main" || prefixes["staging"] != "develop" {
+		t.Errorf("Unexpected env prefixes %v", prefixes)
+	}
+}
@@ -0,0 +1,83 @@
+package giteapages
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/zap"
+)
+
+// maxGunzipSize caps the decompressed size of a GunzipFallback file, so a
+// small archive cannot fill the cache disk
+const maxGunzipSize = 256 << 20
+
+// gunzipSibling stores fullPath decompressed from its ".gz" sibling in the
+// cache entry, so the plain file is served from then on like any other.
+// It reports whether the plain file was created.
+func (gp *GitteaPages) gunzipSibling(entry *cacheEntry, fullPath string) bool {
+	rel, err := filepath.Rel(entry.path, fullPath+".gz")
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	if gp.isDenied(rel) || entry.rejected[rel] || entry.streamed[rel] {
+		return false
+	}
+
+	// Small files live in memory rather than in their stand-in on disk
+	data, ok := entry.memory[rel]
+	if !ok {
+		if data, err = readCachedFile(fullPath+".gz", entry.compressed); err != nil {
+			return false
+		}
+	}
+
+	if err := gp.writeGunzipped(fullPath, data); err != nil {
+		gp.logger.Warn("failed to decompress gzip sibling",
+			zap.String("file", fullPath+".gz"),
+			zap.Error(err))
+		return false
+	}
+	return true
+}
+
+// writeGunzipped decompresses data into path, through a temporary file so
+// concurrent requests never serve a partial copy
+func (gp *GitteaPages) writeGunzipped(path string, data []byte) error {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".gunzip-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// The copy is stored the way the rest of the entry is, compressed or not
+	src := &io.LimitedReader{R: gz, N: maxGunzipSize + 1}
+	err = gp.writeCachedFile(tmp, src, path)
+	if err == nil && src.N == 0 {
+		err = fmt.Errorf("decompressed size exceeds %d bytes", maxGunzipSize)
+	}
+	mode := os.FileMode(0644)
+	if gp.fileMode != 0 {
+		mode = gp.fileMode
+	}
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
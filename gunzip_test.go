@@ -0,0 +1,130 @@
+package giteapages
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func gzipString(t *testing.T, s string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestServeHTTP_GunzipFallback(t *testing.T) {
+	data := `{"items":[` + strings.Repeat(`{"id":1},`, 200) + `{"id":2}]}`
+
+	tests := []struct {
+		name     string
+		compress bool
+		minSize  int64
+	}{
+		{"plain cache", false, 0},
+		{"compressed cache", true, 0},
+		{"memory tier", false, 1 << 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helper := NewTestHelper(t)
+			defer helper.Cleanup()
+
+			helper.CreateMockGiteaServer(map[string]MockRepo{
+				"user/data": {
+					Name:          "data",
+					FullName:      "user/data",
+					DefaultBranch: "main",
+					Files: map[string]string{
+						"index.html":   "<h1>Data</h1>",
+						"data.json.gz": gzipString(t, data),
+					},
+				},
+			})
+			gp := helper.SetupGiteaPages(GitteaPagesConfig{
+				GitteaURL:     helper.server.URL,
+				CompressCache: tt.compress,
+			})
+			gp.GunzipFallback = true
+			gp.CacheMinSize = tt.minSize
+
+			for i := 0; i < 2; i++ {
+				w := helper.MakeHTTPRequest("GET", "/user/data/data.json", "", nil)
+				if w.Code != http.StatusOK || w.Body.String() != data {
+					t.Fatalf("Request %d: expected the decompressed JSON, got %d: %.60q", i, w.Code, w.Body.String())
+				}
+				if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+					t.Errorf("Expected Content-Type application/json, got %q", ct)
+				}
+				if ce := w.Header().Get("Content-Encoding"); ce != "" {
+					t.Errorf("Expected no Content-Encoding, got %q", ce)
+				}
+			}
+
+			// The decompressed copy is kept in the cache entry
+			cached := filepath.Join(gp.cache.entryPath("user", "data", "main"), "data.json")
+			stored, err := readCachedFile(cached, tt.compress)
+			if err != nil || string(stored) != data {
+				t.Errorf("Expected the decompressed copy cached, got %v", err)
+			}
+
+			w := helper.MakeHTTPRequest("GET", "/user/data/missing.json", "", nil)
+			if w.Code != http.StatusNotFound {
+				t.Errorf("Expected 404 without a gzip sibling, got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_GunzipFallbackDisabled(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/data": {
+			Name:          "data",
+			FullName:      "user/data",
+			DefaultBranch: "main",
+			Files:         map[string]string{"data.json.gz": gzipString(t, "{}")},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+
+	w := helper.MakeHTTPRequest("GET", "/user/data/data.json", "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 with gunzip_fallback off, got %d", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(gp.cache.entryPath("user", "data", "main"), "data.json")); !os.IsNotExist(err) {
+		t.Errorf("Expected no decompressed copy, got %v", err)
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_GunzipFallback(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		gunzip_fallback
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if !gp.GunzipFallback {
+		t.Error("Expected gunzip_fallback to be enabled")
+	}
+}
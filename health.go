@@ -0,0 +1,154 @@
+package giteapages
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+// readinessTimeout bounds each dependency check of the readiness probe
+const readinessTimeout = 5 * time.Second
+
+// readinessTTL is how long a readiness report is reused. Anyone who can
+// reach a served host can ask, so probes must not each call Gitea.
+const readinessTTL = 5 * time.Second
+
+// readinessState remembers the last readiness report
+type readinessState struct {
+	// refresh shares one round of checks between probes arriving while
+	// the report is out of date
+	refresh singleflight.Group
+
+	mu      sync.Mutex
+	report  healthReport
+	checked time.Time
+}
+
+// healthCheck is the outcome of one readiness dependency check
+type healthCheck struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// healthReport is the JSON body of the liveness and readiness endpoints
+type healthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks,omitempty"`
+}
+
+// serveHealth answers {health_path}/live and {health_path}/ready. Liveness
+// only shows the handler is running; readiness also requires Gitea to
+// answer and the cache directory to be writable, and is 503 otherwise.
+// Failed checks are only named; their errors go to the log.
+func (gp *GitteaPages) serveHealth(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	switch strings.Trim(strings.TrimPrefix(r.URL.Path, strings.TrimRight(gp.HealthPath, "/")), "/") {
+	case "live":
+		return json.NewEncoder(w).Encode(healthReport{Status: "live"})
+	case "ready":
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return json.NewEncoder(w).Encode(map[string]string{"error": "expected /live or /ready"})
+	}
+
+	report := gp.readinessReport(r.Context())
+	if report.Status != "ready" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	return json.NewEncoder(w).Encode(report)
+}
+
+// readinessReport returns the last readiness report, checking the
+// dependencies again once it is older than readinessTTL
+func (gp *GitteaPages) readinessReport(ctx context.Context) healthReport {
+	state := gp.readiness
+	state.mu.Lock()
+	report, checked := state.report, state.checked
+	state.mu.Unlock()
+	if !checked.IsZero() && time.Since(checked) < readinessTTL {
+		return report
+	}
+
+	v, _, _ := state.refresh.Do("", func() (interface{}, error) {
+		report := gp.checkReadiness(context.WithoutCancel(ctx))
+		state.mu.Lock()
+		state.report, state.checked = report, time.Now()
+		state.mu.Unlock()
+		return report, nil
+	})
+	return v.(healthReport)
+}
+
+// checkReadiness runs the readiness checks
+func (gp *GitteaPages) checkReadiness(ctx context.Context) healthReport {
+	report := healthReport{
+		Status: "ready",
+		Checks: map[string]healthCheck{
+			"gitea":     gp.toHealthCheck("gitea", gp.checkGitea(ctx)),
+			"cache_dir": gp.toHealthCheck("cache_dir", gp.checkCacheWritable()),
+		},
+	}
+	for _, check := range report.Checks {
+		if !check.OK {
+			report.Status = "unavailable"
+			break
+		}
+	}
+	return report
+}
+
+// toHealthCheck turns a check's error into its report entry. The error
+// may name internal hosts and paths, so it is logged rather than sent.
+func (gp *GitteaPages) toHealthCheck(name string, err error) healthCheck {
+	if err != nil {
+		gp.logger.Warn("readiness check failed",
+			zap.String("check", name),
+			zap.Error(err))
+		return healthCheck{Error: "check failed"}
+	}
+	return healthCheck{OK: true}
+}
+
+// checkGitea asks Gitea for its version, the cheapest API call that
+// proves it is reachable and serving
+func (gp *GitteaPages) checkGitea(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	req, err := gp.newUpstreamRequest(ctx, strings.TrimRight(gp.GitteaURL, "/")+"/api/v1/version")
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Transport: gp.upstreamTransport()}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("gitea API returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// checkCacheWritable creates and removes a file in the cache directory
+func (gp *GitteaPages) checkCacheWritable() error {
+	f, err := os.CreateTemp(gp.cache.cacheDir, ".ready-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
@@ -0,0 +1,134 @@
+package giteapages
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_Health(t *testing.T) {
+	tests := []struct {
+		name           string
+		breakGitea     bool
+		breakCache     bool
+		expectedStatus int
+		failing        string
+	}{
+		{"all dependencies up", false, false, http.StatusOK, ""},
+		{"gitea unreachable", true, false, http.StatusServiceUnavailable, "gitea"},
+		{"cache not writable", false, true, http.StatusServiceUnavailable, "cache_dir"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helper := NewTestHelper(t)
+			defer helper.Cleanup()
+
+			helper.CreateMockGiteaServer(map[string]MockRepo{})
+			gp := helper.SetupGiteaPages(GitteaPagesConfig{
+				GitteaURL: helper.server.URL,
+			})
+			gp.HealthPath = "/_health"
+			if tt.breakGitea {
+				helper.server.Close()
+			}
+			if tt.breakCache {
+				// A path below a regular file can never be created
+				blocker := filepath.Join(helper.tempDir, "blocker")
+				if err := os.WriteFile(blocker, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+				gp.cache.cacheDir = filepath.Join(blocker, "cache")
+			}
+
+			// Liveness does not depend on either
+			w := helper.MakeHTTPRequest("GET", "/_health/live", "", nil)
+			helper.AssertResponse(w, http.StatusOK, `"live"`)
+
+			w = helper.MakeHTTPRequest("GET", "/_health/ready", "", nil)
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected readiness status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			var report healthReport
+			if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+				t.Fatalf("Invalid readiness JSON: %v", err)
+			}
+			for name, check := range report.Checks {
+				if expected := name != tt.failing; check.OK != expected {
+					t.Errorf("Expected check %s ok=%v, got %+v", name, expected, check)
+				}
+			}
+			if len(report.Checks) != 2 {
+				t.Errorf("Expected 2 checks, got %v", report.Checks)
+			}
+			// The cause is logged, not sent to whoever asked
+			if check := report.Checks[tt.failing]; tt.failing != "" && check.Error != "check failed" {
+				t.Errorf("Expected a generic error, got %q", check.Error)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_HealthReadinessCached(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.HealthPath = "/_health"
+
+	w := helper.MakeHTTPRequest("GET", "/_health/ready", "", nil)
+	helper.AssertResponse(w, http.StatusOK, `"ready"`)
+
+	// Probes within the TTL reuse the report, even once Gitea is gone
+	helper.server.Close()
+	for i := 0; i < 3; i++ {
+		w = helper.MakeHTTPRequest("GET", "/_health/ready", "", nil)
+		helper.AssertResponse(w, http.StatusOK, `"ready"`)
+	}
+
+	gp.readiness.checked = gp.readiness.checked.Add(-readinessTTL)
+	w = helper.MakeHTTPRequest("GET", "/_health/ready", "", nil)
+	helper.AssertResponse(w, http.StatusServiceUnavailable, `"unavailable"`)
+}
+
+func TestServeHTTP_HealthSkipsForceHTTPS(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.HealthPath = "/_health"
+	gp.ForceHTTPS = []string{"*"}
+
+	w := helper.MakeHTTPRequest("GET", "/_health/live", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "live")
+
+	w = helper.MakeHTTPRequest("GET", "/_health/other", "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown probe, got %d", w.Code)
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_HealthPath(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		health_path /_health
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.HealthPath != "/_health" {
+		t.Errorf("Expected health_path /_health, got %q", gp.HealthPath)
+	}
+}
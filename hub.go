@@ -0,0 +1,228 @@
+package giteapages
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+// HubPage configures a generated directory of hosted sites, served for
+// the bare root of a host that is not mapped to a repository
+type HubPage struct {
+	// Owners lists users or organizations whose repositories are added
+	// to the directory, linked by path on the requested host
+	Owners []string `json:"owners,omitempty"`
+
+	// Template is an html/template file replacing the built-in page. It
+	// is executed with a value whose Sites field lists every hubSite.
+	Template string `json:"template,omitempty"`
+}
+
+// hubSite is one link of the hub page
+type hubSite struct {
+	Name   string
+	URL    string
+	Owner  string
+	Repo   string
+	Branch string
+}
+
+// hubState holds the parsed hub template and the repositories discovered
+// for HubPage.Owners
+type hubState struct {
+	tmpl *template.Template
+
+	// refresh shares one listing between requests arriving while the
+	// discovered repositories are out of date
+	refresh singleflight.Group
+
+	mu         sync.Mutex
+	discovered []hubSite
+	fetched    time.Time
+}
+
+var defaultHubTemplate = template.Must(template.New("hub").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Hosted sites</title>
+</head>
+<body>
+<h1>Hosted sites</h1>
+<ul>
+{{- range .Sites}}
+<li><a href="{{.URL}}">{{.Name}}</a></li>
+{{- end}}
+</ul>
+</body>
+</html>
+`))
+
+// newHubState parses the hub template, falling back to the built-in page
+func newHubState(hub *HubPage) (*hubState, error) {
+	if hub.Template == "" {
+		return &hubState{tmpl: defaultHubTemplate}, nil
+	}
+	src, err := os.ReadFile(hub.Template)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hub_page template: %v", err)
+	}
+	tmpl, err := template.New("hub").Parse(string(src))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse hub_page template: %v", err)
+	}
+	return &hubState{tmpl: tmpl}, nil
+}
+
+// serveHub renders the hub page listing the domain mappings, then any
+// repositories discovered for HubPage.Owners
+func (gp *GitteaPages) serveHub(w http.ResponseWriter, r *http.Request) error {
+	scheme := requestScheme(r)
+	var sites []hubSite
+	for _, m := range gp.domainMappings() {
+		sites = append(sites, hubSite{
+			Name:   m.Domain,
+			URL:    (&url.URL{Scheme: scheme, Host: m.Domain, Path: "/"}).String(),
+			Owner:  m.Owner,
+			Repo:   m.Repository,
+			Branch: m.Branch,
+		})
+	}
+	sites = append(sites, gp.discoveredSites(r.Context())...)
+
+	var page bytes.Buffer
+	if err := gp.hub.tmpl.Execute(&page, struct{ Sites []hubSite }{sites}); err != nil {
+		return fmt.Errorf("failed to render hub page: %w", err)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.Itoa(page.Len()))
+		return nil
+	}
+	_, err := w.Write(page.Bytes())
+	return err
+}
+
+// discoveredSites returns the public repositories of HubPage.Owners,
+// refreshed at most once per CacheTTL. A failed refresh keeps the
+// previous list.
+func (gp *GitteaPages) discoveredSites(ctx context.Context) []hubSite {
+	if len(gp.HubPage.Owners) == 0 {
+		return nil
+	}
+
+	gp.hub.mu.Lock()
+	discovered, fetched := gp.hub.discovered, gp.hub.fetched
+	gp.hub.mu.Unlock()
+	if !fetched.IsZero() && time.Since(fetched) <= time.Duration(gp.CacheTTL) {
+		return discovered
+	}
+
+	// The listing is not done under hub.mu, so a slow Gitea does not hold
+	// up every other hub request
+	sites, _, _ := gp.hub.refresh.Do("", func() (interface{}, error) {
+		return gp.listHubSites(context.WithoutCancel(ctx)), nil
+	})
+	return sites.([]hubSite)
+}
+
+// listHubSites lists the repositories of HubPage.Owners and records them
+// as discovered, returning the previous list when a listing fails
+func (gp *GitteaPages) listHubSites(ctx context.Context) []hubSite {
+	var sites []hubSite
+	for _, owner := range gp.HubPage.Owners {
+		repos, err := gp.listOwnerRepos(ctx, owner)
+		if err != nil {
+			gp.logger.Warn("failed to list repositories for hub page",
+				zap.String("owner", owner),
+				zap.Error(err))
+			gp.hub.mu.Lock()
+			previous := gp.hub.discovered
+			gp.hub.mu.Unlock()
+			return previous
+		}
+		for _, repo := range repos {
+			sites = append(sites, hubSite{
+				Name:  owner + "/" + repo,
+				URL:   (&url.URL{Path: "/" + owner + "/" + repo + "/"}).EscapedPath(),
+				Owner: owner,
+				Repo:  repo,
+			})
+		}
+	}
+	gp.hub.mu.Lock()
+	gp.hub.discovered = sites
+	gp.hub.fetched = time.Now()
+	gp.hub.mu.Unlock()
+	return sites
+}
+
+// listOwnerRepos returns the names of owner's public repositories, in
+// name order, through the Gitea API. With gitea_token set Gitea lists
+// private repositories too, and those must not be advertised.
+func (gp *GitteaPages) listOwnerRepos(ctx context.Context, owner string) ([]string, error) {
+	client := &http.Client{Timeout: 30 * time.Second, Transport: gp.upstreamTransport()}
+
+	var names []string
+	pageSize := gp.apiPageSize()
+	base := fmt.Sprintf("%s/api/v1/users/%s/repos", strings.TrimRight(gp.GitteaURL, "/"), url.PathEscape(owner))
+	apiURL := fmt.Sprintf("%s?page=1&limit=%d", base, pageSize)
+
+	for page := 1; ; page++ {
+		req, err := gp.newUpstreamRequest(ctx, apiURL)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		var batch []struct {
+			Name    string `json:"name"`
+			Private bool   `json:"private"`
+		}
+		switch resp.StatusCode {
+		case http.StatusOK:
+			err = gp.decodeJSON(resp, &batch)
+		case http.StatusTooManyRequests:
+			err = gp.rateLimited(resp)
+		default:
+			err = fmt.Errorf("gitea API returned status %d", resp.StatusCode)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, repo := range batch {
+			if !repo.Private {
+				names = append(names, repo.Name)
+			}
+		}
+		next, linked := nextPageURL(apiURL, resp.Header)
+		if linked && next == "" || !linked && len(batch) < pageSize {
+			break
+		}
+		if next == "" {
+			next = fmt.Sprintf("%s?page=%d&limit=%d", base, page+1, pageSize)
+		}
+		apiURL = next
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
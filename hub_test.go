@@ -0,0 +1,121 @@
+package giteapages
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_HubPage(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"docs/handbook": {Name: "handbook", FullName: "docs/handbook", DefaultBranch: "main"},
+		"docs/api":      {Name: "api", FullName: "docs/api", DefaultBranch: "main"},
+		"docs/internal": {Name: "internal", FullName: "docs/internal", DefaultBranch: "main", Private: true},
+		"other/site":    {Name: "site", FullName: "other/site", DefaultBranch: "main"},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+		DomainMappings: []DomainMapping{
+			{Domain: "www.example.com", Owner: "acme", Repository: "website"},
+			{Domain: "blog.example.com", Owner: "acme", Repository: "blog", Branch: "pages"},
+		},
+	})
+	hub, err := newHubState(&HubPage{})
+	if err != nil {
+		t.Fatalf("newHubState failed: %v", err)
+	}
+	gp.HubPage = &HubPage{Owners: []string{"docs"}}
+	gp.hub = hub
+
+	helper.TrustProxies()
+	w := helper.MakeHTTPRequest("GET", "/", "pages.example.com", map[string]string{"X-Forwarded-Proto": "https"})
+	helper.AssertResponse(w, http.StatusOK, "Hosted sites")
+	if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Expected HTML Content-Type, got '%s'", ct)
+	}
+	body := w.Body.String()
+	for _, link := range []string{
+		`<a href="https://www.example.com/">www.example.com</a>`,
+		`<a href="https://blog.example.com/">blog.example.com</a>`,
+		`<a href="/docs/api/">docs/api</a>`,
+		`<a href="/docs/handbook/">docs/handbook</a>`,
+	} {
+		if !strings.Contains(body, link) {
+			t.Errorf("Expected hub page to contain %s, got:\n%s", link, body)
+		}
+	}
+	if strings.Contains(body, "other/site") {
+		t.Error("Expected repositories of unlisted owners to be left out")
+	}
+	if strings.Contains(body, "docs/internal") {
+		t.Error("Expected private repositories to be left out")
+	}
+
+	// Discovered repositories are cached for the cache TTL
+	api, _ := helper.UpstreamCalls()
+	helper.MakeHTTPRequest("GET", "/", "pages.example.com", nil)
+	if again, _ := helper.UpstreamCalls(); again != api {
+		t.Errorf("Expected discovered repositories to be cached, got %d more API calls", again-api)
+	}
+
+	// Mapped domains still serve their site rather than the hub
+	w = helper.MakeHTTPRequest("GET", "/", "www.example.com", nil)
+	if strings.Contains(w.Body.String(), "Hosted sites") {
+		t.Error("Expected mapped domain not to serve the hub page")
+	}
+}
+
+func TestServeHTTP_HubPageTemplate(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+		DomainMappings: []DomainMapping{
+			{Domain: "www.example.com", Owner: "acme", Repository: "website"},
+		},
+	})
+	tmpl := filepath.Join(t.TempDir(), "hub.html")
+	if err := os.WriteFile(tmpl, []byte(`<ol>{{range .Sites}}<li data-repo="{{.Owner}}/{{.Repo}}">{{.URL}}</li>{{end}}</ol>`), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v", err)
+	}
+	gp.HubPage = &HubPage{Template: tmpl}
+	hub, err := newHubState(gp.HubPage)
+	if err != nil {
+		t.Fatalf("newHubState failed: %v", err)
+	}
+	gp.hub = hub
+
+	w := helper.MakeHTTPRequest("GET", "/", "pages.example.com", nil)
+	helper.AssertResponse(w, http.StatusOK, `<ol><li data-repo="acme/website">http://www.example.com/</li></ol>`)
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_HubPage(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		hub_page {
+			owners docs acme
+			template /etc/caddy/hub.html
+		}
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.HubPage == nil || len(gp.HubPage.Owners) != 2 || gp.HubPage.Template != "/etc/caddy/hub.html" {
+		t.Errorf("Unexpected hub_page %+v", gp.HubPage)
+	}
+
+	gp.LandingHTML = "<h1>Welcome</h1>"
+	if err := gp.Validate(); err == nil {
+		t.Error("Expected hub_page and landing_html to be rejected together")
+	}
+}
@@ -0,0 +1,82 @@
+package giteapages
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+)
+
+// defaultImmutablePattern matches build-tool output such as
+// app.3f9a1c2b.js or chunk-0a1b2c3d4e.css
+const defaultImmutablePattern = `[.-][0-9a-f]{8,}\.[A-Za-z0-9]+$`
+
+// immutableCacheControl is sent with content-hashed assets
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// ImmutableAssets treats files whose name carries a content hash as
+// never changing: they are sent with a long-lived immutable Cache-Control
+// and served from an expired cache entry without revalidating it.
+type ImmutableAssets struct {
+	// Pattern is a regular expression matched against the file name;
+	// it defaults to a dot or dash followed by eight or more hex digits
+	// before the extension
+	Pattern string `json:"pattern,omitempty"`
+
+	// Extensions limits matching to these extensions; empty means all
+	Extensions []string `json:"extensions,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// provision compiles the pattern
+func (ia *ImmutableAssets) provision() error {
+	pattern := ia.Pattern
+	if pattern == "" {
+		pattern = defaultImmutablePattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid immutable_assets pattern %q: %v", pattern, err)
+	}
+	ia.re = re
+	return nil
+}
+
+// isImmutable reports whether the repository file relPath is a
+// content-hashed asset
+func (gp *GitteaPages) isImmutable(relPath string) bool {
+	ia := gp.ImmutableAssets
+	if ia == nil || ia.re == nil {
+		return false
+	}
+	name := path.Base(relPath)
+	if len(ia.Extensions) > 0 && !extensionListed(ia.Extensions, name) {
+		return false
+	}
+	return ia.re.MatchString(name)
+}
+
+// immutableCached reports whether relPath is a content-hashed asset the
+// cache entry under cacheKey already holds, so it can be served without
+// refreshing the entry even once it has expired
+func (gp *GitteaPages) immutableCached(cacheKey, relPath string) bool {
+	if !gp.isImmutable(relPath) {
+		return false
+	}
+
+	gp.cache.mu.RLock()
+	entry, exists := gp.cache.repos[cacheKey]
+	gp.cache.mu.RUnlock()
+	if !exists {
+		return false
+	}
+
+	fullPath := filepath.Join(entry.path, relPath)
+	if !withinDir(entry.path, fullPath) {
+		return false
+	}
+	info, err := os.Stat(fullPath)
+	return err == nil && info.Mode().IsRegular()
+}
@@ -0,0 +1,108 @@
+package giteapages
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_ImmutableAssets(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/app": {
+			Name:          "app",
+			FullName:      "user/app",
+			DefaultBranch: "main",
+			Files: map[string]string{
+				"index.html":               "<h1>App</h1>",
+				"assets/app.3f9a1c2b.js":   "console.log('hashed')",
+				"assets/app.js":            "console.log('plain')",
+				"assets/logo.3f9a1c2b.png": "PNG",
+			},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.ImmutableAssets = &ImmutableAssets{Extensions: []string{".js", ".css"}}
+	if err := gp.ImmutableAssets.provision(); err != nil {
+		t.Fatalf("provision failed: %v", err)
+	}
+
+	w := helper.MakeHTTPRequest("GET", "/user/app/assets/app.3f9a1c2b.js", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "hashed")
+	if cc := w.Header().Get("Cache-Control"); cc != immutableCacheControl {
+		t.Errorf("Expected Cache-Control '%s', got '%s'", immutableCacheControl, cc)
+	}
+
+	w = helper.MakeHTTPRequest("GET", "/user/app/assets/logo.3f9a1c2b.png", "", nil)
+	if cc := w.Header().Get("Cache-Control"); cc != "" {
+		t.Errorf("Expected no Cache-Control outside the listed extensions, got '%s'", cc)
+	}
+
+	// Once the entry has expired, the hashed asset is still served without
+	// asking Gitea while a plain asset triggers the normal refresh
+	gp.cache.repos["user/app:main"].lastUpdate = time.Now().Add(-time.Hour)
+	api, archive := helper.UpstreamCalls()
+
+	w = helper.MakeHTTPRequest("GET", "/user/app/assets/app.3f9a1c2b.js", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "hashed")
+	if a, b := helper.UpstreamCalls(); a != api || b != archive {
+		t.Errorf("Expected no upstream calls for the hashed asset, got %d API and %d archive", a-api, b-archive)
+	}
+
+	w = helper.MakeHTTPRequest("GET", "/user/app/assets/app.js", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "plain")
+	if cc := w.Header().Get("Cache-Control"); cc != "" {
+		t.Errorf("Expected no Cache-Control for a plain asset, got '%s'", cc)
+	}
+	if _, b := helper.UpstreamCalls(); b == archive {
+		t.Error("Expected the plain asset to refresh the expired entry")
+	}
+}
+
+func TestIsImmutable(t *testing.T) {
+	gp := &GitteaPages{ImmutableAssets: &ImmutableAssets{}}
+	if err := gp.ImmutableAssets.provision(); err != nil {
+		t.Fatalf("provision failed: %v", err)
+	}
+
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		{"app.3f9a1c2b.js", true},
+		{"assets/chunk-0a1b2c3d4e.css", true},
+		{"app.js", false},
+		{"index-template.js", false},
+		{"app.3f9a.js", false},
+		{"3f9a1c2b/app.js", false},
+	}
+
+	for _, tt := range tests {
+		if got := gp.isImmutable(tt.path); got != tt.expected {
+			t.Errorf("isImmutable(%q): expected %v, got %v", tt.path, tt.expected, got)
+		}
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_ImmutableAssets(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		immutable_assets \.[0-9a-z]{10}\. {
+			extensions .js .css
+		}
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.ImmutableAssets == nil || gp.ImmutableAssets.Pattern != `\.[0-9a-z]{10}\.` || len(gp.ImmutableAssets.Extensions) != 2 {
+		t.Errorf("Unexpected immutable_assets %+v", gp.ImmutableAssets)
+	}
+}
@@ -0,0 +1,211 @@
+package giteapages
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// defaultIncludeExtensions are assembled when Includes lists no extensions
+var defaultIncludeExtensions = []string{".html", ".htm"}
+
+// defaultIncludeDepth bounds how deeply partials may nest
+const defaultIncludeDepth = 8
+
+// defaultIncludeSize bounds the bytes read to assemble one page, counting
+// each inclusion of a partial
+const defaultIncludeSize = 10 << 20
+
+// includeDirective matches {{include "path"}}
+var includeDirective = regexp.MustCompile(`\{\{\s*include\s+"([^"]+)"\s*\}\}`)
+
+// errInclude is returned when a page's includes cannot be assembled
+var errInclude = errors.New("include failed")
+
+// Includes assembles pages server-side from partials in the same
+// repository, referenced as {{include "header.html"}}. Paths are relative
+// to the including file, or to the repository root when they start with
+// a slash.
+type Includes struct {
+	// Extensions limits assembly to these file types
+	Extensions []string `json:"extensions,omitempty"`
+
+	// MaxDepth bounds how deeply partials may include further partials,
+	// 8 by default
+	MaxDepth int `json:"max_depth,omitempty"`
+
+	// MaxSize bounds the bytes read, counting every inclusion of a partial,
+	// to assemble one page, 10 MiB by default. A partial included many
+	// times at every level would otherwise multiply with each level.
+	MaxSize int64 `json:"max_size,omitempty"`
+}
+
+func (inc *Includes) maxSize() int64 {
+	if inc.MaxSize == 0 {
+		return defaultIncludeSize
+	}
+	return inc.MaxSize
+}
+
+// includeCache holds the assembled pages of a cache entry, keyed by path
+// within the repository. A refresh replaces the entry and with it this
+// cache.
+type includeCache struct {
+	mu    sync.Mutex
+	pages map[string]assembledPage
+}
+
+func newIncludeCache() *includeCache {
+	return &includeCache{pages: make(map[string]assembledPage)}
+}
+
+// assembly is the state of assembling one page: the bytes it may still
+// read and the files read so far, so partials included many times are
+// read and scanned for includes once
+type assembly struct {
+	budget  int64
+	sources map[string]includeSource
+}
+
+// includeSource is a file read during an assembly with the positions of
+// its include directives
+type includeSource struct {
+	data    []byte
+	matches [][]int
+}
+
+// assembledPage is a page with its includes resolved. sha is the git
+// blob SHA of data, which equals the page's own blob SHA when it
+// includes nothing.
+type assembledPage struct {
+	data []byte
+	sha  string
+}
+
+// shouldInclude reports whether rel is assembled from partials when served
+func (gp *GitteaPages) shouldInclude(rel string) bool {
+	if gp.Includes == nil {
+		return false
+	}
+	exts := gp.Includes.Extensions
+	if len(exts) == 0 {
+		exts = defaultIncludeExtensions
+	}
+	return extensionListed(exts, rel)
+}
+
+// assembled returns rel with its includes resolved, assembling it on
+// first use
+func (gp *GitteaPages) assembled(entry *cacheEntry, rel string) (assembledPage, error) {
+	if entry.included != nil {
+		entry.included.mu.Lock()
+		page, ok := entry.included.pages[rel]
+		entry.included.mu.Unlock()
+		if ok {
+			return page, nil
+		}
+	}
+
+	run := &assembly{budget: gp.Includes.maxSize(), sources: make(map[string]includeSource)}
+	data, err := gp.assemble(entry, rel, nil, run)
+	if err != nil {
+		return assembledPage{}, err
+	}
+	blob := newBlobHash(int64(len(data)))
+	blob.Write(data)
+	page := assembledPage{data: data, sha: hex.EncodeToString(blob.Sum(nil))}
+
+	if entry.included != nil {
+		entry.included.mu.Lock()
+		entry.included.pages[rel] = page
+		entry.included.mu.Unlock()
+	}
+	return page, nil
+}
+
+// assemble reads rel and replaces each include with the assembled
+// partial. stack holds the files including rel, outermost first.
+func (gp *GitteaPages) assemble(entry *cacheEntry, rel string, stack []string, run *assembly) ([]byte, error) {
+	for _, parent := range stack {
+		if parent == rel {
+			return nil, fmt.Errorf("%w: cycle %s", errInclude, strings.Join(append(stack, rel), " -> "))
+		}
+	}
+	maxDepth := gp.Includes.MaxDepth
+	if maxDepth == 0 {
+		maxDepth = defaultIncludeDepth
+	}
+	if len(stack) > maxDepth {
+		return nil, fmt.Errorf("%w: %s nested deeper than %d", errInclude, rel, maxDepth)
+	}
+
+	source, ok := run.sources[rel]
+	if !ok {
+		data, err := gp.readEntryFile(entry, rel)
+		if err != nil {
+			if len(stack) == 0 {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %s includes missing %q", errInclude, stack[len(stack)-1], rel)
+		}
+		source = includeSource{data: data, matches: includeDirective.FindAllSubmatchIndex(data, -1)}
+		run.sources[rel] = source
+	}
+	src, matches := source.data, source.matches
+	if run.budget -= int64(len(src)); run.budget < 0 {
+		page := rel
+		if len(stack) > 0 {
+			page = stack[0]
+		}
+		return nil, fmt.Errorf("%w: %s reads more than %d bytes of includes", errInclude, page, gp.Includes.maxSize())
+	}
+	if len(matches) == 0 {
+		return src, nil
+	}
+
+	var out bytes.Buffer
+	last := 0
+	for _, m := range matches {
+		out.Write(src[last:m[0]])
+		last = m[1]
+
+		target := string(src[m[2]:m[3]])
+		partial := path.Join(path.Dir(rel), target)
+		if strings.HasPrefix(target, "/") {
+			partial = path.Clean(target)
+		}
+		partial = strings.TrimPrefix(partial, "/")
+		if partial == ".." || strings.HasPrefix(partial, "../") || gp.isDenied(partial) {
+			return nil, fmt.Errorf("%w: %s may not include %q", errInclude, rel, target)
+		}
+
+		data, err := gp.assemble(entry, partial, append(stack, rel), run)
+		if err != nil {
+			return nil, err
+		}
+		out.Write(data)
+	}
+	out.Write(src[last:])
+	return out.Bytes(), nil
+}
+
+// readEntryFile returns the content of rel within entry
+func (gp *GitteaPages) readEntryFile(entry *cacheEntry, rel string) ([]byte, error) {
+	if data, ok := entry.memory[rel]; ok {
+		return data, nil
+	}
+	if entry.streamed[rel] {
+		return nil, fmt.Errorf("%s is not cached", rel)
+	}
+	fullPath := filepath.Join(entry.path, filepath.FromSlash(rel))
+	if !withinDir(entry.path, fullPath) {
+		return nil, fmt.Errorf("invalid file path")
+	}
+	return readCachedFile(fullPath, entry.compressed)
+}
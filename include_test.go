@@ -0,0 +1,136 @@
+package giteapages
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_Includes(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Files: map[string]string{
+				"page.html":            `{{include "partials/header.html"}}<main>Body</main>{{ include "/partials/footer.html" }}`,
+				"plain.html":           "<p>No partials</p>",
+				"partials/header.html": `<header>{{include "nav.html"}}</header>`,
+				"partials/nav.html":    "<nav>Home</nav>",
+				"partials/footer.html": "<footer>Footer</footer>",
+				"loop/a.html":          `A{{include "b.html"}}`,
+				"loop/b.html":          `B{{include "a.html"}}`,
+				"deep/1.html":          `1{{include "2.html"}}`,
+				"deep/2.html":          `2{{include "3.html"}}`,
+				"deep/3.html":          "3",
+				"escape.html":          `{{include "../../etc/passwd"}}`,
+				"broken.html":          `{{include "missing.html"}}`,
+			},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.Includes = &Includes{}
+	gp.ETags = true
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/user/site/page.html", http.StatusOK, "<header><nav>Home</nav></header><main>Body</main><footer>Footer</footer>"},
+		{"/user/site/plain.html", http.StatusOK, "<p>No partials</p>"},
+		{"/user/site/deep/1.html", http.StatusOK, "123"},
+		{"/user/site/loop/a.html", http.StatusInternalServerError, "failed to assemble page\n"},
+		{"/user/site/escape.html", http.StatusInternalServerError, "failed to assemble page\n"},
+		{"/user/site/broken.html", http.StatusInternalServerError, "failed to assemble page\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := helper.MakeHTTPRequest("GET", tt.path, "", nil)
+			if w.Code != tt.status || w.Body.String() != tt.body {
+				t.Errorf("Expected %d %q, got %d %q", tt.status, tt.body, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	// A page without includes keeps its blob ETag; an assembled one gets
+	// the blob SHA of what is sent
+	w := helper.MakeHTTPRequest("GET", "/user/site/plain.html", "", nil)
+	if got, want := w.Header().Get("ETag"), `"`+mockBlobSHA("<p>No partials</p>")+`"`; got != want {
+		t.Errorf("Expected ETag %s, got %s", want, got)
+	}
+	w = helper.MakeHTTPRequest("GET", "/user/site/page.html", "", nil)
+	if got, want := w.Header().Get("ETag"), `"`+mockBlobSHA(w.Body.String())+`"`; got != want {
+		t.Errorf("Expected ETag %s, got %s", want, got)
+	}
+
+	// Nesting beyond max_depth is refused
+	gp.Includes.MaxDepth = 1
+	gp.cache.repos["user/site:main"].included = newIncludeCache()
+	w = helper.MakeHTTPRequest("GET", "/user/site/deep/1.html", "", nil)
+	helper.AssertResponse(w, http.StatusInternalServerError, "failed to assemble page")
+}
+
+func TestServeHTTP_IncludesMaxSize(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	// Each level includes the next ten times, 10^8 reads when assembled
+	files := map[string]string{
+		"small.html": `{{include "l7.html"}}{{include "l7.html"}}`,
+		"bomb.html":  `{{include "l0.html"}}`,
+		"l8.html":    "x",
+	}
+	for level := 0; level < 8; level++ {
+		files[fmt.Sprintf("l%d.html", level)] = strings.Repeat(fmt.Sprintf(`{{include "l%d.html"}}`, level+1), 10)
+	}
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {Name: "site", FullName: "user/site", DefaultBranch: "main", Files: files},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.Includes = &Includes{MaxDepth: 10}
+
+	w := helper.MakeHTTPRequest("GET", "/user/site/small.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, strings.Repeat("x", 20))
+
+	// Refused once 10 MiB have been read instead of assembling 100 MB
+	w = helper.MakeHTTPRequest("GET", "/user/site/bomb.html", "", nil)
+	helper.AssertResponse(w, http.StatusInternalServerError, "failed to assemble page")
+
+	// A lower limit refuses pages the default allows
+	gp.Includes.MaxSize = 100
+	gp.cache.repos["user/site:main"].included = newIncludeCache()
+	w = helper.MakeHTTPRequest("GET", "/user/site/small.html", "", nil)
+	helper.AssertResponse(w, http.StatusInternalServerError, "failed to assemble page")
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_Includes(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		includes .html .shtml {
+			max_depth 3
+			max_size 1MB
+		}
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.Includes == nil || len(gp.Includes.Extensions) != 2 || gp.Includes.MaxDepth != 3 || gp.Includes.MaxSize != 1000000 {
+		t.Fatalf("Unexpected includes %+v", gp.Includes)
+	}
+	if !gp.shouldInclude("index.shtml") || gp.shouldInclude("app.js") {
+		t.Error("Expected only the listed extensions to be assembled")
+	}
+}
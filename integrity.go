@@ -0,0 +1,49 @@
+package giteapages
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+)
+
+// integrityHeader carries a served file's Subresource Integrity hash
+const integrityHeader = "X-Content-Integrity"
+
+// defaultIntegrityExtensions are hashed when Integrity lists no extensions
+var defaultIntegrityExtensions = []string{".js", ".mjs", ".css"}
+
+// Integrity sends the SHA-384 Subresource Integrity hash of assets in a
+// response header, so build tooling can write integrity attributes
+type Integrity struct {
+	// Extensions limits hashing to these file types
+	Extensions []string `json:"extensions,omitempty"`
+}
+
+// wantsIntegrity reports whether rel's SRI hash is recorded on extraction
+func (gp *GitteaPages) wantsIntegrity(rel string) bool {
+	if gp.Integrity == nil {
+		return false
+	}
+	exts := gp.Integrity.Extensions
+	if len(exts) == 0 {
+		exts = defaultIntegrityExtensions
+	}
+	return extensionListed(exts, rel)
+}
+
+// newIntegrityHash returns the hash behind an SRI value
+func newIntegrityHash() hash.Hash {
+	return sha512.New384()
+}
+
+// integrityValue formats a SHA-384 sum as an SRI value
+func integrityValue(sum []byte) string {
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum)
+}
+
+// integrityOf returns the SRI value of data
+func integrityOf(data []byte) string {
+	h := newIntegrityHash()
+	h.Write(data)
+	return integrityValue(h.Sum(nil))
+}
@@ -0,0 +1,90 @@
+package giteapages
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_Integrity(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/app": {
+			Name:          "app",
+			FullName:      "user/app",
+			DefaultBranch: "main",
+			Files: map[string]string{
+				"js/app.js":     "console.log('hi');\n",
+				"css/site.css":  "body { color: red; }",
+				"page.html":     "<h1>App</h1>",
+				"big/bundle.js": strings.Repeat("x", 2048),
+			},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.Integrity = &Integrity{}
+	gp.CacheMaxSize = 1024
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		// Expected values from: openssl dgst -sha384 -binary | base64
+		{"/user/app/js/app.js", "sha384-S/E9AczxJ45AfnRY5VLtw/IwaqqEyuZpg/47V2uDmGHkP2qdE/iuDb2BNHoj+IFy"},
+		{"/user/app/css/site.css", "sha384-BN8siYsJqlPeNsRFs2pYbTW0uiUBy9v6JVVKpHaS+KNqD0ZFotD5OFKMkI6/s6sb"},
+		{"/user/app/big/bundle.js", integrityOf([]byte(strings.Repeat("x", 2048)))},
+		{"/user/app/page.html", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			// Stable across requests, including HEAD
+			for _, method := range []string{"GET", "GET", "HEAD"} {
+				w := helper.MakeHTTPRequest(method, tt.path, "", nil)
+				if w.Code != http.StatusOK {
+					t.Fatalf("Expected status 200, got %d", w.Code)
+				}
+				if got := w.Header().Get(integrityHeader); got != tt.expected {
+					t.Errorf("%s: expected %s %q, got %q", method, integrityHeader, tt.expected, got)
+				}
+			}
+		})
+	}
+	if _, archive := helper.UpstreamCalls(); archive != 1 {
+		t.Errorf("Expected hashes recorded from one download, got %d", archive)
+	}
+
+	// Minified responses carry the hash of the bytes actually sent
+	gp.Minify = &Minify{}
+	helper.CreateCacheEntry("user/min", "main", map[string]string{
+		"app.js": "// comment\nvar  answer = 42;\n",
+	})
+	gp.cache.repos["user/min:main"].integrity = map[string]string{"app.js": integrityOf([]byte("// comment\nvar  answer = 42;\n"))}
+	w := helper.MakeHTTPRequest("GET", "/user/min/app.js", "", nil)
+	if got := w.Header().Get(integrityHeader); got != integrityOf(w.Body.Bytes()) {
+		t.Errorf("Expected the hash of the minified body, got %q", got)
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_Integrity(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		integrity .js .wasm
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.Integrity == nil || len(gp.Integrity.Extensions) != 2 {
+		t.Fatalf("Unexpected integrity %+v", gp.Integrity)
+	}
+	if !gp.wantsIntegrity("pkg/app.wasm") || gp.wantsIntegrity("style.css") {
+		t.Error("Expected only the listed extensions to be hashed")
+	}
+}
@@ -0,0 +1,230 @@
+package giteapages
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// errUpstreamBusy is returned for upstream requests that could not get a
+// concurrency slot within MaxConcurrentUpstream's queue timeout
+var errUpstreamBusy = errors.New("too many concurrent upstream requests")
+
+// limitedTransport caps the number of upstream requests in flight. A slot
+// is held from the moment the request is sent until its response body is
+// closed, so long archive downloads count for their whole duration.
+type limitedTransport struct {
+	base    http.RoundTripper
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.acquire(req); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		<-t.slots
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: func() { <-t.slots }}
+	return resp, nil
+}
+
+// acquire takes a slot, waiting up to the queue timeout for one to free up
+func (t *limitedTransport) acquire(req *http.Request) error {
+	select {
+	case t.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if t.timeout <= 0 {
+		return errUpstreamBusy
+	}
+
+	timer := time.NewTimer(t.timeout)
+	defer timer.Stop()
+	select {
+	case t.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return errUpstreamBusy
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
+// releasingBody gives back a concurrency slot when the body is closed
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+// Close implements io.Closer
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// errRepoRateLimited is returned when a repository has used up its quota
+// of upstream refreshes for the current interval
+var errRepoRateLimited = errors.New("repository upstream quota exceeded")
+
+// repoQuota limits how many upstream refreshes each repository may make
+// per interval, so one busy site cannot use up Gitea's capacity
+type repoQuota struct {
+	mu       sync.Mutex
+	limit    int
+	interval time.Duration
+	windows  map[string]*quotaWindow
+}
+
+// quotaWindow counts refreshes in the fixed window starting at start
+type quotaWindow struct {
+	start time.Time
+	count int
+}
+
+// allow records a refresh for key and reports whether it is within quota
+func (q *repoQuota) allow(key string, now time.Time) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	window, ok := q.windows[key]
+	if !ok || now.Sub(window.start) >= q.interval {
+		q.prune(now)
+		window = &quotaWindow{start: now}
+		q.windows[key] = window
+	}
+	if window.count >= q.limit {
+		return false
+	}
+	window.count++
+	return true
+}
+
+// prune drops expired windows; the caller must hold q.mu
+func (q *repoQuota) prune(now time.Time) {
+	for key, window := range q.windows {
+		if now.Sub(window.start) >= q.interval {
+			delete(q.windows, key)
+		}
+	}
+}
+
+// errUpstreamRateLimited is returned when Gitea answered 429, and for
+// every upstream refresh until its Retry-After has passed
+var errUpstreamRateLimited = errors.New("gitea rate limit exceeded")
+
+// defaultRateLimitBackoff is how long to back off after a 429 that did not
+// say when to retry
+const defaultRateLimitBackoff = time.Minute
+
+// upstreamBackoff remembers until when Gitea asked us to stay away
+type upstreamBackoff struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+// active reports whether upstream requests should still be held back
+func (b *upstreamBackoff) active(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return now.Before(b.until)
+}
+
+// extend backs off until now plus delay, never shortening a longer backoff
+func (b *upstreamBackoff) extend(now time.Time, delay time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if until := now.Add(delay); until.After(b.until) {
+		b.until = until
+	}
+}
+
+// rateLimited records a 429 from Gitea, backing off for the response's
+// Retry-After, and returns the error to report for it
+func (gp *GitteaPages) rateLimited(resp *http.Response) error {
+	now := time.Now()
+	delay := retryAfter(resp.Header.Get("Retry-After"), now)
+	gp.backoff.extend(now, delay)
+
+	gp.metrics.rateLimited.WithLabelValues(gp.GitteaURL).Inc()
+
+	gp.logger.Warn("gitea rate limit hit, backing off",
+		zap.String("url", resp.Request.URL.Redacted()),
+		zap.Duration("retry_after", delay))
+	return fmt.Errorf("%w: retry after %s", errUpstreamRateLimited, delay)
+}
+
+// retryAfter parses a Retry-After header given either in seconds or as an
+// HTTP date, falling back to defaultRateLimitBackoff
+func retryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return defaultRateLimitBackoff
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return defaultRateLimitBackoff
+}
+
+// errRepoTooLarge is returned for repositories whose reported size exceeds
+// MaxRepoSize
+var errRepoTooLarge = errors.New("repository exceeds max_repo_size")
+
+// sizeRefusals remembers repositories refused for their size, so they are
+// not looked up in Gitea again on every request
+type sizeRefusals struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+// refused reports whether key was refused and the decision still holds
+func (s *sizeRefusals) refused(key string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	until, ok := s.until[key]
+	if ok && !now.Before(until) {
+		delete(s.until, key)
+		return false
+	}
+	return ok
+}
+
+// refuse records that key is refused until the given time
+func (s *sizeRefusals) refuse(key string, until time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.until[key] = until
+}
+
+// checkRepoSize refuses repositories larger than MaxRepoSize. Gitea
+// reports sizes in KiB.
+func (gp *GitteaPages) checkRepoSize(repoKey string, info *GitteaRepo) error {
+	if gp.MaxRepoSize <= 0 || info.Size*1024 <= gp.MaxRepoSize {
+		return nil
+	}
+
+	gp.tooLarge.refuse(repoKey, time.Now().Add(gp.cacheTTLFor(repoKey)))
+	gp.logger.Warn("refusing repository over max_repo_size",
+		zap.String("repo", repoKey),
+		zap.Int64("size", info.Size*1024),
+		zap.Int64("max_repo_size", gp.MaxRepoSize))
+	return fmt.Errorf("%w: %s is %d KiB", errRepoTooLarge, repoKey, info.Size)
+}
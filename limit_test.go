@@ -0,0 +1,332 @@
+package giteapages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestMaxConcurrentUpstream_Burst(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	const sites = 8
+	repos := make(map[string]MockRepo)
+	for i := 0; i < sites; i++ {
+		name := fmt.Sprintf("site%d", i)
+		repos["user/"+name] = MockRepo{
+			Name:          name,
+			FullName:      "user/" + name,
+			DefaultBranch: "main",
+			Files:         map[string]string{"page.html": "<h1>" + name + "</h1>"},
+		}
+	}
+	helper.CreateMockGiteaServer(repos)
+	helper.SetUpstreamDelay(20 * time.Millisecond)
+
+	gp := &GitteaPages{
+		GitteaURL:             helper.server.URL,
+		CacheDir:              t.TempDir(),
+		MaxConcurrentUpstream: 2,
+		UpstreamQueueTimeout:  caddy.Duration(10 * time.Second),
+	}
+	if err := gp.Provision(caddy.Context{}); err != nil {
+		t.Fatalf("Failed to provision: %v", err)
+	}
+	helper.gp = gp
+
+	var wg sync.WaitGroup
+	codes := make([]int, sites)
+	for i := 0; i < sites; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := helper.MakeHTTPRequest("GET", fmt.Sprintf("/user/site%d/page.html", i), "", nil)
+			codes[i] = w.Code
+		}(i)
+	}
+	wg.Wait()
+
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("Request %d: expected status 200, got %d", i, code)
+		}
+	}
+	if peak := helper.PeakUpstreamConcurrency(); peak > 2 {
+		t.Errorf("Expected at most 2 concurrent upstream requests, got %d", peak)
+	}
+}
+
+func TestMaxConcurrentUpstream_FailFastServesStale(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.limiter = &limitedTransport{
+		base:  gp.transport,
+		slots: make(chan struct{}, 1),
+	}
+
+	helper.CreateCacheEntry("user/website", "main", map[string]string{
+		"about.html": "<h1>Stale About</h1>",
+	})
+	gp.cache.repos["user/website:main"].lastUpdate = time.Now().Add(-time.Hour)
+
+	// Occupy the only slot so every upstream call is refused
+	gp.limiter.slots <- struct{}{}
+
+	w := helper.MakeHTTPRequest("GET", "/user/website/about.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "Stale About")
+
+	w = helper.MakeHTTPRequest("GET", "/org/blog/feed.xml", "", nil)
+	helper.AssertResponse(w, http.StatusNotFound, "Not handled by gitea-pages")
+
+	if api, archive := helper.UpstreamCalls(); api != 0 || archive != 0 {
+		t.Errorf("Expected no upstream calls while saturated, got %d API and %d archive", api, archive)
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_MaxConcurrentUpstream(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		max_concurrent_upstream 4 2s
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.MaxConcurrentUpstream != 4 {
+		t.Errorf("Expected max_concurrent_upstream 4, got %d", gp.MaxConcurrentUpstream)
+	}
+	if time.Duration(gp.UpstreamQueueTimeout) != 2*time.Second {
+		t.Errorf("Expected queue timeout 2s, got %v", time.Duration(gp.UpstreamQueueTimeout))
+	}
+}
+
+func TestPerRepoRateLimit(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	gp := &GitteaPages{
+		GitteaURL:        helper.server.URL,
+		CacheDir:         t.TempDir(),
+		CacheTTL:         caddy.Duration(time.Nanosecond),
+		PerRepoRateLimit: 1,
+	}
+	if err := gp.Provision(caddy.Context{}); err != nil {
+		t.Fatalf("Failed to provision: %v", err)
+	}
+	helper.gp = gp
+
+	w := helper.MakeHTTPRequest("GET", "/user/website/about.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "About Us")
+	_, archives := helper.UpstreamCalls()
+
+	// Over quota: the expired copy is served without contacting Gitea
+	w = helper.MakeHTTPRequest("GET", "/user/website/about.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "About Us")
+	if _, after := helper.UpstreamCalls(); after != archives {
+		t.Errorf("Expected no archive download over quota, got %d", after-archives)
+	}
+
+	// Another repository still has its own budget
+	w = helper.MakeHTTPRequest("GET", "/org/blog/feed.xml", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "<rss>")
+	if _, after := helper.UpstreamCalls(); after != archives+1 {
+		t.Errorf("Expected one archive download for the other repository, got %d", after-archives)
+	}
+}
+
+func TestRepoQuota_WindowResets(t *testing.T) {
+	q := &repoQuota{limit: 2, interval: time.Minute, windows: make(map[string]*quotaWindow)}
+	start := time.Now()
+
+	for i, expected := range []bool{true, true, false} {
+		if got := q.allow("user/site", start); got != expected {
+			t.Errorf("Call %d: expected %v, got %v", i, expected, got)
+		}
+	}
+	if !q.allow("user/other", start) {
+		t.Error("Expected separate quota for another repository")
+	}
+	if !q.allow("user/site", start.Add(time.Minute)) {
+		t.Error("Expected quota to reset after the interval")
+	}
+}
+
+func TestUpstreamRateLimit_ServesStale(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+
+	helper.CreateCacheEntry("user/website", "main", map[string]string{
+		"about.html": "<h1>Stale About</h1>",
+	})
+	gp.cache.repos["user/website:main"].lastUpdate = time.Now().Add(-time.Hour)
+
+	helper.RateLimitNext(1, "60")
+	w := helper.MakeHTTPRequest("GET", "/user/website/about.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "Stale About")
+	api, archive := helper.UpstreamCalls()
+
+	// Within Retry-After Gitea is not asked again, and a repository
+	// without a cached copy still fails
+	w = helper.MakeHTTPRequest("GET", "/user/website/about.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "Stale About")
+	w = helper.MakeHTTPRequest("GET", "/org/blog/feed.xml", "", nil)
+	helper.AssertResponse(w, http.StatusNotFound, "Not handled by gitea-pages")
+	if a, b := helper.UpstreamCalls(); a != api || b != archive {
+		t.Errorf("Expected no upstream calls during backoff, got %d API and %d archive", a-api, b-archive)
+	}
+
+	// Once the backoff has passed the next request refreshes
+	gp.backoff.until = time.Now().Add(-time.Second)
+	w = helper.MakeHTTPRequest("GET", "/user/website/about.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "About Us")
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"", defaultRateLimitBackoff},
+		{"120", 2 * time.Minute},
+		{"0", 0},
+		{now.Add(30 * time.Second).Format(http.TimeFormat), 30 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"soon", defaultRateLimitBackoff},
+	}
+
+	for _, tt := range tests {
+		if got := retryAfter(tt.value, now); got != tt.expected {
+			t.Errorf("retryAfter(%q): expected %v, got %v", tt.value, tt.expected, got)
+		}
+	}
+}
+
+func TestMaxRepoSize(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"corp/monorepo": {
+			Name:          "monorepo",
+			FullName:      "corp/monorepo",
+			DefaultBranch: "main",
+			Size:          5 * 1024 * 1024, // 5 GiB
+			Files:         map[string]string{"index.html": "<h1>Everything</h1>"},
+		},
+		"corp/site": {
+			Name:          "site",
+			FullName:      "corp/site",
+			DefaultBranch: "main",
+			Size:          512,
+			Files:         map[string]string{"index.html": "<h1>Site</h1>"},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.MaxRepoSize = 100 << 20
+
+	w := helper.MakeHTTPRequest("GET", "/corp/monorepo/", "", nil)
+	helper.AssertResponse(w, http.StatusForbidden, "too large")
+	api, archives := helper.UpstreamCalls()
+	if archives != 0 {
+		t.Errorf("Expected no archive download for an oversized repository, got %d", archives)
+	}
+
+	// The refusal is remembered, so Gitea is not asked again
+	w = helper.MakeHTTPRequest("GET", "/corp/monorepo/", "", nil)
+	helper.AssertResponse(w, http.StatusForbidden, "too large")
+	w = helper.MakeHTTPRequest("HEAD", "/corp/monorepo/", "", nil)
+	helper.AssertResponse(w, http.StatusForbidden, "")
+	if after, _ := helper.UpstreamCalls(); after != api {
+		t.Errorf("Expected the refusal to be cached, got %d more API calls", after-api)
+	}
+
+	w = helper.MakeHTTPRequest("GET", "/corp/site/", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "<h1>Site</h1>")
+}
+
+func TestMaxRepoSize_CacheDownloadURL(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	repos := map[string]MockRepo{
+		"corp/site": {
+			Name:          "site",
+			FullName:      "corp/site",
+			DefaultBranch: "main",
+			Size:          512,
+			Files:         map[string]string{"index.html": "<h1>Site</h1>"},
+		},
+	}
+	helper.CreateMockGiteaServer(repos)
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL:        helper.server.URL,
+		CacheDownloadURL: true,
+	})
+	gp.MaxRepoSize = 100 << 20
+
+	if err := gp.updateRepoCache(context.Background(), "corp", "site", "main"); err != nil {
+		t.Fatalf("Initial refresh failed: %v", err)
+	}
+
+	// The repository grows past the limit; the remembered archive URL must
+	// not let the new archive in without the size check
+	repos["corp/site"] = MockRepo{
+		Name:          "site",
+		FullName:      "corp/site",
+		DefaultBranch: "main",
+		Size:          5 * 1024 * 1024,
+		Files:         map[string]string{"index.html": "<h1>Everything</h1>"},
+	}
+	if err := gp.updateRepoCache(context.Background(), "corp", "site", "main"); !errors.Is(err, errRepoTooLarge) {
+		t.Errorf("Expected errRepoTooLarge, got %v", err)
+	}
+	if _, archives := helper.UpstreamCalls(); archives != 2 {
+		t.Errorf("Expected only the revalidation of the changed archive, got %d archive calls", archives)
+	}
+}
+
+func TestUpdateRepoCache_DownloadURLReuses(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL:        helper.server.URL,
+		CacheDownloadURL: true,
+	})
+
+	for i := 0; i <= downloadURLReuses+1; i++ {
+		if err := gp.updateRepoCache(context.Background(), "user", "website", "main"); err != nil {
+			t.Fatalf("Refresh %d failed: %v", i+1, err)
+		}
+	}
+	// The first refresh and the one after downloadURLReuses revalidations
+	// look the repository up
+	if api, _ := helper.UpstreamCalls(); api != 2 {
+		t.Errorf("Expected 2 repository lookups, got %d", api)
+	}
+}
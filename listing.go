@@ -0,0 +1,151 @@
+package giteapages
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// listingEntry is one item of a directory listing
+type listingEntry struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Size int64  `json:"size"`
+}
+
+// readListing lists dir, which is relDir within the repository, leaving
+// out denied paths and the index dotfile. Directories sort first.
+func (gp *GitteaPages) readListing(dir, relDir string, compressed bool) ([]listingEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	listing := []listingEntry{}
+	for _, e := range entries {
+		if e.Name() == indexDotfile || gp.isDenied(path.Join(relDir, e.Name())) {
+			continue
+		}
+		if e.IsDir() {
+			listing = append(listing, listingEntry{Name: e.Name(), Type: "dir"})
+			continue
+		}
+		if !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		size := info.Size()
+		if compressed {
+			size = gzipSize(filepath.Join(dir, e.Name()), size)
+		}
+		listing = append(listing, listingEntry{Name: e.Name(), Type: "file", Size: size})
+	}
+
+	sort.SliceStable(listing, func(i, j int) bool {
+		if listing[i].Type != listing[j].Type {
+			return listing[i].Type == "dir"
+		}
+		return listing[i].Name < listing[j].Name
+	})
+	return listing, nil
+}
+
+// gzipSize returns the uncompressed size recorded in the trailer of the
+// gzip file at p, or fallback when it cannot be read
+func gzipSize(p string, fallback int64) int64 {
+	f, err := os.Open(p)
+	if err != nil {
+		return fallback
+	}
+	defer f.Close()
+
+	var trailer [4]byte
+	if _, err := f.Seek(-4, io.SeekEnd); err != nil {
+		return fallback
+	}
+	if _, err := io.ReadFull(f, trailer[:]); err != nil {
+		return fallback
+	}
+	return int64(binary.LittleEndian.Uint32(trailer[:]))
+}
+
+// serveListing writes the listing of dir as JSON, or as a simple HTML page
+// when asJSON is false
+func (gp *GitteaPages) serveListing(w http.ResponseWriter, dir, relDir string, compressed, asJSON bool) error {
+	listing, err := gp.readListing(dir, relDir, compressed)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(listing)
+	}
+
+	title := html.EscapeString("/" + relDir)
+	var page strings.Builder
+	page.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>Index of ")
+	page.WriteString(title)
+	page.WriteString("</title>\n</head>\n<body>\n<h1>Index of ")
+	page.WriteString(title)
+	page.WriteString("</h1>\n")
+	if gp.AutoindexReadme {
+		page.WriteString(listingReadme(dir, compressed))
+	}
+	page.WriteString("<ul>\n")
+	for _, e := range listing {
+		name := e.Name
+		if e.Type == "dir" {
+			name += "/"
+		}
+		href := (&url.URL{Path: "./" + name}).EscapedPath()
+		page.WriteString("<li><a href=\"" + html.EscapeString(href) + "\">" + html.EscapeString(name) + "</a></li>\n")
+	}
+	page.WriteString("</ul>\n</body>\n</html>\n")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err = io.WriteString(w, page.String())
+	return err
+}
+
+// listingReadme returns the README of dir as HTML to head its listing,
+// rendered when markdown and preformatted otherwise, or "" when dir has
+// none
+func listingReadme(dir string, compressed bool) string {
+	name := findReadme(dir)
+	if name == "" {
+		return ""
+	}
+	source, err := readCachedFile(filepath.Join(dir, name), compressed)
+	if err != nil {
+		return ""
+	}
+
+	var body bytes.Buffer
+	if isMarkdownFile(name) {
+		if err := markdownRenderer.Convert(source, &body); err != nil {
+			return ""
+		}
+	} else {
+		body.WriteString("<pre>" + html.EscapeString(string(source)) + "</pre>\n")
+	}
+	return "<article class=\"readme\">\n" + body.String() + "</article>\n"
+}
+
+// prefersJSON reports whether an Accept header ranks application/json
+// above HTML
+func prefersJSON(accept string) bool {
+	return parseAccept(accept).prefers([]string{"application/json"}, htmlMediaTypes)
+}
@@ -0,0 +1,183 @@
+package giteapages
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_JSONIndex(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+	})
+	gp.JSONIndex = true
+	gp.DenyFiles = []string{"*.secret"}
+	helper.CreateCacheEntry("user/data", "main", map[string]string{
+		"index.html":               "<h1>Data</h1>",
+		"datasets/b.json":          `{"b":2}`,
+		"datasets/a.csv":           "x,y\n1,2\n",
+		"datasets/raw/1.json":      "[]",
+		"datasets/token.secret":    "hunter2",
+		"datasets/" + indexDotfile: "a.csv",
+	})
+
+	t.Run("json listing", func(t *testing.T) {
+		w := helper.MakeHTTPRequest("GET", "/user/data/datasets/", "", map[string]string{
+			"Accept": "application/json",
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Expected Content-Type 'application/json', got '%s'", ct)
+		}
+
+		var listing []listingEntry
+		if err := json.Unmarshal(w.Body.Bytes(), &listing); err != nil {
+			t.Fatalf("Failed to decode listing: %v", err)
+		}
+		expected := []listingEntry{
+			{Name: "raw", Type: "dir"},
+			{Name: "a.csv", Type: "file", Size: 8},
+			{Name: "b.json", Type: "file", Size: 7},
+		}
+		if len(listing) != len(expected) {
+			t.Fatalf("Expected %v, got %v", expected, listing)
+		}
+		for i := range expected {
+			if listing[i] != expected[i] {
+				t.Errorf("Entry %d: expected %+v, got %+v", i, expected[i], listing[i])
+			}
+		}
+	})
+
+	t.Run("json listing even with an index", func(t *testing.T) {
+		w := helper.MakeHTTPRequest("GET", "/user/data/", "", map[string]string{
+			"Accept": "application/json",
+		})
+		helper.AssertResponse(w, http.StatusOK, `"name":"datasets"`)
+	})
+
+	t.Run("browser gets the index document", func(t *testing.T) {
+		w := helper.MakeHTTPRequest("GET", "/user/data/datasets/", "", map[string]string{
+			"Accept": "text/html,application/xhtml+xml,*/*;q=0.8",
+		})
+		helper.AssertResponse(w, http.StatusOK, "x,y")
+	})
+
+	t.Run("browser without index and autoindex off", func(t *testing.T) {
+		w := helper.MakeHTTPRequest("GET", "/user/data/datasets/raw/", "", map[string]string{
+			"Accept": "text/html",
+		})
+		helper.AssertResponse(w, http.StatusNotFound, "")
+	})
+
+	t.Run("browser without index and autoindex on", func(t *testing.T) {
+		gp.Autoindex = true
+		defer func() { gp.Autoindex = false }()
+
+		w := helper.MakeHTTPRequest("GET", "/user/data/datasets/raw/", "", map[string]string{
+			"Accept": "text/html",
+		})
+		helper.AssertResponse(w, http.StatusOK, `<a href="./1.json">1.json</a>`)
+	})
+}
+
+func TestServeHTTP_AutoindexReadme(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+	})
+	gp.Autoindex = true
+	gp.AutoindexReadme = true
+	helper.CreateCacheEntry("user/files", "main", map[string]string{
+		"docs/README.md":  "# Docs\n\nGuides for <b>users</b>.",
+		"docs/guide.pdf":  "PDF",
+		"notes/README":    "plain <notes>",
+		"notes/todo.txt":  "todo",
+		"images/logo.png": "PNG",
+	})
+
+	tests := []struct {
+		name     string
+		path     string
+		readme   string
+		listItem string
+	}{
+		{"markdown readme", "/user/files/docs/", "<h1>Docs</h1>", `<a href="./guide.pdf">guide.pdf</a>`},
+		{"plain readme", "/user/files/notes/", "<pre>plain &lt;notes&gt;</pre>", `<a href="./todo.txt">todo.txt</a>`},
+		{"no readme", "/user/files/images/", "", `<a href="./logo.png">logo.png</a>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := helper.MakeHTTPRequest("GET", tt.path, "", nil)
+			helper.AssertResponse(w, http.StatusOK, tt.listItem)
+
+			body := w.Body.String()
+			readme := strings.Index(body, `<article class="readme">`)
+			list := strings.Index(body, "<ul>")
+			if tt.readme == "" {
+				if readme != -1 {
+					t.Errorf("Expected no README section, got %s", body)
+				}
+				return
+			}
+			if readme == -1 || readme > list {
+				t.Fatalf("Expected the README above the listing, got %s", body)
+			}
+			if !strings.Contains(body[readme:list], tt.readme) {
+				t.Errorf("Expected README section to contain %q, got %s", tt.readme, body)
+			}
+		})
+	}
+
+	// Raw HTML in the README is not passed through
+	w := helper.MakeHTTPRequest("GET", "/user/files/docs/", "", nil)
+	if strings.Contains(w.Body.String(), "<b>users</b>") {
+		t.Errorf("Expected raw HTML in the README to be omitted, got %s", w.Body.String())
+	}
+}
+
+func TestPrefersJSON(t *testing.T) {
+	tests := []struct {
+		accept   string
+		expected bool
+	}{
+		{"", false},
+		{"application/json", true},
+		{"application/json, text/html;q=0.5", true},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", false},
+		{"text/html;q=0.9, application/json;q=0.5", false},
+		{"application/json;q=0", false},
+	}
+
+	for _, tt := range tests {
+		if got := prefersJSON(tt.accept); got != tt.expected {
+			t.Errorf("prefersJSON(%q): expected %v, got %v", tt.accept, tt.expected, got)
+		}
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_AutoindexReadme(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		autoindex
+		autoindex_readme
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if !gp.Autoindex || !gp.AutoindexReadme {
+		t.Errorf("Expected autoindex with README, got %v and %v", gp.Autoindex, gp.AutoindexReadme)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !unix || aix
+
+package giteapages
+
+import (
+	"errors"
+	"os"
+)
+
+// fileLockingSupported reports whether tryLockFile can lock files here;
+// Validate rejects shared_cache where it cannot
+const fileLockingSupported = false
+
+// tryLockFile is not implemented on this platform
+func tryLockFile(f *os.File) (bool, error) {
+	return false, errors.New("cache entry locking not supported on this platform")
+}
+
+// unlockFile is not implemented on this platform
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,27 @@
+//go:build unix && !aix
+
+package giteapages
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileLockingSupported reports whether tryLockFile can lock files here
+const fileLockingSupported = true
+
+// tryLockFile takes a non-blocking exclusive advisory lock on f, reporting
+// false when another process, or another open of the file, holds it
+func tryLockFile(f *os.File) (bool, error) {
+	err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// unlockFile releases the lock taken by tryLockFile
+func unlockFile(f *os.File) error {
+	return unix.Flock(int(f.Fd()), unix.LOCK_UN)
+}
@@ -0,0 +1,85 @@
+package giteapages
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lruCache is a map whose entries each carry a cost, dropping the least
+// recently used ones when a put takes the total cost past its budget
+type lruCache[K comparable, V any] struct {
+	mu    sync.Mutex
+	order *list.List // front is the most recently used
+	items map[K]*list.Element
+	used  int64
+}
+
+// lruItem is one entry of an lruCache
+type lruItem[K comparable, V any] struct {
+	key   K
+	value V
+	cost  int64
+}
+
+func newLRUCache[K comparable, V any]() *lruCache[K, V] {
+	return &lruCache[K, V]{order: list.New(), items: make(map[K]*list.Element)}
+}
+
+// get returns the value for key, marking it recently used
+func (c *lruCache[K, V]) get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*lruItem[K, V]).value, true
+}
+
+// put sets key to value, then drops the least recently used entries
+// until the total cost is at most budget. A value costing more than the
+// whole budget is not kept.
+func (c *lruCache[K, V]) put(key K, value V, cost, budget int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+	if cost > budget {
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruItem[K, V]{key: key, value: value, cost: cost})
+	c.used += cost
+	for c.used > budget && c.order.Len() > 0 {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// removeFunc drops every entry drop reports true for
+func (c *lruCache[K, V]) removeFunc(drop func(K, V) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		item := el.Value.(*lruItem[K, V])
+		if drop(item.key, item.value) {
+			c.removeElement(el)
+		}
+		el = next
+	}
+}
+
+// len returns the number of entries
+func (c *lruCache[K, V]) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *lruCache[K, V]) removeElement(el *list.Element) {
+	item := c.order.Remove(el).(*lruItem[K, V])
+	delete(c.items, item.key)
+	c.used -= item.cost
+}
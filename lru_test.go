@@ -0,0 +1,45 @@
+package giteapages
+
+import "testing"
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache[string, int]()
+	c.put("a", 1, 4, 10)
+	c.put("b", 2, 4, 10)
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("Expected a cached")
+	}
+
+	// b is now the least recently used, so it makes room for c
+	c.put("c", 3, 4, 10)
+	if _, ok := c.get("b"); ok {
+		t.Error("Expected b evicted")
+	}
+	if v, ok := c.get("a"); !ok || v != 1 {
+		t.Errorf("Expected a kept, got %v %v", v, ok)
+	}
+
+	// Replacing a value replaces its cost
+	c.put("a", 10, 2, 10)
+	if c.len() != 2 || c.used != 6 {
+		t.Errorf("Expected 2 entries costing 6, got %d costing %d", c.len(), c.used)
+	}
+
+	// A value costing more than the budget is not kept
+	c.put("huge", 0, 11, 10)
+	if _, ok := c.get("huge"); ok {
+		t.Error("Expected an oversized value dropped")
+	}
+	if c.len() != 2 {
+		t.Errorf("Expected an oversized value to evict nothing, got %d entries", c.len())
+	}
+
+	c.put("d", 4, 1, 10)
+	c.removeFunc(func(_ string, v int) bool { return v < 5 })
+	if c.len() != 1 {
+		t.Errorf("Expected 1 entry left, got %d", c.len())
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Error("Expected a kept by removeFunc")
+	}
+}
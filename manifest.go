@@ -0,0 +1,115 @@
+package giteapages
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// manifestFile is the checksums file verify_manifest checks files against
+const manifestFile = "SHA256SUMS"
+
+// errChecksumMismatch is returned by serveFile for files refused by
+// verify_manifest
+var errChecksumMismatch = errors.New("file failed checksum verification")
+
+// parseManifest reads sha256sum output: a hex digest, one or two spaces
+// or " *" for binary mode, then the path. Malformed lines are skipped.
+func parseManifest(data []byte) map[string]string {
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		digest, name, ok := strings.Cut(line, " ")
+		if !ok || len(digest) != sha256.Size*2 {
+			continue
+		}
+		if _, err := hex.DecodeString(digest); err != nil {
+			continue
+		}
+		name = strings.TrimLeft(name, " ")
+		name = strings.TrimPrefix(name, "*")
+		name = strings.TrimPrefix(name, "./")
+		if name != "" {
+			sums[name] = strings.ToLower(digest)
+		}
+	}
+	return sums
+}
+
+// manifestRejections compares sums, the SHA-256 of every extracted file,
+// against the SHA256SUMS file in dir and returns the files that must not
+// be served: mismatches, and unlisted files when ManifestUnlisted is
+// "refuse". Without a manifest nothing is rejected.
+//
+// Streamed files are fetched from Gitea again when served, so they only
+// match what was checked here when fetched at the archive's commit;
+// without one, listed streamed files are refused too.
+func (gp *GitteaPages) manifestRejections(dir string, sums map[string]string, streamed map[string]bool, commit string) map[string]bool {
+	data, err := readCachedFile(filepath.Join(dir, manifestFile), gp.CompressCache)
+	if err != nil {
+		return nil
+	}
+	manifest := parseManifest(data)
+
+	rejected := make(map[string]bool)
+	var mismatched, unpinned []string
+	for name, sum := range sums {
+		if name == manifestFile {
+			continue
+		}
+		expected, listed := manifest[name]
+		switch {
+		case listed && expected != sum:
+			rejected[name] = true
+			mismatched = append(mismatched, name)
+		case !listed && gp.ManifestUnlisted == "refuse":
+			rejected[name] = true
+		case listed && streamed[name] && commit == "":
+			rejected[name] = true
+			unpinned = append(unpinned, name)
+		}
+	}
+
+	if len(mismatched) > 0 {
+		gp.logger.Warn("files do not match SHA256SUMS and will not be served",
+			zap.String("path", dir),
+			zap.Strings("files", mismatched))
+	}
+	if len(unpinned) > 0 {
+		gp.logger.Warn("streamed files cannot be verified without the archive's commit and will not be served",
+			zap.String("path", dir),
+			zap.Strings("files", unpinned))
+	}
+	return rejected
+}
+
+// diskChecksums hashes every file cached in dir, for entries restored
+// from disk rather than extracted in this process
+func (gp *GitteaPages) diskChecksums(dir string) (map[string]string, error) {
+	sums := make(map[string]string)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		data, err := readCachedFile(p, gp.CompressCache)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		sums[filepath.ToSlash(rel)] = hex.EncodeToString(sum[:])
+		return nil
+	})
+	return sums, err
+}
@@ -0,0 +1,207 @@
+package giteapages
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestServeHTTP_VerifyManifest(t *testing.T) {
+	tests := []struct {
+		unlisted       string
+		unlistedStatus int
+	}{
+		{"", http.StatusOK},
+		{"refuse", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run("unlisted="+tt.unlisted, func(t *testing.T) {
+			helper := NewTestHelper(t)
+			defer helper.Cleanup()
+
+			manifest := fmt.Sprintf("%s  page.html\n%s *app.js\n",
+				sha256Hex("<h1>Signed</h1>"),
+				sha256Hex("console.log('original')"))
+			helper.CreateMockGiteaServer(map[string]MockRepo{
+				"user/site": {
+					Name:          "site",
+					FullName:      "user/site",
+					DefaultBranch: "main",
+					Files: map[string]string{
+						"SHA256SUMS": manifest,
+						"page.html":  "<h1>Signed</h1>",
+						"app.js":     "console.log('tampered')",
+						"extra.txt":  "not in the manifest",
+					},
+				},
+			})
+			gp := helper.SetupGiteaPages(GitteaPagesConfig{
+				GitteaURL: helper.server.URL,
+			})
+			gp.VerifyManifest = true
+			gp.ManifestUnlisted = tt.unlisted
+
+			w := helper.MakeHTTPRequest("GET", "/user/site/page.html", "", nil)
+			helper.AssertResponse(w, http.StatusOK, "Signed")
+
+			w = helper.MakeHTTPRequest("GET", "/user/site/app.js", "", nil)
+			helper.AssertResponse(w, http.StatusForbidden, "checksum")
+
+			w = helper.MakeHTTPRequest("GET", "/user/site/extra.txt", "", nil)
+			if w.Code != tt.unlistedStatus {
+				t.Errorf("Expected status %d for an unlisted file, got %d", tt.unlistedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_VerifyManifestArchive(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	manifest := fmt.Sprintf("%s  page.html\n%s  app.js\n",
+		sha256Hex("<h1>Signed</h1>"),
+		sha256Hex("console.log('original')"))
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Files: map[string]string{
+				"SHA256SUMS": manifest,
+				"page.html":  "<h1>Signed</h1>",
+				"app.js":     "console.log('tampered')",
+			},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.VerifyManifest = true
+	gp.AllowArchive = true
+
+	w := helper.MakeHTTPRequest("GET", "/user/site/archive.zip", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("Failed to read zip: %v", err)
+	}
+	names := make(map[string]bool)
+	for _, f := range zr.File {
+		names[f.Name] = true
+	}
+	if !names["page.html"] || names["app.js"] {
+		t.Errorf("Expected the archive to leave out the rejected file, got %v", names)
+	}
+}
+
+func TestServeHTTP_VerifyManifestStreamed(t *testing.T) {
+	large := strings.Repeat("L", 4096)
+	tests := []struct {
+		name   string
+		commit string
+		status int
+	}{
+		{"pinned to the commit", "3f9a1c2b7d8e4f6a0b1c2d3e4f5a6b7c8d9e0f1a", http.StatusOK},
+		{"branch only", "", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helper := NewTestHelper(t)
+			defer helper.Cleanup()
+
+			helper.CreateMockGiteaServer(map[string]MockRepo{
+				"user/site": {
+					Name:          "site",
+					FullName:      "user/site",
+					DefaultBranch: "main",
+					Commit:        tt.commit,
+					Files: map[string]string{
+						"SHA256SUMS": sha256Hex(large) + "  large.bin\n",
+						"large.bin":  large,
+					},
+				},
+			})
+			gp := helper.SetupGiteaPages(GitteaPagesConfig{
+				GitteaURL: helper.server.URL,
+			})
+			gp.VerifyManifest = true
+			gp.CacheMaxSize = 1024
+
+			w := helper.MakeHTTPRequest("GET", "/user/site/large.bin", "", nil)
+			if w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_VerifyManifestWithoutManifest(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Files:         map[string]string{"page.html": "<h1>Unsigned</h1>"},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.VerifyManifest = true
+	gp.ManifestUnlisted = "refuse"
+
+	w := helper.MakeHTTPRequest("GET", "/user/site/page.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "Unsigned")
+}
+
+func TestParseManifest(t *testing.T) {
+	digest := sha256Hex("x")
+	data := []byte(digest + "  page.html\n" +
+		digest + " *./img/logo.png\n" +
+		"not a checksum line\n" +
+		"abc  short.txt\n" +
+		"\n")
+
+	sums := parseManifest(data)
+	if len(sums) != 2 {
+		t.Fatalf("Expected 2 entries, got %d: %v", len(sums), sums)
+	}
+	if sums["page.html"] != digest || sums["img/logo.png"] != digest {
+		t.Errorf("Unexpected entries %v", sums)
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_VerifyManifest(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		verify_manifest refuse
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if !gp.VerifyManifest || gp.ManifestUnlisted != "refuse" {
+		t.Errorf("Unexpected verify_manifest %v/%q", gp.VerifyManifest, gp.ManifestUnlisted)
+	}
+}
@@ -0,0 +1,70 @@
+package giteapages
+
+import (
+	"bytes"
+	"html"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// markdownRenderer converts repository markdown to HTML
+var markdownRenderer = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// isMarkdownFile reports whether name has a markdown extension
+func isMarkdownFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".md", ".markdown":
+		return true
+	}
+	return false
+}
+
+// serveMarkdown serves a markdown file either rendered as an HTML page or
+// as raw source, depending on what the client's Accept header prefers
+func (gp *GitteaPages) serveMarkdown(w http.ResponseWriter, r *http.Request, fullPath string, compressed bool) error {
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return err
+	}
+	source, err := readCachedFile(fullPath, compressed)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Add("Vary", "Accept")
+
+	if prefersRawMarkdown(r.Header.Get("Accept")) {
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		http.ServeContent(w, r, info.Name(), info.ModTime(), bytes.NewReader(source))
+		return nil
+	}
+
+	var body bytes.Buffer
+	if err := markdownRenderer.Convert(source, &body); err != nil {
+		return err
+	}
+
+	title := strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))
+	var page bytes.Buffer
+	page.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>")
+	page.WriteString(html.EscapeString(title))
+	page.WriteString("</title>\n</head>\n<body>\n")
+	page.Write(body.Bytes())
+	page.WriteString("</body>\n</html>\n")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), bytes.NewReader(page.Bytes()))
+	return nil
+}
+
+// prefersRawMarkdown reports whether an Accept header ranks markdown or
+// plain text above HTML. Browsers and clients without preferences get the
+// rendered page.
+func prefersRawMarkdown(accept string) bool {
+	return parseAccept(accept).prefers([]string{"text/markdown", "text/x-markdown", "text/plain"}, htmlMediaTypes)
+}
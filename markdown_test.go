@@ -0,0 +1,70 @@
+package giteapages
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPrefersRawMarkdown(t *testing.T) {
+	tests := []struct {
+		accept   string
+		expected bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", false},
+		{"text/markdown", true},
+		{"text/plain", true},
+		{"text/html;q=0.5, text/markdown", true},
+		{"text/markdown;q=0.4, text/html", false},
+		{"text/plain;q=0", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			if got := prefersRawMarkdown(tt.accept); got != tt.expected {
+				t.Errorf("Expected %v for Accept '%s', got %v", tt.expected, tt.accept, got)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_MarkdownNegotiation(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+	})
+	gp.RenderMarkdown = true
+	helper.CreateCacheEntry("user/docs", "main", map[string]string{
+		"guide.md": "# Getting Started\n\nSome *emphasis*.\n",
+	})
+
+	t.Run("browser gets rendered html", func(t *testing.T) {
+		w := helper.MakeHTTPRequest("GET", "/user/docs/guide.md", "", map[string]string{
+			"Accept": "text/html,application/xhtml+xml,*/*;q=0.8",
+		})
+		helper.AssertResponse(w, http.StatusOK, "<h1>Getting Started</h1>")
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+			t.Errorf("Expected HTML content type, got '%s'", ct)
+		}
+		if vary := w.Header().Get("Vary"); vary != "Accept" {
+			t.Errorf("Expected Vary 'Accept', got '%s'", vary)
+		}
+	})
+
+	t.Run("tooling gets raw markdown", func(t *testing.T) {
+		w := helper.MakeHTTPRequest("GET", "/user/docs/guide.md", "", map[string]string{
+			"Accept": "text/markdown",
+		})
+		helper.AssertResponse(w, http.StatusOK, "# Getting Started")
+		if strings.Contains(w.Body.String(), "<h1>") {
+			t.Errorf("Expected raw markdown, got: %s", w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/markdown") {
+			t.Errorf("Expected markdown content type, got '%s'", ct)
+		}
+	})
+}
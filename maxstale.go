@@ -0,0 +1,43 @@
+package giteapages
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// staleCopy reports whether cacheKey has a cached copy to fall back on
+// while it cannot be refreshed, and whether that copy may be served: with
+// MaxStale set, only one that expired at most MaxStale ago may be
+func (gp *GitteaPages) staleCopy(repoKey, cacheKey string, now time.Time) (exists, usable bool) {
+	gp.cache.mu.RLock()
+	entry, exists := gp.cache.repos[cacheKey]
+	gp.cache.mu.RUnlock()
+	if !exists {
+		return false, false
+	}
+	if gp.MaxStale <= 0 {
+		return true, true
+	}
+	expired := entry.lastUpdate.Add(gp.cacheTTLFor(repoKey))
+	return true, now.Sub(expired) <= time.Duration(gp.MaxStale)
+}
+
+// staleServable reports whether cacheKey has a copy that may be served stale
+func (gp *GitteaPages) staleServable(repoKey, cacheKey string) bool {
+	_, usable := gp.staleCopy(repoKey, cacheKey, time.Now())
+	return usable
+}
+
+// usableStaleCopy is staleServable's answer for whether a copy may be served,
+// logging a copy that exists but is past MaxStale
+func (gp *GitteaPages) usableStaleCopy(repoKey, branch, cacheKey string) bool {
+	exists, usable := gp.staleCopy(repoKey, cacheKey, time.Now())
+	if exists && !usable {
+		gp.logger.Warn("cached copy expired more than max_stale ago, not serving it stale",
+			zap.String("repo", repoKey),
+			zap.String("branch", branch),
+			zap.Duration("max_stale", time.Duration(gp.MaxStale)))
+	}
+	return usable
+}
@@ -0,0 +1,88 @@
+package giteapages
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_MaxStale(t *testing.T) {
+	tests := []struct {
+		name     string
+		age      time.Duration // since the last refresh; the TTL is 15m
+		maxStale time.Duration
+		status   int
+		body     string
+	}{
+		{"within max_stale", 45 * time.Minute, time.Hour, http.StatusOK, "Stale About"},
+		{"past max_stale", 2 * time.Hour, time.Hour, http.StatusNotFound, "Not handled by gitea-pages"},
+		{"unbounded", 48 * time.Hour, 0, http.StatusOK, "Stale About"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helper := NewTestHelper(t)
+			defer helper.Cleanup()
+
+			helper.CreateMockGiteaServer(GenerateTestRepos())
+			gp := helper.SetupGiteaPages(GitteaPagesConfig{
+				GitteaURL: helper.server.URL,
+			})
+			gp.MaxStale = caddy.Duration(tt.maxStale)
+
+			helper.CreateCacheEntry("user/website", "main", map[string]string{
+				"about.html": "<h1>Stale About</h1>",
+			})
+			gp.cache.repos["user/website:main"].lastUpdate = time.Now().Add(-tt.age)
+
+			// Gitea is rate limiting, so the entry cannot be refreshed
+			helper.RateLimitNext(1, "60")
+			w := helper.MakeHTTPRequest("GET", "/user/website/about.html", "", nil)
+			helper.AssertResponse(w, tt.status, tt.body)
+		})
+	}
+}
+
+func TestServeHTTP_MaxStaleRevalidate(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.RevalidateCooldown = caddy.Duration(time.Minute)
+	gp.MaxStale = caddy.Duration(time.Hour)
+
+	helper.CreateCacheEntry("user/website", "main", map[string]string{
+		"about.html": "<h1>Stale About</h1>",
+	})
+	gp.cache.repos["user/website:main"].lastUpdate = time.Now().Add(-2 * time.Hour)
+
+	// Too old to be served while revalidating, so the request waits for
+	// the refresh
+	w := helper.MakeHTTPRequest("GET", "/user/website/about.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "About Us")
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_MaxStale(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		max_stale 6h
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if time.Duration(gp.MaxStale) != 6*time.Hour {
+		t.Errorf("Expected max_stale 6h, got %v", time.Duration(gp.MaxStale))
+	}
+
+	gp = GitteaPages{GitteaURL: "https://git.example.com", MaxStale: caddy.Duration(-time.Second)}
+	if err := gp.Validate(); err == nil {
+		t.Error("Expected a negative max_stale to fail validation")
+	}
+}
@@ -0,0 +1,118 @@
+package giteapages
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// maxMetadataEntries caps how many repositories the metadata cache
+// remembers, as any owner/repo can be asked for
+const maxMetadataEntries = 10000
+
+// repoMetadata is the document served by the metadata endpoint
+type repoMetadata struct {
+	Owner         string   `json:"owner"`
+	Repository    string   `json:"repository"`
+	Description   string   `json:"description"`
+	Topics        []string `json:"topics"`
+	DefaultBranch string   `json:"default_branch"`
+	UpdatedAt     string   `json:"updated_at,omitempty"`
+}
+
+// metadataCache keeps repository metadata fetched from Gitea for
+// MetadataTTL, for up to maxMetadataEntries repositories
+type metadataCache struct {
+	entries *lruCache[string, metadataCacheEntry]
+}
+
+func newMetadataCache() *metadataCache {
+	return &metadataCache{entries: newLRUCache[string, metadataCacheEntry]()}
+}
+
+// prune drops the metadata older than ttl
+func (c *metadataCache) prune(ttl time.Duration, now time.Time) {
+	c.entries.removeFunc(func(_ string, e metadataCacheEntry) bool {
+		return now.Sub(e.fetched) >= ttl
+	})
+}
+
+// metadataCacheEntry is cached metadata and when it was fetched
+type metadataCacheEntry struct {
+	fetched  time.Time
+	metadata repoMetadata
+}
+
+// serveMetadata describes a repository for hub UIs building site cards.
+// Requests take the form {metadata_path}/{owner}/{repo}; clients may cache
+// the answer for MetadataTTL.
+func (gp *GitteaPages) serveMetadata(w http.ResponseWriter, r *http.Request) error {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, gp.MetadataPath), "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		http.Error(w, "expected /{owner}/{repo}", http.StatusBadRequest)
+		return nil
+	}
+	owner, repo := parts[0], parts[1]
+
+	w.Header().Set("Content-Type", "application/json")
+
+	metadata, err := gp.repoMetadata(r, owner, repo)
+	if err != nil {
+		// The cause may name Gitea's address, so it is only logged
+		status, msg := http.StatusBadGateway, "failed to fetch repository metadata"
+		if errors.Is(err, errRepoNotFound) {
+			status, msg = http.StatusNotFound, "repository not found"
+		} else {
+			gp.logger.Warn("failed to fetch repository metadata",
+				zap.String("repo", owner+"/"+repo),
+				zap.Error(err))
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		return json.NewEncoder(w).Encode(map[string]string{"error": msg})
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(time.Duration(gp.MetadataTTL).Seconds())))
+	return json.NewEncoder(w).Encode(metadata)
+}
+
+// repoMetadata returns the repository's metadata, from the metadata cache
+// when it was fetched within MetadataTTL
+func (gp *GitteaPages) repoMetadata(r *http.Request, owner, repo string) (repoMetadata, error) {
+	key := owner + "/" + repo
+	token, reachable := gp.requestToken(r, owner, repo)
+	if !reachable {
+		return repoMetadata{}, errRepoNotFound
+	}
+
+	cached, ok := gp.metadata.entries.get(key)
+	if ok && time.Since(cached.fetched) < time.Duration(gp.MetadataTTL) {
+		return cached.metadata, nil
+	}
+
+	info, err := gp.getRepoInfo(withUpstreamToken(r.Context(), token), owner, repo)
+	if err != nil {
+		return repoMetadata{}, err
+	}
+	topics := info.Topics
+	if topics == nil {
+		topics = []string{}
+	}
+	metadata := repoMetadata{
+		Owner:         owner,
+		Repository:    repo,
+		Description:   info.Description,
+		Topics:        topics,
+		DefaultBranch: info.DefaultBranch,
+		UpdatedAt:     info.UpdatedAt,
+	}
+
+	gp.metadata.entries.put(key, metadataCacheEntry{fetched: time.Now(), metadata: metadata}, 1, maxMetadataEntries)
+
+	return metadata, nil
+}
@@ -0,0 +1,140 @@
+package giteapages
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeMetadata(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	repos := GenerateTestRepos()
+	website := repos["user/website"]
+	website.Description = "My personal site"
+	website.Topics = []string{"blog", "hugo"}
+	repos["user/website"] = website
+
+	helper.CreateMockGiteaServer(repos)
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.MetadataPath = "/_pages/meta"
+	gp.MetadataTTL = caddy.Duration(10 * time.Minute)
+
+	w := helper.MakeHTTPRequest("GET", "/_pages/meta/user/website", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected Content-Type 'application/json', got '%s'", ct)
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "public, max-age=600" {
+		t.Errorf("Expected Cache-Control 'public, max-age=600', got '%s'", cc)
+	}
+
+	var metadata repoMetadata
+	if err := json.Unmarshal(w.Body.Bytes(), &metadata); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if metadata.Owner != "user" || metadata.Repository != "website" {
+		t.Errorf("Expected user/website, got %s/%s", metadata.Owner, metadata.Repository)
+	}
+	if metadata.Description != "My personal site" || metadata.DefaultBranch != "main" || metadata.UpdatedAt == "" {
+		t.Errorf("Unexpected metadata %+v", metadata)
+	}
+	if len(metadata.Topics) != 2 || metadata.Topics[0] != "blog" || metadata.Topics[1] != "hugo" {
+		t.Errorf("Expected topics [blog hugo], got %v", metadata.Topics)
+	}
+
+	// Within the TTL the cached metadata is served without asking Gitea
+	apiCalls, _ := helper.UpstreamCalls()
+	w = helper.MakeHTTPRequest("GET", "/_pages/meta/user/website", "", nil)
+	helper.AssertResponse(w, http.StatusOK, `"topics":["blog","hugo"]`)
+	if calls, _ := helper.UpstreamCalls(); calls != apiCalls {
+		t.Errorf("Expected cached metadata, got %d more API calls", calls-apiCalls)
+	}
+
+	// A repository without topics lists none rather than null
+	w = helper.MakeHTTPRequest("GET", "/_pages/meta/org/blog", "", nil)
+	helper.AssertResponse(w, http.StatusOK, `"topics":[]`)
+
+	w = helper.MakeHTTPRequest("GET", "/_pages/meta/user/missing", "", nil)
+	helper.AssertResponse(w, http.StatusNotFound, `"repository not found"`)
+	if cc := w.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("Expected errors not to be cached, got Cache-Control '%s'", cc)
+	}
+
+	w = helper.MakeHTTPRequest("GET", "/_pages/meta/user", "", nil)
+	helper.AssertResponse(w, http.StatusBadRequest, "expected /{owner}/{repo}")
+
+	// Upstream failures are not described to the client
+	helper.server.Close()
+	w = helper.MakeHTTPRequest("GET", "/_pages/meta/org/other", "", nil)
+	helper.AssertResponse(w, http.StatusBadGateway, `{"error":"failed to fetch repository metadata"}`)
+	if strings.Contains(w.Body.String(), "127.0.0.1") {
+		t.Errorf("Expected Gitea's address kept out of the response, got %s", w.Body.String())
+	}
+}
+
+func TestServeMetadata_MappingToken(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"team-a/docs": {
+			Name:          "docs",
+			FullName:      "team-a/docs",
+			DefaultBranch: "main",
+			Description:   "Team A docs",
+			Token:         "a-token",
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL:   helper.server.URL,
+		GitteaToken: "global-token",
+		DomainMappings: []DomainMapping{
+			{Domain: "a.example.com", Owner: "team-a", Repository: "docs", Token: "a-token"},
+		},
+	})
+	gp.MetadataPath = "/_pages/meta"
+
+	w := helper.MakeHTTPRequest("GET", "/_pages/meta/team-a/docs", "a.example.com", nil)
+	helper.AssertResponse(w, http.StatusOK, "Team A docs")
+}
+
+func TestMetadataCache_Prune(t *testing.T) {
+	c := newMetadataCache()
+	now := time.Now()
+	c.entries.put("user/old", metadataCacheEntry{fetched: now.Add(-time.Hour)}, 1, maxMetadataEntries)
+	c.entries.put("user/new", metadataCacheEntry{fetched: now}, 1, maxMetadataEntries)
+
+	c.prune(10*time.Minute, now)
+	if _, ok := c.entries.get("user/old"); ok {
+		t.Error("Expected expired metadata dropped")
+	}
+	if _, ok := c.entries.get("user/new"); !ok {
+		t.Error("Expected fresh metadata kept")
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_Metadata(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		metadata_path /_pages/meta
+		metadata_ttl 1h
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.MetadataPath != "/_pages/meta" || time.Duration(gp.MetadataTTL) != time.Hour {
+		t.Errorf("Unexpected metadata settings %q / %v", gp.MetadataPath, gp.MetadataTTL)
+	}
+}
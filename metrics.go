@@ -0,0 +1,238 @@
+package giteapages
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// pagesMetrics are the collectors a handler records to. Every handler in a
+// config shares one set, registered on the config's metrics registry.
+type pagesMetrics struct {
+	cacheSize   *prometheus.GaugeVec
+	cacheFree   *prometheus.GaugeVec
+	evictions   *prometheus.CounterVec
+	rateLimited *prometheus.CounterVec
+}
+
+// newPagesMetrics registers the collectors on reg, reusing the ones another
+// handler already registered there
+func newPagesMetrics(reg prometheus.Registerer) (*pagesMetrics, error) {
+	const ns, sub = "caddy", "gitea_pages"
+
+	labels := []string{"cache_dir"}
+	var m pagesMetrics
+	var err error
+	if m.cacheSize, err = registerCollector(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "cache_size_bytes",
+		Help:      "Total size of the files in the repository cache directory.",
+	}, labels)); err != nil {
+		return nil, err
+	}
+	if m.cacheFree, err = registerCollector(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "cache_free_bytes",
+		Help:      "Free space on the filesystem holding the repository cache.",
+	}, labels)); err != nil {
+		return nil, err
+	}
+	if m.evictions, err = registerCollector(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "cache_evictions_total",
+		Help:      "Cache entries evicted to recover disk space.",
+	}, labels)); err != nil {
+		return nil, err
+	}
+	if m.rateLimited, err = registerCollector(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ns,
+		Subsystem: sub,
+		Name:      "upstream_rate_limited_total",
+		Help:      "Upstream requests Gitea answered with 429 Too Many Requests.",
+	}, []string{"gitea_url"})); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// registerCollector registers c on reg, returning the collector already
+// registered under the same name instead when there is one
+func registerCollector[C prometheus.Collector](reg prometheus.Registerer, c C) (C, error) {
+	err := reg.Register(c)
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		if existing, ok := registered.ExistingCollector.(C); ok {
+			return existing, nil
+		}
+	}
+	return c, err
+}
+
+// metricsRegistry returns the registry ctx carries on Caddy versions that
+// give each config its own, and the global registry otherwise
+func metricsRegistry(ctx caddy.Context) prometheus.Registerer {
+	if c, ok := any(&ctx).(interface{ GetMetricsRegistry() *prometheus.Registry }); ok {
+		if reg := c.GetMetricsRegistry(); reg != nil {
+			return reg
+		}
+	}
+	return prometheus.DefaultRegisterer
+}
+
+// monitorCache periodically checks disk usage until stop is closed
+func (gp *GitteaPages) monitorCache(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		gp.checkDiskUsage()
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// checkDiskUsage records cache size and free space and, when free space is
+// below MinFreeSpace, evicts the least recently refreshed entries until the
+// threshold is met again or nothing is left to evict
+func (gp *GitteaPages) checkDiskUsage() {
+	// With a cache namespace only this instance's share is measured
+	dir := gp.cache.cacheDir
+
+	size, err := cacheDirSize(dir)
+	if err != nil {
+		gp.logger.Warn("failed to measure cache size",
+			zap.String("cache_dir", dir),
+			zap.Error(err))
+	}
+	gp.metrics.cacheSize.WithLabelValues(dir).Set(float64(size))
+
+	free, err := gp.freeSpace(dir)
+	if err != nil {
+		gp.logger.Debug("failed to measure free space",
+			zap.String("cache_dir", dir),
+			zap.Error(err))
+		return
+	}
+	gp.metrics.cacheFree.WithLabelValues(dir).Set(float64(free))
+
+	if gp.MinFreeSpace <= 0 || free >= uint64(gp.MinFreeSpace) {
+		return
+	}
+
+	gp.logger.Warn("cache filesystem low on free space",
+		zap.String("cache_dir", dir),
+		zap.Int64("cache_size", size),
+		zap.Uint64("free", free),
+		zap.Int64("min_free_space", gp.MinFreeSpace))
+
+	for _, key := range gp.cacheKeysByAge() {
+		gp.evictCacheEntry(key)
+		gp.metrics.evictions.WithLabelValues(dir).Inc()
+
+		free, err = gp.freeSpace(dir)
+		if err != nil || free >= uint64(gp.MinFreeSpace) {
+			break
+		}
+	}
+}
+
+// freeSpace reports free bytes for dir, using the test hook when set
+func (gp *GitteaPages) freeSpace(dir string) (uint64, error) {
+	if gp.freeSpaceFunc != nil {
+		return gp.freeSpaceFunc(dir)
+	}
+	return diskFreeSpace(dir)
+}
+
+// cacheKeysByAge returns the keys of evictable cache entries ordered from
+// least to most recently updated. Pinned entries are left out.
+func (gp *GitteaPages) cacheKeysByAge() []string {
+	gp.cache.mu.RLock()
+	defer gp.cache.mu.RUnlock()
+
+	keys := make([]string, 0, len(gp.cache.repos))
+	for key := range gp.cache.repos {
+		if !gp.isPinned(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return gp.cache.repos[keys[i]].lastUpdate.Before(gp.cache.repos[keys[j]].lastUpdate)
+	})
+	return keys
+}
+
+// evictCacheEntry drops a cache entry and removes its files from disk
+func (gp *GitteaPages) evictCacheEntry(cacheKey string) {
+	gp.cache.mu.Lock()
+	entry, exists := gp.cache.repos[cacheKey]
+	delete(gp.cache.repos, cacheKey)
+	shared := exists && gp.entryShared(cacheKey, entry.path)
+	gp.cache.mu.Unlock()
+
+	if !exists {
+		return
+	}
+	gp.notifyEviction(cacheKey, evictionReasonEvicted)
+	if shared {
+		// Another key names the same download and still serves it
+		gp.logger.Info("evicted cache entry",
+			zap.String("cache_key", cacheKey))
+		return
+	}
+
+	owner, repo, branch := gp.parsePin(cacheKey)
+	base := gp.cache.entryPath(owner, repo, branch)
+	if gp.SharedCache {
+		// Other instances may be serving the files
+		gp.evictSharedEntry(base, entry.path)
+		gp.logger.Info("evicted cache entry",
+			zap.String("cache_key", cacheKey))
+		return
+	}
+
+	if err := os.RemoveAll(entry.path); err != nil {
+		gp.logger.Warn("failed to remove evicted cache entry",
+			zap.String("cache_key", cacheKey),
+			zap.Error(err))
+		return
+	}
+	if gp.snapshotEntries() {
+		gp.pruneSnapshots(base)
+	}
+
+	gp.logger.Info("evicted cache entry",
+		zap.String("cache_key", cacheKey))
+}
+
+// cacheDirSize sums the sizes of all regular files below dir
+func cacheDirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,105 @@
+package giteapages
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCacheDirSize(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+	})
+	helper.CreateCacheEntry("user/site", "main", map[string]string{
+		"index.html":    strings.Repeat("a", 1000),
+		"css/style.css": strings.Repeat("b", 234),
+	})
+	helper.CreateCacheEntry("user/blog", "main", map[string]string{
+		"index.html": strings.Repeat("c", 66),
+	})
+
+	size, err := cacheDirSize(gp.CacheDir)
+	if err != nil {
+		t.Fatalf("cacheDirSize failed: %v", err)
+	}
+	if size != 1300 {
+		t.Errorf("Expected cache size 1300, got %d", size)
+	}
+
+	gp.freeSpaceFunc = func(string) (uint64, error) { return 1 << 30, nil }
+	gp.checkDiskUsage()
+	if got := testutil.ToFloat64(gp.metrics.cacheSize.WithLabelValues(gp.CacheDir)); got != 1300 {
+		t.Errorf("Expected cache size gauge 1300, got %v", got)
+	}
+	if got := testutil.ToFloat64(gp.metrics.cacheFree.WithLabelValues(gp.CacheDir)); got != 1<<30 {
+		t.Errorf("Expected free space gauge %d, got %v", 1<<30, got)
+	}
+}
+
+func TestCheckDiskUsage_LowSpaceEvictsOldest(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+	})
+	helper.CreateCacheEntry("user/old", "main", map[string]string{
+		"index.html": strings.Repeat("o", 500),
+	})
+	helper.CreateCacheEntry("user/new", "main", map[string]string{
+		"index.html": strings.Repeat("n", 500),
+	})
+	gp.cache.repos["user/old:main"].lastUpdate = time.Now().Add(-time.Hour)
+
+	// Simulate a 1600 byte filesystem: free space is whatever the cache
+	// does not use, so evicting one entry is enough to clear the threshold
+	gp.MinFreeSpace = 1000
+	gp.freeSpaceFunc = func(dir string) (uint64, error) {
+		size, err := cacheDirSize(dir)
+		return uint64(1600 - size), err
+	}
+
+	gp.checkDiskUsage()
+
+	if _, exists := gp.cache.repos["user/old:main"]; exists {
+		t.Error("Expected oldest entry to be evicted")
+	}
+	if _, exists := gp.cache.repos["user/new:main"]; !exists {
+		t.Error("Expected newest entry to be kept")
+	}
+	if size, _ := cacheDirSize(gp.CacheDir); size != 500 {
+		t.Errorf("Expected 500 bytes left in cache, got %d", size)
+	}
+}
+
+func TestNewPagesMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	first, err := newPagesMetrics(reg)
+	if err != nil {
+		t.Fatalf("newPagesMetrics failed: %v", err)
+	}
+
+	// A second handler on the same registry shares the collectors
+	second, err := newPagesMetrics(reg)
+	if err != nil {
+		t.Fatalf("newPagesMetrics failed for a second handler: %v", err)
+	}
+	if first.cacheSize != second.cacheSize || first.rateLimited != second.rateLimited {
+		t.Error("Expected the second handler to reuse the registered collectors")
+	}
+
+	second.evictions.WithLabelValues("/cache").Inc()
+	if n, err := testutil.GatherAndCount(reg, "caddy_gitea_pages_cache_evictions_total"); err != nil || n != 1 {
+		t.Errorf("Expected the eviction counter on the given registry, got %d series: %v", n, err)
+	}
+	if metricsRegistry(caddy.Context{}) != prometheus.DefaultRegisterer {
+		t.Error("Expected the global registry for a context without one")
+	}
+}
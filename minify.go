@@ -0,0 +1,350 @@
+package giteapages
+
+import (
+	"bytes"
+	"errors"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Minifier shrinks the source of one file type. Minify must return src
+// unchanged, or an error, when it cannot minify it safely.
+type Minifier interface {
+	Minify(src []byte) ([]byte, error)
+}
+
+// MinifierFunc adapts a function to the Minifier interface
+type MinifierFunc func(src []byte) ([]byte, error)
+
+// Minify calls f(src)
+func (f MinifierFunc) Minify(src []byte) ([]byte, error) {
+	return f(src)
+}
+
+// errUnterminatedComment makes a minifier leave its input alone
+var errUnterminatedComment = errors.New("unterminated comment")
+
+// minifiers maps extensions to their Minifier. The built-in ones are
+// conservative; programs embedding the module can plug in a full
+// minifier with RegisterMinifier.
+var minifiers = struct {
+	sync.RWMutex
+	byExt map[string]Minifier
+}{byExt: map[string]Minifier{
+	".html": MinifierFunc(minifyHTML),
+	".htm":  MinifierFunc(minifyHTML),
+	".css":  MinifierFunc(minifyCSS),
+	".js":   MinifierFunc(minifyJS),
+}}
+
+// RegisterMinifier sets the Minifier used for files with extension ext,
+// e.g. ".js", replacing any built-in one
+func RegisterMinifier(ext string, m Minifier) {
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	minifiers.Lock()
+	defer minifiers.Unlock()
+	minifiers.byExt[strings.ToLower(ext)] = m
+}
+
+// minifierFor returns the Minifier for name's extension, if any
+func minifierFor(name string) Minifier {
+	minifiers.RLock()
+	defer minifiers.RUnlock()
+	return minifiers.byExt[strings.ToLower(path.Ext(name))]
+}
+
+// defaultMinifyExtensions are minified when Minify lists no extensions
+var defaultMinifyExtensions = []string{".html", ".htm", ".css", ".js"}
+
+// Minify configures minification of served HTML, CSS and JavaScript
+type Minify struct {
+	// Extensions limits minification to these file types
+	Extensions []string `json:"extensions,omitempty"`
+}
+
+// minifyCache holds the minified copies of a cache entry's files, keyed
+// by path within the repository. A refresh replaces the entry and with
+// it this cache.
+type minifyCache struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func newMinifyCache() *minifyCache {
+	return &minifyCache{files: make(map[string][]byte)}
+}
+
+// shouldMinify reports whether rel is minified when served
+func (gp *GitteaPages) shouldMinify(rel string) bool {
+	if gp.Minify == nil {
+		return false
+	}
+	exts := gp.Minify.Extensions
+	if len(exts) == 0 {
+		exts = defaultMinifyExtensions
+	}
+	return extensionListed(exts, rel) && minifierFor(rel) != nil
+}
+
+// minified returns the minified form of rel, whose source is src,
+// minifying it on first use. Files that look minified already, or that
+// the minifier refuses, are returned as they are.
+func (gp *GitteaPages) minified(entry *cacheEntry, rel string, src []byte) []byte {
+	if entry.minified != nil {
+		entry.minified.mu.Lock()
+		data, ok := entry.minified.files[rel]
+		entry.minified.mu.Unlock()
+		if ok {
+			return data
+		}
+	}
+
+	data := src
+	if !looksMinified(rel, src) {
+		if out, err := minifierFor(rel).Minify(src); err == nil && len(out) < len(src) {
+			data = out
+		}
+	}
+
+	if entry.minified != nil {
+		entry.minified.mu.Lock()
+		entry.minified.files[rel] = data
+		entry.minified.mu.Unlock()
+	}
+	return data
+}
+
+// looksMinified guesses whether src is minified already: a ".min." name,
+// or long lines on average
+func looksMinified(name string, src []byte) bool {
+	if strings.Contains(path.Base(name), ".min.") {
+		return true
+	}
+	if len(src) < 1024 {
+		return false
+	}
+	return len(src)/(bytes.Count(src, []byte("\n"))+1) > 500
+}
+
+// rawTextElements keep their contents as written by minifyHTML
+var rawTextElements = []string{"pre", "textarea", "script", "style"}
+
+// minifyHTML drops comments, other than conditional and "<!--!" ones, and
+// collapses whitespace between tags and in text to a single space or
+// newline. Tags and quoted attribute values keep their content, as do
+// pre, textarea, script and style elements, so rendering is unchanged.
+func minifyHTML(src []byte) ([]byte, error) {
+	var out bytes.Buffer
+	out.Grow(len(src))
+	lower := bytes.ToLower(src)
+
+	for i := 0; i < len(src); {
+		switch {
+		case bytes.HasPrefix(src[i:], []byte("<!--")):
+			end := bytes.Index(src[i+4:], []byte("-->"))
+			if end < 0 {
+				out.Write(src[i:])
+				return out.Bytes(), nil
+			}
+			end += i + 7
+			if bytes.HasPrefix(src[i+4:], []byte("[if")) || bytes.HasPrefix(src[i+4:], []byte("!")) ||
+				bytes.HasPrefix(src[i+4:], []byte("<![")) {
+				out.Write(src[i:end])
+			}
+			i = end
+
+		case src[i] == '<' && i+1 < len(src) && (isASCIILetter(src[i+1]) || src[i+1] == '/' || src[i+1] == '!'):
+			end := tagEnd(src, i)
+			out.Write(src[i:end])
+			name := tagName(lower[i:end])
+			i = end
+			for _, raw := range rawTextElements {
+				if name != raw {
+					continue
+				}
+				closing := bytes.Index(lower[i:], []byte("</"+raw))
+				if closing < 0 {
+					closing = len(src) - i
+				}
+				out.Write(src[i : i+closing])
+				i += closing
+				break
+			}
+
+		case isHTMLSpace(src[i]):
+			j, newline := i, false
+			for j < len(src) && isHTMLSpace(src[j]) {
+				newline = newline || src[j] == '\n'
+				j++
+			}
+			if newline {
+				out.WriteByte('\n')
+			} else {
+				out.WriteByte(' ')
+			}
+			i = j
+
+		default:
+			out.WriteByte(src[i])
+			i++
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// tagEnd returns the index just past the tag starting at src[start],
+// skipping ">" inside quoted attribute values
+func tagEnd(src []byte, start int) int {
+	var quote byte
+	for i := start + 1; i < len(src); i++ {
+		switch {
+		case quote != 0:
+			if src[i] == quote {
+				quote = 0
+			}
+		case src[i] == '"' || src[i] == '\'':
+			quote = src[i]
+		case src[i] == '>':
+			return i + 1
+		}
+	}
+	return len(src)
+}
+
+// tagName returns the lower-cased name of an opening tag, or "" for
+// closing tags and declarations
+func tagName(tag []byte) string {
+	if len(tag) < 2 || !isASCIILetter(tag[1]) {
+		return ""
+	}
+	end := 1
+	for end < len(tag) && (isASCIILetter(tag[end]) || tag[end] >= '0' && tag[end] <= '9') {
+		end++
+	}
+	return string(tag[1:end])
+}
+
+func isASCIILetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// minifyCSS drops comments, other than "/*!" ones, collapses whitespace
+// and removes it around braces, semicolons and commas, leaving strings
+// untouched
+func minifyCSS(src []byte) ([]byte, error) {
+	var out bytes.Buffer
+	out.Grow(len(src))
+
+	trimSpace := func() {
+		if b := out.Bytes(); len(b) > 0 && b[len(b)-1] == ' ' {
+			out.Truncate(len(b) - 1)
+		}
+	}
+	last := func() byte {
+		if b := out.Bytes(); len(b) > 0 {
+			return b[len(b)-1]
+		}
+		return 0
+	}
+
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(src) && src[j] != c {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			j = min(j+1, len(src))
+			out.Write(src[i:j])
+			i = j
+
+		case bytes.HasPrefix(src[i:], []byte("/*")):
+			end := bytes.Index(src[i+2:], []byte("*/"))
+			if end < 0 {
+				return nil, errUnterminatedComment
+			}
+			end += i + 4
+			if i+2 < len(src) && src[i+2] == '!' {
+				out.Write(src[i:end])
+			}
+			i = end
+
+		case isHTMLSpace(c):
+			for i < len(src) && isHTMLSpace(src[i]) {
+				i++
+			}
+			if l := last(); l != 0 && !strings.ContainsRune("{};,", rune(l)) && l != ' ' {
+				out.WriteByte(' ')
+			}
+
+		case strings.ContainsRune("{};,", rune(c)):
+			trimSpace()
+			if c == '}' && last() == ';' {
+				out.Truncate(out.Len() - 1)
+			}
+			out.WriteByte(c)
+			i++
+
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return bytes.TrimSpace(out.Bytes()), nil
+}
+
+// minifyJS only removes what cannot change a script's meaning without a
+// full parser: indentation, trailing spaces, blank lines and lines that
+// are entirely a comment ("/*!" license comments are kept). Newlines stay,
+// so automatic semicolon insertion is unaffected. Scripts with template
+// literals or line continuations, whose lines may be string content, are
+// left as they are.
+func minifyJS(src []byte) ([]byte, error) {
+	if bytes.ContainsRune(src, '`') || bytes.Contains(src, []byte("\\\n")) {
+		return src, nil
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(src))
+	inComment := false
+	for _, line := range bytes.Split(src, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if inComment {
+			if end := bytes.Index(line, []byte("*/")); end >= 0 {
+				inComment = false
+				line = bytes.TrimSpace(line[end+2:])
+			} else {
+				continue
+			}
+		}
+		if bytes.HasPrefix(line, []byte("//")) || len(line) == 0 {
+			continue
+		}
+		if bytes.HasPrefix(line, []byte("/*")) && !bytes.HasPrefix(line, []byte("/*!")) {
+			end := bytes.Index(line[2:], []byte("*/"))
+			if end < 0 {
+				inComment = true
+				continue
+			}
+			if rest := bytes.TrimSpace(line[end+4:]); len(rest) > 0 {
+				out.Write(rest)
+				out.WriteByte('\n')
+			}
+			continue
+		}
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,177 @@
+package giteapages
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+const verboseHTML = `<!DOCTYPE html>
+<html>
+    <head>
+        <!-- page metadata -->
+        <title>Verbose   page</title>
+        <!--[if IE]><p>Old browser</p><![endif]-->
+    </head>
+    <body>
+        <h1 title="two  spaces">Hello,
+            world</h1>
+
+
+        <pre>
+    keep   this
+        indentation
+</pre>
+        <script>
+            var  x = 1;   // spacing kept
+        </script>
+    </body>
+</html>
+`
+
+func TestServeHTTP_Minify(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Files: map[string]string{
+				"page.html":  verboseHTML,
+				"style.css":  "/* theme */\nbody {\n    color : red;\n    margin: 0 auto;\n}\n",
+				"app.min.js": "var a = 1;\n\n\n// comment\n",
+			},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL:     helper.server.URL,
+		CompressCache: true,
+	})
+	gp.Minify = &Minify{}
+
+	w := helper.MakeHTTPRequest("GET", "/user/site/page.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "")
+	body := w.Body.String()
+	if len(body) >= len(verboseHTML) {
+		t.Errorf("Expected minified HTML smaller than %d bytes, got %d", len(verboseHTML), len(body))
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Expected an HTML content type, got %q", ct)
+	}
+	for _, kept := range []string{
+		"<title>Verbose page</title>",
+		`title="two  spaces"`,
+		"<!--[if IE]><p>Old browser</p><![endif]-->",
+		"<pre>\n    keep   this\n        indentation\n</pre>",
+		"var  x = 1;   // spacing kept",
+	} {
+		if !strings.Contains(body, kept) {
+			t.Errorf("Expected minified HTML to contain %q, got:\n%s", kept, body)
+		}
+	}
+	if strings.Contains(body, "page metadata") {
+		t.Error("Expected the comment to be removed")
+	}
+
+	w = helper.MakeHTTPRequest("GET", "/user/site/style.css", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "body{color : red;margin: 0 auto}")
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/css") {
+		t.Errorf("Expected a CSS content type, got %q", ct)
+	}
+
+	// Already minified files are served as they are
+	w = helper.MakeHTTPRequest("GET", "/user/site/app.min.js", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "var a = 1;\n\n\n// comment\n")
+
+	// The minified copy is kept with the cache entry
+	entry := gp.cache.repos["user/site:main"]
+	entry.minified.mu.Lock()
+	_, cached := entry.minified.files["page.html"]
+	entry.minified.mu.Unlock()
+	if !cached {
+		t.Error("Expected the minified page to be cached")
+	}
+}
+
+func TestServeHTTP_MinifyExtensions(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Files: map[string]string{
+				"page.html": verboseHTML,
+				"style.css": "body {\n    color: red;\n}\n",
+			},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.Minify = &Minify{Extensions: []string{"css"}}
+
+	w := helper.MakeHTTPRequest("GET", "/user/site/page.html", "", nil)
+	if w.Body.String() != verboseHTML {
+		t.Error("Expected HTML to be served unchanged when only CSS is minified")
+	}
+	w = helper.MakeHTTPRequest("GET", "/user/site/style.css", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "body{color: red}")
+}
+
+func TestMinifyJS(t *testing.T) {
+	src := "/*! license */\n/* build\n   notes */\nfunction f() {\n    // inline\n    return 1\n}\n\nvar s = \"a  b\"   \n"
+	out, err := minifyJS([]byte(src))
+	if err != nil {
+		t.Fatalf("minifyJS failed: %v", err)
+	}
+	expected := "/*! license */\nfunction f() {\nreturn 1\n}\nvar s = \"a  b\"\n"
+	if string(out) != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+
+	// Template literals may hold meaningful whitespace
+	tmpl := "const t = `\n    indented\n`\n"
+	if out, _ := minifyJS([]byte(tmpl)); string(out) != tmpl {
+		t.Errorf("Expected a script with template literals unchanged, got %q", out)
+	}
+}
+
+func TestMinifyCSS(t *testing.T) {
+	tests := map[string]string{
+		"a :hover { color: red; }":               "a :hover{color: red}",
+		`p::before { content: "  /* x */  "; }`:  `p::before{content: "  /* x */  "}`,
+		"/*! keep */ h1 , h2 {\n  margin : 0\n}": "/*! keep */ h1,h2{margin : 0}",
+	}
+	for input, expected := range tests {
+		out, err := minifyCSS([]byte(input))
+		if err != nil || string(out) != expected {
+			t.Errorf("minifyCSS(%q): expected %q, got %q (%v)", input, expected, out, err)
+		}
+	}
+
+	if _, err := minifyCSS([]byte("a { /* open")); err == nil {
+		t.Error("Expected an unterminated comment to be refused")
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_Minify(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		minify .html .css
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.Minify == nil || len(gp.Minify.Extensions) != 2 {
+		t.Errorf("Unexpected minify %+v", gp.Minify)
+	}
+}
@@ -0,0 +1,66 @@
+package giteapages
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.uber.org/zap"
+)
+
+// MissingBranch answers requests for a branch that no longer exists in a
+// repository that still does, such as a domain mapped to a deleted
+// branch, with a response of its own instead of the file-not-found a
+// missing file gets. Page is a local HTML file served with Status (404 by
+// default); without it a short plain-text notice is served.
+type MissingBranch struct {
+	Page   string `json:"page,omitempty"`
+	Status int    `json:"status,omitempty"`
+
+	page []byte
+}
+
+func (mb *MissingBranch) provision() error {
+	if mb.Status == 0 {
+		mb.Status = http.StatusNotFound
+	}
+	if mb.Page != "" {
+		page, err := os.ReadFile(mb.Page)
+		if err != nil {
+			return fmt.Errorf("failed to read missing_branch page: %v", err)
+		}
+		mb.page = page
+	}
+	return nil
+}
+
+func (mb *MissingBranch) validate() error {
+	if mb.Status != 0 && (mb.Status < 400 || mb.Status > 599) {
+		return fmt.Errorf("missing_branch status must be 4xx or 5xx, got %d", mb.Status)
+	}
+	return nil
+}
+
+// serveMissingBranch writes the MissingBranch response for branch of
+// owner/repo, naming the branch in X-Pages-Missing-Branch. Like the
+// auto-mapping placeholder it is not cached, as the branch may be pushed
+// again at any time.
+func (gp *GitteaPages) serveMissingBranch(w http.ResponseWriter, owner, repo, branch string) {
+	gp.logger.Info("branch not found, serving missing branch response",
+		zap.String("repo", owner+"/"+repo),
+		zap.String("branch", branch))
+
+	mb := gp.MissingBranch
+	w.Header().Set("X-Pages-Missing-Branch", branch)
+	w.Header().Set("Cache-Control", "no-store")
+	if mb.page == nil {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(mb.Status)
+		fmt.Fprintf(w, "branch %q of %s/%s no longer exists\n", branch, owner, repo)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(mb.Status)
+	w.Write(mb.page)
+}
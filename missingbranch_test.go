@@ -0,0 +1,113 @@
+package giteapages
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_MissingBranch(t *testing.T) {
+	page := filepath.Join(t.TempDir(), "gone.html")
+	if err := os.WriteFile(page, []byte("<h1>This preview was retired</h1>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		missing  *MissingBranch
+		fallback bool
+		host     string
+		path     string
+		status   int
+		body     string
+		header   string
+	}{
+		{"mapped branch deleted", &MissingBranch{}, false, "preview.example.com", "/about.html",
+			http.StatusNotFound, `branch "feature-gone" of user/site no longer exists`, "feature-gone"},
+		{"configured page and status", &MissingBranch{Page: page, Status: http.StatusGone}, false, "preview.example.com", "/",
+			http.StatusGone, "This preview was retired", "feature-gone"},
+		{"missing file is not a missing branch", &MissingBranch{Status: http.StatusGone}, false, "www.example.com", "/nope.html",
+			http.StatusNotFound, "Not handled by gitea-pages", ""},
+		{"branch fallback takes precedence", &MissingBranch{Status: http.StatusGone}, true, "preview.example.com", "/about.html",
+			http.StatusOK, "Main About", ""},
+		{"disabled", nil, false, "preview.example.com", "/about.html",
+			http.StatusNotFound, "Not handled by gitea-pages", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helper := NewTestHelper(t)
+			defer helper.Cleanup()
+
+			helper.CreateMockGiteaServer(map[string]MockRepo{
+				"user/site": {
+					Name:           "site",
+					FullName:       "user/site",
+					DefaultBranch:  "main",
+					Files:          map[string]string{"about.html": "<h1>Main About</h1>"},
+					StrictBranches: true,
+				},
+			})
+			gp := helper.SetupGiteaPages(GitteaPagesConfig{
+				GitteaURL: helper.server.URL,
+				DomainMappings: []DomainMapping{
+					{Domain: "www.example.com", Owner: "user", Repository: "site", Branch: "main"},
+					{Domain: "preview.example.com", Owner: "user", Repository: "site", Branch: "feature-gone"},
+				},
+			})
+			gp.BranchFallback = tt.fallback
+			if tt.missing != nil {
+				gp.MissingBranch = tt.missing
+				if err := gp.MissingBranch.provision(); err != nil {
+					t.Fatalf("provision failed: %v", err)
+				}
+			}
+
+			w := helper.MakeHTTPRequest("GET", tt.path, tt.host, nil)
+			helper.AssertResponse(w, tt.status, tt.body)
+			if got := w.Header().Get("X-Pages-Missing-Branch"); got != tt.header {
+				t.Errorf("Expected X-Pages-Missing-Branch %q, got %q", tt.header, got)
+			}
+			if tt.header != "" && w.Header().Get("Cache-Control") != "no-store" {
+				t.Errorf("Expected Cache-Control no-store, got %q", w.Header().Get("Cache-Control"))
+			}
+		})
+	}
+}
+
+func TestMissingBranch_Validate(t *testing.T) {
+	for status, ok := range map[int]bool{0: true, 404: true, 410: true, 503: true, 200: false, 302: false, 600: false} {
+		if err := (&MissingBranch{Status: status}).validate(); (err == nil) != ok {
+			t.Errorf("status %d: expected ok=%v, got %v", status, ok, err)
+		}
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_MissingBranch(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		missing_branch {
+			page /srv/gone.html
+			status 410
+		}
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if mb := gp.MissingBranch; mb == nil || mb.Page != "/srv/gone.html" || mb.Status != http.StatusGone {
+		t.Errorf("Unexpected missing_branch config %+v", gp.MissingBranch)
+	}
+
+	d = caddyfile.NewTestDispenser(`gitea_pages {
+		missing_branch {
+			status gone
+		}
+	}`)
+	if err := new(GitteaPages).UnmarshalCaddyfile(d); err == nil {
+		t.Error("Expected a non-numeric status to fail")
+	}
+}
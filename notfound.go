@@ -0,0 +1,74 @@
+package giteapages
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// NotFoundFallback is the response served in place of a 404 for missing
+// files of one extension, e.g. an empty JSON object for ".json" so API
+// clients get something they can parse
+type NotFoundFallback struct {
+	// Status is the response status, 404 by default
+	Status int `json:"status,omitempty"`
+
+	// Body is served as is
+	Body string `json:"body,omitempty"`
+
+	// ContentType defaults to the extension's MIME type
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// validateNotFoundFallbacks checks the extensions and statuses of
+// NotFoundFallbacks
+func (gp *GitteaPages) validateNotFoundFallbacks() error {
+	for ext, fallback := range gp.NotFoundFallbacks {
+		if !strings.HasPrefix(ext, ".") || len(ext) < 2 || strings.Contains(ext, "/") {
+			return fmt.Errorf("not_found_fallback extension %q must look like \".json\"", ext)
+		}
+		if fallback == nil {
+			return fmt.Errorf("not_found_fallback %s: missing fallback", ext)
+		}
+		if fallback.Status != 0 && (fallback.Status < 200 || fallback.Status > 599) {
+			return fmt.Errorf("not_found_fallback %s: invalid status %d", ext, fallback.Status)
+		}
+	}
+	return nil
+}
+
+// serveNotFoundFallback answers for a missing filePath with the fallback
+// configured for its extension, reporting whether one was
+func (gp *GitteaPages) serveNotFoundFallback(w http.ResponseWriter, r *http.Request, filePath string) bool {
+	if len(gp.NotFoundFallbacks) == 0 {
+		return false
+	}
+	ext := strings.ToLower(path.Ext(filePath))
+	fallback, ok := gp.NotFoundFallbacks[ext]
+	if !ok || fallback == nil {
+		return false
+	}
+
+	contentType := fallback.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(ext)
+	}
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	// The file may appear with the next refresh
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Length", fmt.Sprint(len(fallback.Body)))
+
+	status := fallback.Status
+	if status == 0 {
+		status = http.StatusNotFound
+	}
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write([]byte(fallback.Body))
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package giteapages
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_NotFoundFallback(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+	})
+	gp.NotFoundFallbacks = map[string]*NotFoundFallback{
+		".json": {Status: http.StatusOK, Body: `{"items": []}`},
+		".xml":  {Body: "<empty/>", ContentType: "application/xml"},
+	}
+	helper.CreateCacheEntry("user/api", "main", map[string]string{
+		"data/users.json": `{"items": ["alice"]}`,
+		"page.html":       "<h1>Page</h1>",
+	})
+
+	tests := []struct {
+		name        string
+		path        string
+		status      int
+		body        string
+		contentType string
+	}{
+		{"existing json", "/user/api/data/users.json", http.StatusOK, "alice", "application/json"},
+		{"missing json", "/user/api/data/groups.json", http.StatusOK, `{"items": []}`, "application/json"},
+		{"extension case", "/user/api/data/GROUPS.JSON", http.StatusOK, `{"items": []}`, "application/json"},
+		{"default status", "/user/api/feed.xml", http.StatusNotFound, "<empty/>", "application/xml"},
+		{"missing html", "/user/api/missing.html", http.StatusNotFound, "Not handled by gitea-pages", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := helper.MakeHTTPRequest("GET", tt.path, "", nil)
+			helper.AssertResponse(w, tt.status, tt.body)
+			if ct := w.Header().Get("Content-Type"); tt.contentType != "" && ct != tt.contentType {
+				t.Errorf("Expected Content-Type %q, got %q", tt.contentType, ct)
+			}
+		})
+	}
+}
+
+func TestNotFoundFallback_Validate(t *testing.T) {
+	tests := map[string]map[string]*NotFoundFallback{
+		"no dot":     {"json": {}},
+		"bare dot":   {".": {}},
+		"bad status": {".json": {Status: 99}},
+		"nil":        {".json": nil},
+	}
+	for name, fallbacks := range tests {
+		t.Run(name, func(t *testing.T) {
+			gp := &GitteaPages{NotFoundFallbacks: fallbacks}
+			if err := gp.validateNotFoundFallbacks(); err == nil {
+				t.Error("Expected validation error")
+			}
+		})
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_NotFoundFallback(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		not_found_fallback .JSON 200 {
+			body ` + "`{}`" + `
+			content_type "application/json; charset=utf-8"
+		}
+		not_found_fallback .txt
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	fallback := gp.NotFoundFallbacks[".json"]
+	if fallback == nil || fallback.Status != http.StatusOK || fallback.Body != "{}" || fallback.ContentType != "application/json; charset=utf-8" {
+		t.Errorf("Unexpected .json fallback %+v", fallback)
+	}
+	if fallback := gp.NotFoundFallbacks[".txt"]; fallback == nil || fallback.Status != 0 || fallback.Body != "" {
+		t.Errorf("Unexpected .txt fallback %+v", fallback)
+	}
+	if err := gp.Validate(); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+}
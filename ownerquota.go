@@ -0,0 +1,97 @@
+package giteapages
+
+import (
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// ownerCacheQuota returns the byte quota for owner's cache entries: its
+// OwnerCacheQuotas entry, else PerOwnerCacheQuota. Zero means unbounded.
+func (gp *GitteaPages) ownerCacheQuota(owner string) int64 {
+	if quota, ok := gp.OwnerCacheQuotas[owner]; ok {
+		return quota
+	}
+	return gp.PerOwnerCacheQuota
+}
+
+// enforceOwnerQuota evicts owner's least recently refreshed entries until
+// their total size on disk fits the owner's quota. Only that owner's
+// entries are considered, so one tenant cannot push out another's sites.
+// The entry under keep, just refreshed, and pinned entries are never
+// evicted, even if they alone exceed the quota.
+func (gp *GitteaPages) enforceOwnerQuota(owner, keep string) {
+	quota := gp.ownerCacheQuota(owner)
+	if quota <= 0 {
+		return
+	}
+
+	type ownedEntry struct {
+		key  string
+		size int64
+	}
+	var total int64
+	var evictable []ownedEntry
+
+	prefix := owner + "/"
+	for _, key := range gp.ownerCacheKeysByAge(prefix) {
+		gp.cache.mu.RLock()
+		entry := gp.cache.repos[key]
+		gp.cache.mu.RUnlock()
+		if entry == nil {
+			continue
+		}
+		size, err := cacheDirSize(entry.path)
+		if err != nil {
+			gp.logger.Debug("failed to measure cache entry",
+				zap.String("cache_key", key),
+				zap.Error(err))
+			continue
+		}
+		total += size
+		if key != keep && !gp.isPinned(key) {
+			evictable = append(evictable, ownedEntry{key: key, size: size})
+		}
+	}
+	if total <= quota {
+		return
+	}
+
+	gp.logger.Info("owner over cache quota",
+		zap.String("owner", owner),
+		zap.Int64("cache_size", total),
+		zap.Int64("quota", quota))
+
+	for _, e := range evictable {
+		gp.evictCacheEntry(e.key)
+		gp.metrics.evictions.WithLabelValues(gp.cache.cacheDir).Inc()
+		total -= e.size
+		if total <= quota {
+			return
+		}
+	}
+
+	gp.logger.Warn("owner cache quota exceeded by entries that cannot be evicted",
+		zap.String("owner", owner),
+		zap.Int64("cache_size", total),
+		zap.Int64("quota", quota))
+}
+
+// ownerCacheKeysByAge returns the keys of cache entries starting with
+// prefix, ordered from least to most recently updated
+func (gp *GitteaPages) ownerCacheKeysByAge(prefix string) []string {
+	gp.cache.mu.RLock()
+	defer gp.cache.mu.RUnlock()
+
+	var keys []string
+	for key := range gp.cache.repos {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return gp.cache.repos[keys[i]].lastUpdate.Before(gp.cache.repos[keys[j]].lastUpdate)
+	})
+	return keys
+}
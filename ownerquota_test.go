@@ -0,0 +1,103 @@
+package giteapages
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_PerOwnerCacheQuota(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	page := strings.Repeat("x", 1000)
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"alice/one": {
+			Name:          "one",
+			FullName:      "alice/one",
+			DefaultBranch: "main",
+			Files:         map[string]string{"page.html": page},
+		},
+		"alice/two": {
+			Name:          "two",
+			FullName:      "alice/two",
+			DefaultBranch: "main",
+			Files:         map[string]string{"page.html": page},
+		},
+		"bob/site": {
+			Name:          "site",
+			FullName:      "bob/site",
+			DefaultBranch: "main",
+			Files:         map[string]string{"page.html": page},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.PerOwnerCacheQuota = 1500
+
+	for _, path := range []string{"/alice/one/page.html", "/bob/site/page.html", "/alice/two/page.html"} {
+		w := helper.MakeHTTPRequest("GET", path, "", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 for %s, got %d", path, w.Code)
+		}
+	}
+
+	gp.cache.mu.RLock()
+	_, one := gp.cache.repos["alice/one:main"]
+	_, two := gp.cache.repos["alice/two:main"]
+	_, bob := gp.cache.repos["bob/site:main"]
+	gp.cache.mu.RUnlock()
+
+	if one {
+		t.Error("Expected alice's older entry to be evicted")
+	}
+	if !two {
+		t.Error("Expected alice's just refreshed entry to be kept")
+	}
+	if !bob {
+		t.Error("Expected bob's entry to be left intact")
+	}
+	if _, err := os.Stat(gp.cache.entryPath("alice", "one", "main")); !os.IsNotExist(err) {
+		t.Errorf("Expected alice's evicted files to be removed, got %v", err)
+	}
+	if _, err := os.Stat(gp.cache.entryPath("bob", "site", "main")); err != nil {
+		t.Errorf("Expected bob's files to remain, got %v", err)
+	}
+}
+
+func TestOwnerCacheQuota(t *testing.T) {
+	gp := &GitteaPages{
+		PerOwnerCacheQuota: 100,
+		OwnerCacheQuotas:   map[string]int64{"big": 1000, "free": 0},
+	}
+
+	tests := map[string]int64{"big": 1000, "free": 0, "other": 100}
+	for owner, expected := range tests {
+		if got := gp.ownerCacheQuota(owner); got != expected {
+			t.Errorf("ownerCacheQuota(%q): expected %d, got %d", owner, expected, got)
+		}
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_PerOwnerCacheQuota(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		per_owner_cache_quota 500MB
+		per_owner_cache_quota bigcorp 2GB
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.PerOwnerCacheQuota != 500000000 {
+		t.Errorf("Expected default quota 500000000, got %d", gp.PerOwnerCacheQuota)
+	}
+	if gp.OwnerCacheQuotas["bigcorp"] != 2000000000 {
+		t.Errorf("Expected bigcorp quota 2000000000, got %d", gp.OwnerCacheQuotas["bigcorp"])
+	}
+}
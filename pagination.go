@@ -0,0 +1,71 @@
+package giteapages
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// defaultAPIPageSize is the page size requested from Gitea list endpoints,
+// Gitea's own default for MAX_RESPONSE_ITEMS
+const defaultAPIPageSize = 50
+
+// apiPageSize returns the page size to request from Gitea list endpoints
+func (gp *GitteaPages) apiPageSize() int {
+	if gp.APIPageSize > 0 {
+		return gp.APIPageSize
+	}
+	return defaultAPIPageSize
+}
+
+// nextPageURL follows the rel="next" link of a paginated Gitea response.
+// Only the link's query is used, applied to apiURL, because Gitea builds
+// links from its ROOT_URL, which may not be the address this module
+// reaches it at. linked reports whether the response had a Link header at
+// all; with one but no next link the listing is complete, without one the
+// caller falls back to comparing the page length with the page size.
+func nextPageURL(apiURL string, header http.Header) (next string, linked bool) {
+	links := header.Values("Link")
+	if len(links) == 0 {
+		return "", false
+	}
+
+	for _, link := range links {
+		for _, part := range strings.Split(link, ",") {
+			target, params, ok := strings.Cut(strings.TrimSpace(part), ";")
+			if !ok || !isNextRel(params) {
+				continue
+			}
+			target = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(target), "<"), ">")
+			u, err := url.Parse(target)
+			if err != nil {
+				continue
+			}
+			base, err := url.Parse(apiURL)
+			if err != nil {
+				return "", true
+			}
+			base.RawQuery = u.RawQuery
+			if next := base.String(); next != apiURL {
+				return next, true
+			}
+		}
+	}
+	return "", true
+}
+
+// isNextRel reports whether Link parameters include rel="next"
+func isNextRel(params string) bool {
+	for _, param := range strings.Split(params, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(name), "rel") {
+			continue
+		}
+		for _, rel := range strings.Fields(strings.Trim(value, `"`)) {
+			if strings.EqualFold(rel, "next") {
+				return true
+			}
+		}
+	}
+	return false
+}
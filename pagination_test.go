@@ -0,0 +1,136 @@
+package giteapages
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestFetchBranches_FollowsLinkPagination(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	branches := make(map[string]map[string]string)
+	for i := 0; i < 24; i++ {
+		branches[fmt.Sprintf("feature-%02d", i)] = map[string]string{"index.html": "x"}
+	}
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/big": {
+			Name:          "big",
+			FullName:      "user/big",
+			DefaultBranch: "main",
+			Files:         map[string]string{"index.html": "x"},
+			Branches:      branches,
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	// Gitea returns fewer items than requested, so a short page does not
+	// mean the listing is complete
+	helper.maxItems = 10
+
+	got, err := gp.fetchBranches(context.Background(), "user", "big")
+	if err != nil {
+		t.Fatalf("fetchBranches failed: %v", err)
+	}
+	if len(got) != 25 {
+		t.Fatalf("Expected 25 branches, got %d", len(got))
+	}
+	seen := make(map[string]bool)
+	for _, b := range got {
+		if seen[b.Name] {
+			t.Errorf("Branch %s listed twice", b.Name)
+		}
+		seen[b.Name] = true
+	}
+	if calls := helper.branchCalls.Load(); calls != 3 {
+		t.Errorf("Expected 3 page requests, got %d", calls)
+	}
+}
+
+func TestListOwnerRepos_FollowsLinkPagination(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	repos := make(map[string]MockRepo)
+	for i := 0; i < 12; i++ {
+		name := fmt.Sprintf("site-%02d", i)
+		repos["org/"+name] = MockRepo{
+			Name:          name,
+			FullName:      "org/" + name,
+			DefaultBranch: "main",
+			Files:         map[string]string{"index.html": "x"},
+		}
+	}
+	helper.CreateMockGiteaServer(repos)
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.APIPageSize = 5
+	helper.maxItems = 5
+
+	names, err := gp.listOwnerRepos(context.Background(), "org")
+	if err != nil {
+		t.Fatalf("listOwnerRepos failed: %v", err)
+	}
+	if len(names) != 12 || names[0] != "site-00" || names[11] != "site-11" {
+		t.Errorf("Expected all 12 repositories, got %v", names)
+	}
+}
+
+func TestNextPageURL(t *testing.T) {
+	apiURL := "http://gitea.internal:3000/api/v1/users/org/repos?page=1&limit=50"
+
+	tests := []struct {
+		name   string
+		link   string
+		next   string
+		linked bool
+	}{
+		{"no link header", "", "", false},
+		{
+			"next under another root url",
+			`<https://git.example.com/api/v1/users/org/repos?limit=50&page=2>; rel="next",<https://git.example.com/api/v1/users/org/repos?limit=50&page=4>; rel="last"`,
+			"http://gitea.internal:3000/api/v1/users/org/repos?limit=50&page=2",
+			true,
+		},
+		{
+			"last page",
+			`<https://git.example.com/api/v1/users/org/repos?limit=50&page=1>; rel="first"`,
+			"",
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.link != "" {
+				header.Set("Link", tt.link)
+			}
+			next, linked := nextPageURL(apiURL, header)
+			if next != tt.next || linked != tt.linked {
+				t.Errorf("Expected (%q, %v), got (%q, %v)", tt.next, tt.linked, next, linked)
+			}
+		})
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_APIPageSize(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		api_page_size 20
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.APIPageSize != 20 {
+		t.Errorf("Expected api_page_size 20, got %d", gp.APIPageSize)
+	}
+}
@@ -0,0 +1,124 @@
+package giteapages
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// defaultBotUserAgents match the crawlers and link unfurlers that do not
+// run JavaScript, or run it unreliably
+var defaultBotUserAgents = []string{
+	"googlebot", "bingbot", "yandex", "baiduspider", "duckduckbot",
+	"slurp", "facebookexternalhit", "twitterbot", "linkedinbot",
+	"slackbot", "discordbot", "applebot", "embedly",
+}
+
+// Prerender serves static snapshots of a single-page app to bots while
+// humans get the normal app shell. Snapshots live either next to the
+// regular file with Suffix before the extension, index.prerender.html
+// for index.html, or in the mirror directory Dir, where the snapshot of
+// /about is Dir/about, Dir/about.html or Dir/about/index.html.
+type Prerender struct {
+	// UserAgents are case-insensitive regular expressions matched
+	// against the User-Agent header; empty means the common crawlers
+	UserAgents []string `json:"user_agents,omitempty"`
+
+	// Suffix names the snapshot stored next to each file
+	Suffix string `json:"suffix,omitempty"`
+
+	// Dir is the repository directory mirroring the site with snapshots
+	Dir string `json:"dir,omitempty"`
+
+	bots []*regexp.Regexp
+}
+
+// provision validates the snapshot location and compiles the patterns
+func (p *Prerender) provision() error {
+	switch {
+	case (p.Suffix == "") == (p.Dir == ""):
+		return fmt.Errorf("prerender needs exactly one of suffix or dir")
+	case strings.ContainsAny(p.Suffix, "/."):
+		return fmt.Errorf("prerender suffix %q must not contain dots or slashes", p.Suffix)
+	case p.Dir != "" && (path.IsAbs(p.Dir) || strings.HasPrefix(path.Clean(p.Dir), "..")):
+		return fmt.Errorf("prerender dir %q must be inside the repository", p.Dir)
+	}
+
+	patterns := p.UserAgents
+	if len(patterns) == 0 {
+		patterns = defaultBotUserAgents
+	}
+	p.bots = p.bots[:0]
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return fmt.Errorf("invalid prerender user agent %q: %v", pattern, err)
+		}
+		p.bots = append(p.bots, re)
+	}
+	return nil
+}
+
+// isBot reports whether r comes from a client that gets snapshots
+func (p *Prerender) isBot(r *http.Request) bool {
+	ua := r.Header.Get("User-Agent")
+	if ua == "" {
+		return false
+	}
+	for _, re := range p.bots {
+		if re.MatchString(ua) {
+			return true
+		}
+	}
+	return false
+}
+
+// prerenderDirPath returns the snapshot in Prerender.Dir for the
+// repository path filePath, or "" when the request is not from a bot or
+// there is no snapshot. SPA routes usually have no file of their own, so
+// this is consulted before the regular file is looked up.
+func (gp *GitteaPages) prerenderDirPath(r *http.Request, entry *cacheEntry, filePath string) string {
+	p := gp.Prerender
+	if p == nil || p.Dir == "" || !p.isBot(r) {
+		return ""
+	}
+	root := filepath.Join(entry.path, filepath.FromSlash(p.Dir))
+	snapshot := filepath.Join(root, filePath)
+	if !withinDir(root, snapshot) {
+		return ""
+	}
+
+	info, err := os.Stat(snapshot)
+	switch {
+	case err == nil && info.Mode().IsRegular():
+		return snapshot
+	case err == nil && info.IsDir():
+		if gp.entryIndexFile(entry, snapshot) != "" {
+			return snapshot
+		}
+	case !strings.HasSuffix(filePath, "/") && path.Ext(filePath) == "":
+		if info, err := os.Stat(snapshot + ".html"); err == nil && info.Mode().IsRegular() {
+			return snapshot + ".html"
+		}
+	}
+	return ""
+}
+
+// prerenderSuffixPath returns the snapshot stored next to fullPath with
+// Prerender.Suffix, or fullPath when the request is not from a bot or
+// there is no snapshot
+func (gp *GitteaPages) prerenderSuffixPath(r *http.Request, fullPath string) string {
+	p := gp.Prerender
+	if p == nil || p.Suffix == "" || !p.isBot(r) {
+		return fullPath
+	}
+	snapshot := variantPath(fullPath, p.Suffix)
+	if info, err := os.Stat(snapshot); err == nil && info.Mode().IsRegular() {
+		return snapshot
+	}
+	return fullPath
+}
@@ -0,0 +1,152 @@
+package giteapages
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+const (
+	googlebotUA = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+	browserUA   = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0 Safari/537.36"
+)
+
+func TestServeHTTP_PrerenderSuffix(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/app": {
+			Name:          "app",
+			FullName:      "user/app",
+			DefaultBranch: "main",
+			Files: map[string]string{
+				"index.html":           "<div id=\"app\"></div>",
+				"index.prerender.html": "<h1>Rendered home</h1>",
+				"app.js":               "render()",
+			},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.Prerender = &Prerender{Suffix: "prerender"}
+	if err := gp.Prerender.provision(); err != nil {
+		t.Fatalf("provision failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		ua       string
+		expected string
+	}{
+		{"googlebot gets snapshot", "/user/app/", googlebotUA, "Rendered home"},
+		{"browser gets shell", "/user/app/", browserUA, "<div id=\"app\"></div>"},
+		{"no user agent", "/user/app/", "", "<div id=\"app\"></div>"},
+		{"bot without snapshot", "/user/app/app.js", googlebotUA, "render()"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := helper.MakeHTTPRequest("GET", tt.path, "", map[string]string{"User-Agent": tt.ua})
+			helper.AssertResponse(w, http.StatusOK, tt.expected)
+			if vary := w.Header().Values("Vary"); len(vary) == 0 || vary[0] != "User-Agent" {
+				t.Errorf("Expected Vary: User-Agent, got %v", vary)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_PrerenderDir(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/app": {
+			Name:          "app",
+			FullName:      "user/app",
+			DefaultBranch: "main",
+			Files: map[string]string{
+				"index.html":                 "<div id=\"app\"></div>",
+				"_prerender/index.html":      "<h1>Rendered home</h1>",
+				"_prerender/about.html":      "<h1>Rendered about</h1>",
+				"_prerender/blog/index.html": "<h1>Rendered blog</h1>",
+			},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.Prerender = &Prerender{Dir: "_prerender", UserAgents: []string{"googlebot", `^custom-crawler/`}}
+	if err := gp.Prerender.provision(); err != nil {
+		t.Fatalf("provision failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		ua       string
+		status   int
+		expected string
+	}{
+		{"googlebot gets snapshot", "/user/app/", googlebotUA, http.StatusOK, "Rendered home"},
+		{"browser gets shell", "/user/app/", browserUA, http.StatusOK, "<div id=\"app\"></div>"},
+		{"route snapshot", "/user/app/about", googlebotUA, http.StatusOK, "Rendered about"},
+		{"route directory snapshot", "/user/app/blog/", googlebotUA, http.StatusOK, "Rendered blog"},
+		{"custom pattern", "/user/app/about", "custom-crawler/1.0", http.StatusOK, "Rendered about"},
+		{"browser on route", "/user/app/about", browserUA, http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := helper.MakeHTTPRequest("GET", tt.path, "", map[string]string{"User-Agent": tt.ua})
+			helper.AssertResponse(w, tt.status, tt.expected)
+		})
+	}
+}
+
+func TestPrerender_Provision(t *testing.T) {
+	tests := []struct {
+		name    string
+		p       Prerender
+		wantErr bool
+	}{
+		{"suffix", Prerender{Suffix: "prerender"}, false},
+		{"dir", Prerender{Dir: "snapshots"}, false},
+		{"neither", Prerender{}, true},
+		{"both", Prerender{Suffix: "prerender", Dir: "snapshots"}, true},
+		{"dotted suffix", Prerender{Suffix: ".prerender"}, true},
+		{"escaping dir", Prerender{Dir: "../other"}, true},
+		{"absolute dir", Prerender{Dir: "/snapshots"}, true},
+		{"bad pattern", Prerender{Suffix: "prerender", UserAgents: []string{"("}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.p.provision()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("provision() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_Prerender(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		prerender {
+			user_agents googlebot bingbot
+			dir _prerender
+		}
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	p := gp.Prerender
+	if p == nil || p.Dir != "_prerender" || len(p.UserAgents) != 2 || p.UserAgents[1] != "bingbot" {
+		t.Errorf("Unexpected prerender %+v", p)
+	}
+}
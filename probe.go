@@ -0,0 +1,159 @@
+package giteapages
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Path kinds reported by the contents API probe
+const (
+	pathMissing = "missing"
+	pathFile    = "file"
+	pathDir     = "dir"
+)
+
+// maxPathProbes caps how many paths the probe cache remembers. Paths
+// come from clients, so the least recently used are dropped beyond it.
+const maxPathProbes = 10000
+
+// pathProbeCache remembers what probe_contents learned about repository
+// paths, keyed by "owner/repo@branch:path"
+type pathProbeCache struct {
+	entries *lruCache[string, pathProbe]
+}
+
+func newPathProbeCache() *pathProbeCache {
+	return &pathProbeCache{entries: newLRUCache[string, pathProbe]()}
+}
+
+// prune drops the probes older than their repository's cache TTL
+func (c *pathProbeCache) prune(gp *GitteaPages, now time.Time) {
+	c.entries.removeFunc(func(key string, probe pathProbe) bool {
+		repoKey, _, _ := strings.Cut(key, "@")
+		return now.Sub(probe.fetched) > gp.cacheTTLFor(repoKey)
+	})
+}
+
+// pathProbe is one probed path: its kind and, for directories, the index
+// document chosen from the listing ("" when there is none)
+type pathProbe struct {
+	kind    string
+	index   string
+	fetched time.Time
+}
+
+// probePath reports whether filePath at branch is a file, a directory or
+// missing, from the probe cache when it is younger than the repository's
+// cache TTL and otherwise through a single contents API call
+func (gp *GitteaPages) probePath(ctx context.Context, owner, repo, branch, filePath string) (pathProbe, error) {
+	repoKey := fmt.Sprintf("%s/%s", owner, repo)
+	key := fmt.Sprintf("%s@%s:%s", repoKey, branch, strings.Trim(filePath, "/"))
+	ttl := gp.cacheTTLFor(repoKey)
+
+	cached, ok := gp.probes.entries.get(key)
+	if ok && time.Since(cached.fetched) <= ttl {
+		return cached, nil
+	}
+
+	probe, err := gp.fetchPathKind(ctx, owner, repo, branch, filePath)
+	if err != nil {
+		return pathProbe{}, err
+	}
+
+	gp.probes.entries.put(key, probe, 1, maxPathProbes)
+	return probe, nil
+}
+
+// fetchPathKind asks the Gitea contents API about filePath. Gitea answers
+// a file with a single object carrying its type and a directory with the
+// array of its entries, from which the index document is picked the way
+// findIndexFile would, short of reading the index dotfile.
+func (gp *GitteaPages) fetchPathKind(ctx context.Context, owner, repo, branch, filePath string) (pathProbe, error) {
+	apiURL := fmt.Sprintf("%s/api/v1/repos/%s/%s/contents/%s?ref=%s",
+		strings.TrimRight(gp.GitteaURL, "/"), url.PathEscape(owner), url.PathEscape(repo),
+		escapePath(strings.Trim(filePath, "/")), url.QueryEscape(branch))
+
+	req, err := gp.newUpstreamRequest(ctx, apiURL)
+	if err != nil {
+		return pathProbe{}, err
+	}
+	client := &http.Client{Timeout: 30 * time.Second, Transport: gp.upstreamTransport()}
+	resp, err := client.Do(req)
+	if err != nil {
+		return pathProbe{}, fmt.Errorf("failed to probe path: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return pathProbe{kind: pathMissing, fetched: time.Now()}, nil
+	case http.StatusTooManyRequests:
+		return pathProbe{}, gp.rateLimited(resp)
+	default:
+		return pathProbe{}, fmt.Errorf("failed to probe path: status %d", resp.StatusCode)
+	}
+
+	var raw json.RawMessage
+	if err := gp.decodeJSON(resp, &raw); err != nil {
+		return pathProbe{}, err
+	}
+
+	type contentsEntry struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+	if trimmed := strings.TrimSpace(string(raw)); !strings.HasPrefix(trimmed, "[") {
+		var entry contentsEntry
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return pathProbe{}, fmt.Errorf("failed to decode contents: %w", err)
+		}
+		kind := pathFile
+		if entry.Type == pathDir {
+			kind = pathDir
+		}
+		return pathProbe{kind: kind, fetched: time.Now()}, nil
+	}
+
+	var entries []contentsEntry
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return pathProbe{}, fmt.Errorf("failed to decode contents: %w", err)
+	}
+	files := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		if e.Type == pathFile || e.Type == "symlink" {
+			files[e.Name] = true
+		}
+	}
+	dir := fmt.Sprintf("%s/%s@%s:%s", owner, repo, branch, strings.Trim(filePath, "/"))
+	return pathProbe{kind: pathDir, index: gp.listedIndexFile(dir, files), fetched: time.Now()}, nil
+}
+
+// listedIndexFile picks the index document among the files of dir, the
+// same one whatever order Gitea listed them in
+func (gp *GitteaPages) listedIndexFile(dir string, files map[string]bool) string {
+	if index := gp.pickIndexFile(dir, func(name string) bool { return files[name] }); index != "" {
+		return index
+	}
+	if gp.ReadmeAsIndex {
+		names := make([]string, 0, len(files))
+		for name := range files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, ext := range readmeExtensions {
+			for _, name := range names {
+				if strings.EqualFold(name, "readme"+ext) {
+					return name
+				}
+			}
+		}
+	}
+	return ""
+}
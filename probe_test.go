@@ -0,0 +1,227 @@
+package giteapages
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestServeHTTP_ProbeContents(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Files: map[string]string{
+				"index.html":      "<h1>Home</h1>",
+				"app.js":          "run()",
+				"docs/index.html": "<h1>Docs</h1>",
+				"assets/logo.svg": "<svg></svg>",
+			},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.ProbeContents = true
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		ctype  string
+		probes int64
+	}{
+		{"file served directly", "/user/site/app.js", http.StatusOK, "text/javascript; charset=utf-8", 1},
+		{"directory resolves index", "/user/site/docs/", http.StatusOK, "text/html; charset=utf-8", 1},
+		{"directory without slash shares probe", "/user/site/docs", http.StatusOK, "text/html; charset=utf-8", 0},
+		{"directory without index", "/user/site/assets/", http.StatusNotFound, "", 1},
+		{"missing path", "/user/site/missing.html", http.StatusNotFound, "", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 2; i++ {
+				before := helper.contentCalls.Load()
+				w := helper.MakeHTTPRequest("HEAD", tt.path, "", nil)
+				if w.Code != tt.status {
+					t.Fatalf("request %d: expected status %d, got %d", i+1, tt.status, w.Code)
+				}
+				if tt.ctype != "" && w.Header().Get("Content-Type") != tt.ctype {
+					t.Errorf("Expected Content-Type %q, got %q", tt.ctype, w.Header().Get("Content-Type"))
+				}
+				probes := helper.contentCalls.Load() - before
+				if i == 0 && probes != tt.probes {
+					t.Errorf("Expected %d contents probes, got %d", tt.probes, probes)
+				}
+				if i == 1 && probes != 0 {
+					t.Errorf("Expected the repeat request to reuse the probe, got %d probes", probes)
+				}
+			}
+		})
+	}
+
+	if calls := helper.archiveCalls.Load(); calls != 0 {
+		t.Errorf("Expected no archive downloads, got %d", calls)
+	}
+}
+
+func TestServeHTTP_ProbeContentsRedirect(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Files:         map[string]string{"docs/index.html": "<h1>Docs</h1>"},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.ProbeContents = true
+	gp.DirectorySlash = "redirect"
+
+	w := helper.MakeHTTPRequest("HEAD", "/user/site/docs", "", nil)
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "./docs/" {
+		t.Errorf("Expected redirect to ./docs/, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestListedIndexFile(t *testing.T) {
+	gp := &GitteaPages{IndexFiles: []string{"index.html", "index.htm"}}
+	files := map[string]bool{"index.htm": true, "README.md": true}
+	if got := gp.listedIndexFile("site", files); got != "index.htm" {
+		t.Errorf("Expected index.htm, got %q", got)
+	}
+
+	files = map[string]bool{"README.md": true}
+	if got := gp.listedIndexFile("site", files); got != "" {
+		t.Errorf("Expected no index without readme_as_index, got %q", got)
+	}
+	gp.ReadmeAsIndex = true
+	if got := gp.listedIndexFile("site", files); got != "README.md" {
+		t.Errorf("Expected README.md, got %q", got)
+	}
+}
+
+func TestListedIndexFile_Tie(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	gp := &GitteaPages{IndexFiles: []string{"index.html", "index.htm"}, ReadmeAsIndex: true, logger: zap.New(core)}
+
+	// Map iteration order varies, so repeat to catch any dependence on it
+	files := map[string]bool{"index.htm": true, "index.html": true, "a.html": true, "z.html": true}
+	for i := 0; i < 50; i++ {
+		if got := gp.listedIndexFile("user/site@main:", files); got != "index.html" {
+			t.Fatalf("Expected index.html, got %q", got)
+		}
+	}
+	entries := logs.FilterMessage("directory has several index files, serving the first configured").All()
+	if len(entries) == 0 {
+		t.Fatal("Expected the tie to be logged")
+	}
+	if fields := entries[0].ContextMap(); fields["dir"] != "user/site@main:" || fields["index"] != "index.html" {
+		t.Errorf("Unexpected log fields %v", fields)
+	}
+
+	// The configured order, not the names, breaks the tie
+	gp.IndexFiles = []string{"index.htm", "index.html"}
+	if got := gp.listedIndexFile("user/site@main:", files); got != "index.htm" {
+		t.Errorf("Expected index.htm first in index_files to win, got %q", got)
+	}
+
+	files = map[string]bool{"readme.md": true, "README.md": true, "Readme.md": true}
+	for i := 0; i < 50; i++ {
+		if got := gp.listedIndexFile("user/site@main:", files); got != "README.md" {
+			t.Fatalf("Expected README.md, sorting first, got %q", got)
+		}
+	}
+}
+
+func TestServeHTTP_ProbeContentsIndexTie(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Files: map[string]string{
+				"docs/index.htm":  "<h1>Old</h1>",
+				"docs/index.html": "<h1>Current</h1>",
+			},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.ProbeContents = true
+
+	// HEAD on a cold cache picks from the contents API listing, GET from
+	// the extracted files; both choose index.html
+	w := helper.MakeHTTPRequest("HEAD", "/user/site/docs/", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	probe, _ := gp.probes.entries.get("user/site@main:docs")
+	if probe.index != "index.html" {
+		t.Errorf("Expected the probe to pick index.html, got %q", probe.index)
+	}
+	w = helper.MakeHTTPRequest("GET", "/user/site/docs/", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "Current")
+}
+
+func TestPathProbeCache(t *testing.T) {
+	gp := &GitteaPages{
+		CacheTTL:     caddy.Duration(time.Minute),
+		RepoCacheTTL: map[string]caddy.Duration{"user/docs": caddy.Duration(time.Hour)},
+	}
+	c := newPathProbeCache()
+	now := time.Now()
+	c.entries.put("user/site@main:old", pathProbe{kind: pathFile, fetched: now.Add(-2 * time.Minute)}, 1, maxPathProbes)
+	c.entries.put("user/site@main:new", pathProbe{kind: pathFile, fetched: now}, 1, maxPathProbes)
+	c.entries.put("user/docs@main:old", pathProbe{kind: pathFile, fetched: now.Add(-2 * time.Minute)}, 1, maxPathProbes)
+
+	// Each probe expires with its repository's TTL
+	c.prune(gp, now)
+	for key, kept := range map[string]bool{"user/site@main:old": false, "user/site@main:new": true, "user/docs@main:old": true} {
+		if _, ok := c.entries.get(key); ok != kept {
+			t.Errorf("%s: expected kept=%v", key, kept)
+		}
+	}
+
+	// However many paths clients make up, only so many are remembered
+	for i := 0; i < maxPathProbes+10; i++ {
+		c.entries.put(fmt.Sprintf("user/site@main:%d", i), pathProbe{kind: pathMissing, fetched: now}, 1, maxPathProbes)
+	}
+	if n := c.entries.len(); n != maxPathProbes {
+		t.Errorf("Expected %d probes, got %d", maxPathProbes, n)
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_ProbeContents(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		probe_contents
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if !gp.ProbeContents {
+		t.Error("Expected probe_contents to be enabled")
+	}
+}
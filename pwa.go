@@ -0,0 +1,49 @@
+package giteapages
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// manifestContentType is the registered type of web app manifests, which
+// Go's MIME table does not always know
+const manifestContentType = "application/manifest+json"
+
+// defaultServiceWorkerFiles are the script names that get
+// Service-Worker-Allowed when ServiceWorkerFiles is empty
+var defaultServiceWorkerFiles = []string{"sw.js", "service-worker.js"}
+
+// isWebManifest reports whether name is a web app manifest, i.e. has the
+// .webmanifest extension or is called manifest.json
+func isWebManifest(name string) bool {
+	base := strings.ToLower(path.Base(name))
+	return path.Ext(base) == ".webmanifest" || base == "manifest.json"
+}
+
+// isServiceWorker reports whether name is one of the service worker
+// scripts named by ServiceWorkerFiles
+func (gp *GitteaPages) isServiceWorker(name string) bool {
+	files := gp.ServiceWorkerFiles
+	if len(files) == 0 {
+		files = defaultServiceWorkerFiles
+	}
+	base := path.Base(name)
+	for _, f := range files {
+		if strings.EqualFold(base, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// setPWAHeaders sets the manifest content type and, when configured, the
+// Service-Worker-Allowed scope for the repository file name
+func (gp *GitteaPages) setPWAHeaders(w http.ResponseWriter, name string) {
+	if isWebManifest(name) {
+		w.Header().Set("Content-Type", manifestContentType)
+	}
+	if gp.ServiceWorkerAllowed != "" && gp.isServiceWorker(name) {
+		w.Header().Set("Service-Worker-Allowed", gp.ServiceWorkerAllowed)
+	}
+}
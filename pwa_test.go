@@ -0,0 +1,98 @@
+package giteapages
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_PWA(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+	})
+	gp.StrictContentType = true
+	helper.CreateCacheEntry("user/app", "main", map[string]string{
+		"index.html":          "<h1>App</h1>",
+		"site.webmanifest":    `{"name":"App"}`,
+		"manifest.json":       `{"name":"App"}`,
+		"data.json":           `{}`,
+		"js/sw.js":            "self.addEventListener('fetch', () => {})",
+		"js/custom-worker.js": "self.addEventListener('fetch', () => {})",
+		"js/app.js":           "console.log('app')",
+	})
+
+	t.Run("manifest content type", func(t *testing.T) {
+		for _, p := range []string{"/user/app/site.webmanifest", "/user/app/manifest.json"} {
+			w := helper.MakeHTTPRequest("GET", p, "", nil)
+			helper.AssertResponse(w, http.StatusOK, `{"name":"App"}`)
+			if ct := w.Header().Get("Content-Type"); ct != manifestContentType {
+				t.Errorf("%s: expected Content-Type '%s', got '%s'", p, manifestContentType, ct)
+			}
+			if cd := w.Header().Get("Content-Disposition"); cd != "" {
+				t.Errorf("%s: expected no Content-Disposition, got '%s'", p, cd)
+			}
+		}
+
+		w := helper.MakeHTTPRequest("GET", "/user/app/data.json", "", nil)
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Expected Content-Type 'application/json' for other JSON, got '%s'", ct)
+		}
+	})
+
+	t.Run("service worker header disabled by default", func(t *testing.T) {
+		w := helper.MakeHTTPRequest("GET", "/user/app/js/sw.js", "", nil)
+		helper.AssertResponse(w, http.StatusOK, "addEventListener")
+		if h := w.Header().Get("Service-Worker-Allowed"); h != "" {
+			t.Errorf("Expected no Service-Worker-Allowed, got '%s'", h)
+		}
+	})
+
+	t.Run("service worker header when enabled", func(t *testing.T) {
+		gp.ServiceWorkerAllowed = "/"
+		defer func() { gp.ServiceWorkerAllowed = "" }()
+
+		tests := []struct {
+			path     string
+			expected string
+		}{
+			{"/user/app/js/sw.js", "/"},
+			{"/user/app/js/custom-worker.js", ""},
+			{"/user/app/js/app.js", ""},
+		}
+		for _, tt := range tests {
+			w := helper.MakeHTTPRequest("GET", tt.path, "", nil)
+			if got := w.Header().Get("Service-Worker-Allowed"); got != tt.expected {
+				t.Errorf("%s: expected Service-Worker-Allowed '%s', got '%s'", tt.path, tt.expected, got)
+			}
+		}
+
+		gp.ServiceWorkerFiles = []string{"custom-worker.js"}
+		defer func() { gp.ServiceWorkerFiles = nil }()
+		w := helper.MakeHTTPRequest("GET", "/user/app/js/custom-worker.js", "", nil)
+		if got := w.Header().Get("Service-Worker-Allowed"); got != "/" {
+			t.Errorf("Expected Service-Worker-Allowed '/' for configured worker, got '%s'", got)
+		}
+	})
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_ServiceWorkerAllowed(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		service_worker_allowed / worker.js
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.ServiceWorkerAllowed != "/" {
+		t.Errorf("Expected service_worker_allowed '/', got '%s'", gp.ServiceWorkerAllowed)
+	}
+	if len(gp.ServiceWorkerFiles) != 1 || gp.ServiceWorkerFiles[0] != "worker.js" {
+		t.Errorf("Expected service worker files [worker.js], got %v", gp.ServiceWorkerFiles)
+	}
+}
@@ -0,0 +1,108 @@
+package giteapages
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultRegionHeader is the country header CDNs such as Cloudflare set
+const defaultRegionHeader = "CF-IPCountry"
+
+// RegionIndex serves per-region variants of directory index documents,
+// e.g. index.eu.html in place of index.html, chosen by the country code a
+// CDN reports in a request header. A country no region lists, or a region
+// whose variant is missing, gets the Default region's variant, and the
+// plain index when that is missing too.
+type RegionIndex struct {
+	// Header carries the visitor's ISO country code, CF-IPCountry by default
+	Header string `json:"header,omitempty"`
+
+	// Regions map country codes to the variant name their index uses
+	Regions []Region `json:"regions,omitempty"`
+
+	// Default is the region served to countries no region lists
+	Default string `json:"default,omitempty"`
+}
+
+// Region names an index variant and the countries it is served to
+type Region struct {
+	Name      string   `json:"name"`
+	Countries []string `json:"countries"`
+}
+
+func (ri *RegionIndex) provision() {
+	if ri.Header == "" {
+		ri.Header = defaultRegionHeader
+	}
+}
+
+func (ri *RegionIndex) validate() error {
+	seen := make(map[string]string)
+	for _, region := range ri.Regions {
+		if !validRegionName(region.Name) {
+			return fmt.Errorf("region_index: region name %q must be a non-empty name without dots or slashes", region.Name)
+		}
+		if len(region.Countries) == 0 {
+			return fmt.Errorf("region_index: region %q lists no countries", region.Name)
+		}
+		for _, country := range region.Countries {
+			country = strings.ToUpper(country)
+			if other, ok := seen[country]; ok {
+				return fmt.Errorf("region_index: country %s is listed by both %q and %q", country, other, region.Name)
+			}
+			seen[country] = region.Name
+		}
+	}
+	if ri.Default != "" && !validRegionName(ri.Default) {
+		return fmt.Errorf("region_index: default %q must be a name without dots or slashes", ri.Default)
+	}
+	return nil
+}
+
+// validRegionName reports whether name can be inserted into a file name
+func validRegionName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, `/\.`)
+}
+
+// region returns the region the country in r's header belongs to, or
+// Default when no region lists it
+func (ri *RegionIndex) region(r *http.Request) string {
+	country := strings.TrimSpace(r.Header.Get(ri.Header))
+	if country != "" {
+		for _, region := range ri.Regions {
+			for _, c := range region.Countries {
+				if strings.EqualFold(c, country) {
+					return region.Name
+				}
+			}
+		}
+	}
+	return ri.Default
+}
+
+// selectRegionIndex returns the index document to serve for indexPath, a
+// directory index inside root: the variant of the visitor's region, else
+// the default region's, else indexPath itself
+func (gp *GitteaPages) selectRegionIndex(w http.ResponseWriter, r *http.Request, root, indexPath string) string {
+	ri := gp.RegionIndex
+	// Caches must keep the regions' pages apart
+	w.Header().Add("Vary", ri.Header)
+
+	for _, name := range []string{ri.region(r), ri.Default} {
+		if name == "" {
+			continue
+		}
+		alt := variantPath(indexPath, name)
+		rel, err := filepath.Rel(root, alt)
+		if err != nil || gp.isDenied(filepath.ToSlash(rel)) {
+			continue
+		}
+		if info, err := os.Stat(alt); err == nil && info.Mode().IsRegular() {
+			return alt
+		}
+	}
+	return indexPath
+}
@@ -0,0 +1,133 @@
+package giteapages
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_RegionIndex(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/shop": {
+			Name:          "shop",
+			FullName:      "user/shop",
+			DefaultBranch: "main",
+			Files: map[string]string{
+				"index.html":         "<h1>Plain</h1>",
+				"index.us.html":      "<h1>US</h1>",
+				"index.eu.html":      "<h1>EU</h1>",
+				"page.html":          "<h1>Page</h1>",
+				"page.eu.html":       "<h1>Page EU</h1>",
+				"docs/index.html":    "<h1>Docs</h1>",
+				"docs/index.eu.html": "<h1>Docs EU</h1>",
+			},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.RegionIndex = &RegionIndex{
+		Regions: []Region{
+			{Name: "eu", Countries: []string{"DE", "FR"}},
+			{Name: "us", Countries: []string{"US"}},
+			{Name: "apac", Countries: []string{"JP"}},
+		},
+		Default: "us",
+	}
+	gp.RegionIndex.provision()
+	if err := gp.RegionIndex.validate(); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		country  string
+		expected string
+	}{
+		{"EU country", "/user/shop/", "DE", "<h1>EU</h1>"},
+		{"lowercase country", "/user/shop/", "fr", "<h1>EU</h1>"},
+		{"US country", "/user/shop/", "US", "<h1>US</h1>"},
+		{"unknown country", "/user/shop/", "BR", "<h1>US</h1>"},
+		{"no header", "/user/shop/", "", "<h1>US</h1>"},
+		{"region variant missing", "/user/shop/", "JP", "<h1>US</h1>"},
+		{"nested directory", "/user/shop/docs/", "DE", "<h1>Docs EU</h1>"},
+		{"default variant missing", "/user/shop/docs/", "BR", "<h1>Docs</h1>"},
+		{"regular files untouched", "/user/shop/page.html", "DE", "<h1>Page</h1>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var headers map[string]string
+			if tt.country != "" {
+				headers = map[string]string{"CF-IPCountry": tt.country}
+			}
+			w := helper.MakeHTTPRequest("GET", tt.path, "", headers)
+			helper.AssertResponse(w, http.StatusOK, tt.expected)
+		})
+	}
+
+	w := helper.MakeHTTPRequest("GET", "/user/shop/", "", map[string]string{"CF-IPCountry": "DE"})
+	if vary := w.Header().Values("Vary"); len(vary) == 0 || vary[0] != "CF-IPCountry" {
+		t.Errorf("Expected Vary: CF-IPCountry, got %v", vary)
+	}
+}
+
+func TestRegionIndex_Validate(t *testing.T) {
+	tests := []struct {
+		name string
+		ri   RegionIndex
+		ok   bool
+	}{
+		{"valid", RegionIndex{Regions: []Region{{Name: "eu", Countries: []string{"DE"}}}, Default: "eu"}, true},
+		{"dotted name", RegionIndex{Regions: []Region{{Name: "e.u", Countries: []string{"DE"}}}}, false},
+		{"no countries", RegionIndex{Regions: []Region{{Name: "eu"}}}, false},
+		{"country twice", RegionIndex{Regions: []Region{
+			{Name: "eu", Countries: []string{"DE"}},
+			{Name: "dach", Countries: []string{"de"}},
+		}}, false},
+		{"slashed default", RegionIndex{Default: "../us"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.ri.validate(); (err == nil) != tt.ok {
+				t.Errorf("Expected ok=%v, got %v", tt.ok, err)
+			}
+		})
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_RegionIndex(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		region_index X-Country {
+			region eu DE FR IT
+			region us US CA
+			default us
+		}
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	ri := gp.RegionIndex
+	if ri == nil || ri.Header != "X-Country" || ri.Default != "us" || len(ri.Regions) != 2 {
+		t.Fatalf("Unexpected region_index config %+v", ri)
+	}
+	if ri.Regions[0].Name != "eu" || len(ri.Regions[0].Countries) != 3 || ri.Regions[1].Countries[1] != "CA" {
+		t.Errorf("Unexpected regions %+v", ri.Regions)
+	}
+
+	d = caddyfile.NewTestDispenser(`gitea_pages {
+		region_index {
+			region eu
+		}
+	}`)
+	if err := new(GitteaPages).UnmarshalCaddyfile(d); err == nil {
+		t.Error("Expected a region without countries to fail")
+	}
+}
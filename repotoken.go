@@ -0,0 +1,60 @@
+package giteapages
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// upstreamTokenKey carries the Gitea token the upstream requests made for
+// one repository authenticate with
+type upstreamTokenKey struct{}
+
+// repoToken returns the token of the first domain mapping naming
+// owner/repo that has one, or "" when none does
+func (gp *GitteaPages) repoToken(owner, repo string) string {
+	for _, m := range gp.domainMappings() {
+		if m.Token != "" && strings.EqualFold(m.Owner, owner) && strings.EqualFold(m.Repository, repo) {
+			return m.Token
+		}
+	}
+	return ""
+}
+
+// requestToken returns the token upstream requests made for r send for
+// owner/repo, and whether r may reach owner/repo at all. A mapping's token
+// is only used for requests to that mapping's own domain. A repository
+// whose mapping has a token is not served anywhere else: neither other
+// hosts nor path routes may read it, or its cached copies, under the
+// mapping's credential, and they skip the guards set for the domain.
+func (gp *GitteaPages) requestToken(r *http.Request, owner, repo string) (string, bool) {
+	if m := gp.findDomainMapping(r.Host); m != nil && strings.EqualFold(m.Owner, owner) && strings.EqualFold(m.Repository, repo) {
+		return m.Token, true
+	}
+	return "", gp.repoToken(owner, repo) == ""
+}
+
+// withUpstreamToken returns ctx under which upstream requests send token,
+// or GitteaToken when token is empty, replacing any token set earlier
+func withUpstreamToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, upstreamTokenKey{}, token)
+}
+
+// withRefreshToken returns ctx for refreshing owner/repo. A refresh made
+// for a request keeps the token the request resolved; one made in the
+// background, e.g. after a push, uses the token of the repository's
+// mapping, whose domain is the only place the entry is served.
+func (gp *GitteaPages) withRefreshToken(ctx context.Context, owner, repo string) context.Context {
+	if _, scoped := ctx.Value(upstreamTokenKey{}).(string); scoped {
+		return ctx
+	}
+	return withUpstreamToken(ctx, gp.repoToken(owner, repo))
+}
+
+// upstreamToken returns the token upstream requests made under ctx send
+func (gp *GitteaPages) upstreamToken(ctx context.Context) string {
+	if token, ok := ctx.Value(upstreamTokenKey{}).(string); ok && token != "" {
+		return token
+	}
+	return gp.GitteaToken
+}
@@ -0,0 +1,207 @@
+package giteapages
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_MappingTokens(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"team-a/docs": {
+			Name:          "docs",
+			FullName:      "team-a/docs",
+			DefaultBranch: "main",
+			Files:         map[string]string{"index.html": "<h1>Team A</h1>"},
+			Token:         "a-token",
+		},
+		"team-b/docs": {
+			Name:          "docs",
+			FullName:      "team-b/docs",
+			DefaultBranch: "main",
+			Files:         map[string]string{"index.html": "<h1>Team B</h1>"},
+			Token:         "b-token",
+		},
+		"shared/site": {
+			Name:          "site",
+			FullName:      "shared/site",
+			DefaultBranch: "main",
+			Files:         map[string]string{"index.html": "<h1>Shared</h1>"},
+			Token:         "global-token",
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL:   helper.server.URL,
+		GitteaToken: "global-token",
+		DomainMappings: []DomainMapping{
+			{Domain: "a.example.com", Owner: "team-a", Repository: "docs", Token: "a-token"},
+			{Domain: "b.example.com", Owner: "team-b", Repository: "docs", Token: "b-token"},
+			{Domain: "shared.example.com", Owner: "shared", Repository: "site"},
+		},
+	})
+
+	tests := []struct {
+		host     string
+		path     string
+		expected string
+	}{
+		{"a.example.com", "/", "Team A"},
+		{"b.example.com", "/", "Team B"},
+		// Without a token of its own the mapping uses the global one
+		{"shared.example.com", "/", "Shared"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host+tt.path, func(t *testing.T) {
+			w := helper.MakeHTTPRequest("GET", tt.path, tt.host, nil)
+			helper.AssertResponse(w, http.StatusOK, tt.expected)
+		})
+	}
+
+	// Background refreshes, e.g. after a push, carry the token too
+	if err := gp.updateRepoCache(context.Background(), "team-a", "docs", "main"); err != nil {
+		t.Errorf("Background refresh failed: %v", err)
+	}
+
+	// So do the JSON endpoints taking a repository, on the mapped domain
+	gp.BranchesPath = "/_pages/branches"
+	w := helper.MakeHTTPRequest("GET", "/_pages/branches/team-a/docs?pages=true", "a.example.com", nil)
+	helper.AssertResponse(w, http.StatusOK, `"name":"main"`)
+
+	seen := make(map[string]bool)
+	for _, h := range helper.UpstreamHeaders() {
+		seen[h.Get("Authorization")] = true
+	}
+	for _, auth := range []string{"token a-token", "token b-token", "token global-token"} {
+		if !seen[auth] {
+			t.Errorf("Expected an upstream request with Authorization %q, got %v", auth, seen)
+		}
+	}
+}
+
+func TestServeHTTP_MappingTokenScopedToDomain(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"team-b/docs": {
+			Name:          "docs",
+			FullName:      "team-b/docs",
+			DefaultBranch: "main",
+			Files:         map[string]string{"index.html": "<h1>Team B</h1>"},
+			Branches:      map[string]map[string]string{"draft": {"index.html": "<h1>Draft</h1>"}},
+			Token:         "b-token",
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL:   helper.server.URL,
+		GitteaToken: "global-token",
+		DomainMappings: []DomainMapping{
+			{Domain: "b.example.com", Owner: "team-b", Repository: "docs", Token: "b-token"},
+		},
+	})
+	gp.BranchesPath = "/_pages/branches"
+	gp.MetadataPath = "/_pages/meta"
+
+	// Cached through the mapped domain first
+	w := helper.MakeHTTPRequest("GET", "/", "b.example.com", nil)
+	helper.AssertResponse(w, http.StatusOK, "Team B")
+
+	// Neither path routes, "@branch" URLs nor the JSON endpoints on other
+	// hosts reach the repository or its cached copy
+	for _, path := range []string{"/team-b/docs/", "/team-b/docs/@draft/", "/_pages/branches/team-b/docs", "/_pages/meta/team-b/docs"} {
+		w := helper.MakeHTTPRequest("GET", path, "other.example.com", nil)
+		if w.Code == http.StatusOK || strings.Contains(w.Body.String(), "Team B") || strings.Contains(w.Body.String(), "Draft") {
+			t.Errorf("Expected %s on another host refused, got %d: %s", path, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestRequestToken(t *testing.T) {
+	gp := &GitteaPages{
+		GitteaToken: "global",
+		DomainMappings: []DomainMapping{
+			{Domain: "a.example.com", Owner: "Team-A", Repository: "docs", Token: "a-token"},
+		},
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Host = "a.example.com:443"
+	token, ok := gp.requestToken(r, "team-a", "Docs")
+	if !ok || token != "a-token" {
+		t.Errorf("Expected the mapping's token on its domain, got %q %v", token, ok)
+	}
+	ctx := withUpstreamToken(context.Background(), token)
+	if got := gp.upstreamToken(ctx); got != "a-token" {
+		t.Errorf("Expected the mapping's token, got %q", got)
+	}
+
+	r.Host = "other.example.com"
+	if _, ok := gp.requestToken(r, "team-a", "docs"); ok {
+		t.Error("Expected the repository unreachable from another host")
+	}
+	if token, ok := gp.requestToken(r, "other", "site"); !ok || token != "" {
+		t.Errorf("Expected other repositories on the global token, got %q %v", token, ok)
+	}
+
+	// Background refreshes use the mapping's token; request refreshes keep
+	// the one the request resolved
+	if got := gp.upstreamToken(gp.withRefreshToken(context.Background(), "team-a", "docs")); got != "a-token" {
+		t.Errorf("Expected the mapping's token for a background refresh, got %q", got)
+	}
+	if got := gp.upstreamToken(gp.withRefreshToken(withUpstreamToken(context.Background(), ""), "team-a", "docs")); got != "global" {
+		t.Errorf("Expected the request's token kept, got %q", got)
+	}
+}
+
+func TestAdminAPI_DomainMappingsRedactToken(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+		DomainMappings: []DomainMapping{
+			{Domain: "a.example.com", Owner: "team-a", Repository: "docs", Token: "secret"},
+		},
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/gitea_pages/domain_mappings", nil)
+	if err := (AdminAPI{}).handleDomainMappings(rec, req); err != nil {
+		t.Fatalf("handleDomainMappings failed: %v", err)
+	}
+	var mappings map[string]DomainMapping
+	if err := json.Unmarshal(rec.Body.Bytes(), &mappings); err != nil {
+		t.Fatal(err)
+	}
+	if got := mappings["a.example.com"].Token; got != "xxxxx" {
+		t.Errorf("Expected the token to be redacted, got %q", got)
+	}
+	if gp.domainMappings()[0].Token != "secret" {
+		t.Error("Expected the handler to keep its token")
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_MappingToken(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		domain_mapping docs.example.com team-a docs {
+			token scoped-a
+		}
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if len(gp.DomainMappings) != 1 || gp.DomainMappings[0].Token != "scoped-a" {
+		t.Errorf("Unexpected mappings %+v", gp.DomainMappings)
+	}
+}
@@ -0,0 +1,133 @@
+package giteapages
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Request resolution strategies, tried in ResolutionOrder
+const (
+	strategyDomainMapping = "domain_mapping"
+	strategyAutoMapping   = "auto_mapping"
+	strategyPath          = "path"
+
+	// strategyCatchAll marks requests served by CatchAll after every
+	// strategy failed; it cannot be listed in ResolutionOrder
+	strategyCatchAll = "catch_all"
+)
+
+// defaultResolutionOrder tries explicit mappings, then auto-mapping, then
+// path-based routing
+var defaultResolutionOrder = []string{strategyDomainMapping, strategyAutoMapping, strategyPath}
+
+// routeMatch is a request resolved to a repository by one strategy
+type routeMatch struct {
+	owner, repo, filePath, branch string
+	strategy                      string
+
+	// explicitBranch is set when the URL itself names the branch, through
+	// an "@branch" segment or an environment prefix
+	explicitBranch bool
+}
+
+// resolutionOrder returns the strategies to try, in order
+func (gp *GitteaPages) resolutionOrder() []string {
+	if len(gp.ResolutionOrder) > 0 {
+		return gp.ResolutionOrder
+	}
+	return defaultResolutionOrder
+}
+
+// validateResolutionOrder checks that order only names known strategies,
+// each at most once
+func validateResolutionOrder(order []string) error {
+	seen := make(map[string]bool)
+	for _, strategy := range order {
+		switch strategy {
+		case strategyDomainMapping, strategyAutoMapping, strategyPath:
+		default:
+			return fmt.Errorf("resolution_order: unknown strategy %q, expected %s, %s or %s",
+				strategy, strategyDomainMapping, strategyAutoMapping, strategyPath)
+		}
+		if seen[strategy] {
+			return fmt.Errorf("resolution_order: %s listed twice", strategy)
+		}
+		seen[strategy] = true
+	}
+	return nil
+}
+
+// resolveRoute returns the match of the first strategy in the resolution
+// order that resolves r to a repository, or a zero routeMatch
+func (gp *GitteaPages) resolveRoute(r *http.Request) routeMatch {
+	for _, strategy := range gp.resolutionOrder() {
+		if m := gp.resolveStrategy(r, strategy); m.owner != "" && m.repo != "" {
+			m.strategy = strategy
+			return m
+		}
+	}
+	return routeMatch{}
+}
+
+// catchAllMatch resolves r to the CatchAll repository, serving the whole
+// request path from it
+func (gp *GitteaPages) catchAllMatch(r *http.Request) routeMatch {
+	return routeMatch{
+		owner:    gp.CatchAll.Owner,
+		repo:     gp.CatchAll.Repository,
+		filePath: strings.Trim(r.URL.Path, "/"),
+		branch:   gp.CatchAll.Branch,
+		strategy: strategyCatchAll,
+	}
+}
+
+// resolveStrategy resolves r with a single strategy
+func (gp *GitteaPages) resolveStrategy(r *http.Request, strategy string) routeMatch {
+	host := r.Host
+	if colonIndex := strings.Index(host, ":"); colonIndex != -1 {
+		host = host[:colonIndex]
+	}
+	filePath := strings.Trim(r.URL.Path, "/")
+
+	switch strategy {
+	case strategyDomainMapping:
+		mapping := gp.findDomainMapping(host)
+		if mapping == nil {
+			break
+		}
+		if branch, rest, ok := mapping.envBranch(filePath); ok {
+			return routeMatch{owner: mapping.Owner, repo: mapping.Repository, filePath: rest, branch: branch, explicitBranch: true}
+		}
+		return routeMatch{owner: mapping.Owner, repo: mapping.Repository, filePath: filePath, branch: mapping.Branch}
+
+	case strategyAutoMapping:
+		if gp.AutoMapping == nil || !gp.AutoMapping.Enabled {
+			break
+		}
+		if gp.StripHostPrefix != "" {
+			host = strings.TrimPrefix(host, gp.StripHostPrefix)
+		}
+		owner, repo, rest, branch := gp.resolveAutoMapping(host, filePath)
+		return routeMatch{owner: owner, repo: repo, filePath: rest, branch: branch}
+
+	case strategyPath:
+		parts := strings.Split(filePath, "/")
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			break
+		}
+		m := routeMatch{owner: parts[0], repo: parts[1]}
+		rest := parts[2:]
+
+		// An "@branch" segment right after the repository selects the
+		// branch, e.g. /owner/repo/@dev/index.html
+		if len(rest) > 0 && len(rest[0]) > 1 && strings.HasPrefix(rest[0], "@") {
+			m.branch = rest[0][1:]
+			m.explicitBranch = true
+			rest = rest[1:]
+		}
+		m.filePath = strings.Join(rest, "/")
+		return m
+	}
+	return routeMatch{}
+}
@@ -0,0 +1,168 @@
+package giteapages
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_ResolutionOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		order    []string
+		host     string
+		path     string
+		status   int
+		expected string
+	}{
+		{"default prefers the mapping", nil, "site.example.com", "/other/docs/page.html", http.StatusOK, "Mapped copy"},
+		{"path first", []string{"path", "domain_mapping"}, "site.example.com", "/other/docs/page.html", http.StatusOK, "Other docs"},
+		{"path first falls back to mapping", []string{"path", "domain_mapping"}, "site.example.com", "/page.html", http.StatusOK, "Site page"},
+		{"auto mapping before explicit", []string{"auto_mapping", "domain_mapping"}, "site.example.com", "/page.html", http.StatusOK, "Auto page"},
+		{"path routing disabled", []string{"domain_mapping", "auto_mapping"}, "unmapped.test", "/other/docs/page.html", http.StatusNotFound, "Not handled"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helper := NewTestHelper(t)
+			defer helper.Cleanup()
+
+			helper.CreateMockGiteaServer(map[string]MockRepo{
+				"user/site": {
+					Name:          "site",
+					FullName:      "user/site",
+					DefaultBranch: "main",
+					Files: map[string]string{
+						"page.html":            "<h1>Site page</h1>",
+						"other/docs/page.html": "<h1>Mapped copy</h1>",
+					},
+				},
+				"other/docs": {
+					Name:          "docs",
+					FullName:      "other/docs",
+					DefaultBranch: "main",
+					Files:         map[string]string{"page.html": "<h1>Other docs</h1>"},
+				},
+				"auto/site": {
+					Name:          "site",
+					FullName:      "auto/site",
+					DefaultBranch: "main",
+					Files:         map[string]string{"page.html": "<h1>Auto page</h1>"},
+				},
+			})
+			gp := helper.SetupGiteaPages(GitteaPagesConfig{
+				GitteaURL: helper.server.URL,
+				DomainMappings: []DomainMapping{
+					{Domain: "site.example.com", Owner: "user", Repository: "site"},
+				},
+				AutoMapping: &AutoMapping{
+					Enabled: true,
+					Pattern: "{subdomain}.{domain}",
+					Owner:   "auto",
+				},
+			})
+			gp.ResolutionOrder = tt.order
+
+			w := helper.MakeHTTPRequest("GET", tt.path, tt.host, nil)
+			helper.AssertResponse(w, tt.status, tt.expected)
+		})
+	}
+}
+
+func TestServeHTTP_CatchAll(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"org/main-site": {
+			Name:          "main-site",
+			FullName:      "org/main-site",
+			DefaultBranch: "main",
+			Files: map[string]string{
+				"index.html":          "<h1>Main site</h1>",
+				"about/team.html":     "<h1>Team</h1>",
+				"favicon.ico":         "icon",
+				"user/blog/page.html": "<h1>Shadowed</h1>",
+			},
+		},
+		"user/blog": {
+			Name:          "blog",
+			FullName:      "user/blog",
+			DefaultBranch: "main",
+			Files:         map[string]string{"page.html": "<h1>Blog page</h1>"},
+		},
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Files:         map[string]string{"page.html": "<h1>Mapped site</h1>"},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+		DomainMappings: []DomainMapping{
+			{Domain: "site.example.com", Owner: "user", Repository: "site"},
+		},
+	})
+	gp.CatchAll = &CatchAll{Owner: "org", Repository: "main-site"}
+
+	tests := []struct {
+		name     string
+		host     string
+		path     string
+		status   int
+		expected string
+	}{
+		{"unresolvable root", "www.example.com", "/", http.StatusOK, "Main site"},
+		{"single segment", "www.example.com", "/favicon.ico", http.StatusOK, "icon"},
+		{"path to a missing repository", "www.example.com", "/about/team.html", http.StatusOK, "Team"},
+		{"path routing takes precedence", "www.example.com", "/user/blog/page.html", http.StatusOK, "Blog page"},
+		{"domain mapping takes precedence", "site.example.com", "/page.html", http.StatusOK, "Mapped site"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := helper.MakeHTTPRequest("GET", tt.path, tt.host, nil)
+			helper.AssertResponse(w, tt.status, tt.expected)
+		})
+	}
+}
+
+func TestValidateResolutionOrder(t *testing.T) {
+	tests := []struct {
+		order []string
+		valid bool
+	}{
+		{nil, true},
+		{[]string{"path"}, true},
+		{[]string{"auto_mapping", "domain_mapping", "path"}, true},
+		{[]string{"domain_mapping", "subdomain"}, false},
+		{[]string{"path", "path"}, false},
+	}
+
+	for _, tt := range tests {
+		if err := validateResolutionOrder(tt.order); (err == nil) != tt.valid {
+			t.Errorf("validateResolutionOrder(%v): expected valid=%v, got %v", tt.order, tt.valid, err)
+		}
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_ResolutionOrder(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		resolution_order auto_mapping domain_mapping
+		catch_all org main-site live
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if len(gp.ResolutionOrder) != 2 || gp.ResolutionOrder[0] != "auto_mapping" || gp.ResolutionOrder[1] != "domain_mapping" {
+		t.Errorf("Unexpected resolution order %v", gp.ResolutionOrder)
+	}
+	if gp.CatchAll == nil || *gp.CatchAll != (CatchAll{Owner: "org", Repository: "main-site", Branch: "live"}) {
+		t.Errorf("Unexpected catch_all %+v", gp.CatchAll)
+	}
+}
@@ -0,0 +1,38 @@
+package giteapages
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// revalidations records when each cache key last started a background
+// refresh under RevalidateCooldown
+type revalidations struct {
+	mu      sync.Mutex
+	started map[string]time.Time
+}
+
+// revalidateStale starts a background refresh of an expired cache entry
+// unless one was started within RevalidateCooldown, so a burst of stale
+// hits on one key costs at most one refresh per cooldown window, however
+// the refresh turns out. The caller serves the stale copy meanwhile.
+func (gp *GitteaPages) revalidateStale(ctx context.Context, owner, repo, branch, cacheKey string) {
+	cooldown := time.Duration(gp.RevalidateCooldown)
+	now := time.Now()
+
+	gp.revalidating.mu.Lock()
+	if last, ok := gp.revalidating.started[cacheKey]; ok && now.Sub(last) < cooldown {
+		gp.revalidating.mu.Unlock()
+		return
+	}
+	for key, last := range gp.revalidating.started {
+		if now.Sub(last) >= cooldown {
+			delete(gp.revalidating.started, key)
+		}
+	}
+	gp.revalidating.started[cacheKey] = now
+	gp.revalidating.mu.Unlock()
+
+	gp.warmCache(ctx, owner, repo, branch)
+}
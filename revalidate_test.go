@@ -0,0 +1,106 @@
+package giteapages
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_RevalidateCooldown(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.RevalidateCooldown = caddy.Duration(time.Minute)
+
+	helper.CreateCacheEntry("user/website", "main", map[string]string{
+		"about.html": "<h1>Stale About</h1>",
+	})
+	gp.cache.repos["user/website:main"].lastUpdate = time.Now().Add(-time.Hour)
+
+	// Every refresh fails, so the entry stays stale throughout
+	helper.FailNextArchives(1000)
+
+	archiveCalls := func() int64 {
+		_, archive := helper.UpstreamCalls()
+		return archive
+	}
+	settle := func(after int64) int64 {
+		deadline := time.Now().Add(2 * time.Second)
+		for archiveCalls() <= after && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		time.Sleep(50 * time.Millisecond)
+		return archiveCalls()
+	}
+
+	for i := 0; i < 50; i++ {
+		w := helper.MakeHTTPRequest("GET", "/user/website/about.html", "", nil)
+		helper.AssertResponse(w, http.StatusOK, "Stale About")
+	}
+	first := settle(0)
+	if first == 0 {
+		t.Fatal("Expected a background refresh of the stale entry")
+	}
+
+	for i := 0; i < 50; i++ {
+		w := helper.MakeHTTPRequest("GET", "/user/website/about.html", "", nil)
+		helper.AssertResponse(w, http.StatusOK, "Stale About")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := archiveCalls(); got != first {
+		t.Errorf("Expected no further refresh within the cooldown, archive calls went from %d to %d", first, got)
+	}
+
+	// Once the cooldown has passed, the next stale hit refreshes again
+	gp.revalidating.mu.Lock()
+	gp.revalidating.started["user/website:main"] = time.Now().Add(-2 * time.Minute)
+	gp.revalidating.mu.Unlock()
+
+	w := helper.MakeHTTPRequest("GET", "/user/website/about.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "Stale About")
+	if got := settle(first); got <= first {
+		t.Errorf("Expected a new refresh after the cooldown, archive calls stayed at %d", got)
+	}
+}
+
+func TestServeHTTP_RevalidateCooldownColdCache(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(GenerateTestRepos())
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.RevalidateCooldown = caddy.Duration(time.Minute)
+
+	// Without a copy to serve, the first request still waits for it
+	w := helper.MakeHTTPRequest("GET", "/user/website/about.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "About Us")
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_RevalidateCooldown(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		revalidate_cooldown 30s
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if time.Duration(gp.RevalidateCooldown) != 30*time.Second {
+		t.Errorf("Expected revalidate_cooldown 30s, got %v", time.Duration(gp.RevalidateCooldown))
+	}
+
+	gp.RevalidateCooldown = caddy.Duration(-time.Second)
+	if err := gp.Validate(); err == nil {
+		t.Error("Expected a negative revalidate_cooldown to be rejected")
+	}
+}
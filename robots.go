@@ -0,0 +1,49 @@
+package giteapages
+
+import (
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// noIndexRobots is served as robots.txt on NoIndexHosts
+const noIndexRobots = "User-agent: *\nDisallow: /\n"
+
+// hostMatches reports whether host, ignoring any port, matches one of
+// patterns, which may use shell wildcards as in "*.preview.example.com"
+func hostMatches(patterns []string, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// serveNoIndex keeps crawlers away from a NoIndexHosts host: every
+// response carries X-Robots-Tag and /robots.txt disallows everything,
+// whatever the repository's own file says. It reports whether the
+// response has been written.
+func (gp *GitteaPages) serveNoIndex(w http.ResponseWriter, r *http.Request) bool {
+	if !hostMatches(gp.NoIndexHosts, r.Host) {
+		return false
+	}
+
+	w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+	if r.URL.Path != "/robots.txt" {
+		return false
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(noIndexRobots)))
+	if r.Method != http.MethodHead {
+		w.Write([]byte(noIndexRobots))
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package giteapages
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_NoIndexHosts(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+		DomainMappings: []DomainMapping{
+			{Domain: "www.example.com", Owner: "user", Repository: "site"},
+		},
+	})
+	gp.NoIndexHosts = []string{"*.preview.example.com"}
+	helper.CreateCacheEntry("user/site", "main", map[string]string{
+		"robots.txt": "User-agent: *\nAllow: /\nSitemap: https://www.example.com/sitemap.xml\n",
+		"page.html":  "<h1>Page</h1>",
+	})
+
+	w := helper.MakeHTTPRequest("GET", "/robots.txt", "pr-42.preview.example.com:8443", nil)
+	helper.AssertResponse(w, http.StatusOK, "")
+	if w.Body.String() != noIndexRobots {
+		t.Errorf("Expected disallow-all robots.txt, got %q", w.Body.String())
+	}
+	if tag := w.Header().Get("X-Robots-Tag"); tag == "" {
+		t.Error("Expected X-Robots-Tag on preview host")
+	}
+
+	w = helper.MakeHTTPRequest("GET", "/robots.txt", "www.example.com", nil)
+	helper.AssertResponse(w, http.StatusOK, "Sitemap: https://www.example.com/sitemap.xml")
+	if tag := w.Header().Get("X-Robots-Tag"); tag != "" {
+		t.Errorf("Expected no X-Robots-Tag on production host, got '%s'", tag)
+	}
+
+	w = helper.MakeHTTPRequest("GET", "/user/site/page.html", "preview.example.com", nil)
+	if tag := w.Header().Get("X-Robots-Tag"); tag != "" {
+		t.Errorf("Expected the pattern not to match the bare preview domain, got '%s'", tag)
+	}
+}
+
+func TestHostMatches(t *testing.T) {
+	patterns := []string{"*.preview.example.com", "staging.example.org"}
+	tests := []struct {
+		host     string
+		expected bool
+	}{
+		{"pr-1.preview.example.com", true},
+		{"PR-1.Preview.Example.com", true},
+		{"pr-1.preview.example.com:8080", true},
+		{"staging.example.org", true},
+		{"preview.example.com", false},
+		{"www.example.com", false},
+	}
+
+	for _, tt := range tests {
+		if got := hostMatches(patterns, tt.host); got != tt.expected {
+			t.Errorf("hostMatches(%q): expected %v, got %v", tt.host, tt.expected, got)
+		}
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_NoIndexHosts(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		noindex_hosts *.preview.example.com staging.example.org
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if len(gp.NoIndexHosts) != 2 || gp.NoIndexHosts[0] != "*.preview.example.com" {
+		t.Errorf("Expected two noindex host patterns, got %v", gp.NoIndexHosts)
+	}
+}
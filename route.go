@@ -0,0 +1,48 @@
+package giteapages
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// Route describes how a request was resolved to a repository. It is
+// stored in the request context and mirrored into the placeholders
+// {http.gitea_pages.owner}, {http.gitea_pages.repo},
+// {http.gitea_pages.branch}, {http.gitea_pages.path} and
+// {http.gitea_pages.cache_hit} for handlers and logs further down the
+// chain.
+type Route struct {
+	Owner  string
+	Repo   string
+	Branch string
+	Path   string
+
+	// CacheHit reports whether a fresh cached copy was available, so the
+	// request did not need to contact Gitea
+	CacheHit bool
+}
+
+// routeKey is the context key for the resolved Route
+type routeKey struct{}
+
+// RouteFromContext returns the Route gitea_pages resolved for a request,
+// if any
+func RouteFromContext(ctx context.Context) (Route, bool) {
+	route, ok := ctx.Value(routeKey{}).(Route)
+	return route, ok
+}
+
+// withRoute records route in r's context and Caddy's replacer
+func withRoute(r *http.Request, route Route) *http.Request {
+	if repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer); ok {
+		repl.Set("http.gitea_pages.owner", route.Owner)
+		repl.Set("http.gitea_pages.repo", route.Repo)
+		repl.Set("http.gitea_pages.branch", route.Branch)
+		repl.Set("http.gitea_pages.path", route.Path)
+		repl.Set("http.gitea_pages.cache_hit", strconv.FormatBool(route.CacheHit))
+	}
+	return r.WithContext(context.WithValue(r.Context(), routeKey{}, route))
+}
@@ -0,0 +1,92 @@
+package giteapages
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func TestServeHTTP_RoutePlaceholders(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+	})
+	helper.CreateCacheEntry("user/site", "main", map[string]string{
+		"page.html": "<h1>Site</h1>",
+	})
+
+	serve := func(path string) (*caddy.Replacer, *http.Request, *httptest.ResponseRecorder) {
+		repl := caddy.NewReplacer()
+		req := httptest.NewRequest("GET", path, nil)
+		req = req.WithContext(context.WithValue(req.Context(), caddy.ReplacerCtxKey, repl))
+		w := httptest.NewRecorder()
+
+		var downstream *http.Request
+		next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			downstream = r
+			w.WriteHeader(http.StatusTeapot)
+			return nil
+		})
+		if err := gp.ServeHTTP(w, req, next); err != nil {
+			t.Fatalf("ServeHTTP failed: %v", err)
+		}
+		return repl, downstream, w
+	}
+
+	t.Run("resolved request", func(t *testing.T) {
+		repl, _, w := serve("/user/site/page.html")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+		expected := map[string]string{
+			"http.gitea_pages.owner":     "user",
+			"http.gitea_pages.repo":      "site",
+			"http.gitea_pages.branch":    "main",
+			"http.gitea_pages.path":      "page.html",
+			"http.gitea_pages.cache_hit": "true",
+		}
+		for key, want := range expected {
+			if got, _ := repl.GetString(key); got != want {
+				t.Errorf("Expected %s '%s', got '%s'", key, want, got)
+			}
+		}
+	})
+
+	t.Run("fall through carries route", func(t *testing.T) {
+		repl, downstream, w := serve("/user/site/missing.html")
+		if w.Code != http.StatusTeapot {
+			t.Fatalf("Expected request to reach next handler, got %d", w.Code)
+		}
+		route, ok := RouteFromContext(downstream.Context())
+		if !ok {
+			t.Fatal("Expected route in downstream request context")
+		}
+		if route.Owner != "user" || route.Repo != "site" || route.Branch != "main" || route.Path != "missing.html" || !route.CacheHit {
+			t.Errorf("Unexpected route %+v", route)
+		}
+		if got, _ := repl.GetString("http.gitea_pages.path"); got != "missing.html" {
+			t.Errorf("Expected path placeholder 'missing.html', got '%s'", got)
+		}
+	})
+
+	t.Run("nothing matched", func(t *testing.T) {
+		repl, downstream, w := serve("/")
+		if w.Code != http.StatusTeapot {
+			t.Fatalf("Expected request to reach next handler, got %d", w.Code)
+		}
+		for _, key := range []string{"http.gitea_pages.owner", "http.gitea_pages.repo", "http.gitea_pages.branch", "http.gitea_pages.cache_hit"} {
+			if got, _ := repl.GetString(key); got != "" {
+				t.Errorf("Expected empty %s, got '%s'", key, got)
+			}
+		}
+		if _, ok := RouteFromContext(downstream.Context()); ok {
+			t.Error("Expected no route in context")
+		}
+	})
+}
@@ -0,0 +1,249 @@
+package giteapages
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// lockPollInterval is how often a held cache entry lock is retried
+const lockPollInterval = 25 * time.Millisecond
+
+// defaultSharedRemoveDelay is how long the files of an evicted shared
+// entry outlive its index, so requests other nodes started on them can
+// finish
+const defaultSharedRemoveDelay = 30 * time.Second
+
+// sharedIndex is the on-disk record of a cache entry that nodes sharing
+// the cache directory read to adopt each other's downloads
+type sharedIndex struct {
+	Updated   time.Time         `json:"updated"`
+	Path      string            `json:"path"`
+	ETag      string            `json:"etag,omitempty"`
+	Commit    string            `json:"commit,omitempty"`
+	Files     map[string]int64  `json:"files,omitempty"`
+	Streamed  []string          `json:"streamed,omitempty"`
+	Blobs     map[string]string `json:"blobs,omitempty"`
+	Integrity map[string]string `json:"integrity,omitempty"`
+	Rejected  []string          `json:"rejected,omitempty"`
+}
+
+// sharedIndexPath is where the shared index of the entry at base is kept
+func sharedIndexPath(base string) string {
+	return base + ".index.json"
+}
+
+// lockCacheEntry takes the advisory lock guarding the download of the
+// entry at base, waiting while another node or request holds it. The
+// returned function releases the lock.
+func (gp *GitteaPages) lockCacheEntry(ctx context.Context, base string) (func(), error) {
+	if err := gp.ensureCacheDir(filepath.Dir(base)); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(base+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+	for {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if locked {
+			return func() {
+				unlockFile(f)
+				f.Close()
+			}, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// adoptSharedEntry returns the entry another node stored at base while
+// this one waited for the lock, when it is newer than since and still
+// fresh, so the download is not repeated. It returns nil otherwise.
+func (gp *GitteaPages) adoptSharedEntry(repoKey, base string, since time.Time) *cacheEntry {
+	index, path, ok := gp.readSharedIndex(base)
+	if !ok {
+		return nil
+	}
+	if !index.Updated.After(since) || time.Since(index.Updated) > gp.cacheTTLFor(repoKey) {
+		return nil
+	}
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		return nil
+	}
+
+	entry := &cacheEntry{
+		lastUpdate: index.Updated,
+		path:       path,
+		etag:       index.ETag,
+		commit:     index.Commit,
+		compressed: gp.CompressCache,
+		files:      index.Files,
+		blobs:      index.Blobs,
+		integrity:  index.Integrity,
+		indexes:    newIndexCache(),
+		minified:   newMinifyCache(),
+		included:   newIncludeCache(),
+		encoded:    newEncodingCache(),
+	}
+	if len(index.Streamed) > 0 {
+		entry.streamed = make(map[string]bool, len(index.Streamed))
+		for _, name := range index.Streamed {
+			entry.streamed[name] = true
+		}
+	}
+	if len(index.Rejected) > 0 {
+		entry.rejected = make(map[string]bool, len(index.Rejected))
+		for _, name := range index.Rejected {
+			entry.rejected[name] = true
+		}
+	}
+	return entry
+}
+
+// readSharedIndex reads the shared index of the entry at base and resolves
+// the directory it names, which must lie within the cache directory
+func (gp *GitteaPages) readSharedIndex(base string) (sharedIndex, string, bool) {
+	var index sharedIndex
+	data, err := os.ReadFile(sharedIndexPath(base))
+	if err != nil {
+		return index, "", false
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		gp.logger.Warn("ignoring unreadable shared cache index",
+			zap.String("path", sharedIndexPath(base)),
+			zap.Error(err))
+		return index, "", false
+	}
+	path := filepath.Join(gp.cache.cacheDir, filepath.FromSlash(index.Path))
+	if !withinDir(gp.cache.cacheDir, path) {
+		return index, "", false
+	}
+	return index, path, true
+}
+
+// evictSharedEntry withdraws the shared entry at base whose files are at
+// path. Its index is removed under the entry lock, so other nodes drop
+// their copies on their next request, and the files follow after
+// sharedRemoveDelay unless a new download has taken the path over. An
+// entry another node has refreshed since is left to that node's refresh.
+func (gp *GitteaPages) evictSharedEntry(base, path string) {
+	unlock, err := gp.lockCacheEntry(context.Background(), base)
+	if err != nil {
+		gp.logger.Warn("failed to lock evicted shared cache entry",
+			zap.String("path", path),
+			zap.Error(err))
+		return
+	}
+	if _, current, ok := gp.readSharedIndex(base); ok && current != path {
+		unlock()
+		return
+	}
+	os.Remove(sharedIndexPath(base))
+	unlock()
+
+	time.AfterFunc(gp.sharedRemoveDelay, func() {
+		unlock, err := gp.lockCacheEntry(context.Background(), base)
+		if err != nil {
+			return
+		}
+		defer unlock()
+		if _, current, ok := gp.readSharedIndex(base); ok && current == path {
+			return
+		}
+		if err := os.RemoveAll(path); err != nil {
+			gp.logger.Warn("failed to remove evicted cache entry",
+				zap.String("path", path),
+				zap.Error(err))
+		}
+	})
+}
+
+// dropWithdrawnEntry forgets the entry for cacheKey when its shared index
+// has been removed, i.e. another node evicted it, so the request that
+// follows downloads or adopts the entry again instead of serving files
+// about to be deleted. Pinned entries are never evicted and are kept.
+func (gp *GitteaPages) dropWithdrawnEntry(owner, repo, branch, cacheKey string) {
+	gp.cache.mu.RLock()
+	entry := gp.cache.repos[cacheKey]
+	gp.cache.mu.RUnlock()
+	if entry == nil || gp.isPinned(cacheKey) {
+		return
+	}
+	if _, err := os.Stat(sharedIndexPath(gp.cache.entryPath(owner, repo, branch))); !os.IsNotExist(err) {
+		return
+	}
+
+	gp.cache.mu.Lock()
+	if gp.cache.repos[cacheKey] == entry {
+		delete(gp.cache.repos, cacheKey)
+	}
+	gp.cache.mu.Unlock()
+	gp.logger.Debug("dropped cache entry evicted by another node",
+		zap.String("cache_key", cacheKey))
+}
+
+// writeSharedIndex records entry for the other nodes, replacing the index
+// at base atomically so readers never see a partial file
+func (gp *GitteaPages) writeSharedIndex(base string, entry *cacheEntry) error {
+	rel, err := filepath.Rel(gp.cache.cacheDir, entry.path)
+	if err != nil {
+		return err
+	}
+	index := sharedIndex{
+		Updated:   entry.lastUpdate,
+		Path:      filepath.ToSlash(rel),
+		ETag:      entry.etag,
+		Commit:    entry.commit,
+		Files:     entry.files,
+		Blobs:     entry.blobs,
+		Integrity: entry.integrity,
+	}
+	for name := range entry.streamed {
+		index.Streamed = append(index.Streamed, name)
+	}
+	for name := range entry.rejected {
+		index.Rejected = append(index.Rejected, name)
+	}
+	sort.Strings(index.Streamed)
+	sort.Strings(index.Rejected)
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(base), filepath.Base(base)+".index-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), sharedIndexPath(base)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
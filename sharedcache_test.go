@@ -0,0 +1,256 @@
+package giteapages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func TestServeHTTP_SharedCacheSingleDownload(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	page := strings.Repeat("<p>shared</p>", 500)
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Files: map[string]string{
+				"page.html":     page,
+				"css/style.css": "body { color: red }",
+			},
+		},
+	})
+	helper.SetUpstreamDelay(100 * time.Millisecond)
+
+	// Two instances over one cache directory, as two nodes over NFS
+	var nodes []*GitteaPages
+	for i := 0; i < 2; i++ {
+		gp := helper.SetupGiteaPages(GitteaPagesConfig{
+			GitteaURL: helper.server.URL,
+		})
+		gp.SharedCache = true
+		nodes = append(nodes, gp)
+	}
+	if nodes[0].cache.cacheDir != nodes[1].cache.cacheDir {
+		t.Fatal("Expected both instances to share the cache directory")
+	}
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	results := make(chan *httptest.ResponseRecorder, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(gp *GitteaPages) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			if err := gp.ServeHTTP(w, httptest.NewRequest("GET", "/user/site/page.html", nil), next); err != nil {
+				t.Errorf("ServeHTTP returned error: %v", err)
+			}
+			results <- w
+		}(nodes[i%2])
+	}
+	wg.Wait()
+	close(results)
+
+	for w := range results {
+		if w.Code != http.StatusOK || w.Body.String() != page {
+			t.Errorf("Expected the complete page with status 200, got %d and %d bytes", w.Code, w.Body.Len())
+		}
+	}
+	if _, archive := helper.UpstreamCalls(); archive != 1 {
+		t.Errorf("Expected exactly one archive download, got %d", archive)
+	}
+
+	nodes[0].cache.mu.RLock()
+	first := nodes[0].cache.repos["user/site:main"]
+	nodes[0].cache.mu.RUnlock()
+	nodes[1].cache.mu.RLock()
+	second := nodes[1].cache.repos["user/site:main"]
+	nodes[1].cache.mu.RUnlock()
+	if first == nil || second == nil || first.path != second.path {
+		t.Fatalf("Expected both instances to serve the same entry, got %+v and %+v", first, second)
+	}
+	if !first.lastUpdate.Equal(second.lastUpdate) {
+		t.Errorf("Expected the adopted entry to keep the download time, got %v and %v", first.lastUpdate, second.lastUpdate)
+	}
+}
+
+func TestLockCacheEntry(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{GitteaURL: "http://gitea.invalid"})
+	base := filepath.Join(gp.cache.cacheDir, "user", "site:main")
+
+	unlock, err := gp.lockCacheEntry(context.Background(), base)
+	if err != nil {
+		t.Fatalf("lockCacheEntry failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := gp.lockCacheEntry(ctx, base); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the held lock to block until the deadline, got %v", err)
+	}
+
+	unlock()
+	unlock, err = gp.lockCacheEntry(context.Background(), base)
+	if err != nil {
+		t.Fatalf("Expected the released lock to be available, got %v", err)
+	}
+	unlock()
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_SharedCache(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		shared_cache
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if !gp.SharedCache {
+		t.Error("Expected shared_cache to be enabled")
+	}
+}
+
+func TestGiteaPages_Validate_SharedCacheLocking(t *testing.T) {
+	gp := GitteaPages{GitteaURL: "https://git.example.com", SharedCache: true}
+	err := gp.Validate()
+	if fileLockingSupported && err != nil {
+		t.Errorf("Expected shared_cache to validate where files can be locked, got %v", err)
+	}
+	if !fileLockingSupported && err == nil {
+		t.Error("Expected shared_cache to fail validation without file locking")
+	}
+}
+
+func TestServeHTTP_SharedCacheRefreshIsAtomic(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	files := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		files[fmt.Sprintf("pages/%03d.html", i)] = strings.Repeat(fmt.Sprintf("<p>%d</p>", i), 200)
+	}
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {Name: "site", FullName: "user/site", DefaultBranch: "main", Files: files},
+	})
+
+	var nodes []*GitteaPages
+	for i := 0; i < 2; i++ {
+		gp := helper.SetupGiteaPages(GitteaPagesConfig{
+			GitteaURL: helper.server.URL,
+		})
+		gp.SharedCache = true
+		nodes = append(nodes, gp)
+	}
+	helper.gp = nodes[0]
+	helper.AssertResponse(helper.MakeHTTPRequest("GET", "/user/site/pages/000.html", "", nil), http.StatusOK, "<p>0</p>")
+
+	// The second node finds the first one's download expired and
+	// downloads again while the first keeps serving its copy
+	nodes[1].RepoCacheTTL = map[string]caddy.Duration{"user/site": caddy.Duration(time.Nanosecond)}
+	done := make(chan error)
+	go func() {
+		done <- nodes[1].updateRepoCache(context.Background(), "user", "site", "main")
+	}()
+
+	for refreshing := true; refreshing; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("Refresh failed: %v", err)
+			}
+			refreshing = false
+		default:
+		}
+		for name, content := range files {
+			w := helper.MakeHTTPRequest("GET", "/user/site/"+name, "", nil)
+			if w.Code != http.StatusOK || w.Body.String() != content {
+				t.Fatalf("Expected %s complete during the refresh, got %d and %d bytes", name, w.Code, w.Body.Len())
+			}
+		}
+	}
+
+	if nodes[0].cache.repos["user/site:main"].path == nodes[1].cache.repos["user/site:main"].path {
+		t.Error("Expected the refresh extracted aside from the copy being served")
+	}
+}
+
+func TestServeHTTP_SharedCacheEviction(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Files:         map[string]string{"index.html": "<h1>shared</h1>"},
+		},
+	})
+
+	var nodes []*GitteaPages
+	for i := 0; i < 2; i++ {
+		gp := helper.SetupGiteaPages(GitteaPagesConfig{
+			GitteaURL: helper.server.URL,
+		})
+		gp.SharedCache = true
+		gp.sharedRemoveDelay = 20 * time.Millisecond
+		nodes = append(nodes, gp)
+	}
+	for _, gp := range nodes {
+		helper.gp = gp
+		helper.AssertResponse(helper.MakeHTTPRequest("GET", "/user/site/", "", nil), http.StatusOK, "<h1>shared</h1>")
+	}
+	nodes[1].cache.mu.RLock()
+	adopted := nodes[1].cache.repos["user/site:main"]
+	nodes[1].cache.mu.RUnlock()
+
+	// The first node evicts the entry the second one adopted
+	nodes[0].evictCacheEntry("user/site:main")
+	if _, err := os.Stat(filepath.Join(adopted.path, "index.html")); err != nil {
+		t.Fatalf("Expected the files kept for other nodes after the eviction: %v", err)
+	}
+
+	// The second node notices the withdrawn index and downloads again
+	helper.gp = nodes[1]
+	helper.AssertResponse(helper.MakeHTTPRequest("GET", "/user/site/", "", nil), http.StatusOK, "<h1>shared</h1>")
+	nodes[1].cache.mu.RLock()
+	current := nodes[1].cache.repos["user/site:main"]
+	nodes[1].cache.mu.RUnlock()
+	if current == adopted {
+		t.Error("Expected the withdrawn entry dropped")
+	}
+	if _, archive := helper.UpstreamCalls(); archive != 2 {
+		t.Errorf("Expected the entry downloaded again, got %d archive downloads", archive)
+	}
+
+	// The delayed removal spares files a new download has taken over
+	time.Sleep(100 * time.Millisecond)
+	helper.AssertResponse(helper.MakeHTTPRequest("GET", "/user/site/", "", nil), http.StatusOK, "<h1>shared</h1>")
+	if _, err := os.Stat(filepath.Join(current.path, "index.html")); err != nil {
+		t.Errorf("Expected the current files kept: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package giteapages
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// snapshotSeparator joins an entry path and the commit it holds. It is
+// always escaped by cacheSegment, so a snapshot can never be mistaken for
+// the entry of a branch with a similar name.
+const snapshotSeparator = "#"
+
+// partialSuffix marks a snapshot that is still being extracted
+const partialSuffix = ".partial"
+
+// stagingPath returns a fresh directory to extract the next snapshot of
+// the entry at base into
+func stagingPath(base string) string {
+	return base + snapshotSeparator + strconv.FormatInt(time.Now().UnixNano(), 36) + partialSuffix
+}
+
+// snapshotEntries reports whether refreshes are extracted aside and renamed
+// into place: with SnapshotByCommit, and always with SharedCache, whose
+// readers on other nodes must never see an entry being extracted
+func (gp *GitteaPages) snapshotEntries() bool {
+	return gp.SnapshotByCommit || gp.SharedCache
+}
+
+// publishSnapshot moves a fully extracted snapshot into place under the
+// commit it was built from and returns its path. Archives without a commit
+// ID are keyed by time instead.
+func publishSnapshot(base, staging, commit string) (string, error) {
+	id := commit
+	if id == "" {
+		id = strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	snapshot := base + snapshotSeparator + cacheSegment(id)
+
+	// The same commit extracted again, e.g. to repair a damaged copy,
+	// replaces the old one
+	if err := os.RemoveAll(snapshot); err != nil {
+		return "", err
+	}
+	if err := os.Rename(staging, snapshot); err != nil {
+		return "", err
+	}
+	return snapshot, nil
+}
+
+// pruneSnapshots removes the snapshots of the entry at base except those
+// in keep. Snapshots still being extracted are left alone.
+func (gp *GitteaPages) pruneSnapshots(base string, keep ...string) {
+	dirents, err := os.ReadDir(filepath.Dir(base))
+	if err != nil {
+		return
+	}
+
+	prefix := filepath.Base(base) + snapshotSeparator
+	for _, d := range dirents {
+		name := d.Name()
+		if !strings.HasPrefix(name, prefix) || strings.HasSuffix(name, partialSuffix) {
+			continue
+		}
+		p := filepath.Join(filepath.Dir(base), name)
+		kept := false
+		for _, k := range keep {
+			if p == k {
+				kept = true
+			}
+		}
+		if kept {
+			continue
+		}
+		if err := os.RemoveAll(p); err != nil {
+			gp.logger.Warn("failed to remove old snapshot",
+				zap.String("path", p),
+				zap.Error(err))
+		}
+	}
+}
+
+// latestSnapshot returns the most recently published snapshot of the entry
+// at base, or "" when there is none
+func latestSnapshot(base string) string {
+	dirents, err := os.ReadDir(filepath.Dir(base))
+	if err != nil {
+		return ""
+	}
+
+	prefix := filepath.Base(base) + snapshotSeparator
+	var latest string
+	var latestTime time.Time
+	for _, d := range dirents {
+		name := d.Name()
+		if !d.IsDir() || !strings.HasPrefix(name, prefix) || strings.HasSuffix(name, partialSuffix) {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			continue
+		}
+		if latest == "" || info.ModTime().After(latestTime) {
+			latest = filepath.Join(filepath.Dir(base), name)
+			latestTime = info.ModTime()
+		}
+	}
+	return latest
+}
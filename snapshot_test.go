@@ -0,0 +1,162 @@
+package giteapages
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestSnapshotByCommit(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	const commitA = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	const commitB = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	repos := map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Commit:        commitA,
+			Files: map[string]string{
+				"index.html": "<script src=app.js></script> version A",
+				"app.js":     "console.log('version A')",
+			},
+		},
+	}
+	helper.CreateMockGiteaServer(repos)
+
+	gp := &GitteaPages{
+		GitteaURL:        helper.server.URL,
+		CacheDir:         t.TempDir(),
+		CacheTTL:         caddy.Duration(time.Hour),
+		SnapshotByCommit: true,
+	}
+	if err := gp.Provision(caddy.Context{}); err != nil {
+		t.Fatalf("Failed to provision: %v", err)
+	}
+	helper.gp = gp
+
+	// versionOf checks a response is whole and returns the version it
+	// carries, failing when the header and body disagree
+	versionOf := func(w *httptest.ResponseRecorder) string {
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", w.Code)
+			return ""
+		}
+		body := w.Body.String()
+		commit := w.Header().Get("X-Pages-Commit")
+		switch {
+		case commit == commitA && strings.Contains(body, "version A"):
+			return "A"
+		case commit == commitB && strings.Contains(body, "version B"):
+			return "B"
+		}
+		t.Errorf("Commit %q does not match body %q", commit, body)
+		return ""
+	}
+
+	t.Run("page and assets share a commit", func(t *testing.T) {
+		for _, p := range []string{"/user/site/", "/user/site/app.js"} {
+			w := helper.MakeHTTPRequest("GET", p, "", nil)
+			if v := versionOf(w); v != "A" {
+				t.Errorf("%s: expected version A, got %q", p, v)
+			}
+		}
+	})
+
+	t.Run("refresh in flight does not mix versions", func(t *testing.T) {
+		gp.cache.mu.RLock()
+		before := gp.cache.repos["user/site:main"]
+		gp.cache.mu.RUnlock()
+
+		repos["user/site"] = MockRepo{
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Commit:        commitB,
+			Files: map[string]string{
+				"index.html": "<script src=app.js></script> version B",
+				"app.js":     "console.log('version B')",
+			},
+		}
+		helper.SetUpstreamDelay(50 * time.Millisecond)
+		defer helper.SetUpstreamDelay(0)
+
+		done := make(chan error, 1)
+		go func() {
+			done <- gp.updateRepoCache(context.Background(), "user", "site", "main")
+		}()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				p := "/user/site/app.js"
+				if i%2 == 0 {
+					p = "/user/site/"
+				}
+				w := helper.MakeHTTPRequest("GET", p, "", nil)
+				versionOf(w)
+			}(i)
+			time.Sleep(5 * time.Millisecond)
+		}
+		wg.Wait()
+		if err := <-done; err != nil {
+			t.Fatalf("Refresh failed: %v", err)
+		}
+
+		w := helper.MakeHTTPRequest("GET", "/user/site/app.js", "", nil)
+		if v := versionOf(w); v != "B" {
+			t.Errorf("Expected version B after the refresh, got %q", v)
+		}
+
+		// A page rendered from the old snapshot can still load its assets
+		data, err := os.ReadFile(filepath.Join(before.path, "app.js"))
+		if err != nil || !strings.Contains(string(data), "version A") {
+			t.Errorf("Expected previous snapshot to be kept, got %q, %v", data, err)
+		}
+	})
+
+	t.Run("older snapshots are pruned", func(t *testing.T) {
+		repo := repos["user/site"]
+		repo.Commit = "cccccccccccccccccccccccccccccccccccccccc"
+		repos["user/site"] = repo
+		if err := gp.updateRepoCache(context.Background(), "user", "site", "main"); err != nil {
+			t.Fatalf("Refresh failed: %v", err)
+		}
+
+		base := gp.cache.entryPath("user", "site", "main")
+		matches, err := filepath.Glob(filepath.Join(filepath.Dir(base), "*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var snapshots []string
+		for _, m := range matches {
+			if strings.HasPrefix(filepath.Base(m), filepath.Base(base)+snapshotSeparator) {
+				snapshots = append(snapshots, filepath.Base(m))
+			}
+		}
+		if len(snapshots) != 2 {
+			t.Errorf("Expected current and previous snapshot, got %v", snapshots)
+		}
+	})
+}
+
+func TestSnapshotSeparatorEscaped(t *testing.T) {
+	c := &repoCache{cacheDir: "/cache"}
+	branch := c.entryPath("user", "site", "main#"+strings.Repeat("a", 40))
+	snapshot := c.entryPath("user", "site", "main") + snapshotSeparator + strings.Repeat("a", 40)
+	if branch == snapshot {
+		t.Errorf("Branch %q collides with a snapshot directory", branch)
+	}
+}
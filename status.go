@@ -0,0 +1,141 @@
+package giteapages
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// deploymentStatus is the build-info document served by the status endpoint
+type deploymentStatus struct {
+	Owner      string    `json:"owner"`
+	Repository string    `json:"repository"`
+	Branch     string    `json:"branch"`
+	Commit     string    `json:"commit,omitempty"`
+	Pinned     bool      `json:"pinned,omitempty"`
+	ETag       string    `json:"etag,omitempty"`
+	LastUpdate time.Time `json:"last_update"`
+	AgeSeconds int64     `json:"age_seconds"`
+}
+
+// serveStatus reports which commit is currently served for a repository.
+// Requests take the form {status_path}/{owner}/{repo}?branch=...&format=svg
+// and answer with JSON by default or an SVG badge when format=svg.
+func (gp *GitteaPages) serveStatus(w http.ResponseWriter, r *http.Request) error {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, gp.StatusPath), "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		http.Error(w, "expected /{owner}/{repo}", http.StatusBadRequest)
+		return nil
+	}
+	owner, repo := parts[0], parts[1]
+
+	branch := r.URL.Query().Get("branch")
+	if branch == "" {
+		branch = gp.DefaultBranch
+	}
+
+	// A commit pin is what is served whatever the branch, as in serveMatch
+	ref := branch
+	pinned := gp.pinnedCommit(owner, repo)
+	if pinned != "" {
+		ref = pinned
+	}
+
+	cacheKey := fmt.Sprintf("%s/%s:%s", owner, repo, ref)
+	gp.cache.mu.RLock()
+	entry, exists := gp.cache.repos[cacheKey]
+	gp.cache.mu.RUnlock()
+	if _, reachable := gp.requestToken(r, owner, repo); !reachable {
+		// Not reported outside its mapped domain either
+		exists = false
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+
+	var commit, etag string
+	if exists {
+		commit, etag = entry.commit, entry.etag
+		if pinned != "" {
+			commit = pinned
+		}
+	}
+
+	if r.URL.Query().Get("format") == "svg" {
+		message, color := "not deployed", "#9f9f9f"
+		if exists {
+			message = fmt.Sprintf("%s · %s ago", shortCommit(commit, etag), formatAge(time.Since(entry.lastUpdate)))
+			color = "#4c1"
+		}
+		w.Header().Set("Content-Type", "image/svg+xml; charset=utf-8")
+		_, err := w.Write([]byte(renderBadge("pages", message, color)))
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return json.NewEncoder(w).Encode(map[string]string{"error": "repository not cached"})
+	}
+
+	return json.NewEncoder(w).Encode(deploymentStatus{
+		Owner:      owner,
+		Repository: repo,
+		Branch:     branch,
+		Commit:     commit,
+		Pinned:     pinned != "",
+		ETag:       etag,
+		LastUpdate: entry.lastUpdate.UTC(),
+		AgeSeconds: int64(time.Since(entry.lastUpdate).Seconds()),
+	})
+}
+
+// shortCommit returns an abbreviated identifier for the served content
+func shortCommit(commit, etag string) string {
+	id := commit
+	if id == "" {
+		id = strings.Trim(etag, `W/"`)
+	}
+	if id == "" {
+		return "unknown"
+	}
+	if len(id) > 7 {
+		id = id[:7]
+	}
+	return id
+}
+
+// formatAge renders a duration in the coarse units badges usually show
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
+
+// renderBadge produces a flat two-part badge in the familiar shields style
+func renderBadge(label, message, color string) string {
+	// Rough width estimate: ~7px per character plus padding
+	labelWidth := 7*len(label) + 10
+	messageWidth := 7*len([]rune(message)) + 10
+	total := labelWidth + messageWidth
+
+	label, message = html.EscapeString(label), html.EscapeString(message)
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20" role="img" aria-label="%s: %s">`+
+		`<rect width="%d" height="20" fill="#555"/>`+
+		`<rect x="%d" width="%d" height="20" fill="%s"/>`+
+		`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,sans-serif" font-size="11">`+
+		`<text x="%d" y="14">%s</text><text x="%d" y="14">%s</text></g></svg>`,
+		total, label, message,
+		labelWidth,
+		labelWidth, messageWidth, color,
+		labelWidth/2, label, labelWidth+messageWidth/2, message)
+}
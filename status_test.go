@@ -0,0 +1,116 @@
+package giteapages
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeStatus(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	repos := GenerateTestRepos()
+	helper.CreateMockGiteaServer(repos)
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.StatusPath = "/_pages/status"
+
+	if err := gp.updateRepoCache(context.Background(), "user", "website", "main"); err != nil {
+		t.Fatalf("updateRepoCache failed: %v", err)
+	}
+
+	// Age the entry so the reported age is observable
+	gp.cache.mu.Lock()
+	gp.cache.repos["user/website:main"].lastUpdate = time.Now().Add(-90 * time.Second)
+	gp.cache.mu.Unlock()
+
+	t.Run("json", func(t *testing.T) {
+		w := helper.MakeHTTPRequest("GET", "/_pages/status/user/website", "", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Expected Content-Type 'application/json', got '%s'", ct)
+		}
+
+		var status deploymentStatus
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+			t.Fatalf("Failed to decode status: %v", err)
+		}
+		if status.Commit != repos["user/website"].Commit {
+			t.Errorf("Expected commit '%s', got '%s'", repos["user/website"].Commit, status.Commit)
+		}
+		if status.Branch != "main" {
+			t.Errorf("Expected branch 'main', got '%s'", status.Branch)
+		}
+		if status.AgeSeconds < 90 || status.AgeSeconds > 120 {
+			t.Errorf("Expected age around 90s, got %ds", status.AgeSeconds)
+		}
+	})
+
+	t.Run("svg", func(t *testing.T) {
+		w := helper.MakeHTTPRequest("GET", "/_pages/status/user/website?format=svg", "", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "image/svg+xml") {
+			t.Errorf("Expected SVG content type, got '%s'", ct)
+		}
+		if !strings.Contains(w.Body.String(), "3f9a1c2") {
+			t.Errorf("Expected badge to show short commit, got: %s", w.Body.String())
+		}
+	})
+
+	t.Run("not cached", func(t *testing.T) {
+		w := helper.MakeHTTPRequest("GET", "/_pages/status/org/blog?branch=gh-pages", "", nil)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+		}
+	})
+}
+
+func TestServeStatus_CommitPin(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Files:         map[string]string{"page.html": "<h1>Main</h1>"},
+			Branches: map[string]map[string]string{
+				"aaaaaaa1": {"page.html": "<h1>Release A</h1>"},
+			},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.StatusPath = "/_pages/status"
+	gp.setCommitPin("user/site", "aaaaaaa1")
+
+	helper.AssertResponse(helper.MakeHTTPRequest("GET", "/user/site/page.html", "", nil), http.StatusOK, "Release A")
+
+	w := helper.MakeHTTPRequest("GET", "/_pages/status/user/site", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var status deploymentStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Failed to decode status: %v", err)
+	}
+	if status.Commit != "aaaaaaa1" || !status.Pinned {
+		t.Errorf("Expected the pinned commit reported, got %+v", status)
+	}
+
+	w = helper.MakeHTTPRequest("GET", "/_pages/status/user/site?format=svg", "", nil)
+	if !strings.Contains(w.Body.String(), "aaaaaaa") {
+		t.Errorf("Expected the badge to show the pinned commit, got: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,209 @@
+package giteapages
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// StreamFallback fetches streamed files from Gitea's contents API as well
+// as its raw endpoint, serving whichever answers first and cancelling
+// the other, so one slow endpoint does not hold up the file
+type StreamFallback struct {
+	// After is how long the raw endpoint may take to answer before the
+	// contents API is asked too; zero races both from the start
+	After caddy.Duration `json:"after,omitempty"`
+
+	// MaxSize bounds the files taken from the contents API, which sends
+	// the whole file base64-encoded in one JSON document that has to be
+	// held in memory. Larger files are left to the raw endpoint. Defaults
+	// to 8MB.
+	MaxSize int64 `json:"max_size,omitempty"`
+}
+
+// maxSize returns MaxSize or its default
+func (sf *StreamFallback) maxSize() int64 {
+	if sf.MaxSize > 0 {
+		return sf.MaxSize
+	}
+	return 8 << 20
+}
+
+// errContentsTooLarge is returned by fetchContents for files above
+// StreamFallback.MaxSize
+var errContentsTooLarge = errors.New("file too large for the contents API")
+
+// contentsOverhead allows for the fields of a contents API response
+// besides the file itself
+const contentsOverhead = 4 << 10
+
+// streamResult is the outcome of one way of fetching a streamed file:
+// the raw endpoint's response, or the file decoded from the contents API
+type streamResult struct {
+	resp    *http.Response
+	content []byte
+	sha     string
+	err     error
+}
+
+// contentsFile is the part of a contents API file response that is used
+type contentsFile struct {
+	Type     string `json:"type"`
+	Encoding string `json:"encoding"`
+	Content  string `json:"content"`
+	SHA      string `json:"sha"`
+}
+
+// raceStreamed fetches relPath with rawReq and, after StreamFallback.After
+// or once the raw endpoint fails, from the contents API, returning the
+// first success. The cancel function releases the loser and must be
+// called once the result has been served.
+func (gp *GitteaPages) raceStreamed(r *http.Request, rawReq *http.Request, owner, repo, ref, relPath string) (streamResult, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	rawCtx, cancelRaw := context.WithCancel(ctx)
+	contentsCtx, cancelContents := context.WithCancel(ctx)
+	stop := func() {
+		cancelRaw()
+		cancelContents()
+		cancel()
+	}
+
+	results := make(chan streamResult, 2)
+	go func() {
+		resp, err := gp.fetchRaw(rawReq.WithContext(rawCtx))
+		results <- streamResult{resp: resp, err: err}
+	}()
+	pending := 1
+	contentsStarted := false
+	startContents := func() {
+		contentsStarted = true
+		pending++
+		go func() {
+			content, sha, err := gp.fetchContents(contentsCtx, owner, repo, ref, relPath)
+			results <- streamResult{content: content, sha: sha, err: err}
+		}()
+	}
+	// Whatever arrives after the winner is released in the background
+	release := func() {
+		go func(n int) {
+			for ; n > 0; n-- {
+				if res := <-results; res.resp != nil {
+					res.resp.Body.Close()
+				}
+			}
+		}(pending)
+	}
+
+	after := time.Duration(gp.StreamFallback.After)
+	if after == 0 {
+		startContents()
+	}
+	timer := time.NewTimer(after)
+	defer timer.Stop()
+
+	var firstErr error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if !contentsStarted {
+				startContents()
+			}
+		case res := <-results:
+			pending--
+			if res.err != nil {
+				if firstErr == nil {
+					firstErr = res.err
+				}
+				if !contentsStarted {
+					startContents()
+				}
+				continue
+			}
+			if res.resp != nil {
+				cancelContents()
+			} else {
+				cancelRaw()
+			}
+			release()
+			return res, stop
+		}
+	}
+	return streamResult{err: firstErr}, stop
+}
+
+// fetchContents fetches relPath at ref from the contents API, returning
+// the decoded file and its blob SHA
+func (gp *GitteaPages) fetchContents(ctx context.Context, owner, repo, ref, relPath string) ([]byte, string, error) {
+	apiURL := fmt.Sprintf("%s/api/v1/repos/%s/%s/contents/%s?ref=%s",
+		strings.TrimRight(gp.GitteaURL, "/"), url.PathEscape(owner), url.PathEscape(repo),
+		escapePath(relPath), url.QueryEscape(ref))
+
+	req, err := gp.newUpstreamRequest(ctx, apiURL)
+	if err != nil {
+		return nil, "", err
+	}
+	client := &http.Client{Timeout: 5 * time.Minute, Transport: gp.upstreamTransport()}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch contents: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusTooManyRequests:
+		return nil, "", gp.rateLimited(resp)
+	default:
+		return nil, "", fmt.Errorf("failed to fetch contents: status %d", resp.StatusCode)
+	}
+
+	// Read no more than a file of MaxSize takes, so a larger one is
+	// given up on rather than held in memory
+	maxSize := gp.StreamFallback.maxSize()
+	limit := int64(base64.StdEncoding.EncodedLen(int(maxSize))) + contentsOverhead
+	if resp.ContentLength > limit {
+		return nil, "", errContentsTooLarge
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch contents: %w", err)
+	}
+	if int64(len(body)) > limit {
+		return nil, "", errContentsTooLarge
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	var file contentsFile
+	if err := gp.decodeJSON(resp, &file); err != nil {
+		return nil, "", err
+	}
+	if file.Type != "file" || file.Encoding != "base64" {
+		return nil, "", fmt.Errorf("failed to fetch contents: %s is not a file", relPath)
+	}
+	content, err := base64.StdEncoding.DecodeString(file.Content)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode contents: %w", err)
+	}
+	if int64(len(content)) > maxSize {
+		return nil, "", errContentsTooLarge
+	}
+	return content, file.SHA, nil
+}
+
+// serveContentsResult serves a streamed file fetched from the contents API
+func serveContentsResult(w http.ResponseWriter, r *http.Request, relPath string, res streamResult) {
+	if w.Header().Get("ETag") == "" && res.sha != "" {
+		w.Header().Set("ETag", `"`+res.sha+`"`)
+	}
+	serveMemoryFile(w, r, path.Base(relPath), time.Time{}, res.content)
+}
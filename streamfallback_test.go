@@ -0,0 +1,179 @@
+package giteapages
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_StreamFallback(t *testing.T) {
+	large := strings.Repeat("L", 2048)
+
+	tests := []struct {
+		name      string
+		after     time.Duration
+		stall     string
+		maxRaw    int
+		cancelled int64
+	}{
+		{"raw stalls past the deadline", 50 * time.Millisecond, "raw", 0, 1},
+		{"contents stalls in a race", 0, "contents", 0, 1},
+		{"raw fails before the deadline", 10 * time.Second, "", 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helper := NewTestHelper(t)
+			defer helper.Cleanup()
+
+			helper.CreateMockGiteaServer(map[string]MockRepo{
+				"user/site": {
+					Name:          "site",
+					FullName:      "user/site",
+					DefaultBranch: "main",
+					Files:         map[string]string{"large.bin": large},
+				},
+			})
+			gp := helper.SetupGiteaPages(GitteaPagesConfig{
+				GitteaURL: helper.server.URL,
+			})
+			gp.CacheMaxSize = 1024
+			gp.StreamFallback = &StreamFallback{After: caddy.Duration(tt.after)}
+
+			// Cache the archive first so only the file fetch is timed
+			helper.MakeHTTPRequest("GET", "/user/site/missing.txt", "", nil)
+			if tt.stall != "" {
+				helper.SetEndpointDelay(tt.stall, 5*time.Second)
+			}
+			helper.maxRawPath = tt.maxRaw
+
+			start := time.Now()
+			w := helper.MakeHTTPRequest("GET", "/user/site/large.bin", "", nil)
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("Expected the file within a second, took %v", elapsed)
+			}
+			if w.Code != http.StatusOK || w.Body.String() != large {
+				t.Fatalf("Expected the streamed file, got %d with %d bytes", w.Code, w.Body.Len())
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/octet-stream" {
+				t.Errorf("Expected application/octet-stream, got %q", ct)
+			}
+
+			deadline := time.Now().Add(2 * time.Second)
+			for helper.CancelledCalls() < tt.cancelled && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if got := helper.CancelledCalls(); got != tt.cancelled {
+				t.Errorf("Expected %d cancelled upstream requests, got %d", tt.cancelled, got)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_StreamFallbackMaxSize(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	large := strings.Repeat("L", 2048)
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Files:         map[string]string{"large.bin": large},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.CacheMaxSize = 1024
+	gp.StreamFallback = &StreamFallback{MaxSize: 1024}
+
+	helper.MakeHTTPRequest("GET", "/user/site/missing.txt", "", nil)
+	stall := 300 * time.Millisecond
+	helper.SetEndpointDelay("raw", stall)
+
+	// The file is too large for the contents API, so the slow raw
+	// endpoint is waited for
+	start := time.Now()
+	w := helper.MakeHTTPRequest("GET", "/user/site/large.bin", "", nil)
+	if w.Code != http.StatusOK || w.Body.String() != large {
+		t.Fatalf("Expected the streamed file, got %d with %d bytes", w.Code, w.Body.Len())
+	}
+	if elapsed := time.Since(start); elapsed < stall {
+		t.Errorf("Expected the raw endpoint's answer, got one after %v", elapsed)
+	}
+}
+
+func TestServeHTTP_StreamFallbackBothFail(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	files := map[string]string{"large.bin": strings.Repeat("L", 2048)}
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Files:         files,
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.CacheMaxSize = 1024
+	gp.StreamFallback = &StreamFallback{}
+
+	helper.MakeHTTPRequest("GET", "/user/site/missing.txt", "", nil)
+
+	// Remove the file upstream after it was cached as streamed
+	delete(files, "large.bin")
+
+	w := helper.MakeHTTPRequest("GET", "/user/site/large.bin", "", nil)
+	if w.Code == http.StatusOK {
+		t.Errorf("Expected a failure when both endpoints fail, got %d", w.Code)
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_StreamFallback(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		stream_fallback 250ms
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.StreamFallback == nil || time.Duration(gp.StreamFallback.After) != 250*time.Millisecond {
+		t.Errorf("Unexpected stream_fallback %+v", gp.StreamFallback)
+	}
+
+	d = caddyfile.NewTestDispenser(`gitea_pages {
+		stream_fallback
+	}`)
+	gp = GitteaPages{}
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.StreamFallback == nil || gp.StreamFallback.After != 0 {
+		t.Errorf("Expected a race from the start, got %+v", gp.StreamFallback)
+	}
+
+	d = caddyfile.NewTestDispenser(`gitea_pages {
+		stream_fallback 1s {
+			max_size 2MB
+		}
+	}`)
+	gp = GitteaPages{}
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.StreamFallback == nil || gp.StreamFallback.MaxSize != 2000000 {
+		t.Errorf("Unexpected stream_fallback %+v", gp.StreamFallback)
+	}
+}
@@ -0,0 +1,110 @@
+package giteapages
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// defaultSurrogateControl lets a CDN keep pages for a day; pushes purge
+// them sooner when PurgeURL is set
+const defaultSurrogateControl = "max-age=86400"
+
+// SurrogateControl emits Surrogate-Control and Surrogate-Key headers so
+// a fronting CDN caches pages apart from browsers and can purge them by
+// tag. Each response is tagged with its owner, "owner/repo" and
+// "owner/repo:branch".
+type SurrogateControl struct {
+	// Value is the Surrogate-Control header, "max-age=86400" by default
+	Value string `json:"value,omitempty"`
+
+	// PurgeURL is POSTed with the pushed branch's "owner/repo:branch"
+	// key in a Surrogate-Key header after a push webhook refreshes it,
+	// as CDN purge-by-key APIs expect
+	PurgeURL string `json:"purge_url,omitempty"`
+
+	// PurgeHeaders are added to purge requests, e.g. an API token
+	PurgeHeaders map[string]string `json:"purge_headers,omitempty"`
+}
+
+// validate checks PurgeURL
+func (sc *SurrogateControl) validate() error {
+	if sc.PurgeURL == "" {
+		return nil
+	}
+	u, err := url.Parse(sc.PurgeURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("surrogate_control purge_url must be an http or https URL, got %q", sc.PurgeURL)
+	}
+	return nil
+}
+
+// surrogateKeys returns the tags a page of owner/repo at branch carries
+func surrogateKeys(owner, repo, branch string) []string {
+	return []string{owner, owner + "/" + repo, owner + "/" + repo + ":" + branch}
+}
+
+// setSurrogateHeaders tags a response from owner/repo at branch
+func (gp *GitteaPages) setSurrogateHeaders(w http.ResponseWriter, owner, repo, branch string) {
+	sc := gp.SurrogateControl
+	if sc == nil {
+		return
+	}
+	value := sc.Value
+	if value == "" {
+		value = defaultSurrogateControl
+	}
+	w.Header().Set("Surrogate-Control", value)
+	w.Header().Set("Surrogate-Key", strings.Join(surrogateKeys(owner, repo, branch), " "))
+}
+
+// purgeSurrogateKey asks the CDN at PurgeURL to drop every page tagged
+// with owner/repo:branch
+func (gp *GitteaPages) purgeSurrogateKey(ctx context.Context, owner, repo, branch string) {
+	sc := gp.SurrogateControl
+	if sc == nil || sc.PurgeURL == "" {
+		return
+	}
+	key := fmt.Sprintf("%s/%s:%s", owner, repo, branch)
+	if err := gp.postPurge(ctx, key); err != nil {
+		gp.logger.Warn("failed to purge CDN",
+			zap.String("surrogate_key", key),
+			zap.Error(err))
+	}
+}
+
+// postPurge sends one purge request, treating any non-2xx response as a
+// failure
+func (gp *GitteaPages) postPurge(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	sc := gp.SurrogateControl
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sc.PurgeURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Surrogate-Key", key)
+	for name, value := range sc.PurgeHeaders {
+		req.Header.Set(name, value)
+	}
+	if gp.UserAgent != "" {
+		req.Header.Set("User-Agent", gp.UserAgent)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("purge returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package giteapages
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_SurrogateHeaders(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: "https://git.example.com",
+	})
+	helper.CreateCacheEntry("user/site", "main", map[string]string{
+		"page.html": "<h1>Page</h1>",
+	})
+
+	w := helper.MakeHTTPRequest("GET", "/user/site/page.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "Page")
+	if got := w.Header().Get("Surrogate-Key"); got != "" {
+		t.Errorf("Expected no Surrogate-Key by default, got %q", got)
+	}
+
+	gp.SurrogateControl = &SurrogateControl{}
+	w = helper.MakeHTTPRequest("GET", "/user/site/page.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "Page")
+	if got := w.Header().Get("Surrogate-Key"); got != "user user/site user/site:main" {
+		t.Errorf("Unexpected Surrogate-Key %q", got)
+	}
+	if got := w.Header().Get("Surrogate-Control"); got != defaultSurrogateControl {
+		t.Errorf("Expected Surrogate-Control %q, got %q", defaultSurrogateControl, got)
+	}
+
+	gp.SurrogateControl.Value = "max-age=600"
+	w = helper.MakeHTTPRequest("GET", "/user/site/page.html", "", nil)
+	if got := w.Header().Get("Surrogate-Control"); got != "max-age=600" {
+		t.Errorf("Expected configured Surrogate-Control, got %q", got)
+	}
+}
+
+func TestServeHTTP_WebhookPurgesSurrogateKey(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	var mu sync.Mutex
+	var purged []http.Header
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		purged = append(purged, r.Header.Clone())
+		mu.Unlock()
+	}))
+	defer cdn.Close()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Files:         map[string]string{"page.html": "<h1>Page</h1>"},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.WebhookPath = "/_hooks/gitea"
+	gp.WebhookSecret = testWebhookSecret
+	gp.SurrogateControl = &SurrogateControl{
+		PurgeURL:     cdn.URL + "/purge",
+		PurgeHeaders: map[string]string{"Fastly-Key": "token"},
+	}
+
+	w := httptest.NewRecorder()
+	if err := gp.ServeHTTP(w, signedPush(t, "push", "refs/heads/main", testWebhookSecret), nil); err != nil {
+		t.Fatalf("ServeHTTP failed: %v", err)
+	}
+	gp.webhooks.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(purged) != 1 {
+		t.Fatalf("Expected one purge request, got %d", len(purged))
+	}
+	if got := purged[0].Get("Surrogate-Key"); got != "user/site:main" {
+		t.Errorf("Expected purge of user/site:main, got %q", got)
+	}
+	if got := purged[0].Get("Fastly-Key"); got != "token" {
+		t.Errorf("Expected the configured purge header, got %q", got)
+	}
+
+	// The purged tag is one every page of the branch carries
+	w = helper.MakeHTTPRequest("GET", "/user/site/page.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "Page")
+	if got := w.Header().Get("Surrogate-Key"); got != "user user/site user/site:main" {
+		t.Errorf("Unexpected Surrogate-Key %q", got)
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_SurrogateControl(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		surrogate_control "max-age=3600, stale-while-revalidate=60" {
+			purge_url https://api.fastly.com/service/abc/purge
+			purge_header Fastly-Key token
+		}
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	sc := gp.SurrogateControl
+	if sc == nil || sc.Value != "max-age=3600, stale-while-revalidate=60" || sc.PurgeHeaders["Fastly-Key"] != "token" {
+		t.Fatalf("Unexpected surrogate_control %+v", sc)
+	}
+	if err := gp.Validate(); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+
+	sc.PurgeURL = "ftp://cdn.example.com"
+	if err := gp.Validate(); err == nil {
+		t.Error("Expected a non-HTTP purge_url to be rejected")
+	}
+}
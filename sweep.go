@@ -0,0 +1,125 @@
+package giteapages
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// accessTimes records when each cache entry was last served, so CacheMaxIdle
+// can reclaim entries nobody asks for. It is kept apart from the entries
+// themselves because a refresh replaces the entry.
+type accessTimes struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func newAccessTimes() *accessTimes {
+	return &accessTimes{last: make(map[string]time.Time)}
+}
+
+// touch records an access to cacheKey at now
+func (a *accessTimes) touch(cacheKey string, now time.Time) {
+	a.mu.Lock()
+	a.last[cacheKey] = now
+	a.mu.Unlock()
+}
+
+// idleSince reports when cacheKey was last accessed. An entry never seen
+// before, e.g. restored on startup or warmed in the background, counts as
+// accessed now.
+func (a *accessTimes) idleSince(cacheKey string, now time.Time) time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	last, ok := a.last[cacheKey]
+	if !ok {
+		a.last[cacheKey] = now
+		return now
+	}
+	return last
+}
+
+// forget drops the access time of an entry that is gone
+func (a *accessTimes) forget(cacheKey string) {
+	a.mu.Lock()
+	delete(a.last, cacheKey)
+	a.mu.Unlock()
+}
+
+// cacheSweepInterval is how often idle entries are looked for: the
+// configured CacheSweepInterval, or a quarter of CacheMaxIdle kept between
+// a second and a minute
+func (gp *GitteaPages) cacheSweepInterval() time.Duration {
+	if gp.CacheSweepInterval > 0 {
+		return time.Duration(gp.CacheSweepInterval)
+	}
+	return max(min(time.Duration(gp.CacheMaxIdle)/4, time.Minute), time.Second)
+}
+
+// sweepIdleCache periodically removes idle cache entries until stop is
+// closed
+func (gp *GitteaPages) sweepIdleCache(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			gp.sweepIdleEntries(time.Now())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// sweepIdleEntries evicts the entries, files and shared index included,
+// not served within CacheMaxIdle of now. Pinned entries are kept however
+// long they sit idle.
+func (gp *GitteaPages) sweepIdleEntries(now time.Time) {
+	maxIdle := time.Duration(gp.CacheMaxIdle)
+
+	gp.cache.mu.RLock()
+	keys := make([]string, 0, len(gp.cache.repos))
+	for key := range gp.cache.repos {
+		keys = append(keys, key)
+	}
+	gp.cache.mu.RUnlock()
+
+	for _, key := range keys {
+		if gp.isPinned(key) {
+			continue
+		}
+		idle := now.Sub(gp.accessed.idleSince(key, now))
+		if idle < maxIdle {
+			continue
+		}
+		gp.logger.Info("sweeping idle cache entry",
+			zap.String("cache_key", key),
+			zap.Duration("idle", idle))
+		gp.evictCacheEntry(key)
+		gp.accessed.forget(key)
+	}
+}
+
+// lookupPruneInterval is how often expired entries are dropped from the
+// caches filled by client lookups
+const lookupPruneInterval = time.Minute
+
+// pruneLookups periodically drops expired path probes and repository
+// metadata until stop is closed, so lookups nobody repeats do not stay
+// until evicted
+func (gp *GitteaPages) pruneLookups(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			gp.probes.prune(gp, now)
+			gp.metadata.prune(time.Duration(gp.MetadataTTL), now)
+		case <-stop:
+			return
+		}
+	}
+}
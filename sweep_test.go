@@ -0,0 +1,113 @@
+package giteapages
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestSweepIdleEntries(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.CacheTTL = caddy.Duration(24 * time.Hour)
+	gp.CacheMaxIdle = caddy.Duration(time.Hour)
+	gp.Pins = []string{"user/pinned:main"}
+
+	for _, repoKey := range []string{"user/idle", "user/busy", "user/pinned"} {
+		helper.CreateCacheEntry(repoKey, "main", map[string]string{"page.html": repoKey})
+	}
+	paths := make(map[string]string)
+	for key, entry := range gp.cache.repos {
+		paths[key] = entry.path
+	}
+
+	// The first sweep only notices the entries
+	start := time.Now()
+	gp.sweepIdleEntries(start)
+	if len(gp.cache.repos) != 3 {
+		t.Fatalf("Expected all entries kept on first sight, got %d", len(gp.cache.repos))
+	}
+
+	// Only the busy entry is served; serving records the access
+	w := helper.MakeHTTPRequest("GET", "/user/busy/page.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "user/busy")
+	if gp.accessed.idleSince("user/busy:main", start).Before(start) {
+		t.Fatal("Expected serving to record an access")
+	}
+	// Pretend that was half an hour later
+	gp.accessed.touch("user/busy:main", start.Add(30*time.Minute))
+
+	gp.sweepIdleEntries(start.Add(61 * time.Minute))
+
+	if _, ok := gp.cache.repos["user/idle:main"]; ok {
+		t.Error("Expected idle entry to be swept")
+	}
+	if _, err := os.Stat(paths["user/idle:main"]); !os.IsNotExist(err) {
+		t.Errorf("Expected idle entry removed from disk, got %v", err)
+	}
+	for _, key := range []string{"user/busy:main", "user/pinned:main"} {
+		if _, ok := gp.cache.repos[key]; !ok {
+			t.Errorf("Expected %s to be retained", key)
+		}
+		if _, err := os.Stat(paths[key]); err != nil {
+			t.Errorf("Expected %s kept on disk: %v", key, err)
+		}
+	}
+
+	// Idle long enough, the busy entry goes too but the pinned one stays
+	gp.sweepIdleEntries(start.Add(3 * time.Hour))
+	if _, ok := gp.cache.repos["user/busy:main"]; ok {
+		t.Error("Expected busy entry swept once idle")
+	}
+	if _, ok := gp.cache.repos["user/pinned:main"]; !ok {
+		t.Error("Expected pinned entry never swept")
+	}
+}
+
+func TestCacheSweepInterval(t *testing.T) {
+	tests := []struct {
+		maxIdle, interval, want time.Duration
+	}{
+		{time.Hour, 0, time.Minute},
+		{2 * time.Minute, 0, 30 * time.Second},
+		{time.Second, 0, time.Second},
+		{time.Hour, 5 * time.Minute, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		gp := &GitteaPages{CacheMaxIdle: caddy.Duration(tt.maxIdle), CacheSweepInterval: caddy.Duration(tt.interval)}
+		if got := gp.cacheSweepInterval(); got != tt.want {
+			t.Errorf("cacheSweepInterval(%v, %v) = %v, want %v", tt.maxIdle, tt.interval, got, tt.want)
+		}
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_CacheMaxIdle(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		cache_max_idle 72h 10m
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if time.Duration(gp.CacheMaxIdle) != 72*time.Hour || time.Duration(gp.CacheSweepInterval) != 10*time.Minute {
+		t.Errorf("Unexpected cache_max_idle %v / %v", gp.CacheMaxIdle, gp.CacheSweepInterval)
+	}
+
+	d = caddyfile.NewTestDispenser(`gitea_pages {
+		cache_max_idle soon
+	}`)
+	if err := (&GitteaPages{}).UnmarshalCaddyfile(d); err == nil {
+		t.Error("Expected an invalid duration to be rejected")
+	}
+}
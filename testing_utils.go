@@ -7,13 +7,21 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -28,6 +36,49 @@ type TestHelper struct {
 	tempDir string
 	server  *httptest.Server
 	gp      *GitteaPages
+
+	// Upstream request counters for the mock Gitea server
+	apiCalls     atomic.Int64
+	archiveCalls atomic.Int64
+	failArchives atomic.Int64
+	branchCalls  atomic.Int64
+	contentCalls atomic.Int64
+
+	// rateLimits answers that many upstream requests with 429 and
+	// retryAfter as the Retry-After header
+	rateLimits atomic.Int64
+	retryAfter string
+
+	// loginPages answers that many API requests with an HTML login page
+	// and status 200, like a misconfigured proxy in front of Gitea
+	loginPages atomic.Int64
+
+	// maxItems caps list pages, like Gitea's MAX_RESPONSE_ITEMS, and makes
+	// list endpoints send Link headers; zero lists everything on page 1
+	maxItems int
+
+	// maxRawPath makes raw file requests with a longer escaped URL path
+	// fail with 414, like a proxy enforcing a URL length limit
+	maxRawPath int
+
+	// In-flight tracking; upstreamDelay holds each request open so bursts
+	// overlap
+	inFlight      atomic.Int64
+	peakInFlight  atomic.Int64
+	upstreamDelay time.Duration
+
+	// endpointDelays holds requests to one repository endpoint, such as
+	// "raw" or "contents", for that long or until the client gives up,
+	// which cancelledCalls counts
+	endpointDelays map[string]time.Duration
+	cancelledCalls atomic.Int64
+
+	headersMu       sync.Mutex
+	upstreamHeaders []http.Header
+
+	// trustProxies makes MakeHTTPRequest mark requests as coming through
+	// one of Caddy's trusted_proxies
+	trustProxies bool
 }
 
 // NewTestHelper creates a new test helper instance
@@ -47,6 +98,94 @@ func (th *TestHelper) Cleanup() {
 	if th.server != nil {
 		th.server.Close()
 	}
+	if th.gp != nil {
+		th.gp.Cleanup()
+	}
+}
+
+// UpstreamCalls returns the number of API and archive requests the mock
+// Gitea server has received
+func (th *TestHelper) UpstreamCalls() (api, archive int64) {
+	return th.apiCalls.Load(), th.archiveCalls.Load()
+}
+
+// PeakUpstreamConcurrency returns the largest number of requests the mock
+// Gitea server has handled at the same time
+func (th *TestHelper) PeakUpstreamConcurrency() int64 {
+	return th.peakInFlight.Load()
+}
+
+// SetEndpointDelay makes the mock Gitea server hold requests to one
+// repository endpoint, e.g. "raw" or "contents", for d before answering
+func (th *TestHelper) SetEndpointDelay(endpoint string, d time.Duration) {
+	if th.endpointDelays == nil {
+		th.endpointDelays = make(map[string]time.Duration)
+	}
+	th.endpointDelays[endpoint] = d
+}
+
+// CancelledCalls returns the number of delayed requests the client
+// cancelled before the mock Gitea server answered
+func (th *TestHelper) CancelledCalls() int64 {
+	return th.cancelledCalls.Load()
+}
+
+// SetUpstreamDelay makes the mock Gitea server hold every request for d
+// before answering
+func (th *TestHelper) SetUpstreamDelay(d time.Duration) {
+	th.upstreamDelay = d
+}
+
+// BranchCalls returns the number of branch list requests the mock Gitea
+// server has received
+func (th *TestHelper) BranchCalls() int64 {
+	return th.branchCalls.Load()
+}
+
+// FailNextArchives makes the mock Gitea server answer the next n archive
+// requests with 404, as if the archive URL had gone stale
+func (th *TestHelper) FailNextArchives(n int64) {
+	th.failArchives.Store(n)
+}
+
+// RateLimitNext makes the mock Gitea server answer the next n requests
+// with 429 Too Many Requests, sending retryAfter as Retry-After when set
+func (th *TestHelper) RateLimitNext(n int64, retryAfter string) {
+	th.retryAfter = retryAfter
+	th.rateLimits.Store(n)
+}
+
+// ServeLoginPageNext makes the mock Gitea server answer the next n API
+// requests with an HTML login page and status 200
+func (th *TestHelper) ServeLoginPageNext(n int64) {
+	th.loginPages.Store(n)
+}
+
+// LimitRawPathLength makes the mock Gitea server reject raw file requests
+// whose escaped URL path is longer than n bytes
+func (th *TestHelper) LimitRawPathLength(n int) {
+	th.maxRawPath = n
+}
+
+// rateLimit answers r with 429 when a rate limit is pending
+func (th *TestHelper) rateLimit(w http.ResponseWriter) bool {
+	if th.rateLimits.Add(-1) < 0 {
+		th.rateLimits.Store(0)
+		return false
+	}
+	if th.retryAfter != "" {
+		w.Header().Set("Retry-After", th.retryAfter)
+	}
+	w.WriteHeader(http.StatusTooManyRequests)
+	return true
+}
+
+// UpstreamHeaders returns the request headers of every request the mock
+// Gitea server has received, in arrival order
+func (th *TestHelper) UpstreamHeaders() []http.Header {
+	th.headersMu.Lock()
+	defer th.headersMu.Unlock()
+	return append([]http.Header(nil), th.upstreamHeaders...)
 }
 
 // CreateMockGiteaServer creates a mock Gitea server for testing
@@ -66,21 +205,76 @@ type MockRepo struct {
 	Files         map[string]string
 	Private       bool
 	RequireToken  bool
+	Commit        string
+	Size          int64 // KiB, as the Gitea API reports it
+	Description   string
+	Topics        []string
+	// Branches overrides Files for archives of the named branches
+	Branches map[string]map[string]string
+	// StrictBranches answers archives of branches other than the default
+	// and those in Branches with 404, as for a deleted branch
+	StrictBranches bool
+	// Token, when set, is the only credential the repository accepts
+	Token string
 }
 
 func (th *TestHelper) handleMockGiteaRequest(w http.ResponseWriter, r *http.Request, repos map[string]MockRepo) {
-	// Handle API requests
-	if strings.HasPrefix(r.URL.Path, "/api/v1/repos/") {
-		th.handleRepoAPI(w, r, repos)
-		return
+	th.headersMu.Lock()
+	th.upstreamHeaders = append(th.upstreamHeaders, r.Header.Clone())
+	th.headersMu.Unlock()
+
+	current := th.inFlight.Add(1)
+	defer th.inFlight.Add(-1)
+	for {
+		peak := th.peakInFlight.Load()
+		if current <= peak || th.peakInFlight.CompareAndSwap(peak, current) {
+			break
+		}
+	}
+	if th.upstreamDelay > 0 {
+		time.Sleep(th.upstreamDelay)
 	}
 
 	// Handle archive requests
 	if strings.Contains(r.URL.Path, "/archive/") {
+		th.archiveCalls.Add(1)
+		if th.rateLimit(w) {
+			return
+		}
+		if th.failArchives.Add(-1) >= 0 {
+			http.NotFound(w, r)
+			return
+		}
 		th.handleArchiveRequest(w, r, repos)
 		return
 	}
 
+	// Handle API requests
+	if r.URL.Path == "/api/v1/version" {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"version":"1.22.0"}`)
+		return
+	}
+	if owner, ok := strings.CutPrefix(r.URL.Path, "/api/v1/users/"); ok {
+		th.apiCalls.Add(1)
+		th.handleUserReposAPI(w, r, strings.TrimSuffix(owner, "/repos"), repos)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/v1/repos/") {
+		th.apiCalls.Add(1)
+		if th.rateLimit(w) {
+			return
+		}
+		if th.loginPages.Add(-1) >= 0 {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, "<!DOCTYPE html><html><head><title>Sign in</title></head><body><form action=\"/login\">"+
+				strings.Repeat("<input>", 100)+"</form></body></html>")
+			return
+		}
+		th.handleRepoAPI(w, r, repos)
+		return
+	}
+
 	http.NotFound(w, r)
 }
 
@@ -110,6 +304,38 @@ func (th *TestHelper) handleRepoAPI(w http.ResponseWriter, r *http.Request, repo
 			return
 		}
 	}
+	if repo.Token != "" && r.Header.Get("Authorization") != "token "+repo.Token {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if len(parts) > 5 {
+		if d := th.endpointDelays[parts[5]]; d > 0 {
+			select {
+			case <-time.After(d):
+			case <-r.Context().Done():
+				th.cancelledCalls.Add(1)
+				return
+			}
+		}
+		switch parts[5] {
+		case "branches":
+			th.handleBranchesAPI(w, r, repo)
+		case "contents":
+			th.handleContentsAPI(w, r, repo, strings.Join(parts[6:], "/"))
+		case "raw":
+			if th.maxRawPath > 0 && len(r.URL.EscapedPath()) > th.maxRawPath {
+				http.Error(w, "URI too long", http.StatusRequestURITooLong)
+				return
+			}
+			th.handleRawAPI(w, r, repo, strings.Join(parts[6:], "/"))
+		case "git":
+			th.handleGitAPI(w, r, repo, parts[6:])
+		default:
+			http.NotFound(w, r)
+		}
+		return
+	}
 
 	// Return repository info
 	giteaRepo := GitteaRepo{
@@ -117,12 +343,262 @@ func (th *TestHelper) handleRepoAPI(w http.ResponseWriter, r *http.Request, repo
 		FullName:      repo.FullName,
 		DefaultBranch: repo.DefaultBranch,
 		UpdatedAt:     time.Now().Format(time.RFC3339),
+		Size:          repo.Size,
+		Description:   repo.Description,
+		Topics:        repo.Topics,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(giteaRepo)
 }
 
+// handleUserReposAPI lists the mock repositories of owner on the first
+// page
+func (th *TestHelper) handleUserReposAPI(w http.ResponseWriter, r *http.Request, owner string, repos map[string]MockRepo) {
+	type listedRepo struct {
+		GitteaRepo
+		Private bool `json:"private"`
+	}
+	list := []listedRepo{}
+	for key, repo := range repos {
+		if strings.HasPrefix(key, owner+"/") {
+			list = append(list, listedRepo{
+				GitteaRepo: GitteaRepo{Name: repo.Name, FullName: repo.FullName, DefaultBranch: repo.DefaultBranch},
+				Private:    repo.Private,
+			})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	start, end := th.pageBounds(w, r, len(list))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list[start:end])
+}
+
+// handleBranchesAPI lists the default branch followed by any extra
+// branches of the mock repo, in name order
+func (th *TestHelper) handleBranchesAPI(w http.ResponseWriter, r *http.Request, repo MockRepo) {
+	th.branchCalls.Add(1)
+
+	type branch struct {
+		Name   string `json:"name"`
+		Commit struct {
+			ID string `json:"id"`
+		} `json:"commit"`
+	}
+
+	names := []string{repo.DefaultBranch}
+	extra := make([]string, 0, len(repo.Branches))
+	for name := range repo.Branches {
+		if name != repo.DefaultBranch {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	names = append(names, extra...)
+
+	var branches []branch
+	for _, name := range names {
+		b := branch{Name: name}
+		if name == repo.DefaultBranch {
+			b.Commit.ID = repo.Commit
+		}
+		branches = append(branches, b)
+	}
+
+	start, end := th.pageBounds(w, r, len(branches))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(branches[start:end])
+}
+
+// pageBounds returns the slice of n list items on the requested page.
+// Without maxItems everything is on page 1. With it, pages hold at most
+// maxItems items and a Link header points at the next and last pages
+// under an unrelated ROOT_URL, as Gitea builds them.
+func (th *TestHelper) pageBounds(w http.ResponseWriter, r *http.Request, n int) (start, end int) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	if th.maxItems == 0 {
+		if page == 1 {
+			return 0, n
+		}
+		return 0, 0
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > th.maxItems {
+		limit = th.maxItems
+	}
+	start = min((page-1)*limit, n)
+	end = min(start+limit, n)
+
+	link := func(p int, rel string) string {
+		q := r.URL.Query()
+		q.Set("page", strconv.Itoa(p))
+		q.Set("limit", strconv.Itoa(limit))
+		return fmt.Sprintf(`<https://gitea.example.com%s?%s>; rel="%s"`, r.URL.Path, q.Encode(), rel)
+	}
+	last := max((n+limit-1)/limit, 1)
+	links := []string{}
+	if page < last {
+		links = append(links, link(page+1, "next"), link(last, "last"))
+	}
+	if page > 1 {
+		links = append(links, link(1, "first"), link(page-1, "prev"))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ","))
+	}
+	return start, end
+}
+
+// handleRawAPI serves a file's content on the requested ref, which may be
+// a branch or the repository's commit
+func (th *TestHelper) handleRawAPI(w http.ResponseWriter, r *http.Request, repo MockRepo, filePath string) {
+	files := repo.Files
+	if ref := r.URL.Query().Get("ref"); ref != "" && ref != repo.DefaultBranch && ref != repo.Commit {
+		var ok bool
+		if files, ok = repo.Branches[ref]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	content, ok := files[filePath]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, path.Base(filePath), time.Time{}, strings.NewReader(content))
+}
+
+// handleGitAPI serves the git trees and blobs endpoints. Trees list every
+// file of a ref on one page; blobs are looked up by their git blob SHA
+// across all refs.
+func (th *TestHelper) handleGitAPI(w http.ResponseWriter, r *http.Request, repo MockRepo, parts []string) {
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	type treeEntry struct {
+		Path string `json:"path"`
+		Type string `json:"type"`
+		SHA  string `json:"sha"`
+		Size int    `json:"size"`
+	}
+
+	switch parts[0] {
+	case "trees":
+		files := repo.Files
+		if ref := parts[1]; ref != repo.DefaultBranch && ref != repo.Commit {
+			var ok bool
+			if files, ok = repo.Branches[ref]; !ok {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		tree := []treeEntry{}
+		for name, content := range files {
+			tree = append(tree, treeEntry{Path: name, Type: "blob", SHA: mockBlobSHA(content), Size: len(content)})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"tree": tree, "truncated": false, "page": 1, "total_count": len(tree)})
+	case "blobs":
+		candidates := []map[string]string{repo.Files}
+		for _, files := range repo.Branches {
+			candidates = append(candidates, files)
+		}
+		for _, files := range candidates {
+			for _, content := range files {
+				if mockBlobSHA(content) == parts[1] {
+					w.Header().Set("Content-Type", "application/json")
+					json.NewEncoder(w).Encode(map[string]interface{}{
+						"sha":      parts[1],
+						"size":     len(content),
+						"encoding": "base64",
+						"content":  base64.StdEncoding.EncodeToString([]byte(content)),
+					})
+					return
+				}
+			}
+		}
+		http.NotFound(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// mockBlobSHA returns the git blob SHA of content
+func mockBlobSHA(content string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(fmt.Sprintf("blob %d\x00%s", len(content), content))))
+}
+
+// handleContentsAPI answers 200 when the file exists on the requested ref
+func (th *TestHelper) handleContentsAPI(w http.ResponseWriter, r *http.Request, repo MockRepo, filePath string) {
+	files := repo.Files
+	if ref := r.URL.Query().Get("ref"); ref != "" && ref != repo.DefaultBranch {
+		var ok bool
+		if files, ok = repo.Branches[ref]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	th.contentCalls.Add(1)
+	w.Header().Set("Content-Type", "application/json")
+	if content, ok := files[filePath]; ok {
+		json.NewEncoder(w).Encode(map[string]string{
+			"name":     path.Base(filePath),
+			"path":     filePath,
+			"type":     "file",
+			"encoding": "base64",
+			"content":  base64.StdEncoding.EncodeToString([]byte(content)),
+			"sha":      mockBlobSHA(content),
+		})
+		return
+	}
+
+	// A directory lists its immediate children, files and directories
+	type entry struct {
+		Name string `json:"name"`
+		Path string `json:"path"`
+		Type string `json:"type"`
+	}
+	prefix := strings.Trim(filePath, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	seen := make(map[string]bool)
+	entries := []entry{}
+	for name := range files {
+		rest, ok := strings.CutPrefix(name, prefix)
+		if !ok {
+			continue
+		}
+		child, _, nested := strings.Cut(rest, "/")
+		if seen[child] {
+			continue
+		}
+		seen[child] = true
+		kind := "file"
+		if nested {
+			kind = "dir"
+		}
+		entries = append(entries, entry{Name: child, Path: prefix + child, Type: kind})
+	}
+	if len(entries) == 0 {
+		w.Header().Del("Content-Type")
+		http.NotFound(w, r)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	json.NewEncoder(w).Encode(entries)
+}
+
 func (th *TestHelper) handleArchiveRequest(w http.ResponseWriter, r *http.Request, repos map[string]MockRepo) {
 	// Extract repo info from archive path
 	// Example: /api/v1/repos/owner/repo/archive/main.tar.gz
@@ -134,7 +610,8 @@ func (th *TestHelper) handleArchiveRequest(w http.ResponseWriter, r *http.Reques
 
 	owner := pathParts[4]
 	repoName := pathParts[5]
-	// branch := strings.TrimSuffix(pathParts[7], ".tar.gz")
+	branch := r.URL.Path[strings.Index(r.URL.Path, "/archive/")+len("/archive/"):]
+	branch = strings.TrimSuffix(branch, ".tar.gz")
 
 	repoKey := fmt.Sprintf("%s/%s", owner, repoName)
 	repo, exists := repos[repoKey]
@@ -151,6 +628,25 @@ func (th *TestHelper) handleArchiveRequest(w http.ResponseWriter, r *http.Reques
 			return
 		}
 	}
+	if repo.Token != "" && r.Header.Get("Authorization") != "token "+repo.Token {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if files, ok := repo.Branches[branch]; ok {
+		repo.Files = files
+	} else if repo.StrictBranches && branch != repo.DefaultBranch {
+		http.Error(w, "Branch not found", http.StatusNotFound)
+		return
+	}
+
+	// Honor conditional requests so revalidation can be exercised
+	etag := archiveETag(repo)
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 
 	// Create and serve archive
 	archive := th.createTestArchive(repo)
@@ -158,6 +654,21 @@ func (th *TestHelper) handleArchiveRequest(w http.ResponseWriter, r *http.Reques
 	w.Write(archive)
 }
 
+// archiveETag derives a stable ETag from the mock repo files
+func archiveETag(repo MockRepo) string {
+	names := make([]string, 0, len(repo.Files))
+	for name := range repo.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha1.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s\x00%s\x00", name, repo.Files[name])
+	}
+	return fmt.Sprintf(`"%x"`, h.Sum(nil))
+}
+
 // createTestArchive creates a tar.gz archive from mock repo files
 func (th *TestHelper) createTestArchive(repo MockRepo) []byte {
 	th.t.Helper()
@@ -166,6 +677,18 @@ func (th *TestHelper) createTestArchive(repo MockRepo) []byte {
 	gzw := gzip.NewWriter(&buf)
 	tw := tar.NewWriter(gzw)
 
+	// git archive records the commit ID in a pax global header
+	if repo.Commit != "" {
+		hdr := &tar.Header{
+			Typeflag:   tar.TypeXGlobalHeader,
+			Name:       "pax_global_header",
+			PAXRecords: map[string]string{"comment": repo.Commit},
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			th.t.Fatal(err)
+		}
+	}
+
 	// Create archive with repo structure
 	repoDir := fmt.Sprintf("%s-%s/", strings.Replace(repo.FullName, "/", "-", -1), repo.DefaultBranch)
 
@@ -218,6 +741,12 @@ func (th *TestHelper) SetupGiteaPages(config GitteaPagesConfig) *GitteaPages {
 		IndexFiles:       config.IndexFiles,
 		DomainMappings:   config.DomainMappings,
 		AutoMapping:      config.AutoMapping,
+		CacheDownloadURL: config.CacheDownloadURL,
+		UserAgent:        config.UserAgent,
+		Rewrites:         config.Rewrites,
+		UpstreamProxy:    config.UpstreamProxy,
+		UpstreamNoProxy:  config.UpstreamNoProxy,
+		CompressCache:    config.CompressCache,
 	}
 
 	if gp.DefaultBranch == "" {
@@ -244,13 +773,32 @@ func (th *TestHelper) SetupGiteaPages(config GitteaPagesConfig) *GitteaPages {
 
 // GitteaPagesConfig holds configuration for test setup
 type GitteaPagesConfig struct {
-	GitteaURL       string
-	GitteaToken     string
-	CacheTTL        time.Duration
-	DefaultBranch   string
-	IndexFiles      []string
-	DomainMappings  []DomainMapping
-	AutoMapping     *AutoMapping
+	GitteaURL        string
+	GitteaToken      string
+	CacheTTL         time.Duration
+	DefaultBranch    string
+	IndexFiles       []string
+	DomainMappings   []DomainMapping
+	AutoMapping      *AutoMapping
+	CacheDownloadURL bool
+	UserAgent        string
+	Rewrites         []RewriteRule
+	UpstreamProxy    string
+	UpstreamNoProxy  []string
+	CompressCache    bool
+}
+
+// TrustProxies makes later requests count as sent through a trusted proxy,
+// so forwarded headers such as X-Forwarded-Proto are honoured
+func (th *TestHelper) TrustProxies() {
+	th.trustProxies = true
+}
+
+// trustedProxyRequest returns req marked the way Caddy marks requests from
+// one of its trusted_proxies
+func trustedProxyRequest(req *http.Request) *http.Request {
+	vars := map[string]any{caddyhttp.TrustedProxyVarKey: true}
+	return req.WithContext(context.WithValue(req.Context(), caddyhttp.VarsCtxKey, vars))
 }
 
 // MakeHTTPRequest creates and executes an HTTP request for testing
@@ -265,6 +813,9 @@ func (th *TestHelper) MakeHTTPRequest(method, path, host string, headers map[str
 	for key, value := range headers {
 		req.Header.Set(key, value)
 	}
+	if th.trustProxies {
+		req = trustedProxyRequest(req)
+	}
 
 	w := httptest.NewRecorder()
 
@@ -456,6 +1007,7 @@ func GenerateTestRepos() map[string]MockRepo {
 			Name:          "website",
 			FullName:      "user/website",
 			DefaultBranch: "main",
+			Commit:        "3f9a1c2b7d8e4f6a0b1c2d3e4f5a6b7c8d9e0f1a",
 			Files: map[string]string{
 				"index.html":        "<h1>Welcome to My Website</h1>",
 				"about.html":        "<h1>About Us</h1>",
@@ -0,0 +1,138 @@
+package giteapages
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// cacheTier is where an extracted file is kept
+type cacheTier int
+
+const (
+	tierDisk cacheTier = iota
+	tierMemory
+	tierStream
+)
+
+// cacheTier picks the tier for a file of the given size from the
+// CacheMinSize and CacheMaxSize band
+func (gp *GitteaPages) cacheTier(size int64) cacheTier {
+	switch {
+	case gp.CacheMinSize > 0 && size < gp.CacheMinSize:
+		return tierMemory
+	case gp.CacheMaxSize > 0 && size > gp.CacheMaxSize:
+		return tierStream
+	}
+	return tierDisk
+}
+
+// serveMemoryFile serves a file held in memory
+func serveMemoryFile(w http.ResponseWriter, r *http.Request, name string, modTime time.Time, data []byte) {
+	if w.Header().Get("Content-Type") == "" {
+		if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+			w.Header().Set("Content-Type", ctype)
+		}
+	}
+	http.ServeContent(w, r, name, modTime, bytes.NewReader(data))
+}
+
+// streamedHeaders are the upstream response headers passed on to clients
+// of a streamed file
+var streamedHeaders = []string{"Content-Length", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified"}
+
+// serveStreamed proxies relPath at ref from Gitea's raw file endpoint
+// without caching it
+func (gp *GitteaPages) serveStreamed(w http.ResponseWriter, r *http.Request, owner, repo, ref, relPath string) error {
+	rawURL := fmt.Sprintf("%s/api/v1/repos/%s/%s/raw/%s?ref=%s",
+		strings.TrimRight(gp.GitteaURL, "/"), url.PathEscape(owner), url.PathEscape(repo),
+		escapePath(relPath), url.QueryEscape(ref))
+
+	req, err := gp.newUpstreamRequest(r.Context(), rawURL)
+	if err != nil {
+		return err
+	}
+	for _, h := range []string{"Range", "If-None-Match", "If-Modified-Since", "If-Range"} {
+		if v := r.Header.Get(h); v != "" {
+			req.Header.Set(h, v)
+		}
+	}
+
+	// With our own ETag, If-Range is decided here: Gitea's validators
+	// differ from ours
+	etag := w.Header().Get("ETag")
+	if etag != "" {
+		req.Header.Del("If-None-Match")
+		req.Header.Del("If-Range")
+		if !ifRangeMatches(r.Header.Get("If-Range"), etag) {
+			req.Header.Del("Range")
+		}
+		if match := r.Header.Get("If-None-Match"); match != "" && (match == etag || match == "*") {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+	}
+
+	var resp *http.Response
+	if gp.StreamFallback != nil {
+		res, cancel := gp.raceStreamed(r, req, owner, repo, ref, relPath)
+		defer cancel()
+		if res.err != nil {
+			return res.err
+		}
+		if res.resp == nil {
+			serveContentsResult(w, r, relPath, res)
+			return nil
+		}
+		resp = res.resp
+	} else if resp, err = gp.fetchRaw(req); err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	for _, h := range streamedHeaders {
+		if v := resp.Header.Get(h); v != "" && (h != "ETag" || etag == "") {
+			w.Header().Set(h, v)
+		}
+	}
+	if w.Header().Get("Content-Type") == "" {
+		ctype := mime.TypeByExtension(path.Ext(relPath))
+		if ctype == "" {
+			ctype = resp.Header.Get("Content-Type")
+		}
+		w.Header().Set("Content-Type", ctype)
+	}
+	w.WriteHeader(resp.StatusCode)
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// fetchRaw sends a raw endpoint request, returning the response when it
+// carries the file or an unchanged status
+func (gp *GitteaPages) fetchRaw(req *http.Request) (*http.Response, error) {
+	client := &http.Client{Timeout: 5 * time.Minute, Transport: gp.upstreamTransport()}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stream file: %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusNotModified:
+		return resp, nil
+	case http.StatusTooManyRequests:
+		defer resp.Body.Close()
+		return nil, gp.rateLimited(resp)
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to stream file: status %d", resp.StatusCode)
+	}
+}
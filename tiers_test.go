@@ -0,0 +1,191 @@
+package giteapages
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestCacheSizeBand(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	small := "tiny"
+	medium := strings.Repeat("m", 200)
+	large := strings.Repeat("L", 4096)
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Commit:        "3f9a1c2b7d8e4f6a0b1c2d3e4f5a6b7c8d9e0f1a",
+			Files: map[string]string{
+				"small.txt":  small,
+				"medium.css": medium,
+				"large.bin":  large,
+			},
+		},
+	})
+
+	gp := &GitteaPages{
+		GitteaURL:    helper.server.URL,
+		CacheDir:     t.TempDir(),
+		CacheMinSize: 64,
+		CacheMaxSize: 1024,
+	}
+	if err := gp.Provision(caddy.Context{}); err != nil {
+		t.Fatalf("Failed to provision: %v", err)
+	}
+	helper.gp = gp
+
+	tests := []struct {
+		file     string
+		content  string
+		tier     cacheTier
+		diskSize int64
+	}{
+		{"small.txt", small, tierMemory, 0},
+		{"medium.css", medium, tierDisk, int64(len(medium))},
+		{"large.bin", large, tierStream, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			w := helper.MakeHTTPRequest("GET", "/user/site/"+tt.file, "", nil)
+			if w.Code != http.StatusOK || w.Body.String() != tt.content {
+				t.Fatalf("Expected 200 with the file content, got %d: %.40q", w.Code, w.Body.String())
+			}
+
+			entry := gp.cache.repos["user/site:main"]
+			_, inMemory := entry.memory[tt.file]
+			if inMemory != (tt.tier == tierMemory) {
+				t.Errorf("Expected in memory %v, got %v", tt.tier == tierMemory, inMemory)
+			}
+			if entry.streamed[tt.file] != (tt.tier == tierStream) {
+				t.Errorf("Expected streamed %v, got %v", tt.tier == tierStream, entry.streamed[tt.file])
+			}
+
+			info, err := os.Stat(filepath.Join(entry.path, tt.file))
+			if err != nil {
+				t.Fatalf("Expected a file on disk: %v", err)
+			}
+			if info.Size() != tt.diskSize {
+				t.Errorf("Expected %d bytes on disk, got %d", tt.diskSize, info.Size())
+			}
+		})
+	}
+
+	// Streamed files go to Gitea on every request, cached ones do not
+	api, _ := helper.UpstreamCalls()
+	helper.MakeHTTPRequest("GET", "/user/site/small.txt", "", nil)
+	helper.MakeHTTPRequest("GET", "/user/site/medium.css", "", nil)
+	if after, _ := helper.UpstreamCalls(); after != api {
+		t.Errorf("Expected no upstream calls for cached files, got %d", after-api)
+	}
+	w := helper.MakeHTTPRequest("GET", "/user/site/large.bin", "", map[string]string{"Range": "bytes=0-9"})
+	helper.AssertResponse(w, http.StatusPartialContent, "LLLLLLLLLL")
+	if after, _ := helper.UpstreamCalls(); after != api+1 {
+		t.Errorf("Expected one upstream call for the streamed file, got %d", after-api)
+	}
+
+	// Archives read files kept in memory from there and leave streamed
+	// files out
+	gp.AllowArchive = true
+	w = helper.MakeHTTPRequest("GET", "/user/site/archive.zip", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the archive, got %d: %s", w.Code, w.Body.String())
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("Failed to read zip: %v", err)
+	}
+	archived := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		archived[f.Name] = string(content)
+	}
+	if len(archived) != 2 || archived["small.txt"] != small || archived["medium.css"] != medium {
+		t.Errorf("Expected small.txt and medium.css with their content, got %v", archived)
+	}
+}
+
+func TestServeStreamed_EscapesRef(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Files:         map[string]string{"large.bin": "main"},
+			Branches: map[string]map[string]string{
+				"v1+hotfix&x": {"large.bin": "hotfix"},
+			},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/user/site/large.bin", nil)
+	if err := gp.serveStreamed(w, r, "user", "site", "v1+hotfix&x", "large.bin"); err != nil {
+		t.Fatalf("serveStreamed failed: %v", err)
+	}
+	helper.AssertResponse(w, http.StatusOK, "hotfix")
+}
+
+func TestCacheTier(t *testing.T) {
+	tests := []struct {
+		min, max int64
+		size     int64
+		expected cacheTier
+	}{
+		{0, 0, 1, tierDisk},
+		{0, 0, 1 << 30, tierDisk},
+		{100, 0, 99, tierMemory},
+		{100, 0, 100, tierDisk},
+		{0, 1000, 1000, tierDisk},
+		{0, 1000, 1001, tierStream},
+		{100, 1000, 500, tierDisk},
+	}
+
+	for _, tt := range tests {
+		gp := &GitteaPages{CacheMinSize: tt.min, CacheMaxSize: tt.max}
+		if got := gp.cacheTier(tt.size); got != tt.expected {
+			t.Errorf("cacheTier(%d) with band [%d, %d]: expected %v, got %v",
+				tt.size, tt.min, tt.max, tt.expected, got)
+		}
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_CacheSizeBand(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		cache_min_size 4KB
+		cache_max_size 10MB
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.CacheMinSize != 4000 || gp.CacheMaxSize != 10000000 {
+		t.Errorf("Expected band [4000, 10000000], got [%d, %d]", gp.CacheMinSize, gp.CacheMaxSize)
+	}
+}
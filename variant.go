@@ -0,0 +1,76 @@
+package giteapages
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Variant is an alternate rendering of pages, such as AMP, stored next to
+// the canonical file with the variant name before the extension:
+// page.amp.html for page.html. It is selected by a query parameter, a
+// request header, or both; Query defaults to the variant name.
+type Variant struct {
+	Name   string `json:"name"`
+	Query  string `json:"query,omitempty"`
+	Header string `json:"header,omitempty"`
+}
+
+// requested reports whether r asks for the variant. The parameter or
+// header selects it unless its value is "0", "false" or "off".
+func (v Variant) requested(r *http.Request) bool {
+	query := v.Query
+	if query == "" {
+		query = v.Name
+	}
+	if values, ok := r.URL.Query()[query]; ok {
+		return len(values) > 0 && truthy(values[0])
+	}
+	if v.Header != "" {
+		if value := r.Header.Get(v.Header); value != "" {
+			return truthy(value)
+		}
+	}
+	return false
+}
+
+// truthy reports whether a selector value turns a variant on. An empty
+// value counts, so "?amp" works like "?amp=1".
+func truthy(value string) bool {
+	switch strings.ToLower(value) {
+	case "0", "false", "off", "no":
+		return false
+	}
+	return true
+}
+
+// variantPath returns the path of the variant of fullPath: the variant
+// name inserted before the extension
+func variantPath(fullPath, name string) string {
+	ext := filepath.Ext(fullPath)
+	return strings.TrimSuffix(fullPath, ext) + "." + name + ext
+}
+
+// selectVariant returns the file to serve for fullPath, a file inside
+// root: the first requested variant that exists, else fullPath itself
+func (gp *GitteaPages) selectVariant(w http.ResponseWriter, r *http.Request, root, fullPath string) string {
+	for _, v := range gp.Variants {
+		// Caches must keep the renderings apart when a header decides
+		if v.Header != "" {
+			w.Header().Add("Vary", v.Header)
+		}
+		if !v.requested(r) {
+			continue
+		}
+		alt := variantPath(fullPath, v.Name)
+		rel, err := filepath.Rel(root, alt)
+		if err != nil || gp.isDenied(filepath.ToSlash(rel)) {
+			continue
+		}
+		if info, err := os.Stat(alt); err == nil && info.Mode().IsRegular() {
+			return alt
+		}
+	}
+	return fullPath
+}
@@ -0,0 +1,87 @@
+package giteapages
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestServeHTTP_Variants(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/news": {
+			Name:          "news",
+			FullName:      "user/news",
+			DefaultBranch: "main",
+			Files: map[string]string{
+				"page.html":           "<h1>Canonical</h1>",
+				"page.amp.html":       "<h1>AMP</h1>",
+				"other.html":          "<h1>Other canonical</h1>",
+				"docs/index.html":     "<h1>Docs</h1>",
+				"docs/index.amp.html": "<h1>Docs AMP</h1>",
+			},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.Variants = []Variant{{Name: "amp", Header: "X-AMP"}}
+
+	tests := []struct {
+		name     string
+		path     string
+		headers  map[string]string
+		expected string
+	}{
+		{"query selects variant", "/user/news/page.html?amp=1", nil, "<h1>AMP</h1>"},
+		{"no selector", "/user/news/page.html", nil, "Canonical"},
+		{"query turned off", "/user/news/page.html?amp=0", nil, "Canonical"},
+		{"variant absent", "/user/news/other.html?amp=1", nil, "Other canonical"},
+		{"header selects variant", "/user/news/page.html", map[string]string{"X-AMP": "1"}, "<h1>AMP</h1>"},
+		{"directory index", "/user/news/docs/?amp", nil, "Docs AMP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := helper.MakeHTTPRequest("GET", tt.path, "", tt.headers)
+			helper.AssertResponse(w, http.StatusOK, tt.expected)
+			if vary := w.Header().Values("Vary"); len(vary) == 0 || vary[0] != "X-AMP" {
+				t.Errorf("Expected Vary: X-AMP, got %v", vary)
+			}
+		})
+	}
+}
+
+func TestVariantPath(t *testing.T) {
+	tests := map[string]string{
+		"/cache/page.html":     "/cache/page.amp.html",
+		"/cache/a.b/page.html": "/cache/a.b/page.amp.html",
+		"/cache/README":        "/cache/README.amp",
+	}
+	for input, expected := range tests {
+		if got := variantPath(input, "amp"); got != expected {
+			t.Errorf("variantPath(%q): expected %q, got %q", input, expected, got)
+		}
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_Variant(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		variant amp {
+			query format
+			header X-AMP
+		}
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if len(gp.Variants) != 1 || gp.Variants[0] != (Variant{Name: "amp", Query: "format", Header: "X-AMP"}) {
+		t.Errorf("Unexpected variants %+v", gp.Variants)
+	}
+}
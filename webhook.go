@@ -0,0 +1,293 @@
+package giteapages
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// maxWebhookBody caps the size of an accepted webhook payload
+const maxWebhookBody = 1 << 20
+
+// maxPrefetchPages caps how many sitemap pages one push prefetches
+const maxPrefetchPages = 1000
+
+// defaultPrefetchConcurrency is how many pages are prefetched at once
+// when MaxConcurrentUpstream sets no lower bound
+const defaultPrefetchConcurrency = 4
+
+// pushEvent is the part of a Gitea push webhook payload that is used
+type pushEvent struct {
+	Ref        string `json:"ref"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// serveWebhook receives Gitea push webhooks at WebhookPath. A push to a
+// branch refreshes that branch's cache entry in the background and, with
+// PrefetchSitemapOnPush, then prefetches the pages its sitemap.xml lists.
+func (gp *GitteaPages) serveWebhook(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		gp.rejectWebhook(w, http.StatusMethodNotAllowed, "method not allowed",
+			"method_not_allowed", fmt.Sprintf("webhooks must be POSTed, got %s", r.Method))
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody+1))
+	if err != nil {
+		gp.rejectWebhook(w, http.StatusBadRequest, "invalid payload",
+			"unreadable_payload", err.Error())
+		return nil
+	}
+	if len(body) > maxWebhookBody {
+		gp.rejectWebhook(w, http.StatusBadRequest, "invalid payload",
+			"payload_too_large", fmt.Sprintf("payload exceeds %d bytes", maxWebhookBody))
+		return nil
+	}
+	signature := r.Header.Get("X-Gitea-Signature")
+	if signature == "" {
+		gp.rejectWebhook(w, http.StatusForbidden, "invalid signature",
+			"missing_signature", "X-Gitea-Signature header is missing")
+		return nil
+	}
+	if !validWebhookSignature(gp.WebhookSecret, body, signature) {
+		gp.rejectWebhook(w, http.StatusForbidden, "invalid signature",
+			"signature_mismatch", "X-Gitea-Signature does not match the payload signed with webhook_secret")
+		return nil
+	}
+
+	if event := r.Header.Get("X-Gitea-Event"); event != "push" {
+		gp.ignoreWebhook(w, "unsupported_event",
+			fmt.Sprintf("only push events are handled, got %q", event))
+		return nil
+	}
+	var push pushEvent
+	if err := json.Unmarshal(body, &push); err != nil {
+		gp.rejectWebhook(w, http.StatusBadRequest, "invalid payload",
+			"unparseable_payload", err.Error())
+		return nil
+	}
+	owner, repo, ok := strings.Cut(push.Repository.FullName, "/")
+	if !ok || owner == "" || repo == "" {
+		gp.ignoreWebhook(w, "invalid_repository",
+			fmt.Sprintf("repository.full_name %q is not owner/repo", push.Repository.FullName))
+		return nil
+	}
+	branch, isBranch := strings.CutPrefix(push.Ref, "refs/heads/")
+	if !isBranch || branch == "" {
+		// Tag pushes leave the cache alone
+		gp.ignoreWebhook(w, "not_a_branch",
+			fmt.Sprintf("ref %q is not a branch", push.Ref))
+		return nil
+	}
+
+	if !gp.isPinned(fmt.Sprintf("%s/%s:%s", owner, repo, branch)) {
+		ctx := context.WithoutCancel(r.Context())
+		// A push relayed from a peer's eviction notice is not announced again
+		notify := r.Header.Get(evictionForwardedHeader) == ""
+		gp.webhooks.Add(1)
+		go func() {
+			defer gp.webhooks.Done()
+			gp.handlePush(ctx, owner, repo, branch, notify)
+		}()
+	}
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
+
+// webhookError is the JSON body WebhookJSONErrors answers a rejected or
+// ignored webhook with
+type webhookError struct {
+	Error  string `json:"error"`
+	Reason string `json:"reason"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// rejectWebhook answers a webhook that could not be accepted with status,
+// as JSON describing reason when WebhookJSONErrors is set and as plain
+// text otherwise
+func (gp *GitteaPages) rejectWebhook(w http.ResponseWriter, status int, msg, reason, detail string) {
+	if !gp.WebhookJSONErrors {
+		http.Error(w, msg, status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(webhookError{Error: msg, Reason: reason, Detail: detail})
+}
+
+// ignoreWebhook answers a valid delivery that leaves the cache alone.
+// Without WebhookJSONErrors it has no body; with it, 200 OK carries the
+// reason so Gitea's delivery log shows why nothing happened.
+func (gp *GitteaPages) ignoreWebhook(w http.ResponseWriter, reason, detail string) {
+	if !gp.WebhookJSONErrors {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	gp.rejectWebhook(w, http.StatusOK, "ignored", reason, detail)
+}
+
+// validWebhookSignature reports whether signature is the hex HMAC-SHA256
+// of body under secret, as Gitea sends in X-Gitea-Signature
+func validWebhookSignature(secret string, body []byte, signature string) bool {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(strings.ToLower(signature)))
+}
+
+// handlePush refreshes a pushed branch, purges it from the CDN, tells
+// EvictionWebhook when notify is set and prefetches its sitemap
+func (gp *GitteaPages) handlePush(ctx context.Context, owner, repo, branch string, notify bool) {
+	if err := gp.updateRepoCache(ctx, owner, repo, branch); err != nil {
+		gp.logger.Warn("failed to refresh cache after push",
+			zap.String("repo", owner+"/"+repo),
+			zap.String("branch", branch),
+			zap.Error(err))
+		return
+	}
+	gp.purgeSurrogateKey(ctx, owner, repo, branch)
+	if notify {
+		gp.notifyEviction(fmt.Sprintf("%s/%s:%s", owner, repo, branch), evictionReasonPushed)
+	}
+	if !gp.PrefetchSitemapOnPush {
+		return
+	}
+	pages := gp.prefetchSitemap(ctx, owner, repo, branch)
+	gp.logger.Info("prefetched sitemap after push",
+		zap.String("repo", owner+"/"+repo),
+		zap.String("branch", branch),
+		zap.Int("pages", pages))
+}
+
+// sitemapURLs returns the page URLs listed in a sitemap.xml urlset
+func sitemapURLs(data []byte) ([]string, error) {
+	var sitemap struct {
+		URLs []struct {
+			Loc string `xml:"loc"`
+		} `xml:"url"`
+	}
+	if err := xml.Unmarshal(data, &sitemap); err != nil {
+		return nil, err
+	}
+	locs := make([]string, 0, len(sitemap.URLs))
+	for _, u := range sitemap.URLs {
+		if loc := strings.TrimSpace(u.Loc); loc != "" {
+			locs = append(locs, loc)
+		}
+	}
+	return locs, nil
+}
+
+// prefetchSitemap serves every page listed in the branch's sitemap.xml
+// into a discarding writer, so per-page work such as index resolution,
+// minification and fetching streamed files is done before the first
+// visitor asks. Pages that do not route to owner/repo are skipped. It
+// returns the number of pages served.
+func (gp *GitteaPages) prefetchSitemap(ctx context.Context, owner, repo, branch string) int {
+	gp.cache.mu.RLock()
+	entry := gp.cache.repos[fmt.Sprintf("%s/%s:%s", owner, repo, branch)]
+	gp.cache.mu.RUnlock()
+	if entry == nil {
+		return 0
+	}
+	data, err := readCachedFile(filepath.Join(entry.path, "sitemap.xml"), entry.compressed)
+	if err != nil {
+		return 0
+	}
+	locs, err := sitemapURLs(data)
+	if err != nil {
+		gp.logger.Warn("failed to parse sitemap",
+			zap.String("repo", owner+"/"+repo),
+			zap.Error(err))
+		return 0
+	}
+	if len(locs) > maxPrefetchPages {
+		locs = locs[:maxPrefetchPages]
+	}
+
+	workers := defaultPrefetchConcurrency
+	if gp.MaxConcurrentUpstream > 0 {
+		workers = min(workers, gp.MaxConcurrentUpstream)
+	}
+	jobs := make(chan string)
+	var served sync.WaitGroup
+	var mu sync.Mutex
+	count := 0
+	for i := 0; i < workers; i++ {
+		served.Add(1)
+		go func() {
+			defer served.Done()
+			for loc := range jobs {
+				if gp.prefetchPage(ctx, owner, repo, branch, loc) {
+					mu.Lock()
+					count++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, loc := range locs {
+		jobs <- loc
+	}
+	close(jobs)
+	served.Wait()
+	return count
+}
+
+// prefetchPage serves the page at loc if it routes to owner/repo
+func (gp *GitteaPages) prefetchPage(ctx context.Context, owner, repo, branch, loc string) bool {
+	u, err := url.Parse(loc)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false
+	}
+	match := gp.resolveRoute(req)
+	if match.owner != owner || match.repo != repo || match.branch != "" && match.branch != branch {
+		return false
+	}
+	w := &discardResponse{header: make(http.Header)}
+	if err := gp.serveFile(w, req, owner, repo, match.filePath, branch); err != nil {
+		return false
+	}
+	return w.status < http.StatusBadRequest
+}
+
+// discardResponse is a ResponseWriter that keeps only the status
+type discardResponse struct {
+	header http.Header
+	status int
+}
+
+func (d *discardResponse) Header() http.Header { return d.header }
+
+func (d *discardResponse) Write(p []byte) (int, error) {
+	if d.status == 0 {
+		d.status = http.StatusOK
+	}
+	return len(p), nil
+}
+
+func (d *discardResponse) WriteHeader(status int) {
+	if d.status == 0 {
+		d.status = status
+	}
+}
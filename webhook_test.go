@@ -0,0 +1,251 @@
+package giteapages
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+const testWebhookSecret = "webhook-secret"
+
+// signedPush returns a push webhook request for ref of user/site
+func signedPush(t *testing.T, event, ref, secret string) *http.Request {
+	t.Helper()
+	return signedWebhook(t, event, `{"ref": "`+ref+`", "repository": {"full_name": "user/site"}}`, secret)
+}
+
+// signedWebhook returns a webhook request for event carrying body
+func signedWebhook(t *testing.T, event, body, secret string) *http.Request {
+	t.Helper()
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+
+	req := httptest.NewRequest("POST", "/_hooks/gitea", strings.NewReader(body))
+	req.Header.Set("X-Gitea-Event", event)
+	req.Header.Set("X-Gitea-Signature", hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestServeHTTP_WebhookPrefetchSitemap(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	sitemap := `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://pages.example.com/user/site/page.html</loc></url>
+  <url><loc> https://pages.example.com/user/site/docs/ </loc></url>
+  <url><loc>https://pages.example.com/user/site/missing.html</loc></url>
+  <url><loc>https://pages.example.com/other/repo/page.html</loc></url>
+</urlset>`
+	helper.CreateMockGiteaServer(map[string]MockRepo{
+		"user/site": {
+			Name:          "site",
+			FullName:      "user/site",
+			DefaultBranch: "main",
+			Files: map[string]string{
+				"page.html":       "<h1>Page</h1>\n\n<!-- note -->\n<p>Text</p>",
+				"docs/index.html": "<h1>Docs</h1>",
+				"sitemap.xml":     sitemap,
+			},
+		},
+	})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.WebhookPath = "/_hooks/gitea"
+	gp.WebhookSecret = testWebhookSecret
+	gp.PrefetchSitemapOnPush = true
+	gp.Minify = &Minify{}
+
+	w := httptest.NewRecorder()
+	if err := gp.ServeHTTP(w, signedPush(t, "push", "refs/heads/main", testWebhookSecret), nil); err != nil {
+		t.Fatalf("ServeHTTP failed: %v", err)
+	}
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d", w.Code)
+	}
+	gp.webhooks.Wait()
+
+	if _, archive := helper.UpstreamCalls(); archive != 1 {
+		t.Errorf("Expected the push to download the archive once, got %d", archive)
+	}
+	gp.cache.mu.RLock()
+	entry := gp.cache.repos["user/site:main"]
+	gp.cache.mu.RUnlock()
+	if entry == nil {
+		t.Fatal("Expected the push to cache the branch")
+	}
+
+	// The listed pages went through the handler: minified and, for the
+	// directory, with its index resolved
+	entry.minified.mu.Lock()
+	_, pagePrefetched := entry.minified.files["page.html"]
+	_, docsPrefetched := entry.minified.files["docs/index.html"]
+	entry.minified.mu.Unlock()
+	if !pagePrefetched || !docsPrefetched {
+		t.Errorf("Expected listed pages to be prefetched, got %v", entry.minified.files)
+	}
+	entry.indexes.mu.Lock()
+	index := entry.indexes.names[filepath.Join(entry.path, "docs")]
+	entry.indexes.mu.Unlock()
+	if index != "index.html" {
+		t.Errorf("Expected the docs index to be resolved, got %q", index)
+	}
+
+	if pages := gp.prefetchSitemap(context.Background(), "user", "site", "main"); pages != 2 {
+		t.Errorf("Expected 2 prefetched pages, got %d", pages)
+	}
+
+	// The first visitor is served from the warm cache
+	w = helper.MakeHTTPRequest("GET", "/user/site/page.html", "", nil)
+	helper.AssertResponse(w, http.StatusOK, "<p>Text</p>")
+	if _, archive := helper.UpstreamCalls(); archive != 1 {
+		t.Errorf("Expected no further downloads, got %d", archive)
+	}
+}
+
+func TestServeHTTP_WebhookRejected(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.WebhookPath = "/_hooks/gitea"
+	gp.WebhookSecret = testWebhookSecret
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{"wrong secret", signedPush(t, "push", "refs/heads/main", "other"), http.StatusForbidden},
+		{"other event", signedPush(t, "issues", "refs/heads/main", testWebhookSecret), http.StatusNoContent},
+		{"tag push", signedPush(t, "push", "refs/tags/v1.0", testWebhookSecret), http.StatusNoContent},
+		{"GET", httptest.NewRequest("GET", "/_hooks/gitea", nil), http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if err := gp.ServeHTTP(w, tt.req, nil); err != nil {
+				t.Fatalf("ServeHTTP failed: %v", err)
+			}
+			if w.Code != tt.status {
+				t.Errorf("Expected %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+	gp.webhooks.Wait()
+	if api, archive := helper.UpstreamCalls(); api != 0 || archive != 0 {
+		t.Errorf("Expected no upstream calls, got %d API and %d archive", api, archive)
+	}
+}
+
+func TestServeHTTP_WebhookJSONErrors(t *testing.T) {
+	helper := NewTestHelper(t)
+	defer helper.Cleanup()
+
+	helper.CreateMockGiteaServer(map[string]MockRepo{})
+	gp := helper.SetupGiteaPages(GitteaPagesConfig{
+		GitteaURL: helper.server.URL,
+	})
+	gp.WebhookPath = "/_hooks/gitea"
+	gp.WebhookSecret = testWebhookSecret
+	gp.WebhookJSONErrors = true
+
+	unsigned := signedPush(t, "push", "refs/heads/main", testWebhookSecret)
+	unsigned.Header.Del("X-Gitea-Signature")
+	oversize := signedWebhook(t, "push", strings.Repeat(" ", maxWebhookBody+1), testWebhookSecret)
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+		want   webhookError
+	}{
+		{"GET", httptest.NewRequest("GET", "/_hooks/gitea", nil), http.StatusMethodNotAllowed,
+			webhookError{"method not allowed", "method_not_allowed", "webhooks must be POSTed, got GET"}},
+		{"oversize", oversize, http.StatusBadRequest,
+			webhookError{"invalid payload", "payload_too_large", "payload exceeds 1048576 bytes"}},
+		{"unsigned", unsigned, http.StatusForbidden,
+			webhookError{"invalid signature", "missing_signature", "X-Gitea-Signature header is missing"}},
+		{"wrong secret", signedPush(t, "push", "refs/heads/main", "other"), http.StatusForbidden,
+			webhookError{"invalid signature", "signature_mismatch", "X-Gitea-Signature does not match the payload signed with webhook_secret"}},
+		{"other event", signedPush(t, "issues", "refs/heads/main", testWebhookSecret), http.StatusOK,
+			webhookError{"ignored", "unsupported_event", `only push events are handled, got "issues"`}},
+		{"unparseable", signedWebhook(t, "push", `{"ref": `, testWebhookSecret), http.StatusBadRequest,
+			webhookError{"invalid payload", "unparseable_payload", "unexpected end of JSON input"}},
+		{"bad repository", signedWebhook(t, "push", `{"ref": "refs/heads/main", "repository": {"full_name": "site"}}`, testWebhookSecret), http.StatusOK,
+			webhookError{"ignored", "invalid_repository", `repository.full_name "site" is not owner/repo`}},
+		{"tag push", signedPush(t, "push", "refs/tags/v1.0", testWebhookSecret), http.StatusOK,
+			webhookError{"ignored", "not_a_branch", `ref "refs/tags/v1.0" is not a branch`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if err := gp.ServeHTTP(w, tt.req, nil); err != nil {
+				t.Fatalf("ServeHTTP failed: %v", err)
+			}
+			if w.Code != tt.status {
+				t.Errorf("Expected %d, got %d", tt.status, w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Expected a JSON response, got %q", ct)
+			}
+			var got webhookError
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("Invalid JSON body %q: %v", w.Body.String(), err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+	gp.webhooks.Wait()
+	if api, archive := helper.UpstreamCalls(); api != 0 || archive != 0 {
+		t.Errorf("Expected no upstream calls, got %d API and %d archive", api, archive)
+	}
+}
+
+func TestSitemapURLs(t *testing.T) {
+	locs, err := sitemapURLs([]byte(`<urlset><url><loc>https://a.example/</loc></url><url><loc></loc></url></urlset>`))
+	if err != nil || len(locs) != 1 || locs[0] != "https://a.example/" {
+		t.Errorf("Unexpected sitemap URLs %v, %v", locs, err)
+	}
+	if _, err := sitemapURLs([]byte("not xml <")); err == nil {
+		t.Error("Expected a parse error")
+	}
+}
+
+func TestGiteaPages_UnmarshalCaddyfile_Webhook(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`gitea_pages {
+		gitea_url https://git.example.com
+		webhook_path /_hooks/gitea
+		webhook_secret s3cret
+		prefetch_sitemap_on_push
+		webhook_json_errors
+	}`)
+
+	var gp GitteaPages
+	if err := gp.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile failed: %v", err)
+	}
+	if gp.WebhookPath != "/_hooks/gitea" || gp.WebhookSecret != "s3cret" || !gp.PrefetchSitemapOnPush || !gp.WebhookJSONErrors {
+		t.Errorf("Unexpected webhook config %q %q %v %v", gp.WebhookPath, gp.WebhookSecret, gp.PrefetchSitemapOnPush, gp.WebhookJSONErrors)
+	}
+
+	gp.WebhookSecret = ""
+	if err := gp.Validate(); err == nil {
+		t.Error("Expected webhook_path without webhook_secret to fail validation")
+	}
+}